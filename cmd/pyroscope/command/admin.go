@@ -30,6 +30,7 @@ func newAdminCmd(cfg *config.Admin) *cobra.Command {
 	cmd.AddCommand(newAdminAppCmd(cfg))
 	cmd.AddCommand(newAdminUserCmd(cfg))
 	cmd.AddCommand(newAdminStorageCmd(cfg))
+	cmd.AddCommand(newAdminReloadCmd(&cfg.AdminConfigReload))
 
 	return cmd
 }
@@ -169,6 +170,7 @@ func newAdminStorageCmd(cfg *config.Admin) *cobra.Command {
 	}
 
 	cmd.AddCommand(newAdminStorageCleanupCmd(&cfg.AdminStorageCleanup))
+	cmd.AddCommand(newAdminStorageCardinalityCmd(&cfg.AdminStorageCardinality))
 	return cmd
 }
 
@@ -190,3 +192,44 @@ func newAdminStorageCleanupCmd(cfg *config.AdminStorageCleanup) *cobra.Command {
 	cli.PopulateFlagSet(cfg, cmd.Flags(), vpr)
 	return cmd
 }
+
+func newAdminStorageCardinalityCmd(cfg *config.AdminStorageCardinality) *cobra.Command {
+	vpr := newViper()
+	cmd := &cobra.Command{
+		Use:   "cardinality",
+		Short: "list applications and label keys with the highest cardinality",
+		Long:  "list applications with the largest number of series and label keys with the largest number of values ingested since the server start. The numbers are estimates",
+		Args:  cobra.NoArgs,
+		RunE: cli.CreateCmdRunFn(cfg, vpr, func(_ *cobra.Command, arg []string) error {
+			ac, err := admin.NewCLI(cfg.SocketPath, cfg.Timeout)
+			if err != nil {
+				return err
+			}
+			return ac.CardinalityReport(cfg.Limit)
+		}),
+	}
+
+	cli.PopulateFlagSet(cfg, cmd.Flags(), vpr)
+	return cmd
+}
+
+// admin reload
+func newAdminReloadCmd(cfg *config.AdminConfigReload) *cobra.Command {
+	vpr := newViper()
+	cmd := &cobra.Command{
+		Use:   "reload",
+		Short: "reload server configuration",
+		Long:  "make the server re-read its configuration file and apply scrape configs, metrics export rules, remote write targets and log level without restart. Same as sending SIGHUP to the server process",
+		Args:  cobra.NoArgs,
+		RunE: cli.CreateCmdRunFn(cfg, vpr, func(_ *cobra.Command, arg []string) error {
+			ac, err := admin.NewCLI(cfg.SocketPath, cfg.Timeout)
+			if err != nil {
+				return err
+			}
+			return ac.ReloadConfig()
+		}),
+	}
+
+	cli.PopulateFlagSet(cfg, cmd.Flags(), vpr)
+	return cmd
+}
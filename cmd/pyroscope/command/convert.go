@@ -4,26 +4,31 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 
 	"github.com/spf13/cobra"
 
 	"github.com/pyroscope-io/pyroscope/pkg/cli"
 	"github.com/pyroscope-io/pyroscope/pkg/config"
-	"github.com/pyroscope-io/pyroscope/pkg/convert"
-	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
-	"github.com/pyroscope-io/pyroscope/pkg/structs/transporttrie"
+	"github.com/pyroscope-io/pyroscope/pkg/convert/offline"
+	"github.com/pyroscope-io/pyroscope/pkg/flameql"
+	"github.com/pyroscope-io/pyroscope/webapp"
 )
 
 func newConvertCmd(cfg *config.Convert) *cobra.Command {
 	vpr := newViper()
 	convertCmd := &cobra.Command{
-		Use:    "convert [flags]",
-		Short:  "Convert between different profiling formats",
+		Use:   "convert [flags] [<input files>]",
+		Short: "Convert between different profiling formats",
+		Long: `Convert between different profiling formats.
+
+All the input files are merged into a single profile. If no files are given,
+the input profile is read from stdin.`,
 		Hidden: true,
 
 		DisableFlagParsing: true,
-		RunE: cli.CreateCmdRunFn(cfg, vpr, func(_ *cobra.Command, _ []string) error {
-			return parse(os.Stdin, cfg.Format)
+		RunE: cli.CreateCmdRunFn(cfg, vpr, func(_ *cobra.Command, args []string) error {
+			return convert(cfg, args)
 		}),
 	}
 
@@ -31,22 +36,65 @@ func newConvertCmd(cfg *config.Convert) *cobra.Command {
 	return convertCmd
 }
 
-func parse(input io.Reader, format string) error {
-	parser := convert.ParseGroups
-	switch format {
-	case "tree":
-		t := tree.New()
-		parser(input, func(name []byte, val int) {
-			t.Insert(name, uint64(val))
-		})
-		return t.SerializeTruncateNoDict(4096, os.Stdout)
-	case "trie":
-		t := transporttrie.New()
-		parser(input, func(name []byte, val int) {
-			t.Insert(name, uint64(val), true)
+func convert(cfg *config.Convert, files []string) error {
+	inputs, err := readInputs(offline.InputFormat(cfg.InputFormat), files)
+	if err != nil {
+		return err
+	}
+	matchers, err := flameql.ParseMatchers(cfg.Labels)
+	if err != nil {
+		return fmt.Errorf("invalid labels: %w", err)
+	}
+	p, err := offline.Read(inputs, offline.ReadOptions{
+		ProfileType: cfg.ProfileType,
+		Matchers:    matchers,
+	})
+	if err != nil {
+		return err
+	}
+
+	options := offline.WriteOptions{
+		Format:   offline.OutputFormat(cfg.Format),
+		MaxNodes: cfg.MaxNodes,
+	}
+	if options.Format == offline.OutputFormatHTML {
+		if options.Assets, err = webapp.Assets(); err != nil {
+			return fmt.Errorf("could not get the asset directory: %w", err)
+		}
+	}
+	if cfg.Output == "" {
+		return offline.Write(os.Stdout, p, options)
+	}
+	f, err := os.Create(cfg.Output)
+	if err != nil {
+		return err
+	}
+	if err = offline.Write(f, p, options); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func readInputs(format offline.InputFormat, files []string) ([]offline.Input, error) {
+	if len(files) == 0 {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		return []offline.Input{{Format: format, Data: b}}, nil
+	}
+	inputs := make([]offline.Input, 0, len(files))
+	for _, name := range files {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, offline.Input{
+			Name:   filepath.Base(name),
+			Format: format,
+			Data:   b,
 		})
-		return t.Serialize(os.Stdout)
-	default:
-		return fmt.Errorf("unknown format: %s", format)
 	}
+	return inputs, nil
 }
@@ -14,8 +14,8 @@ func newServerCmd(cfg *config.Server) *cobra.Command {
 		Short: "Start pyroscope server. This is the database + web-based user interface",
 
 		DisableFlagParsing: true,
-		RunE: cli.CreateCmdRunFn(cfg, vpr, func(_ *cobra.Command, _ []string) error {
-			srv, err := cli.NewServer(cfg)
+		RunE: cli.CreateCmdRunFn(cfg, vpr, func(cmd *cobra.Command, _ []string) error {
+			srv, err := cli.NewServer(cfg, cli.ConfigLoader[config.Server](cmd, vpr))
 			if err != nil {
 				return err
 			}
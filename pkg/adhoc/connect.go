@@ -25,7 +25,7 @@ func newConnect(cfg *config.Adhoc, st *storage.Storage, logger *logrus.Logger) (
 		return nil, err
 	}
 
-	upstream := direct.New(st, exporter.MetricsExporter{})
+	upstream := direct.New(st, new(exporter.MetricsExporter))
 
 	// if the sample rate is zero, use the default value
 	sampleRate := uint32(types.DefaultSampleRate)
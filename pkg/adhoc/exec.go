@@ -27,7 +27,7 @@ func newExec(cfg *config.Adhoc, args []string, st *storage.Storage, logger *logr
 		return nil, err
 	}
 
-	upstream := direct.New(st, exporter.MetricsExporter{})
+	upstream := direct.New(st, new(exporter.MetricsExporter))
 
 	// if the sample rate is zero, use the default value
 	sampleRate := uint32(types.DefaultSampleRate)
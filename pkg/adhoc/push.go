@@ -37,7 +37,7 @@ func newPush(_ *config.Adhoc, args []string, st *storage.Storage, logger *logrus
 	p := parser.New(logger, st, e)
 	return push{
 		args:    args,
-		handler: server.NewIngestHandler(kitLogrus.NewLogger(logger), p, func(*ingestion.IngestInput) {}, httputils.NewDefaultHelper(logger)),
+		handler: server.NewIngestHandler(kitLogrus.NewLogger(logger), p, config.IngestLimits{}, nil, func(*ingestion.IngestInput) {}, httputils.NewDefaultHelper(logger)),
 		logger:  logger,
 	}, nil
 }
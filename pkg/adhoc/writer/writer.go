@@ -51,7 +51,11 @@ func (w Writer) Write(t0, t1 time.Time) error {
 	profiles := 0
 
 	// The assumption is that these were the only ingested apps
-	for _, name := range w.storage.GetAppNames(context.TODO()) {
+	appNames, err := w.storage.GetAppNames(context.TODO())
+	if err != nil {
+		return fmt.Errorf("could not get app names: %w", err)
+	}
+	for _, name := range appNames {
 		skey, err := segment.ParseKey(name)
 		if err != nil {
 			w.logger.WithError(err).Error("parsing storage key")
@@ -40,7 +40,7 @@ var _ = Describe("integration", func() {
 
 		httpServer, err := admin.NewUdsHTTPServer(socketAddr, http)
 		Expect(err).ToNot(HaveOccurred())
-		ctrl := admin.NewController(logger, mockStorage{}, mockUserService{}, mockStorageService{})
+		ctrl := admin.NewController(logger, mockStorage{}, mockUserService{}, mockStorageService{}, mockConfigReloader{})
 		s, err := admin.NewServer(logger, ctrl, httpServer)
 		Expect(err).ToNot(HaveOccurred())
 		server = s
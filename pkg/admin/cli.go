@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"text/tabwriter"
 	"time"
 )
 
@@ -93,6 +94,37 @@ func (c *CLI) CleanupStorage() error {
 	return c.client.CleanupStorage()
 }
 
+// CardinalityReport prints applications with the largest number of series
+// and label keys with the largest number of values
+func (c *CLI) CardinalityReport(limit int) error {
+	report, err := c.client.GetCardinalityReport(limit)
+	if err != nil {
+		return CLIError{err}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "APPLICATION\tSERIES")
+	for _, e := range report.Apps {
+		_, _ = fmt.Fprintf(w, "%s\t%d\n", e.Name, e.Count)
+	}
+	_, _ = fmt.Fprintln(w)
+	_, _ = fmt.Fprintln(w, "LABEL\tVALUES")
+	for _, e := range report.Labels {
+		_, _ = fmt.Fprintf(w, "%s\t%d\n", e.Name, e.Count)
+	}
+
+	return w.Flush()
+}
+
+// ReloadConfig makes the server re-read its configuration file
+func (c *CLI) ReloadConfig() error {
+	if err := c.client.ReloadConfig(); err != nil {
+		return CLIError{err}
+	}
+	fmt.Println("Configuration reloaded.")
+	return nil
+}
+
 func (c *CLI) ResetUserPassword(username, password string, enable bool) error {
 	if username == "" || password == "" {
 		return fmt.Errorf("username and password are required")
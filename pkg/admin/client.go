@@ -7,10 +7,12 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/pyroscope-io/pyroscope/pkg/api"
 	"github.com/pyroscope-io/pyroscope/pkg/model/appmetadata"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
 
 	"github.com/hashicorp/go-multierror"
 )
@@ -25,6 +27,7 @@ const (
 	appsEndpoint    = "http://pyroscope/v1/apps"
 	usersEndpoint   = "http://pyroscope/v1/users"
 	storageEndpoint = "http://pyroscope/v1/storage"
+	configEndpoint  = "http://pyroscope/v1/config"
 )
 
 var (
@@ -125,6 +128,32 @@ func (c *Client) CleanupStorage() error {
 	return c.do(req)
 }
 
+func (c *Client) GetCardinalityReport(limit int) (report storage.CardinalityReport, err error) {
+	resp, err := c.httpClient.Get(storageEndpoint + "/cardinality?limit=" + strconv.Itoa(limit))
+	if err != nil {
+		return report, multierror.Append(ErrMakingRequest, err)
+	}
+	defer resp.Body.Close()
+
+	if err = checkStatusCodeOK(resp.StatusCode); err != nil {
+		return report, multierror.Append(ErrStatusCodeNotOK, err)
+	}
+
+	if err = json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return report, multierror.Append(ErrDecodingResponse, err)
+	}
+
+	return report, nil
+}
+
+func (c *Client) ReloadConfig() error {
+	req, err := http.NewRequest(http.MethodPost, configEndpoint+"/reload", nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+	return c.do(req)
+}
+
 func (c *Client) do(req *http.Request) error {
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -168,4 +168,37 @@ var _ = Describe("client", func() {
 		})
 	})
 
+	Describe("ReloadConfig", func() {
+		Context("when server returns just fine", func() {
+			BeforeEach(func() {
+				handler = http.NewServeMux()
+				handler.HandleFunc("/v1/config/reload", func(w http.ResponseWriter, r *http.Request) {
+					Expect(r.Method).To(Equal(http.MethodPost))
+					w.WriteHeader(200)
+				})
+			})
+
+			It("works", func() {
+				client, err := admin.NewClient(socketAddr, fastTimeout)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(client.ReloadConfig()).To(Succeed())
+			})
+		})
+
+		Context("when server responds with error", func() {
+			BeforeEach(func() {
+				handler = http.NewServeMux()
+				handler.HandleFunc("/v1/config/reload", func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(400)
+					fmt.Fprint(w, "invalid log level")
+				})
+			})
+
+			It("returns the error message", func() {
+				client, err := admin.NewClient(socketAddr, fastTimeout)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(client.ReloadConfig()).To(MatchError("invalid log level"))
+			})
+		})
+	})
 })
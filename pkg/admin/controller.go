@@ -7,9 +7,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/pyroscope-io/pyroscope/pkg/model/appmetadata"
 	"github.com/pyroscope-io/pyroscope/pkg/server/httputils"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
@@ -23,6 +25,7 @@ type Controller struct {
 	appService     ApplicationListerAndDeleter
 	userService    UserService
 	storageService StorageService
+	configReloader ConfigReloader
 }
 
 type UserService interface {
@@ -31,10 +34,17 @@ type UserService interface {
 
 type StorageService interface {
 	Cleanup(ctx context.Context) error
+	CardinalityReport(limit int) storage.CardinalityReport
+}
+
+// ConfigReloader re-reads the server configuration and applies
+// the changes that do not require a restart.
+type ConfigReloader interface {
+	Reload() error
 }
 
 type ApplicationListerAndDeleter interface {
-	List(ctx context.Context) (apps []appmetadata.ApplicationMetadata, err error)
+	List(ctx context.Context, filter appmetadata.Filter) (apps []appmetadata.ApplicationMetadata, err error)
 	Delete(ctx context.Context, name string) error
 }
 
@@ -42,13 +52,15 @@ func NewController(
 	log *logrus.Logger,
 	appService ApplicationListerAndDeleter,
 	userService UserService,
-	storageService StorageService) *Controller {
+	storageService StorageService,
+	configReloader ConfigReloader) *Controller {
 	return &Controller{
 		log: log,
 
 		appService:     appService,
 		userService:    userService,
 		storageService: storageService,
+		configReloader: configReloader,
 	}
 }
 
@@ -82,3 +94,21 @@ func (ctrl *Controller) StorageCleanupHandler(w http.ResponseWriter, r *http.Req
 		ctrl.writeError(w, http.StatusInternalServerError, err, "failed to clean up storage")
 	}
 }
+
+func (ctrl *Controller) StorageCardinalityHandler(w http.ResponseWriter, r *http.Request) {
+	var limit int
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit < 0 {
+			ctrl.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", v), "invalid parameter")
+			return
+		}
+	}
+	ctrl.writeResponseJSON(w, ctrl.storageService.CardinalityReport(limit))
+}
+
+func (ctrl *Controller) ConfigReloadHandler(w http.ResponseWriter, _ *http.Request) {
+	if err := ctrl.configReloader.Reload(); err != nil {
+		ctrl.writeError(w, http.StatusBadRequest, err, "failed to reload configuration")
+	}
+}
@@ -19,6 +19,7 @@ import (
 
 	"github.com/pyroscope-io/pyroscope/pkg/admin"
 	"github.com/pyroscope-io/pyroscope/pkg/model"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
 )
 
 type mockStorage struct {
@@ -26,7 +27,7 @@ type mockStorage struct {
 	deleteResult  error
 }
 
-func (m mockStorage) List(ctx context.Context) ([]appmetadata.ApplicationMetadata, error) {
+func (m mockStorage) List(context.Context, appmetadata.Filter) ([]appmetadata.ApplicationMetadata, error) {
 	return m.getAppsResult, nil
 }
 
@@ -46,6 +47,17 @@ func (mockStorageService) Cleanup(context.Context) error {
 	return nil
 }
 
+func (mockStorageService) CardinalityReport(int) storage.CardinalityReport {
+	return storage.CardinalityReport{
+		Apps:   []storage.CardinalityEstimate{{Name: "app1", Count: 10}},
+		Labels: []storage.CardinalityEstimate{{Name: "foo", Count: 5}},
+	}
+}
+
+type mockConfigReloader struct{ err error }
+
+func (m mockConfigReloader) Reload() error { return m.err }
+
 var _ = Describe("controller", func() {
 	Describe("/v1/apps", func() {
 		var svr *admin.Server
@@ -67,7 +79,7 @@ var _ = Describe("controller", func() {
 			// create a null logger, since we aren't interested
 			logger, _ := test.NewNullLogger()
 
-			ctrl := admin.NewController(logger, appSvc, mockUserService{}, mockStorageService{})
+			ctrl := admin.NewController(logger, appSvc, mockUserService{}, mockStorageService{}, mockConfigReloader{})
 			httpServer := &admin.UdsHTTPServer{}
 			server, err := admin.NewServer(logger, ctrl, httpServer)
 
@@ -160,4 +172,74 @@ var _ = Describe("controller", func() {
 			Entry("NON_VALID_METHOD", http.MethodPost),
 		)
 	})
+
+	Describe("/v1/storage/cardinality", func() {
+		var svr *admin.Server
+		var response *httptest.ResponseRecorder
+
+		BeforeEach(func() {
+			logger, _ := test.NewNullLogger()
+			ctrl := admin.NewController(logger, mockStorage{}, mockUserService{}, mockStorageService{}, mockConfigReloader{})
+			server, err := admin.NewServer(logger, ctrl, &admin.UdsHTTPServer{})
+			Expect(err).ToNot(HaveOccurred())
+			svr = server
+			response = httptest.NewRecorder()
+		})
+
+		It("returns the cardinality report", func() {
+			request, err := http.NewRequest(http.MethodGet, "/v1/storage/cardinality?limit=5", nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			svr.Handler.ServeHTTP(response, request)
+			Expect(response.Code).To(Equal(http.StatusOK))
+
+			var report storage.CardinalityReport
+			Expect(json.NewDecoder(response.Body).Decode(&report)).To(Succeed())
+			Expect(report.Apps).To(Equal([]storage.CardinalityEstimate{{Name: "app1", Count: 10}}))
+			Expect(report.Labels).To(Equal([]storage.CardinalityEstimate{{Name: "foo", Count: 5}}))
+		})
+
+		It("rejects invalid limit", func() {
+			request, err := http.NewRequest(http.MethodGet, "/v1/storage/cardinality?limit=foo", nil)
+			Expect(err).ToNot(HaveOccurred())
+
+			svr.Handler.ServeHTTP(response, request)
+			Expect(response.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+
+	Describe("/v1/config/reload", func() {
+		var reloader mockConfigReloader
+		var response *httptest.ResponseRecorder
+
+		BeforeEach(func() {
+			reloader = mockConfigReloader{}
+			response = httptest.NewRecorder()
+		})
+
+		JustBeforeEach(func() {
+			logger, _ := test.NewNullLogger()
+			ctrl := admin.NewController(logger, mockStorage{}, mockUserService{}, mockStorageService{}, reloader)
+			svr, err := admin.NewServer(logger, ctrl, &admin.UdsHTTPServer{})
+			Expect(err).ToNot(HaveOccurred())
+			request, err := http.NewRequest(http.MethodPost, "/v1/config/reload", nil)
+			Expect(err).ToNot(HaveOccurred())
+			svr.Handler.ServeHTTP(response, request)
+		})
+
+		It("reloads the configuration", func() {
+			Expect(response.Code).To(Equal(http.StatusOK))
+		})
+
+		Context("when the configuration is invalid", func() {
+			BeforeEach(func() {
+				reloader.err = fmt.Errorf("invalid log level")
+			})
+
+			It("returns the error", func() {
+				Expect(response.Code).To(Equal(http.StatusBadRequest))
+				Expect(response.Body.String()).To(ContainSubstring("invalid log level"))
+			})
+		})
+	})
 })
@@ -49,6 +49,8 @@ func NewServer(logger *logrus.Logger, ctrl *Controller, httpServer HTTPServer) (
 
 	r.HandleFunc("/v1/users/{username}", ctrl.UpdateUserHandler).Methods("PATCH")
 	r.HandleFunc("/v1/storage/cleanup", ctrl.StorageCleanupHandler).Methods("PUT")
+	r.HandleFunc("/v1/storage/cardinality", ctrl.StorageCardinalityHandler).Methods("GET")
+	r.HandleFunc("/v1/config/reload", ctrl.ConfigReloadHandler).Methods("POST")
 
 	// Global middlewares
 	r.Use(logginMiddleware)
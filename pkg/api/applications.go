@@ -11,7 +11,7 @@ import (
 )
 
 type ApplicationListerAndDeleter interface {
-	List(context.Context) ([]appmetadata.ApplicationMetadata, error)
+	List(context.Context, appmetadata.Filter) ([]appmetadata.ApplicationMetadata, error)
 	Delete(ctx context.Context, name string) error
 }
 
@@ -28,8 +28,7 @@ func NewApplicationsHandler(svc ApplicationListerAndDeleter, httpUtils httputils
 }
 
 func (h *ApplicationsHandler) GetApps(w http.ResponseWriter, r *http.Request) {
-	ctx := context.WithValue(r.Context(), "query", r.URL.Query())
-	apps, err := h.svc.List(ctx)
+	apps, err := h.svc.List(r.Context(), appmetadata.ParseFilter(r.URL.Query()))
 	if err != nil {
 		h.httpUtils.HandleError(r, w, err)
 		return
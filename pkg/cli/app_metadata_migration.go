@@ -9,12 +9,12 @@ import (
 )
 
 type AppNamesGetter interface {
-	GetAppNames(ctx context.Context) []string
+	GetAppNames(ctx context.Context) ([]string, error)
 }
 
 type AppMetadataSaver interface {
 	CreateOrUpdate(_ context.Context, _ appmetadata.ApplicationMetadata) error
-	List(context.Context) ([]appmetadata.ApplicationMetadata, error)
+	List(context.Context, appmetadata.Filter) ([]appmetadata.ApplicationMetadata, error)
 }
 
 type AppMetadataMigrator struct {
@@ -36,8 +36,11 @@ func (m *AppMetadataMigrator) Migrate() (err error) {
 	ctx := context.Background()
 
 	// Get all app names
-	appNamesFromOrigin := m.appNamesGetter.GetAppNames(ctx)
-	apps, err := m.appMetadataSaver.List(ctx)
+	appNamesFromOrigin, err := m.appNamesGetter.GetAppNames(ctx)
+	if err != nil {
+		return err
+	}
+	apps, err := m.appMetadataSaver.List(ctx, appmetadata.Filter{})
 	if err != nil {
 		return err
 	}
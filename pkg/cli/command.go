@@ -70,6 +70,21 @@ func CreateCmdRunFn(cfg interface{}, vpr *viper.Viper, fn CmdRunFn) CmdRunFn {
 	}
 }
 
+// ConfigLoader returns a function that reads the command configuration
+// again, with the same precedence of the sources as in CreateCmdRunFn.
+func ConfigLoader[T any](cmd *cobra.Command, vpr *viper.Viper) func() (*T, error) {
+	return func() (*T, error) {
+		if err := loadConfigFile(cmd, vpr); err != nil {
+			return nil, err
+		}
+		c := new(T)
+		if err := Unmarshal(vpr, c); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
 func NewViper(prefix string) *viper.Viper {
 	v := viper.New()
 	v.SetEnvPrefix(prefix)
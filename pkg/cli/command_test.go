@@ -3,6 +3,7 @@ package cli
 import (
 	"errors"
 	"os"
+	"path/filepath"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
@@ -259,3 +260,30 @@ var _ = Describe("CreateCmdRunFn", func() {
 		})
 	})
 })
+
+var _ = Describe("ConfigLoader", func() {
+	It("reads the config file again preserving precedence of arguments", func() {
+		configPath := filepath.Join(GinkgoT().TempDir(), "config.yml")
+		Expect(os.WriteFile(configPath, []byte("foo: config-value\nfoo-struct:\n  bar: config-value\n"), 0o644)).To(Succeed())
+
+		cfg := new(TestConfig)
+		vpr := NewViper("PYROSCOPE")
+		var load func() (*TestConfig, error)
+		cmd := &cobra.Command{
+			RunE: CreateCmdRunFn(cfg, vpr, func(cmd *cobra.Command, args []string) error {
+				load = ConfigLoader[TestConfig](cmd, vpr)
+				return nil
+			}),
+		}
+		cmd.SetArgs([]string{"--config", configPath, "--foo", "arg-value"})
+		PopulateFlagSet(cfg, cmd.Flags(), vpr)
+		Expect(cmd.Execute()).To(Succeed())
+		Expect(cfg.FooStruct.Bar).To(Equal("config-value"))
+
+		Expect(os.WriteFile(configPath, []byte("foo: config-value\nfoo-struct:\n  bar: new-config-value\n"), 0o644)).To(Succeed())
+		c, err := load()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.Foo).To(Equal("arg-value"))
+		Expect(c.FooStruct.Bar).To(Equal("new-config-value"))
+	})
+})
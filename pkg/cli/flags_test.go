@@ -205,11 +205,26 @@ var _ = Describe("flags", func() {
 				err := exampleCommand.Execute()
 				Expect(err).ToNot(HaveOccurred())
 				Expect(cfg).To(Equal(config.Server{
-					AnalyticsOptOut:         false,
-					Config:                  "testdata/server.yml",
-					LogLevel:                "debug",
-					BadgerLogLevel:          "error",
-					StoragePath:             "/var/lib/pyroscope",
+					AnalyticsOptOut: false,
+					Config:          "testdata/server.yml",
+					LogLevel:        "debug",
+					BadgerLogLevel:  "error",
+					StorageBackend:  "clickhouse",
+					StoragePath:     "/var/lib/pyroscope",
+					WAL: config.WAL{
+						SegmentSize: 16 * bytesize.MB,
+						MaxSize:     bytesize.GB,
+					},
+					IngestLimits: config.IngestLimits{
+						MaxDecompressedSize: 512 * bytesize.MB,
+						MemoryBudget:        bytesize.GB,
+					},
+					PprofDelta: config.PprofDelta{
+						Enabled:   false,
+						MaxSeries: 10000,
+						TTL:       time.Hour,
+					},
+					AppMetadataErdaLabels:   true,
 					APIBindAddr:             ":4040",
 					BaseURL:                 "",
 					CacheEvictThreshold:     0.25,
@@ -232,6 +247,9 @@ var _ = Describe("flags", func() {
 						Zero: 100 * time.Second,
 						One:  1000 * time.Second,
 					},
+					StorageQuota: config.StorageQuota{
+						CheckInterval: time.Hour,
+					},
 					SampleRate:          0,
 					OutOfSpaceThreshold: 0,
 					CacheDimensionSize:  0,
@@ -305,7 +323,9 @@ var _ = Describe("flags", func() {
 					AdminSocketPath: "/tmp/pyroscope.sock",
 
 					RemoteWrite: config.RemoteWrite{
-						Enabled: true,
+						Enabled:     true,
+						AckMode:     "any",
+						SinkTimeout: 10 * time.Second,
 					},
 
 					ScrapeConfigs: []*scrape.Config{
@@ -330,6 +350,9 @@ var _ = Describe("flags", func() {
 							},
 						},
 					},
+					ScrapeSharding: config.ScrapeSharding{
+						Peers: []string{},
+					},
 				}))
 			})
 
@@ -0,0 +1,185 @@
+package cli
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
+	"github.com/pyroscope-io/pyroscope/pkg/remotewrite"
+)
+
+// remoteWriteTarget is a running remote write target: profiles
+// ingested to the sink are queued and sent by the client.
+type remoteWriteTarget struct {
+	name string
+	// config of the target as specified by the user.
+	config config.RemoteWriteTarget
+	sink   ingestion.Sink
+	queue  *remotewrite.IngestionQueue
+	client *remotewrite.Client
+	// Metrics of the target are unregistered once it is stopped,
+	// so that the target can be created again.
+	metrics *trackingRegisterer
+}
+
+func (svc *serverService) newRemoteWriteTarget(name string, t config.RemoteWriteTarget) (*remoteWriteTarget, error) {
+	logger := svc.logger.WithField("remote_target", name)
+	logger.Debug("Initializing remote write target")
+
+	target := remoteWriteTarget{
+		name:    name,
+		config:  t,
+		metrics: &trackingRegisterer{Registerer: prometheus.DefaultRegisterer},
+	}
+	targetWAL, err := svc.openWAL(target.metrics, "remote-write-"+name, filepath.Join("wal", "remote-write", url.PathEscape(name)))
+	if err != nil {
+		target.metrics.unregisterAll()
+		return nil, fmt.Errorf("remote write queue wal: %w", err)
+	}
+	if t.SpoolPath == "" {
+		t.SpoolPath = filepath.Join(svc.config.StoragePath, "spool", "remote-write", url.PathEscape(name))
+	}
+	target.client = remotewrite.NewClient(logger, target.metrics, name, t)
+	target.queue = remotewrite.NewIngestionQueue(logger, target.metrics, target.client, name, t, targetWAL)
+	target.sink = ingestion.Sink{
+		Name:     name,
+		Ingester: remotewrite.NewFilter(target.metrics, target.queue, name, t),
+	}
+	return &target, nil
+}
+
+func (t *remoteWriteTarget) stop() {
+	t.queue.Stop()
+	t.client.Stop()
+	t.metrics.unregisterAll()
+}
+
+// applyRemoteWriteTargets starts new and modified remote write targets,
+// and stops removed and modified ones. Targets that have not changed
+// keep running. New targets are started before anything is changed:
+// if any of them fails to start, the running targets are not affected.
+// If a modified target fails to start, its previous version is restored.
+func (svc *serverService) applyRemoteWriteTargets(targets map[string]config.RemoteWriteTarget) error {
+	next := make(map[string]*remoteWriteTarget, len(targets))
+	var stale, modified []*remoteWriteTarget
+	for name, t := range svc.remoteWriteTargets {
+		c, ok := targets[name]
+		switch {
+		case !ok:
+			stale = append(stale, t)
+		case reflect.DeepEqual(t.config, c):
+			next[name] = t
+		default:
+			modified = append(modified, t)
+		}
+	}
+
+	var added []*remoteWriteTarget
+	for name, t := range targets {
+		if _, ok := svc.remoteWriteTargets[name]; ok {
+			continue
+		}
+		target, err := svc.newRemoteWriteTarget(name, t)
+		if err != nil {
+			for _, a := range added {
+				a.stop()
+			}
+			return fmt.Errorf("remote write target %q: %w", name, err)
+		}
+		added = append(added, target)
+	}
+	for _, t := range added {
+		next[t.name] = t
+	}
+
+	// Modified targets must be stopped before they are created
+	// again, because the WAL and spool directories are reused.
+	svc.setRemoteWriteSinks(next)
+	for _, t := range append(stale, modified...) {
+		svc.logger.WithField("remote_target", t.name).Info("stopping remote write target")
+		t.stop()
+	}
+	var err error
+	for _, prev := range modified {
+		target, targetErr := svc.newRemoteWriteTarget(prev.name, targets[prev.name])
+		if targetErr != nil {
+			err = multierror.Append(err, fmt.Errorf("remote write target %q: %w", prev.name, targetErr))
+			if target, targetErr = svc.newRemoteWriteTarget(prev.name, prev.config); targetErr != nil {
+				svc.logger.WithError(targetErr).WithField("remote_target", prev.name).
+					Error("failed to restore remote write target")
+				continue
+			}
+		}
+		next[prev.name] = target
+	}
+	svc.remoteWriteTargets = next
+	svc.setRemoteWriteSinks(next)
+	return err
+}
+
+// remoteWriteTargetConfigs returns configs of the running targets.
+func (svc *serverService) remoteWriteTargetConfigs() map[string]config.RemoteWriteTarget {
+	c := make(map[string]config.RemoteWriteTarget, len(svc.remoteWriteTargets))
+	for name, t := range svc.remoteWriteTargets {
+		c[name] = t.config
+	}
+	return c
+}
+
+func (svc *serverService) setRemoteWriteSinks(targets map[string]*remoteWriteTarget) {
+	names := make([]string, 0, len(targets))
+	for name := range targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	sinks := make([]ingestion.Sink, 0, len(svc.localSinks)+len(names))
+	sinks = append(sinks, svc.localSinks...)
+	for _, name := range names {
+		sinks = append(sinks, targets[name].sink)
+	}
+	svc.remoteWrite.SetSinks(sinks...)
+}
+
+// trackingRegisterer keeps track of the registered collectors,
+// so that they can be unregistered at once.
+type trackingRegisterer struct {
+	prometheus.Registerer
+
+	m          sync.Mutex
+	collectors []prometheus.Collector
+}
+
+func (r *trackingRegisterer) Register(c prometheus.Collector) error {
+	if err := r.Registerer.Register(c); err != nil {
+		return err
+	}
+	r.m.Lock()
+	r.collectors = append(r.collectors, c)
+	r.m.Unlock()
+	return nil
+}
+
+func (r *trackingRegisterer) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func (r *trackingRegisterer) unregisterAll() {
+	r.m.Lock()
+	defer r.m.Unlock()
+	for _, c := range r.collectors {
+		r.Registerer.Unregister(c)
+	}
+	r.collectors = nil
+}
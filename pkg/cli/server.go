@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
@@ -15,9 +17,10 @@ import (
 
 	// revive:disable:blank-imports register discoverer
 	"github.com/pyroscope-io/pyroscope/pkg/baseurl"
-	"github.com/pyroscope-io/pyroscope/pkg/remotewrite"
 	_ "github.com/pyroscope-io/pyroscope/pkg/scrape/discovery/aws"
 	_ "github.com/pyroscope-io/pyroscope/pkg/scrape/discovery/consul"
+	_ "github.com/pyroscope-io/pyroscope/pkg/scrape/discovery/dns"
+	_ "github.com/pyroscope-io/pyroscope/pkg/scrape/discovery/docker"
 	_ "github.com/pyroscope-io/pyroscope/pkg/scrape/discovery/file"
 	_ "github.com/pyroscope-io/pyroscope/pkg/scrape/discovery/http"
 	_ "github.com/pyroscope-io/pyroscope/pkg/scrape/discovery/kubernetes"
@@ -37,6 +40,7 @@ import (
 	"github.com/pyroscope-io/pyroscope/pkg/service"
 	"github.com/pyroscope-io/pyroscope/pkg/sqlstore"
 	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/wal"
 	"github.com/pyroscope-io/pyroscope/pkg/util/debug"
 )
 
@@ -59,14 +63,25 @@ type serverService struct {
 	discoveryManager *discovery.Manager
 	scrapeManager    *scrape.Manager
 	database         *sqlstore.SQLStore
-	remoteWriteQueue []*remotewrite.IngestionQueue
+	metricsExporter  *exporter.MetricsExporter
+	// remoteWrite ingests profiles to the local storage, unless
+	// local writes are disabled, and to the remote write targets.
+	remoteWrite        *ingestion.Parallelizer
+	localSinks         []ingestion.Sink
+	remoteWriteTargets map[string]*remoteWriteTarget
+
+	// loadConfig reads the configuration again on reload.
+	loadConfig func() (*config.Server, error)
+	// reloadMu serializes configuration changes.
+	reloadMu sync.Mutex
+	closed   bool
 
 	stopped chan struct{}
 	done    chan struct{}
 	group   *errgroup.Group
 }
 
-func newServerService(c *config.Server) (*serverService, error) {
+func newServerService(c *config.Server, loadConfig func() (*config.Server, error)) (*serverService, error) {
 	logLevel, err := logrus.ParseLevel(c.LogLevel)
 	if err != nil {
 		return nil, err
@@ -80,10 +95,11 @@ func newServerService(c *config.Server) (*serverService, error) {
 	}
 
 	svc := serverService{
-		config:  c,
-		logger:  logger,
-		stopped: make(chan struct{}),
-		done:    make(chan struct{}),
+		config:     c,
+		logger:     logger,
+		loadConfig: loadConfig,
+		stopped:    make(chan struct{}),
+		done:       make(chan struct{}),
 	}
 
 	diskPressure := health.DiskPressure{
@@ -127,7 +143,7 @@ func newServerService(c *config.Server) (*serverService, error) {
 	if svc.config.EnableExperimentalAdmin {
 		socketPath := svc.config.AdminSocketPath
 		userService := service.NewUserService(svc.database.DB())
-		adminController := admin.NewController(svc.logger, appSvc, userService, svc.storage)
+		adminController := admin.NewController(svc.logger, appSvc, userService, svc.storage, &svc)
 		httpClient, err := admin.NewHTTPOverUDSClient(socketPath)
 		if err != nil {
 			return nil, fmt.Errorf("admin: %w", err)
@@ -149,18 +165,22 @@ func newServerService(c *config.Server) (*serverService, error) {
 	}
 
 	exportedMetricsRegistry := prometheus.NewRegistry()
-	metricsExporter, err := exporter.NewExporter(svc.config.MetricsExportRules, exportedMetricsRegistry)
+	svc.metricsExporter, err = exporter.NewExporter(svc.config.MetricsExportRules, exportedMetricsRegistry)
 	if err != nil {
 		return nil, fmt.Errorf("new metric exporter: %w", err)
 	}
 
-	svc.storageQueue = storage.NewIngestionQueue(svc.logger, svc.storage, prometheus.DefaultRegisterer, storageConfig)
+	storageWAL, err := svc.openWAL(prometheus.DefaultRegisterer, "storage", filepath.Join("wal", "storage"))
+	if err != nil {
+		return nil, fmt.Errorf("storage queue wal: %w", err)
+	}
+	svc.storageQueue = storage.NewIngestionQueue(svc.logger, svc.storage, prometheus.DefaultRegisterer, storageConfig, storageWAL)
 
 	defaultMetricsRegistry := prometheus.DefaultRegisterer
 
 	var ingester ingestion.Ingester
 	if !svc.config.RemoteWrite.Enabled || !svc.config.RemoteWrite.DisableLocalWrites {
-		ingester = parser.New(svc.logger, svc.storageQueue, metricsExporter)
+		ingester = parser.New(svc.logger, svc.storageQueue, svc.metricsExporter)
 	} else {
 		ingester = ingestion.NewNoopIngester()
 	}
@@ -176,24 +196,21 @@ func newServerService(c *config.Server) (*serverService, error) {
 			return nil, fmt.Errorf("remote write is enabled but no targets are set up")
 		}
 
-		remoteClients := make([]ingestion.Ingester, len(svc.config.RemoteWrite.Targets))
-		svc.remoteWriteQueue = make([]*remotewrite.IngestionQueue, len(svc.config.RemoteWrite.Targets))
-
-		i := 0
-		for targetName, t := range svc.config.RemoteWrite.Targets {
-			targetLogger := logger.WithField("remote_target", targetName)
-			targetLogger.Debug("Initializing remote write target")
-
-			remoteClient := remotewrite.NewClient(targetLogger, defaultMetricsRegistry, targetName, t)
-			q := remotewrite.NewIngestionQueue(targetLogger, defaultMetricsRegistry, remoteClient, targetName, t)
-
-			remoteClients[i] = q
-			svc.remoteWriteQueue[i] = q
-			i++
+		var ackMode ingestion.AckMode
+		if ackMode, err = ingestion.ParseAckMode(svc.config.RemoteWrite.AckMode); err != nil {
+			return nil, fmt.Errorf("remote write: %w", err)
 		}
-
-		ingesters := append([]ingestion.Ingester{ingester}, remoteClients...)
-		ingester = ingestion.NewParallelizer(svc.logger, ingesters...)
+		if !svc.config.RemoteWrite.DisableLocalWrites {
+			svc.localSinks = []ingestion.Sink{{Name: "local", Ingester: ingester}}
+		}
+		svc.remoteWrite = ingestion.NewParallelizer(svc.logger, ingestion.ParallelizerConfig{
+			Mode:    ackMode,
+			Timeout: svc.config.RemoteWrite.SinkTimeout,
+		})
+		if err = svc.applyRemoteWriteTargets(svc.config.RemoteWrite.Targets); err != nil {
+			return nil, err
+		}
+		ingester = svc.remoteWrite
 	}
 	if !svc.config.NoSelfProfiling {
 		svc.selfProfiling = selfprofiling.NewSession(svc.logger, ingester, "pyroscope.server", svc.config.SelfProfilingTags)
@@ -203,6 +220,16 @@ func newServerService(c *config.Server) (*serverService, error) {
 		svc.logger.WithField("component", "scrape-manager"),
 		ingester,
 		defaultMetricsRegistry)
+	sharding, err := scrape.NewSharding(svc.config.ScrapeSharding)
+	if err != nil {
+		return nil, err
+	}
+	if sharding.Enabled() {
+		svc.logger.WithField("shard", sharding.Index).
+			WithField("shards", sharding.Shards).
+			Info("scrape sharding enabled")
+	}
+	svc.scrapeManager.ApplySharding(sharding)
 
 	svc.controller, err = server.New(server.Config{
 		Configuration:           svc.config,
@@ -267,7 +294,10 @@ func (svc *serverService) Start() error {
 
 	// Scrape and Discovery managers have to be initialized
 	// with ApplyConfig before starting running.
-	if err := svc.applyScrapeConfigs(svc.config); err != nil {
+	svc.reloadMu.Lock()
+	err := svc.applyScrapeConfigs(svc.config.ScrapeConfigs)
+	svc.reloadMu.Unlock()
+	if err != nil {
 		return err
 	}
 	g.Go(func() error {
@@ -324,10 +354,14 @@ func (svc *serverService) stop() {
 		svc.selfProfiling.Stop()
 	}
 
-	if svc.config.RemoteWrite.Enabled {
+	// Configuration can't be changed once the server is stopping.
+	svc.reloadMu.Lock()
+	svc.closed = true
+	svc.reloadMu.Unlock()
+	if svc.remoteWrite != nil {
 		svc.logger.Debug("stopping remote queues")
-		for _, q := range svc.remoteWriteQueue {
-			q.Stop()
+		for _, t := range svc.remoteWriteTargets {
+			t.stop()
 		}
 	}
 
@@ -351,19 +385,26 @@ func (svc *serverService) stop() {
 	}
 }
 
-func (svc *serverService) ApplyConfig(c *config.Server) error {
-	return svc.applyScrapeConfigs(c)
+// openWAL opens the write-ahead log of an ingestion queue in the
+// storage directory. Nil is returned if the log is disabled.
+func (svc *serverService) openWAL(reg prometheus.Registerer, name, dir string) (*wal.WAL, error) {
+	if !svc.config.WAL.Enabled {
+		return nil, nil
+	}
+	return wal.Open(svc.logger.WithField("wal", name), reg,
+		filepath.Join(svc.config.StoragePath, dir), wal.Options{
+			Name:        name,
+			SegmentSize: int64(svc.config.WAL.SegmentSize),
+			MaxSize:     int64(svc.config.WAL.MaxSize),
+		})
 }
 
-func (svc *serverService) applyScrapeConfigs(c *config.Server) error {
-	if err := loadScrapeConfigsFromFile(c); err != nil {
-		return fmt.Errorf("could not load scrape configs from %s: %w", c.Config, err)
-	}
-	if err := svc.discoveryManager.ApplyConfig(discoveryConfigs(c.ScrapeConfigs)); err != nil {
+func (svc *serverService) applyScrapeConfigs(configs []*sc.Config) error {
+	if err := svc.discoveryManager.ApplyConfig(discoveryConfigs(configs)); err != nil {
 		// discoveryManager.ApplyConfig never return errors.
 		return err
 	}
-	return svc.scrapeManager.ApplyConfig(c.ScrapeConfigs)
+	return svc.scrapeManager.ApplyConfig(configs)
 }
 
 func discoveryConfigs(cfg []*sc.Config) map[string]discovery.Configs {
@@ -390,6 +431,13 @@ func loadScrapeConfigsFromFile(c *config.Server) error {
 	if err = yaml.Unmarshal([]byte(performSubstitutions(b)), &s); err != nil {
 		return err
 	}
+	jobs := make(map[string]struct{}, len(s.ScrapeConfigs))
+	for _, x := range s.ScrapeConfigs {
+		if _, ok := jobs[x.JobName]; ok {
+			return fmt.Errorf("found multiple scrape configs with job name %q", x.JobName)
+		}
+		jobs[x.JobName] = struct{}{}
+	}
 	// Populate scrape configs.
 	c.ScrapeConfigs = s.ScrapeConfigs
 	return nil
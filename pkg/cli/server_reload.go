@@ -0,0 +1,149 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape"
+)
+
+var (
+	errReloadNotSupported = errors.New("configuration reload is not supported")
+	errServerStopping     = errors.New("server is stopping")
+)
+
+// reloadableSettings are the server settings that are applied on reload.
+// Changes of other settings require restart.
+var reloadableSettings = map[string]struct{}{
+	"log-level":            {},
+	"metrics-export-rules": {},
+	"scrape-configs":       {},
+	"scrape-sharding":      {},
+}
+
+// Reload reads the configuration again and applies the changes.
+func (svc *serverService) Reload() error {
+	if svc.loadConfig == nil {
+		return errReloadNotSupported
+	}
+	svc.reloadMu.Lock()
+	defer svc.reloadMu.Unlock()
+	c, err := svc.loadConfig()
+	if err != nil {
+		return err
+	}
+	return svc.applyConfig(c)
+}
+
+// ApplyConfig applies changes of the settings that can be changed at
+// runtime: scrape configs and sharding, metrics export rules, remote write
+// targets, and log level. The configuration is validated first: if it is
+// invalid, the running server is not affected.
+func (svc *serverService) ApplyConfig(c *config.Server) error {
+	svc.reloadMu.Lock()
+	defer svc.reloadMu.Unlock()
+	return svc.applyConfig(c)
+}
+
+func (svc *serverService) applyConfig(c *config.Server) error {
+	if svc.closed {
+		return errServerStopping
+	}
+	level, err := logrus.ParseLevel(c.LogLevel)
+	if err != nil {
+		return fmt.Errorf("invalid log level: %w", err)
+	}
+	if err = loadScrapeConfigsFromFile(c); err != nil {
+		return fmt.Errorf("could not load scrape configs from %s: %w", c.Config, err)
+	}
+	sharding, err := scrape.NewSharding(c.ScrapeSharding)
+	if err != nil {
+		return err
+	}
+	if svc.remoteWrite != nil {
+		if err = loadRemoteWriteTargetConfigsFromFile(c); err != nil {
+			return fmt.Errorf("could not load remote write targets from %s: %w", c.Config, err)
+		}
+		if len(c.RemoteWrite.Targets) == 0 {
+			return fmt.Errorf("remote write is enabled but no targets are set up")
+		}
+	}
+
+	// The configuration is valid: changes that may fail are applied
+	// first, so that the log level and scrape sharding are not changed
+	// if the rest of the configuration can't be applied. The exporter
+	// and remote write targets are not changed if they fail to apply.
+	if !reflect.DeepEqual(svc.config.MetricsExportRules, c.MetricsExportRules) {
+		if err = svc.metricsExporter.ApplyRules(c.MetricsExportRules); err != nil {
+			return fmt.Errorf("invalid metrics export rules: %w", err)
+		}
+		svc.config.MetricsExportRules = c.MetricsExportRules
+		svc.logger.Info("metrics export rules updated")
+	}
+
+	if svc.remoteWrite != nil && !reflect.DeepEqual(svc.config.RemoteWrite.Targets, c.RemoteWrite.Targets) {
+		err = svc.applyRemoteWriteTargets(c.RemoteWrite.Targets)
+		svc.config.RemoteWrite.Targets = svc.remoteWriteTargetConfigs()
+		if err != nil {
+			return err
+		}
+		svc.logger.Info("remote write targets updated")
+	}
+
+	if !reflect.DeepEqual(svc.config.ScrapeConfigs, c.ScrapeConfigs) {
+		err = svc.applyScrapeConfigs(c.ScrapeConfigs)
+		svc.config.ScrapeConfigs = c.ScrapeConfigs
+		if err != nil {
+			return err
+		}
+		svc.logger.Info("scrape configs updated")
+	}
+
+	if sharding != svc.scrapeManager.Sharding() {
+		svc.scrapeManager.ApplySharding(sharding)
+		svc.logger.WithField("shard", sharding.Index).
+			WithField("shards", sharding.Shards).
+			Info("scrape sharding updated")
+	}
+
+	if level != svc.logger.GetLevel() {
+		svc.logger.SetLevel(level)
+		svc.logger.WithField("level", level).Info("log level changed")
+	}
+
+	if changed := changedSettings(svc.config, c); len(changed) > 0 {
+		svc.logger.WithField("settings", strings.Join(changed, ", ")).
+			Warn("changes of the settings will take effect after restart")
+	}
+
+	return nil
+}
+
+// changedSettings returns names of the top-level server settings that
+// differ and can't be applied on reload. Remote write targets are the
+// only nested settings that can be changed at runtime.
+func changedSettings(a, b *config.Server) []string {
+	x, y := *a, *b
+	x.RemoteWrite.Targets, y.RemoteWrite.Targets = nil, nil
+	va, vb := reflect.ValueOf(x), reflect.ValueOf(y)
+	var changed []string
+	for i := 0; i < va.NumField(); i++ {
+		f := va.Type().Field(i)
+		name := strings.Split(f.Tag.Get("mapstructure"), ",")[0]
+		if name == "-" {
+			name = strings.Split(f.Tag.Get("yaml"), ",")[0]
+		}
+		if _, ok := reloadableSettings[name]; ok || name == "" {
+			continue
+		}
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	return changed
+}
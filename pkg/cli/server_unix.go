@@ -13,8 +13,10 @@ import (
 	"github.com/pyroscope-io/pyroscope/pkg/config"
 )
 
-func NewServer(c *config.Server) (*Server, error) {
-	svc, err := newServerService(c)
+// NewServer creates a new server. If loadConfig is not nil, the server
+// reloads its configuration on SIGHUP and on the admin API request.
+func NewServer(c *config.Server, loadConfig func() (*config.Server, error)) (*Server, error) {
+	svc, err := newServerService(c, loadConfig)
 	if err != nil {
 		return nil, fmt.Errorf("could not initialize server: %w", err)
 	}
@@ -32,11 +34,19 @@ func (s *Server) Start() error {
 
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
 	for {
 		select {
 		case err := <-exited:
 			return err
 
+		case <-reload:
+			s.svc.logger.Info("reloading configuration")
+			if err := s.svc.Reload(); err != nil {
+				s.svc.logger.WithError(err).Error("failed to reload configuration")
+			}
+
 		case <-sigs:
 			s.svc.logger.Info("stopping server")
 			stopTime := time.Now()
@@ -6,7 +6,7 @@ import (
 	"github.com/pyroscope-io/pyroscope/pkg/config"
 )
 
-func NewServer(_ *config.Server) (*Server, error) {
+func NewServer(_ *config.Server, _ func() (*config.Server, error)) (*Server, error) {
 	return nil, fmt.Errorf("server mode is not supported on Windows")
 }
 
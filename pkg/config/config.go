@@ -12,6 +12,7 @@ import (
 	"time"
 
 	scrape "github.com/pyroscope-io/pyroscope/pkg/scrape/config"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/relabel"
 	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
 )
 
@@ -106,10 +107,21 @@ type Server struct {
 	LogLevel       string `def:"info" desc:"log level: debug|info|warn|error" mapstructure:"log-level"`
 	BadgerLogLevel string `def:"error" desc:"log level: debug|info|warn|error" mapstructure:"badger-log-level"`
 
+	StorageBackend         string  `def:"clickhouse" desc:"storage backend: clickhouse|embedded. The embedded backend keeps data on disk in storage-path" mapstructure:"storage-backend"`
 	StoragePath            string  `def:"<installPrefix>/var/lib/pyroscope" desc:"directory where pyroscope stores profiling data" mapstructure:"storage-path"`
 	StorageQueueSize       int     `desc:"storage queue size" mapstructure:"storage-queue-size"`
 	StorageQueueWorkers    int     `desc:"number of workers handling internal storage queue" mapstructure:"storage-queue-workers"`
 	MinFreeSpacePercentage float64 `def:"5" desc:"percentage of available disk space at which ingestion requests are discarded. Defaults to 5% but not less than 1GB. Set 0 to disable" mapstructure:"min-free-space-percentage"`
+	StorageRollups         bool    `def:"false" desc:"write rollups: profiling data pre-merged at 1m, 1h and 1d resolutions, which are read instead of the raw data for long time ranges. Rollups multiply the amount of data written" mapstructure:"storage-rollups"`
+
+	StorageQuota      StorageQuota      `mapstructure:"storage-quota"`
+	CardinalityLimits CardinalityLimits `mapstructure:"cardinality-limits"`
+	WAL               WAL               `mapstructure:"wal"`
+	IngestLimits      IngestLimits      `mapstructure:"ingest-limits"`
+	PprofDelta        PprofDelta        `mapstructure:"pprof-delta"`
+
+	AppMetadataLabels     map[string]string `name:"app-metadata-label" def:"" desc:"application metadata attribute to segment key label mapping in attribute=label form. The flag may be specified multiple times" mapstructure:"app-metadata-labels" yaml:"app-metadata-labels"`
+	AppMetadataErdaLabels bool              `def:"true" desc:"map Erda labels (DICE_WORKSPACE, POD_IP, etc.) to application metadata attributes, unless app-metadata-label is specified" mapstructure:"app-metadata-erda-labels"`
 
 	ExemplarsBatchQueueSize int           `deprecated:"true" mapstructure:"exemplars-batch-queue-size"`
 	ExemplarsBatchDuration  time.Duration `deprecated:"true" mapstructure:"exemplars-batch-duration"`
@@ -164,7 +176,8 @@ type Server struct {
 	AdhocDataPath    string `def:"<defaultAdhocDataPath>" desc:"directory where pyroscope stores adhoc profiles" mapstructure:"adhoc-data-path"`
 	AdhocMaxFileSize int    `def:"52428800" desc:"maximum size of adhoc profile file. To remove any size limitations, set the value to -1" mapstructure:"adhoc-max-file-size"`
 
-	ScrapeConfigs []*scrape.Config `yaml:"scrape-configs" mapstructure:"-"`
+	ScrapeConfigs  []*scrape.Config `yaml:"scrape-configs" mapstructure:"-"`
+	ScrapeSharding ScrapeSharding   `yaml:"scrape-sharding" mapstructure:"scrape-sharding"`
 
 	NoSelfProfiling   bool              `def:"false" desc:"disable profiling of pyroscope itself" mapstructure:"no-self-profiling"`
 	SelfProfilingTags map[string]string `name:"self-profiling-tag" def:"" desc:"tag in key=value form. The flag may be specified multiple times" mapstructure:"self-profiling-tags" yaml:"self-profiling-tags"`
@@ -172,6 +185,8 @@ type Server struct {
 	RemoteWrite RemoteWrite `yaml:"remote-write" mapstructure:"remote-write"`
 	RemoteRead  RemoteRead  `yaml:"remote-read" mapstructure:"remote-read"`
 
+	OTLP OTLP `yaml:"otlp" mapstructure:"otlp"`
+
 	DisableExportToFlamegraphDotCom bool `def:"false" desc:"disable exporting to flamegraph.com in the UI" mapstructure:"disable-export-to-flamegraph-dot-com"`
 
 	EnableExperimentalExemplarsPage bool `def:"false" desc:"whether to enable the experimental exemplars page" mapstructure:"enable-experimental-exemplars-page"`
@@ -191,6 +206,39 @@ type RetentionLevels struct {
 	Two  time.Duration `name:"2" deprecated:"true" mapstructure:"2"`
 }
 
+type StorageQuota struct {
+	DB  bytesize.ByteSize `def:"" desc:"max size of every storage DB (main, dicts, dimensions, segments, trees, profiles) at which ingestion requests are discarded. Disabled by default" mapstructure:"db"`
+	App bytesize.ByteSize `def:"" desc:"max size of profiling data of a single application at which its ingestion requests are discarded. Disabled by default" mapstructure:"app"`
+
+	CheckInterval time.Duration `def:"1h" desc:"interval at which the storage size is checked against the quota. Computing the application sizes requires a scan of the profiling data" mapstructure:"check-interval"`
+}
+
+type CardinalityLimits struct {
+	SeriesPerApp   int `def:"0" desc:"max number of series (unique label sets) of a single application. Ingestion requests creating new series are discarded once the limit is reached. Set 0 to disable" mapstructure:"series-per-app"`
+	ValuesPerLabel int `def:"0" desc:"max number of values of a single label key. Ingestion requests introducing new values are discarded once the limit is reached. Set 0 to disable" mapstructure:"values-per-label"`
+}
+
+type WAL struct {
+	Enabled     bool              `def:"false" desc:"whether to persist accepted ingestion requests in a write-ahead log in storage-path before they are acknowledged. Pending requests are replayed on startup" mapstructure:"enabled"`
+	SegmentSize bytesize.ByteSize `def:"16MB" desc:"size of a write-ahead log segment file" mapstructure:"segment-size"`
+	MaxSize     bytesize.ByteSize `def:"1GB" desc:"max size of every write-ahead log (storage, remote write targets) at which ingestion requests are discarded. Set 0 to disable" mapstructure:"max-size"`
+}
+
+type IngestLimits struct {
+	MaxDecompressedSize bytesize.ByteSize `def:"512MB" desc:"max size of a profile after decompression. Larger ingestion requests are rejected with 413 status. Set 0 to disable" mapstructure:"max-decompressed-size"`
+	MemoryBudget        bytesize.ByteSize `def:"1GB" desc:"max amount of memory a single ingestion request may use to hold the request body and decompressed profiles. Larger ingestion requests are rejected with 413 status. Set 0 to disable" mapstructure:"memory-budget"`
+}
+
+type PprofDelta struct {
+	Enabled   bool          `def:"false" desc:"compute deltas of cumulative sample types (e.g. alloc_space) of pprof profiles pushed without the previous profile. The last profile of every series is kept in memory" mapstructure:"enabled"`
+	MaxSeries int           `def:"10000" desc:"max number of series the last cumulative profile is kept in memory for. Least recently updated series are evicted first" mapstructure:"max-series"`
+	TTL       time.Duration `def:"1h" desc:"max age of the last cumulative profile of a series. Older profiles are not used for delta computation. Set 0 to disable" mapstructure:"ttl"`
+}
+
+type OTLP struct {
+	GRPCBindAddr string `def:"" desc:"address of the gRPC server receiving OpenTelemetry profiles. OTLP/HTTP profiles are always accepted at /v1development/profiles. Disabled by default" mapstructure:"grpc-bind-addr"`
+}
+
 type Auth struct {
 	SignupDefaultRole string `json:"-" deprecated:"true" def:"ReadOnly" desc:"specifies which role will be granted to a newly signed up user. Supported roles: Admin, ReadOnly. Defaults to ReadOnly" mapstructure:"signup-default-role"`
 
@@ -270,7 +318,12 @@ type GithubOauth struct {
 }
 
 type Convert struct {
-	Format string `def:"tree" mapstructure:"format"`
+	Format      string `def:"tree" desc:"format of the output profile: tree|trie|pprof|collapsed|speedscope|json|html" mapstructure:"format"`
+	InputFormat string `def:"groups" desc:"format of the input profiles: groups|collapsed|pprof|jfr|perf_script|speedscope|cpuprofile|gecko|json" mapstructure:"input-format"`
+	Output      string `def:"" desc:"file to write the output profile to, stdout is used if empty" mapstructure:"output"`
+	ProfileType string `def:"" desc:"profile type to convert if the input has several of them, e.g. cpu or inuse_space" mapstructure:"profile-type"`
+	Labels      string `def:"" desc:"only include samples with labels matching the given FlameQL tag matchers" mapstructure:"labels"`
+	MaxNodes    int    `def:"8192" desc:"max number of nodes in json and html output" mapstructure:"max-nodes"`
 }
 
 type CombinedDbManager struct {
@@ -372,10 +425,12 @@ type EBPF struct {
 
 // TODO how to abstract this better?
 type Admin struct {
-	AdminAppDelete         AdminAppDelete         `skip:"true" mapstructure:",squash"`
-	AdminAppGet            AdminAppGet            `skip:"true" mapstructure:",squash"`
-	AdminUserPasswordReset AdminUserPasswordReset `skip:"true" mapstructure:",squash"`
-	AdminStorageCleanup    AdminStorageCleanup    `skip:"true" mapstructure:",squash"`
+	AdminAppDelete          AdminAppDelete          `skip:"true" mapstructure:",squash"`
+	AdminAppGet             AdminAppGet             `skip:"true" mapstructure:",squash"`
+	AdminUserPasswordReset  AdminUserPasswordReset  `skip:"true" mapstructure:",squash"`
+	AdminStorageCleanup     AdminStorageCleanup     `skip:"true" mapstructure:",squash"`
+	AdminStorageCardinality AdminStorageCardinality `skip:"true" mapstructure:",squash"`
+	AdminConfigReload       AdminConfigReload       `skip:"true" mapstructure:",squash"`
 }
 
 type AdminAppGet struct {
@@ -403,15 +458,36 @@ type AdminStorageCleanup struct {
 	Timeout    time.Duration `def:"30m" desc:"timeout for the server to respond" mapstructure:"timeout"`
 }
 
+type AdminStorageCardinality struct {
+	SocketPath string        `def:"/tmp/pyroscope.sock" desc:"path where the admin server socket was created." mapstructure:"socket-path"`
+	Timeout    time.Duration `def:"30m" desc:"timeout for the server to respond" mapstructure:"timeout"`
+	Limit      int           `def:"10" desc:"max number of applications and label keys to list" mapstructure:"limit"`
+}
+
+type AdminConfigReload struct {
+	SocketPath string        `def:"/tmp/pyroscope.sock" desc:"path where the admin server socket was created." mapstructure:"socket-path"`
+	Timeout    time.Duration `def:"30m" desc:"timeout for the server to respond" mapstructure:"timeout"`
+}
+
 type Database struct {
 	Type string `def:"sqlite3" desc:"" mapstructure:"type"`
 	URL  string `def:"" desc:"" mapstructure:"url"`
 }
 
+type ScrapeSharding struct {
+	Shards     int      `def:"0" desc:"number of servers scrape targets are distributed across. Every server only scrapes targets of its own shard, assigned by the hash of the target address. 0 disables sharding" mapstructure:"shards"`
+	ShardIndex int      `def:"0" desc:"index of the shard scraped by this server, from 0 to shards-1" mapstructure:"shard-index"`
+	Peers      []string `def:"" desc:"static list of all the servers sharing scrape targets. If set, the number of shards is the number of peers, and the shard index is the position of self in the sorted list" mapstructure:"peers"`
+	Self       string   `def:"" desc:"address of this server in the peer list. Defaults to the hostname" mapstructure:"self"`
+}
+
 type RemoteWrite struct {
 	Enabled            bool `def:"false" desc:"EXPERIMENTAL! the API will change, use at your own risk. whether to enable remote write or not"`
 	DisableLocalWrites bool `def:"false" desc:"EXPERIMENTAL! the API will change, use at your own risk. whether to enable remote write or not" mapstructure:"disable-local-writes"`
 
+	AckMode     string        `def:"any" desc:"EXPERIMENTAL! ingestion requests succeed if the profile is accepted by: all - every sink (local storage and remote targets), any - at least one sink, quorum - the majority of sinks" mapstructure:"ack-mode"`
+	SinkTimeout time.Duration `def:"10s" desc:"EXPERIMENTAL! deadline of ingestion into a single sink. Set 0 to disable" mapstructure:"sink-timeout"`
+
 	// see loadRemoteWriteTargetConfigsFromFile in server.go
 	Targets map[string]RemoteWriteTarget `yaml:"scrape-configs" mapstructure:"-"`
 }
@@ -434,6 +510,13 @@ type RemoteWriteTarget struct {
 	Timeout           time.Duration     `desc:"profile upload timeout" mapstructure:"timeout" yaml:"timeout"`
 	QueueSize         int               `desc:"number of items in the queue" yaml:"queue-size"`
 	QueueWorkers      int               `desc:"number of queue workers" yaml:"queue-workers"`
+	MaxRetries        int               `desc:"max number of upload retries of a profile. Set -1 to disable retries" yaml:"max-retries"`
+	MinBackoff        time.Duration     `desc:"delay before the first upload retry, doubled with every next one" yaml:"min-backoff"`
+	MaxBackoff        time.Duration     `desc:"max delay between upload retries" yaml:"max-backoff"`
+	SpoolPath         string            `desc:"directory where profiles that failed to upload are kept until the target recovers. Defaults to a directory in storage-path" yaml:"spool-path"`
+	SpoolMaxSize      bytesize.ByteSize `desc:"max size of the spool directory. The oldest profiles are discarded once the limit is reached" yaml:"spool-max-size"`
+	RelabelConfigs    []*relabel.Config `desc:"relabeling rules applied to profile labels before sending, the application name is held in __name__. Profiles with dropped label sets are not sent" yaml:"relabel-configs"`
+	SamplePercentage  float64           `desc:"percentage of profiles sent to the target. Defaults to 100" yaml:"sample-percentage"`
 }
 
 func (r RemoteWriteTarget) String() string {
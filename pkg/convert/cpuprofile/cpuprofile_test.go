@@ -0,0 +1,72 @@
+package cpuprofile_test
+
+import (
+	"context"
+	"os"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/convert/cpuprofile"
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+type mockIngester struct{ actual []*storage.PutInput }
+
+func (m *mockIngester) Put(_ context.Context, p *storage.PutInput) error {
+	m.actual = append(m.actual, p)
+	return nil
+}
+
+var _ = Describe("V8 CPU profile", func() {
+	It("weights samples by their duration", func() {
+		data, err := os.ReadFile("testdata/simple.cpuprofile")
+		Expect(err).ToNot(HaveOccurred())
+
+		ingester := new(mockIngester)
+		profile := &cpuprofile.RawProfile{RawData: data}
+		md := ingestion.Metadata{Key: segment.NewKey(map[string]string{"__name__": "foo"}), SampleRate: 100}
+		Expect(profile.Parse(context.Background(), ingester, nil, md)).To(Succeed())
+
+		Expect(ingester.actual).To(HaveLen(1))
+		input := ingester.actual[0]
+		Expect(input.Key.Normalized()).To(Equal("foo{}"))
+		Expect(input.Units).To(Equal(metadata.SamplesUnits))
+		Expect(input.SampleRate).To(Equal(uint32(1000000)))
+		Expect(input.Val.String()).To(Equal(`(idle) 40
+a file:///app.js:1 10
+a file:///app.js:1;(anonymous) file:///app.js:10 50
+`))
+	})
+
+	It("falls back to hit counts", func() {
+		data := []byte(`{"nodes":[
+			{"id":1,"callFrame":{"functionName":"(root)"},"children":[2]},
+			{"id":2,"callFrame":{"functionName":"a"},"hitCount":3}
+		],"startTime":0,"endTime":30}`)
+		ingester := new(mockIngester)
+		md := ingestion.Metadata{Key: segment.NewKey(map[string]string{"__name__": "foo"})}
+		Expect((&cpuprofile.RawProfile{RawData: data}).Parse(context.Background(), ingester, nil, md)).To(Succeed())
+		Expect(ingester.actual[0].Val.String()).To(Equal("a 30\n"))
+	})
+
+	It("rejects malformed profiles", func() {
+		data := []byte(`{"nodes":[{"id":1,"callFrame":{"functionName":"(root)"}}],"samples":[2],"timeDeltas":[0]}`)
+		md := ingestion.Metadata{Key: segment.NewKey(map[string]string{"__name__": "foo"})}
+		Expect((&cpuprofile.RawProfile{RawData: data}).Parse(context.Background(), new(mockIngester), nil, md)).ToNot(Succeed())
+	})
+
+	It("rejects profiles with cyclic children", func() {
+		data := []byte(`{"nodes":[
+			{"id":1,"callFrame":{"functionName":"(root)"},"children":[2]},
+			{"id":2,"callFrame":{"functionName":"a"},"children":[3]},
+			{"id":3,"callFrame":{"functionName":"b"},"children":[2]}
+		],"samples":[3],"timeDeltas":[0],"startTime":0,"endTime":10}`)
+		md := ingestion.Metadata{Key: segment.NewKey(map[string]string{"__name__": "foo"})}
+		err := (&cpuprofile.RawProfile{RawData: data}).Parse(context.Background(), new(mockIngester), nil, md)
+		Expect(err).To(MatchError(ContainSubstring("descendant of itself")))
+	})
+})
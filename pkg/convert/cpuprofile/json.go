@@ -0,0 +1,30 @@
+package cpuprofile
+
+// Description of V8 CPU profile JSON, as saved by Chrome DevTools
+// and Node.js (--cpu-prof). Timestamps are in microseconds.
+// See spec: https://chromedevtools.github.io/devtools-protocol/tot/Profiler/#type-Profile
+
+type profile struct {
+	Nodes     []node
+	StartTime float64
+	EndTime   float64
+	// Node IDs of the top frames.
+	Samples []int64
+	// Intervals between adjacent samples; the first one is
+	// relative to StartTime.
+	TimeDeltas []float64
+}
+
+type node struct {
+	ID        int64
+	CallFrame callFrame
+	HitCount  int64
+	Children  []int64
+}
+
+type callFrame struct {
+	FunctionName string
+	URL          string
+	LineNumber   int64
+	ColumnNumber int64
+}
@@ -0,0 +1,157 @@
+package cpuprofile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+// Sample values are measured in microseconds.
+const sampleRate = 1000 * 1000
+
+// RawProfile implements ingestion.RawProfile for V8 CPU profile format.
+type RawProfile struct {
+	RawData []byte
+}
+
+// Parse parses a profile
+func (p *RawProfile) Parse(ctx context.Context, putter storage.Putter, _ storage.MetricsExporter, md ingestion.Metadata) error {
+	var prof profile
+	if err := json.Unmarshal(p.RawData, &prof); err != nil {
+		return err
+	}
+	t, err := buildTree(&prof)
+	if err != nil {
+		return err
+	}
+	return putter.Put(ctx, &storage.PutInput{
+		StartTime:       md.StartTime,
+		EndTime:         md.EndTime,
+		Key:             md.Key,
+		Val:             t,
+		SpyName:         md.SpyName,
+		SampleRate:      sampleRate,
+		Units:           metadata.SamplesUnits,
+		AggregationType: metadata.SumAggregationType,
+	})
+}
+
+// Bytes returns the raw bytes of the profile
+func (p *RawProfile) Bytes() ([]byte, error) {
+	return p.RawData, nil
+}
+
+// ContentType returns the HTTP ContentType of the profile
+func (*RawProfile) ContentType() string {
+	return "application/json"
+}
+
+func buildTree(prof *profile) (*tree.Tree, error) {
+	if len(prof.Nodes) == 0 {
+		return nil, fmt.Errorf("profile has no nodes")
+	}
+	nodes := make(map[int64]*node, len(prof.Nodes))
+	for i := range prof.Nodes {
+		nodes[prof.Nodes[i].ID] = &prof.Nodes[i]
+	}
+	parents := make(map[int64]int64, len(prof.Nodes))
+	for _, n := range prof.Nodes {
+		for _, c := range n.Children {
+			if _, ok := nodes[c]; !ok {
+				return nil, fmt.Errorf("invalid child node %d", c)
+			}
+			parents[c] = n.ID
+		}
+	}
+
+	stacks := make(map[int64][]string, len(prof.Nodes))
+	stack := func(id int64) ([]string, error) {
+		if s, ok := stacks[id]; ok {
+			return s, nil
+		}
+		var s []string
+		// Children must not form a cycle.
+		for n, ok := id, true; ok; n, ok = parents[n] {
+			if len(s) > len(prof.Nodes) {
+				return nil, fmt.Errorf("node %d is a descendant of itself", n)
+			}
+			// The root node is an artificial "(root)" frame.
+			if _, isChild := parents[n]; isChild {
+				s = append(s, frameName(nodes[n].CallFrame))
+			}
+		}
+		for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+			s[i], s[j] = s[j], s[i]
+		}
+		stacks[id] = s
+		return s, nil
+	}
+
+	t := tree.New()
+	if len(prof.Samples) == 0 {
+		// Sample timings are optional, fall back to hit counts
+		// with the average sampling interval.
+		var hits int64
+		for _, n := range prof.Nodes {
+			hits += n.HitCount
+		}
+		if hits == 0 {
+			return t, nil
+		}
+		interval := (prof.EndTime - prof.StartTime) / float64(hits)
+		for _, n := range prof.Nodes {
+			if n.HitCount > 0 {
+				s, err := stack(n.ID)
+				if err != nil {
+					return nil, err
+				}
+				t.InsertStackString(s, uint64(float64(n.HitCount)*interval))
+			}
+		}
+		return t, nil
+	}
+
+	if len(prof.Samples) != len(prof.TimeDeltas) {
+		return nil, fmt.Errorf("unequal lengths of samples and time deltas: %d != %d", len(prof.Samples), len(prof.TimeDeltas))
+	}
+	// Every sample lasts until the next one is taken.
+	ts := prof.StartTime
+	for i, id := range prof.Samples {
+		if _, ok := nodes[id]; !ok {
+			return nil, fmt.Errorf("invalid sample node %d", id)
+		}
+		ts += prof.TimeDeltas[i]
+		next := prof.EndTime
+		if i+1 < len(prof.Samples) {
+			next = ts + prof.TimeDeltas[i+1]
+		}
+		if d := next - ts; d > 0 {
+			s, err := stack(id)
+			if err != nil {
+				return nil, err
+			}
+			if len(s) > 0 {
+				t.InsertStackString(s, uint64(d))
+			}
+		}
+	}
+	return t, nil
+}
+
+func frameName(f callFrame) string {
+	name := f.FunctionName
+	if name == "" {
+		name = "(anonymous)"
+	}
+	if f.URL == "" {
+		return name
+	}
+	// Line numbers are zero-based.
+	return name + " " + f.URL + ":" + strconv.FormatInt(f.LineNumber+1, 10)
+}
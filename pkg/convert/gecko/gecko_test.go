@@ -0,0 +1,54 @@
+package gecko_test
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/convert/gecko"
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+type mockIngester struct{ actual []*storage.PutInput }
+
+func (m *mockIngester) Put(_ context.Context, p *storage.PutInput) error {
+	m.actual = append(m.actual, p)
+	return nil
+}
+
+var _ = Describe("Firefox Profiler", func() {
+	parse := func(path string) *storage.PutInput {
+		data, err := os.ReadFile(path)
+		Expect(err).ToNot(HaveOccurred())
+		ingester := new(mockIngester)
+		md := ingestion.Metadata{Key: segment.NewKey(map[string]string{"__name__": "foo"})}
+		Expect((&gecko.RawProfile{RawData: data}).Parse(context.Background(), ingester, nil, md)).To(Succeed())
+		Expect(ingester.actual).To(HaveLen(1))
+		return ingester.actual[0]
+	}
+
+	It("can parse a Gecko profile", func() {
+		input := parse("testdata/simple.gecko.json")
+		Expect(input.SampleRate).To(Equal(uint32(1000)))
+		Expect(strings.Split(input.Val.String(), "\n")).To(ConsistOf(
+			"GeckoMain;foo (https://example.com/a.js:1:1) 1",
+			"GeckoMain;foo (https://example.com/a.js:1:1);0x1234 1",
+			"GeckoMain;foo (https://example.com/a.js:1:1);bar (https://example.com/a.js:2:1) 2",
+			"Web Content;baz 3",
+			"",
+		))
+	})
+
+	It("can parse a processed profile", func() {
+		input := parse("testdata/simple.processed.json")
+		Expect(input.SampleRate).To(Equal(uint32(2000)))
+		Expect(input.Val.String()).To(Equal(`GeckoMain;foo 1
+GeckoMain;foo;bar 2
+`))
+	})
+})
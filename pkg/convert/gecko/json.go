@@ -0,0 +1,61 @@
+package gecko
+
+// Description of Firefox Profiler JSON. Both the Gecko format, as produced
+// by the browser, and the processed format, as saved by profiler.firefox.com,
+// are supported. In the former, tables are lists of rows described by
+// a schema; in the latter, tables are stored by columns.
+// See spec: https://github.com/firefox-devtools/profiler/tree/main/docs-developer
+
+type profile struct {
+	Meta      meta
+	Threads   []thread
+	Processes []profile
+	// Processed profiles may share the string table between threads.
+	Shared struct {
+		StringArray []string
+	}
+}
+
+type meta struct {
+	// Sampling interval in milliseconds.
+	Interval                   float64
+	PreprocessedProfileVersion int
+}
+
+type thread struct {
+	Name string
+
+	Samples    samples
+	StackTable stackTable
+	FrameTable frameTable
+	FuncTable  funcTable
+
+	StringTable []string
+	StringArray []string
+}
+
+type schemaTable struct {
+	Schema map[string]int
+	Data   [][]interface{}
+}
+
+type samples struct {
+	schemaTable
+	Stack  []*int
+	Weight []float64
+}
+
+type stackTable struct {
+	schemaTable
+	Frame  []int
+	Prefix []*int
+}
+
+type frameTable struct {
+	schemaTable
+	Func []int
+}
+
+type funcTable struct {
+	Name []int
+}
@@ -0,0 +1,219 @@
+package gecko
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+// RawProfile implements ingestion.RawProfile for Firefox Profiler format.
+type RawProfile struct {
+	RawData []byte
+}
+
+// Parse parses a profile. Samples of all the threads of all the
+// processes are merged, thread names become the root frames.
+func (p *RawProfile) Parse(ctx context.Context, putter storage.Putter, _ storage.MetricsExporter, md ingestion.Metadata) error {
+	var prof profile
+	if err := json.Unmarshal(p.RawData, &prof); err != nil {
+		return err
+	}
+	t := tree.New()
+	if err := prof.insert(t, prof.Shared.StringArray); err != nil {
+		return err
+	}
+	return putter.Put(ctx, &storage.PutInput{
+		StartTime:       md.StartTime,
+		EndTime:         md.EndTime,
+		Key:             md.Key,
+		Val:             t,
+		SpyName:         md.SpyName,
+		SampleRate:      prof.Meta.sampleRate(),
+		Units:           metadata.SamplesUnits,
+		AggregationType: metadata.SumAggregationType,
+	})
+}
+
+// Bytes returns the raw bytes of the profile
+func (p *RawProfile) Bytes() ([]byte, error) {
+	return p.RawData, nil
+}
+
+// ContentType returns the HTTP ContentType of the profile
+func (*RawProfile) ContentType() string {
+	return "application/json"
+}
+
+func (m meta) sampleRate() uint32 {
+	if m.Interval <= 0 {
+		return 1000
+	}
+	return uint32(1000 / m.Interval)
+}
+
+func (p *profile) insert(t *tree.Tree, strings []string) error {
+	for i := range p.Threads {
+		th := &p.Threads[i]
+		var err error
+		if p.Meta.PreprocessedProfileVersion > 0 {
+			err = th.insertProcessed(t, strings)
+		} else {
+			err = th.insertGecko(t)
+		}
+		if err != nil {
+			return fmt.Errorf("thread %q: %w", th.Name, err)
+		}
+	}
+	for i := range p.Processes {
+		if err := p.Processes[i].insert(t, strings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stackBuilder resolves stack table entries to frame names.
+type stackBuilder struct {
+	root   string
+	frames []string
+	prefix []int
+	frame  []int
+	stacks map[int][]string
+}
+
+func (b *stackBuilder) stack(id int) ([]string, error) {
+	if s, ok := b.stacks[id]; ok {
+		return s, nil
+	}
+	var s []string
+	// Prefixes must not form a cycle.
+	for i, n := id, 0; i >= 0; n++ {
+		if i >= len(b.frame) || n > len(b.frame) {
+			return nil, fmt.Errorf("invalid stack %d", i)
+		}
+		f := b.frame[i]
+		if f < 0 || f >= len(b.frames) {
+			return nil, fmt.Errorf("invalid frame %d", f)
+		}
+		s = append(s, b.frames[f])
+		i = b.prefix[i]
+	}
+	s = append(s, b.root)
+	for l, r := 0, len(s)-1; l < r; l, r = l+1, r-1 {
+		s[l], s[r] = s[r], s[l]
+	}
+	b.stacks[id] = s
+	return s, nil
+}
+
+func (th *thread) insertGecko(t *tree.Tree) error {
+	b := &stackBuilder{root: th.Name, stacks: make(map[int][]string)}
+	for _, row := range th.FrameTable.Data {
+		loc, ok := th.FrameTable.int(row, "location")
+		if !ok || loc < 0 || loc >= len(th.StringTable) {
+			return fmt.Errorf("invalid frame location")
+		}
+		b.frames = append(b.frames, th.StringTable[loc])
+	}
+	for _, row := range th.StackTable.Data {
+		f, ok := th.StackTable.int(row, "frame")
+		if !ok {
+			return fmt.Errorf("invalid stack frame")
+		}
+		p, ok := th.StackTable.int(row, "prefix")
+		if !ok {
+			p = -1
+		}
+		b.frame = append(b.frame, f)
+		b.prefix = append(b.prefix, p)
+	}
+	for _, row := range th.Samples.Data {
+		i, ok := th.Samples.int(row, "stack")
+		if !ok {
+			continue
+		}
+		w := 1.0
+		if v, ok := th.Samples.float(row, "weight"); ok {
+			w = v
+		}
+		if err := insertSample(t, b, i, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (th *thread) insertProcessed(t *tree.Tree, shared []string) error {
+	strings := th.StringArray
+	if strings == nil {
+		strings = th.StringTable
+	}
+	if strings == nil {
+		strings = shared
+	}
+	b := &stackBuilder{root: th.Name, stacks: make(map[int][]string)}
+	for _, fn := range th.FrameTable.Func {
+		if fn < 0 || fn >= len(th.FuncTable.Name) {
+			return fmt.Errorf("invalid frame function %d", fn)
+		}
+		name := th.FuncTable.Name[fn]
+		if name < 0 || name >= len(strings) {
+			return fmt.Errorf("invalid function name %d", name)
+		}
+		b.frames = append(b.frames, strings[name])
+	}
+	if len(th.StackTable.Prefix) != len(th.StackTable.Frame) {
+		return fmt.Errorf("malformed stack table")
+	}
+	b.frame = th.StackTable.Frame
+	b.prefix = make([]int, len(th.StackTable.Prefix))
+	for i, p := range th.StackTable.Prefix {
+		b.prefix[i] = -1
+		if p != nil {
+			b.prefix[i] = *p
+		}
+	}
+	for i, s := range th.Samples.Stack {
+		if s == nil {
+			continue
+		}
+		w := 1.0
+		if i < len(th.Samples.Weight) {
+			w = th.Samples.Weight[i]
+		}
+		if err := insertSample(t, b, *s, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertSample(t *tree.Tree, b *stackBuilder, stack int, weight float64) error {
+	s, err := b.stack(stack)
+	if err != nil {
+		return err
+	}
+	if weight > 0 {
+		t.InsertStackString(s, uint64(weight))
+	}
+	return nil
+}
+
+func (st schemaTable) float(row []interface{}, column string) (float64, bool) {
+	i, ok := st.Schema[column]
+	if !ok || i >= len(row) {
+		return 0, false
+	}
+	v, ok := row[i].(float64)
+	return v, ok
+}
+
+func (st schemaTable) int(row []interface{}, column string) (int, bool) {
+	v, ok := st.float(row, column)
+	return int(v), ok
+}
@@ -0,0 +1,305 @@
+package jfr
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/pyroscope-io/jfr-parser/parser"
+	"github.com/pyroscope-io/jfr-parser/reader"
+)
+
+// jfr-parser does not know about some of the events we are interested in,
+// and its event registry can't be extended: unknown events are decoded as
+// parser.UnsupportedEvent with all the fields discarded. parseChunks mirrors
+// parser.ParseWithOptions, but decodes the events listed here on its own.
+// jdk.JavaMonitorWait is decoded here too, as parser.JavaMonitorWait
+// discards the context ID of the event.
+//
+// TODO: remove parseChunks once jfr-parser decodes these events (v0.6.0 does
+// not): bump the dependency and call parser.ParseWithOptions instead.
+var extraEvents = map[string]func() parser.Parseable{
+	"jdk.JavaMonitorWait":      func() parser.Parseable { return new(JavaMonitorWait) },
+	"jdk.SocketRead":           func() parser.Parseable { return new(SocketRead) },
+	"jdk.SocketWrite":          func() parser.Parseable { return new(SocketWrite) },
+	"profiler.Malloc":          func() parser.Parseable { return new(Malloc) },
+	"profiler.WallClockSample": func() parser.Parseable { return new(WallClockSample) },
+}
+
+// Chunk header size, including magic and version.
+const chunkHeaderSize = 68
+
+var chunkMagic = []byte{'F', 'L', 'R', 0}
+
+func parseChunks(r io.Reader, options *parser.ChunkParseOptions) ([]parser.Chunk, error) {
+	var chunks []parser.Chunk
+	for {
+		var c parser.Chunk
+		err := parseChunk(r, &c, options)
+		if err == io.EOF {
+			return chunks, nil
+		}
+		if err != nil {
+			return chunks, fmt.Errorf("unable to parse chunk: %w", err)
+		}
+		chunks = append(chunks, c)
+	}
+}
+
+// revive:disable-next-line:cognitive-complexity mirrors parser.Chunk.Parse
+func parseChunk(r io.Reader, c *parser.Chunk, options *parser.ChunkParseOptions) error {
+	buf := make([]byte, len(chunkMagic))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			return err
+		}
+		return fmt.Errorf("unable to read chunk's header: %w", err)
+	}
+	if !bytes.Equal(buf, chunkMagic) {
+		return fmt.Errorf("unexpected magic header %v expected, %v found", chunkMagic, buf)
+	}
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return fmt.Errorf("unable to read format version: %w", err)
+	}
+
+	buf = make([]byte, chunkHeaderSize-8)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return fmt.Errorf("unable to read chunk header: %w", err)
+	}
+	if err := c.Header.Parse(reader.NewReader(bytes.NewReader(buf), false)); err != nil {
+		return fmt.Errorf("unable to parse chunk header: %w", err)
+	}
+	c.Header.ChunkSize -= chunkHeaderSize
+	c.Header.MetadataOffset -= chunkHeaderSize
+	c.Header.ConstantPoolOffset -= chunkHeaderSize
+	if c.Header.ChunkSize < 0 || c.Header.MetadataOffset < 0 || c.Header.ConstantPoolOffset < 0 {
+		return fmt.Errorf("invalid chunk header")
+	}
+	buf = make([]byte, c.Header.ChunkSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return fmt.Errorf("unable to read chunk contents: %w", err)
+	}
+
+	br := bytes.NewReader(buf)
+	rd := reader.NewReader(br, c.Header.Features&1 == 1)
+	// Offsets and sizes of the events that have been read already.
+	events := make(map[int64]int32)
+
+	if _, err := br.Seek(c.Header.MetadataOffset, io.SeekStart); err != nil {
+		return fmt.Errorf("unable to seek to metadata: %w", err)
+	}
+	metadataSize, err := rd.VarInt()
+	if err != nil {
+		return fmt.Errorf("unable to parse chunk metadata size: %w", err)
+	}
+	events[c.Header.MetadataOffset] = metadataSize
+	if err = c.Metadata.Parse(rd); err != nil {
+		return fmt.Errorf("unable to parse chunk metadata: %w", err)
+	}
+	classes := make(parser.ClassMap)
+	for _, class := range c.Metadata.Root.Metadata.Classes {
+		classes[int(class.ID)] = class
+	}
+
+	cpools := make(parser.PoolMap)
+	delta := int64(0)
+	for {
+		offset := c.Header.ConstantPoolOffset + delta
+		if _, err = br.Seek(offset, io.SeekStart); err != nil {
+			return fmt.Errorf("unable to seek to checkpoint event: %w", err)
+		}
+		size, err := rd.VarInt()
+		if err != nil {
+			return fmt.Errorf("unable to parse checkpoint event size: %w", err)
+		}
+		events[offset] = size
+		var cp parser.CheckpointEvent
+		if err = cp.Parse(rd, classes, cpools); err != nil {
+			return fmt.Errorf("unable to parse checkpoint event: %w", err)
+		}
+		c.Checkpoints = append(c.Checkpoints, cp)
+		if cp.Delta == 0 {
+			break
+		}
+		delta += cp.Delta
+	}
+
+	if options.CPoolProcessor != nil {
+		for classID, pool := range cpools {
+			options.CPoolProcessor(classes[classID], pool)
+		}
+	}
+	for classID := range cpools {
+		if err = parser.ResolveConstants(classes, cpools, classID); err != nil {
+			return err
+		}
+	}
+
+	for pointer := int64(0); pointer < c.Header.ChunkSize; {
+		if size, ok := events[pointer]; ok {
+			pointer += int64(size)
+			continue
+		}
+		if _, err = br.Seek(pointer, io.SeekStart); err != nil {
+			return fmt.Errorf("unable to seek to position %d: %w", pointer, err)
+		}
+		size, err := rd.VarInt()
+		if err != nil {
+			return fmt.Errorf("unable to parse event size: %w", err)
+		}
+		if size <= 0 {
+			return fmt.Errorf("invalid event size %d at position %d", size, pointer)
+		}
+		events[pointer] = size
+		e, err := parseEvent(br, rd, classes, cpools)
+		if err != nil {
+			return fmt.Errorf("unable to parse event: %w", err)
+		}
+		c.Events = append(c.Events, e)
+		pointer += int64(size)
+	}
+	return nil
+}
+
+func parseEvent(br *bytes.Reader, rd reader.Reader, classes parser.ClassMap, cpools parser.PoolMap) (parser.Parseable, error) {
+	start, _ := br.Seek(0, io.SeekCurrent)
+	kind, err := rd.VarLong()
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve event type: %w", err)
+	}
+	class, ok := classes[int(kind)]
+	if !ok {
+		return nil, fmt.Errorf("unknown class %d", kind)
+	}
+	typeFn, ok := extraEvents[class.Name]
+	if !ok {
+		if _, err = br.Seek(start, io.SeekStart); err != nil {
+			return nil, err
+		}
+		return parser.ParseEvent(rd, classes, cpools)
+	}
+	e := typeFn()
+	if err = e.Parse(rd, classes, cpools, class); err != nil {
+		return nil, fmt.Errorf("unable to parse event %s: %w", class.Name, err)
+	}
+	return e, nil
+}
+
+// extraEvent holds the fields of the events from extraEvents we make use of.
+type extraEvent struct {
+	Duration   int64
+	StackTrace *parser.StackTrace
+	ContextId  int64
+	// Value is the event-specific quantity: number of bytes read or written,
+	// allocation size, or number of wall-clock samples.
+	Value int64
+}
+
+type SocketRead struct{ extraEvent }
+
+func (e *SocketRead) Parse(r reader.Reader, classes parser.ClassMap, cpools parser.PoolMap, class parser.ClassMetadata) error {
+	return e.parse(r, classes, cpools, class, "bytesRead")
+}
+
+type SocketWrite struct{ extraEvent }
+
+func (e *SocketWrite) Parse(r reader.Reader, classes parser.ClassMap, cpools parser.PoolMap, class parser.ClassMetadata) error {
+	return e.parse(r, classes, cpools, class, "bytesWritten")
+}
+
+type Malloc struct{ extraEvent }
+
+func (e *Malloc) Parse(r reader.Reader, classes parser.ClassMap, cpools parser.PoolMap, class parser.ClassMetadata) error {
+	return e.parse(r, classes, cpools, class, "size")
+}
+
+type WallClockSample struct{ extraEvent }
+
+// JavaMonitorWait has no event-specific value: the wait time is its duration.
+type JavaMonitorWait struct{ extraEvent }
+
+func (e *JavaMonitorWait) Parse(r reader.Reader, classes parser.ClassMap, cpools parser.PoolMap, class parser.ClassMetadata) error {
+	return e.parse(r, classes, cpools, class, "")
+}
+
+func (e *WallClockSample) Parse(r reader.Reader, classes parser.ClassMap, cpools parser.PoolMap, class parser.ClassMetadata) error {
+	return e.parse(r, classes, cpools, class, "samples")
+}
+
+func (e *extraEvent) parse(r reader.Reader, classes parser.ClassMap, cpools parser.PoolMap, class parser.ClassMetadata, valueField string) error {
+	return parseFields(r, classes, cpools, class, func(name string, p parser.ParseResolvable) error {
+		switch name {
+		case "duration":
+			e.Duration = toLong(p)
+		case "contextId":
+			e.ContextId = toLong(p)
+		case valueField:
+			e.Value = toLong(p)
+		case "stackTrace":
+			if st, ok := p.(*parser.StackTrace); ok {
+				e.StackTrace = st
+			}
+		}
+		return nil
+	})
+}
+
+// parseFields reads event fields. Unlike types stored in constant pools,
+// events refer to constants that are already resolved.
+func parseFields(r reader.Reader, classes parser.ClassMap, cpools parser.PoolMap, class parser.ClassMetadata, cb func(string, parser.ParseResolvable) error) error {
+	for _, f := range class.Fields {
+		switch {
+		case f.ConstantPool:
+			i, err := r.VarLong()
+			if err != nil {
+				return fmt.Errorf("unable to read constant index")
+			}
+			cpool, ok := cpools[int(f.Class)]
+			if !ok {
+				return fmt.Errorf("unknown constant pool class %d", f.Class)
+			}
+			if p, ok := cpool.Pool[int(i)]; ok {
+				if err = cb(f.Name, p); err != nil {
+					return err
+				}
+			}
+		case f.Dimension == 1:
+			n, err := r.VarInt()
+			if err != nil {
+				return fmt.Errorf("failed to parse %s: unable to read array length: %w", class.Name, err)
+			}
+			for i := 0; i < int(n); i++ {
+				p, err := parser.ParseClass(r, classes, cpools, f.Class)
+				if err != nil {
+					return fmt.Errorf("failed to parse %s: unable to read an array element: %w", class.Name, err)
+				}
+				if err = cb(f.Name, p); err != nil {
+					return err
+				}
+			}
+		default:
+			p, err := parser.ParseClass(r, classes, cpools, f.Class)
+			if err != nil {
+				return fmt.Errorf("failed to parse %s: unable to read a field: %w", class.Name, err)
+			}
+			if err = cb(f.Name, p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func toLong(p parser.ParseResolvable) int64 {
+	switch v := p.(type) {
+	case *parser.Long:
+		return int64(*v)
+	case *parser.Int:
+		return int64(*v)
+	case *parser.Short:
+		return int64(*v)
+	case *parser.Byte:
+		return int64(*v)
+	}
+	return 0
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"regexp"
+	"time"
 
 	"github.com/hashicorp/go-multierror"
 	"github.com/pyroscope-io/jfr-parser/parser"
@@ -25,10 +26,20 @@ const (
 	sampleTypeLockSamples
 	sampleTypeLockDuration
 	sampleTypeLiveObject
+	sampleTypeWaitSamples
+	sampleTypeWaitDuration
+	sampleTypeSocketReadDuration
+	sampleTypeSocketReadBytes
+	sampleTypeSocketWriteDuration
+	sampleTypeSocketWriteBytes
+	sampleTypeNativeAllocObjects
+	sampleTypeNativeAllocBytes
+	sampleTypeParkSamples
+	sampleTypeParkDuration
 )
 
 func ParseJFR(ctx context.Context, s storage.Putter, body io.Reader, pi *storage.PutInput, jfrLabels *LabelsSnapshot) (err error) {
-	chunks, err := parser.ParseWithOptions(body, &parser.ChunkParseOptions{
+	chunks, err := parseChunks(body, &parser.ChunkParseOptions{
 		CPoolProcessor: processSymbols,
 	})
 	if err != nil {
@@ -53,9 +64,19 @@ func parse(ctx context.Context, c parser.Chunk, s storage.Putter, piOriginal *st
 		}
 	}
 	cache := make(tree.LabelsCache)
+	nanos := ticksToNanos(c.Header.TicksPerSecond)
 	for contextID, events := range groupEventsByContextID(c.Events) {
 		labels := getContextLabels(contextID, jfrLabels)
 		lh := labels.Hash()
+		// insert adds the values to the trees of consecutive sample types,
+		// starting with sampleType: e.g. the number of events and their size.
+		insert := func(sampleType int64, st *parser.StackTrace, values ...uint64) {
+			if fs := frames(st); fs != nil {
+				for i, v := range values {
+					cache.GetOrCreateTreeByHash(sampleType+int64(i), labels, lh).InsertStackString(fs, v)
+				}
+			}
+		}
 		for _, e := range events {
 			switch e.(type) {
 			case *parser.ExecutionSample:
@@ -64,37 +85,42 @@ func parse(ctx context.Context, c parser.Chunk, s storage.Putter, piOriginal *st
 					if es.State.Name == "STATE_RUNNABLE" {
 						cache.GetOrCreateTreeByHash(sampleTypeCPU, labels, lh).InsertStackString(fs, 1)
 					}
-					cache.GetOrCreateTreeByHash(sampleTypeWall, labels, lh).InsertStackString(fs, 1)
+					if event == "wall" {
+						cache.GetOrCreateTreeByHash(sampleTypeWall, labels, lh).InsertStackString(fs, 1)
+					}
 				}
+			case *WallClockSample:
+				// async-profiler 3 records wall-clock samples separately
+				// from CPU samples, which may have been batched.
+				ws := e.(*WallClockSample)
+				insert(sampleTypeWall, ws.StackTrace, uint64(max(ws.Value, 1)))
 			case *parser.ObjectAllocationInNewTLAB:
 				oa := e.(*parser.ObjectAllocationInNewTLAB)
-				if fs := frames(oa.StackTrace); fs != nil {
-					cache.GetOrCreateTreeByHash(sampleTypeInTLABObjects, labels, lh).InsertStackString(fs, 1)
-					cache.GetOrCreateTreeByHash(sampleTypeInTLABBytes, labels, lh).InsertStackString(fs, uint64(oa.TLABSize))
-				}
+				insert(sampleTypeInTLABObjects, oa.StackTrace, 1, uint64(oa.TLABSize))
 			case *parser.ObjectAllocationOutsideTLAB:
 				oa := e.(*parser.ObjectAllocationOutsideTLAB)
-				if fs := frames(oa.StackTrace); fs != nil {
-					cache.GetOrCreateTreeByHash(sampleTypeOutTLABObjects, labels, lh).InsertStackString(fs, 1)
-					cache.GetOrCreateTreeByHash(sampleTypeOutTLABBytes, labels, lh).InsertStackString(fs, uint64(oa.AllocationSize))
-				}
+				insert(sampleTypeOutTLABObjects, oa.StackTrace, 1, uint64(oa.AllocationSize))
+			case *Malloc:
+				m := e.(*Malloc)
+				insert(sampleTypeNativeAllocObjects, m.StackTrace, 1, uint64(m.Value))
 			case *parser.JavaMonitorEnter:
 				jme := e.(*parser.JavaMonitorEnter)
-				if fs := frames(jme.StackTrace); fs != nil {
-					cache.GetOrCreateTreeByHash(sampleTypeLockSamples, labels, lh).InsertStackString(fs, 1)
-					cache.GetOrCreateTreeByHash(sampleTypeLockDuration, labels, lh).InsertStackString(fs, uint64(jme.Duration))
-				}
+				insert(sampleTypeLockSamples, jme.StackTrace, 1, nanos(jme.Duration))
 			case *parser.ThreadPark:
 				tp := e.(*parser.ThreadPark)
-				if fs := frames(tp.StackTrace); fs != nil {
-					cache.GetOrCreateTreeByHash(sampleTypeLockSamples, labels, lh).InsertStackString(fs, 1)
-					cache.GetOrCreateTreeByHash(sampleTypeLockDuration, labels, lh).InsertStackString(fs, uint64(tp.Duration))
-				}
+				insert(sampleTypeParkSamples, tp.StackTrace, 1, nanos(tp.Duration))
+			case *JavaMonitorWait:
+				jmw := e.(*JavaMonitorWait)
+				insert(sampleTypeWaitSamples, jmw.StackTrace, 1, nanos(jmw.Duration))
+			case *SocketRead:
+				sr := e.(*SocketRead)
+				insert(sampleTypeSocketReadDuration, sr.StackTrace, nanos(sr.Duration), uint64(sr.Value))
+			case *SocketWrite:
+				sw := e.(*SocketWrite)
+				insert(sampleTypeSocketWriteDuration, sw.StackTrace, nanos(sw.Duration), uint64(sw.Value))
 			case *parser.LiveObject:
 				lo := e.(*parser.LiveObject)
-				if fs := frames(lo.StackTrace); fs != nil {
-					cache.GetOrCreateTreeByHash(sampleTypeLiveObject, labels, lh).InsertStackString(fs, 1)
-				}
+				insert(sampleTypeLiveObject, lo.StackTrace, 1)
 			}
 		}
 	}
@@ -123,9 +149,6 @@ func parse(ctx context.Context, c parser.Chunk, s storage.Putter, piOriginal *st
 		}
 	}
 	for sampleType, entries := range cache {
-		if sampleType == sampleTypeWall && event != "wall" {
-			continue
-		}
 		n := getName(sampleType, event)
 		units := getUnits(sampleType)
 		at := aggregationType(sampleType)
@@ -162,6 +185,26 @@ func getName(sampleType int64, event string) string {
 		return "lock_duration"
 	case sampleTypeLiveObject:
 		return "live"
+	case sampleTypeWaitSamples:
+		return "wait_count"
+	case sampleTypeWaitDuration:
+		return "wait_duration"
+	case sampleTypeSocketReadDuration:
+		return "socket_read_duration"
+	case sampleTypeSocketReadBytes:
+		return "socket_read_bytes"
+	case sampleTypeSocketWriteDuration:
+		return "socket_write_duration"
+	case sampleTypeSocketWriteBytes:
+		return "socket_write_bytes"
+	case sampleTypeNativeAllocObjects:
+		return "native_alloc_objects"
+	case sampleTypeNativeAllocBytes:
+		return "native_alloc_bytes"
+	case sampleTypeParkSamples:
+		return "park_count"
+	case sampleTypeParkDuration:
+		return "park_duration"
 	}
 	return "unknown"
 }
@@ -195,6 +238,14 @@ func getUnits(sampleType int64) metadata.Units {
 		return metadata.LockNanosecondsUnits
 	case sampleTypeLiveObject:
 		return metadata.ObjectsUnits
+	case sampleTypeWaitSamples, sampleTypeParkSamples:
+		return metadata.LockSamplesUnits
+	case sampleTypeWaitDuration, sampleTypeParkDuration, sampleTypeSocketReadDuration, sampleTypeSocketWriteDuration:
+		return metadata.LockNanosecondsUnits
+	case sampleTypeSocketReadBytes, sampleTypeSocketWriteBytes, sampleTypeNativeAllocBytes:
+		return metadata.BytesUnits
+	case sampleTypeNativeAllocObjects:
+		return metadata.ObjectsUnits
 	}
 	return metadata.SamplesUnits
 }
@@ -262,6 +313,21 @@ func groupEventsByContextID(events []parser.Parseable) map[int64][]parser.Parsea
 		case *parser.ThreadPark:
 			tp := e.(*parser.ThreadPark)
 			res[tp.ContextId] = append(res[tp.ContextId], e)
+		case *WallClockSample:
+			ws := e.(*WallClockSample)
+			res[ws.ContextId] = append(res[ws.ContextId], e)
+		case *Malloc:
+			m := e.(*Malloc)
+			res[m.ContextId] = append(res[m.ContextId], e)
+		case *SocketRead:
+			sr := e.(*SocketRead)
+			res[sr.ContextId] = append(res[sr.ContextId], e)
+		case *SocketWrite:
+			sw := e.(*SocketWrite)
+			res[sw.ContextId] = append(res[sw.ContextId], e)
+		case *JavaMonitorWait:
+			jmw := e.(*JavaMonitorWait)
+			res[jmw.ContextId] = append(res[jmw.ContextId], e)
 		case *parser.LiveObject:
 			res[0] = append(res[0], e)
 		}
@@ -269,6 +335,16 @@ func groupEventsByContextID(events []parser.Parseable) map[int64][]parser.Parsea
 	return res
 }
 
+// ticksToNanos returns a function converting event durations, which are
+// measured in ticks of the chunk clock, to nanoseconds.
+func ticksToNanos(ticksPerSecond int64) func(int64) uint64 {
+	if ticksPerSecond <= 0 || ticksPerSecond == int64(time.Second) {
+		return func(d int64) uint64 { return uint64(d) }
+	}
+	k := float64(time.Second) / float64(ticksPerSecond)
+	return func(d int64) uint64 { return uint64(float64(d) * k) }
+}
+
 func frames(st *parser.StackTrace) []string {
 	if st == nil {
 		return nil
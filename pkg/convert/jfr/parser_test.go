@@ -0,0 +1,115 @@
+package jfr
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/pyroscope-io/jfr-parser/parser"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+type mockPutter struct {
+	sync.Mutex
+	inputs map[string]*storage.PutInput
+}
+
+func (m *mockPutter) Put(_ context.Context, pi *storage.PutInput) error {
+	m.Lock()
+	defer m.Unlock()
+	m.inputs[pi.Key.Normalized()] = pi
+	return nil
+}
+
+func stackTrace(names ...string) *parser.StackTrace {
+	st := new(parser.StackTrace)
+	// Frames are stored from the leaf to the root.
+	for i := len(names) - 1; i >= 0; i-- {
+		st.Frames = append(st.Frames, &parser.StackFrame{
+			Method: &parser.Method{
+				Type: &parser.Class{Name: &parser.Symbol{String: "Foo"}},
+				Name: &parser.Symbol{String: names[i]},
+			},
+		})
+	}
+	return st
+}
+
+var _ = ginkgo.Describe("JFR parser", func() {
+	ginkgo.It("decodes the same events as jfr-parser", func() {
+		f, err := os.Open("testdata/example.jfr.gz")
+		Expect(err).ToNot(HaveOccurred())
+		defer f.Close()
+		r, err := gzip.NewReader(f)
+		Expect(err).ToNot(HaveOccurred())
+		chunks, err := parseChunks(r, new(parser.ChunkParseOptions))
+		Expect(err).ToNot(HaveOccurred())
+
+		_, _ = f.Seek(0, 0)
+		Expect(r.Reset(f)).To(Succeed())
+		expected, err := parser.Parse(r)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(chunks).To(HaveLen(len(expected)))
+		for i := range chunks {
+			Expect(chunks[i].Header).To(Equal(expected[i].Header))
+			Expect(chunks[i].Events).To(HaveLen(len(expected[i].Events)))
+			for j := range chunks[i].Events {
+				Expect(fmt.Sprintf("%T", chunks[i].Events[j])).To(Equal(fmt.Sprintf("%T", expected[i].Events[j])))
+			}
+		}
+	})
+
+	ginkgo.It("puts each event type into its own profile", func() {
+		labels := &LabelsSnapshot{
+			Contexts: map[int64]*Context{1: {Labels: map[int64]int64{1: 2}}},
+			Strings:  map[int64]string{1: "span_name", 2: "GET /"},
+		}
+		chunk := parser.Chunk{
+			Header: parser.Header{TicksPerSecond: 2e9},
+			Events: []parser.Parseable{
+				&parser.ThreadPark{StackTrace: stackTrace("a", "park"), Duration: 20, ContextId: 1},
+				&JavaMonitorWait{extraEvent{StackTrace: stackTrace("a", "wait"), Duration: 40, ContextId: 1}},
+				&SocketRead{extraEvent{StackTrace: stackTrace("a", "read"), Duration: 100, Value: 512, ContextId: 1}},
+				&SocketWrite{extraEvent{StackTrace: stackTrace("a", "write"), Duration: 200, Value: 64}},
+				&Malloc{extraEvent{StackTrace: stackTrace("a", "malloc"), Value: 1024}},
+				&WallClockSample{extraEvent{StackTrace: stackTrace("a", "sleep"), Value: 3, ContextId: 1}},
+			},
+		}
+		p := &mockPutter{inputs: make(map[string]*storage.PutInput)}
+		pi := &storage.PutInput{Key: segment.NewKey(map[string]string{"__name__": "app"})}
+		Expect(parse(context.Background(), chunk, p, pi, labels)).To(Succeed())
+
+		type profile struct {
+			tree  string
+			units metadata.Units
+		}
+		actual := make(map[string]profile)
+		for k, v := range p.inputs {
+			actual[k] = profile{v.Val.String(), v.Units}
+		}
+		Expect(actual).To(Equal(map[string]profile{
+			"app.park_count{span_name=GET /}":    {"Foo.a;Foo.park 1\n", metadata.LockSamplesUnits},
+			"app.park_duration{span_name=GET /}": {"Foo.a;Foo.park 10\n", metadata.LockNanosecondsUnits},
+			"app.wait_count{span_name=GET /}":    {"Foo.a;Foo.wait 1\n", metadata.LockSamplesUnits},
+			"app.wait_duration{span_name=GET /}": {"Foo.a;Foo.wait 20\n", metadata.LockNanosecondsUnits},
+
+			"app.socket_read_duration{span_name=GET /}": {"Foo.a;Foo.read 50\n", metadata.LockNanosecondsUnits},
+			"app.socket_read_bytes{span_name=GET /}":    {"Foo.a;Foo.read 512\n", metadata.BytesUnits},
+			"app.socket_write_duration{}":               {"Foo.a;Foo.write 100\n", metadata.LockNanosecondsUnits},
+			"app.socket_write_bytes{}":                  {"Foo.a;Foo.write 64\n", metadata.BytesUnits},
+
+			"app.native_alloc_objects{}": {"Foo.a;Foo.malloc 1\n", metadata.ObjectsUnits},
+			"app.native_alloc_bytes{}":   {"Foo.a;Foo.malloc 1024\n", metadata.BytesUnits},
+
+			"app.wall{span_name=GET /}": {"Foo.a;Foo.sleep 3\n", metadata.SamplesUnits},
+		}))
+	})
+})
@@ -0,0 +1,102 @@
+package offline_test
+
+import (
+	"bytes"
+	"os"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/convert/offline"
+	"github.com/pyroscope-io/pyroscope/pkg/flameql"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
+)
+
+func readFile(name string) []byte {
+	b, err := os.ReadFile(name)
+	Expect(err).ToNot(HaveOccurred())
+	return b
+}
+
+func convert(p *offline.Profile, format offline.OutputFormat) []byte {
+	var buf bytes.Buffer
+	Expect(offline.Write(&buf, p, offline.WriteOptions{Format: format, MaxNodes: 1024})).To(Succeed())
+	return buf.Bytes()
+}
+
+var _ = Describe("offline conversion", func() {
+	It("merges several inputs", func() {
+		p, err := offline.Read([]offline.Input{
+			{Format: offline.InputFormatCollapsed, Data: []byte("a;b 1\na;c 2\n")},
+			{Format: offline.InputFormatCollapsed, Data: []byte("a;b 3\n")},
+		}, offline.ReadOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(strings.Split(string(convert(p, offline.OutputFormatCollapsed)), "\n")).
+			To(ConsistOf("a;b 4", "a;c 2", ""))
+	})
+
+	It("filters pprof samples by labels", func() {
+		in := offline.Input{Format: offline.InputFormatPprof, Data: readFile("../pprof/testdata/cpu-exemplars.pb.gz")}
+		all, err := offline.Read([]offline.Input{in}, offline.ReadOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(all.Type).To(Equal("cpu"))
+		Expect(all.Tree.Samples()).To(Equal(uint64(873)))
+
+		matchers, err := flameql.ParseMatchers(`function="slow"`)
+		Expect(err).ToNot(HaveOccurred())
+		slow, err := offline.Read([]offline.Input{in}, offline.ReadOptions{Matchers: matchers})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(slow.Tree.Samples()).To(Equal(uint64(674)))
+	})
+
+	It("selects profile type", func() {
+		in := offline.Input{Format: offline.InputFormatPprof, Data: readFile("../pprof/testdata/heap.pb.gz")}
+		p, err := offline.Read([]offline.Input{in}, offline.ReadOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.Type).To(Equal("alloc_objects"))
+		_, err = offline.Read([]offline.Input{in}, offline.ReadOptions{ProfileType: "cpu"})
+		Expect(err).To(MatchError(offline.ErrProfileTypeUnknown))
+
+		p, err = offline.Read([]offline.Input{in}, offline.ReadOptions{ProfileType: "inuse_space"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.Metadata.Units).To(Equal(metadata.BytesUnits))
+	})
+
+	DescribeTable("converts profiles back and forth",
+		func(out offline.OutputFormat, in offline.InputFormat) {
+			src, err := offline.Read([]offline.Input{{
+				Format: offline.InputFormatPprof,
+				Data:   readFile("../pprof/testdata/cpu.pb.gz"),
+			}}, offline.ReadOptions{})
+			Expect(err).ToNot(HaveOccurred())
+
+			p, err := offline.Read([]offline.Input{{Format: in, Data: convert(src, out)}}, offline.ReadOptions{ProfileType: "cpu"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(p.Tree.String()).To(Equal(src.Tree.String()))
+		},
+		Entry("pprof", offline.OutputFormatPprof, offline.InputFormatPprof),
+		Entry("collapsed", offline.OutputFormatCollapsed, offline.InputFormatCollapsed),
+		Entry("flamebearer", offline.OutputFormatJSON, offline.InputFormatJSON),
+	)
+
+	It("writes speedscope profiles", func() {
+		p, err := offline.Read([]offline.Input{
+			{Format: offline.InputFormatCollapsed, Data: []byte("a;b 1\na;c 2\n")},
+		}, offline.ReadOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		s, err := offline.Read([]offline.Input{
+			{Format: offline.InputFormatSpeedscope, Data: convert(p, offline.OutputFormatSpeedscope)},
+		}, offline.ReadOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		// Speedscope parser scales weights of unitless profiles.
+		Expect(s.Tree.Samples()).To(Equal(p.Tree.Samples() * 100))
+	})
+
+	It("rejects unknown formats", func() {
+		_, err := offline.Read([]offline.Input{{Format: "foo"}}, offline.ReadOptions{})
+		Expect(err).To(MatchError(offline.ErrUnknownInputFormat))
+		err = offline.Write(new(bytes.Buffer), new(offline.Profile), offline.WriteOptions{Format: "foo"})
+		Expect(err).To(MatchError(offline.ErrUnknownOutputFormat))
+	})
+})
@@ -0,0 +1,325 @@
+// Package offline converts profiles between the supported formats without
+// a running server: inputs are parsed into trees, filtered by labels and
+// profile type, merged, and written in the requested output format.
+package offline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/agent/spy"
+	"github.com/pyroscope-io/pyroscope/pkg/convert"
+	"github.com/pyroscope-io/pyroscope/pkg/convert/cpuprofile"
+	"github.com/pyroscope-io/pyroscope/pkg/convert/gecko"
+	"github.com/pyroscope-io/pyroscope/pkg/convert/jfr"
+	"github.com/pyroscope-io/pyroscope/pkg/convert/perf"
+	"github.com/pyroscope-io/pyroscope/pkg/convert/pprof"
+	"github.com/pyroscope-io/pyroscope/pkg/convert/speedscope"
+	"github.com/pyroscope-io/pyroscope/pkg/flameql"
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/structs/flamebearer"
+)
+
+type InputFormat string
+
+const (
+	InputFormatGroups     InputFormat = "groups"
+	InputFormatCollapsed  InputFormat = "collapsed"
+	InputFormatPprof      InputFormat = "pprof"
+	InputFormatJFR        InputFormat = "jfr"
+	InputFormatPerfScript InputFormat = "perf_script"
+	InputFormatSpeedscope InputFormat = "speedscope"
+	InputFormatCPUProfile InputFormat = "cpuprofile"
+	InputFormatGecko      InputFormat = "gecko"
+	InputFormatJSON       InputFormat = "json"
+)
+
+// appName is the application name used for the inputs parsed
+// as if they were ingested; it is never exposed to the user.
+const appName = "convert"
+
+var (
+	ErrUnknownInputFormat = errors.New("unknown input format")
+	ErrProfileTypeUnknown = errors.New("profile type not found")
+	ErrUnitsMismatch      = errors.New("profiles have different units")
+	ErrNoProfiles         = errors.New("no matching profiles found")
+)
+
+type Input struct {
+	// Name of the input, e.g. the file name. Optional.
+	Name   string
+	Format InputFormat
+	Data   []byte
+}
+
+type ReadOptions struct {
+	// ProfileType selects the profile type (e.g. cpu or alloc_space) if
+	// an input contains several of them; the first one is used if empty.
+	// Inputs of formats that have no notion of profile type, e.g.
+	// collapsed, are always included.
+	ProfileType string
+	// Matchers filter samples (or whole profiles, depending on the format)
+	// by labels. Inputs without labels only match negating matchers.
+	Matchers []*flameql.TagMatcher
+}
+
+// Profile is the result of merging all the inputs.
+type Profile struct {
+	Name     string
+	Type     string
+	Tree     *tree.Tree
+	Metadata metadata.Metadata
+}
+
+// series is a profile of a single type with a fixed set of labels.
+type series struct {
+	typ      string
+	labels   map[string]string
+	tree     *tree.Tree
+	metadata metadata.Metadata
+	// filtered indicates that the samples are matched against
+	// the labels already, and labels of the series are not set.
+	filtered bool
+}
+
+// Read parses the inputs and merges them into a single profile.
+func Read(inputs []Input, options ReadOptions) (*Profile, error) {
+	var all []series
+	for _, in := range inputs {
+		s, err := readInput(in, options.Matchers)
+		if err != nil {
+			if in.Name != "" {
+				return nil, fmt.Errorf("%s: %w", in.Name, err)
+			}
+			return nil, err
+		}
+		all = append(all, s...)
+	}
+
+	typ, err := profileType(all, options.ProfileType)
+	if err != nil {
+		return nil, err
+	}
+	p := Profile{Type: typ, Tree: tree.New()}
+	if len(inputs) > 0 {
+		p.Name = inputs[0].Name
+	}
+	var found bool
+	for _, s := range all {
+		if s.typ != "" && s.typ != typ || !s.filtered && !matchLabels(options.Matchers, s.labels) {
+			continue
+		}
+		if !found {
+			p.Metadata = s.metadata
+			found = true
+		} else if s.metadata.Units != p.Metadata.Units {
+			return nil, fmt.Errorf("%w: %q and %q", ErrUnitsMismatch, p.Metadata.Units, s.metadata.Units)
+		}
+		p.Tree.Merge(s.tree)
+	}
+	if !found {
+		return nil, ErrNoProfiles
+	}
+	return &p, nil
+}
+
+// profileType returns the profile type to convert, if the inputs are typed.
+// By default, the type of the first typed profile is used.
+func profileType(all []series, typ string) (string, error) {
+	types := make(map[string]struct{})
+	var available []string
+	for _, s := range all {
+		if _, ok := types[s.typ]; !ok && s.typ != "" {
+			types[s.typ] = struct{}{}
+			available = append(available, s.typ)
+		}
+	}
+	if len(available) == 0 {
+		return "", nil
+	}
+	if typ == "" {
+		return available[0], nil
+	}
+	if _, ok := types[typ]; !ok {
+		return "", fmt.Errorf("%w: %q, available types: %s", ErrProfileTypeUnknown, typ, strings.Join(available, ", "))
+	}
+	return typ, nil
+}
+
+func matchLabels(matchers []*flameql.TagMatcher, labels map[string]string) bool {
+	for _, m := range matchers {
+		if !m.Match(labels[m.Key]) {
+			return false
+		}
+	}
+	return true
+}
+
+func readInput(in Input, matchers []*flameql.TagMatcher) ([]series, error) {
+	switch in.Format {
+	case InputFormatGroups, InputFormatCollapsed:
+		t := tree.New()
+		if err := convert.ParseGroups(bytes.NewReader(in.Data), t.InsertInt); err != nil {
+			return nil, err
+		}
+		return []series{untyped(t)}, nil
+
+	case InputFormatPerfScript:
+		events, err := perf.NewScriptParser(in.Data).ParseEvents()
+		if err != nil {
+			return nil, err
+		}
+		t := tree.New()
+		for _, e := range events {
+			t.InsertStack(e, 1)
+		}
+		return []series{untyped(t)}, nil
+
+	case InputFormatJSON:
+		var fb flamebearer.FlamebearerProfile
+		if err := json.Unmarshal(in.Data, &fb); err != nil {
+			return nil, fmt.Errorf("unable to unmarshall JSON: %w", err)
+		}
+		if err := fb.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid profile: %w", err)
+		}
+		t, err := flamebearer.ProfileToTree(fb)
+		if err != nil {
+			return nil, err
+		}
+		s := untyped(t)
+		s.metadata = metadata.Metadata{
+			SpyName:    fb.Metadata.SpyName,
+			SampleRate: fb.Metadata.SampleRate,
+			Units:      fb.Metadata.Units,
+		}
+		return []series{s}, nil
+
+	case InputFormatPprof:
+		return readPprof(in.Data, matchers)
+	case InputFormatJFR:
+		return readRawProfile(&jfr.RawProfile{RawData: in.Data})
+	case InputFormatSpeedscope:
+		return readRawProfile(&speedscope.RawProfile{RawData: in.Data})
+	case InputFormatCPUProfile:
+		return readRawProfile(&cpuprofile.RawProfile{RawData: in.Data})
+	case InputFormatGecko:
+		return readRawProfile(&gecko.RawProfile{RawData: in.Data})
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownInputFormat, in.Format)
+}
+
+func untyped(t *tree.Tree) series {
+	return series{
+		tree: t,
+		metadata: metadata.Metadata{
+			SpyName:    "unknown",
+			SampleRate: 100, // We don't have this information, use the default
+			Units:      metadata.SamplesUnits,
+		},
+	}
+}
+
+// readPprof reads all the sample types of the profile, unlike the ingestion
+// parser, which only handles the known ones. Samples are filtered by labels
+// here, because pprof labels are attached to individual samples.
+func readPprof(b []byte, matchers []*flameql.TagMatcher) ([]series, error) {
+	var p tree.Profile
+	if err := pprof.Decode(bytes.NewReader(b), &p); err != nil {
+		return nil, fmt.Errorf("parsing pprof: %w", err)
+	}
+	// Known sample types are named as on ingestion, which may
+	// collide with the names of unknown ones: e.g. Go CPU profiles
+	// have both "samples" (shown as "cpu") and "cpu" sample types.
+	names := make(map[string]struct{})
+	for _, st := range p.SampleTypes() {
+		if c, ok := tree.DefaultSampleTypeMapping[st]; ok && c.DisplayName != "" {
+			names[c.DisplayName] = struct{}{}
+		}
+	}
+	var result []series
+	for i, st := range p.SampleTypes() {
+		s := series{
+			typ:      st,
+			tree:     tree.New(),
+			filtered: true,
+			metadata: metadata.Metadata{
+				SpyName:    "unknown",
+				SampleRate: 100,
+				Units:      metadata.SamplesUnits,
+			},
+		}
+		if c, ok := tree.DefaultSampleTypeMapping[st]; ok {
+			if c.DisplayName != "" {
+				s.typ = c.DisplayName
+			}
+			if c.Units != "" {
+				s.metadata.Units = c.Units
+			}
+			if c.Sampled && p.Period > 0 {
+				s.metadata.SampleRate = uint32(time.Second / time.Duration(p.Period))
+			}
+			s.metadata.AggregationType = c.Aggregation
+		} else if _, ok = names[st]; ok {
+			s.typ = st + "_" + p.StringTable[p.SampleType[i].Unit]
+		}
+		err := p.Get(st, func(labels *spy.Labels, name []byte, val int) error {
+			if matchLabels(matchers, labels.Tags()) {
+				s.tree.Insert(name, uint64(val))
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// readRawProfile reads profiles of the formats accepted by the ingestion API.
+func readRawProfile(p ingestion.RawProfile) ([]series, error) {
+	var c collector
+	md := ingestion.Metadata{
+		Key:        segment.NewKey(map[string]string{"__name__": appName}),
+		SpyName:    "unknown",
+		SampleRate: 100,
+	}
+	if err := p.Parse(context.Background(), &c, nil, md); err != nil {
+		return nil, err
+	}
+	return c.series, nil
+}
+
+type collector struct{ series []series }
+
+func (c *collector) Put(_ context.Context, pi *storage.PutInput) error {
+	labels := make(map[string]string)
+	for k, v := range pi.Key.Labels() {
+		if k != flameql.ReservedTagKeyName {
+			labels[k] = v
+		}
+	}
+	typ := strings.TrimPrefix(strings.TrimPrefix(pi.Key.AppName(), appName), ".")
+	c.series = append(c.series, series{
+		typ:    typ,
+		labels: labels,
+		tree:   pi.Val,
+		metadata: metadata.Metadata{
+			SpyName:         pi.SpyName,
+			SampleRate:      pi.SampleRate,
+			Units:           pi.Units,
+			AggregationType: pi.AggregationType,
+		},
+	})
+	return nil
+}
@@ -0,0 +1,101 @@
+package offline
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/pyroscope-io/pyroscope/pkg/convert/speedscope"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/structs/flamebearer"
+	"github.com/pyroscope-io/pyroscope/pkg/structs/transporttrie"
+)
+
+type OutputFormat string
+
+const (
+	OutputFormatTree       OutputFormat = "tree"
+	OutputFormatTrie       OutputFormat = "trie"
+	OutputFormatPprof      OutputFormat = "pprof"
+	OutputFormatCollapsed  OutputFormat = "collapsed"
+	OutputFormatSpeedscope OutputFormat = "speedscope"
+	OutputFormatJSON       OutputFormat = "json"
+	OutputFormatHTML       OutputFormat = "html"
+)
+
+var ErrUnknownOutputFormat = errors.New("unknown output format")
+
+type WriteOptions struct {
+	Format OutputFormat
+	// MaxNodes limits the number of nodes in JSON and HTML output.
+	MaxNodes int
+	// Assets is the web application file system, required for HTML output.
+	Assets http.FileSystem
+}
+
+// Write writes the profile in the given format.
+func Write(w io.Writer, p *Profile, options WriteOptions) error {
+	switch options.Format {
+	case OutputFormatTree:
+		return p.Tree.SerializeTruncateNoDict(4096, w)
+
+	case OutputFormatTrie:
+		t := transporttrie.New()
+		p.Tree.IterateStacks(func(_ string, self uint64, stack []string) {
+			t.Insert([]byte(collapsed(stack)), self, true)
+		})
+		return t.Serialize(w)
+
+	case OutputFormatPprof:
+		b, err := proto.Marshal(p.Tree.Pprof(&tree.PprofMetadata{
+			Type: p.Type,
+			Unit: string(p.Metadata.Units),
+		}))
+		if err != nil {
+			return fmt.Errorf("could not serialize to pprof: %w", err)
+		}
+		_, err = w.Write(b)
+		return err
+
+	case OutputFormatCollapsed:
+		_, err := io.WriteString(w, p.Tree.Collapsed())
+		return err
+
+	case OutputFormatSpeedscope:
+		return speedscope.Encode(w, p.Tree, p.Name, p.Metadata.Units)
+
+	case OutputFormatJSON:
+		fb := p.flamebearer(options.MaxNodes)
+		return json.NewEncoder(w).Encode(&fb)
+
+	case OutputFormatHTML:
+		if options.Assets == nil {
+			return errors.New("assets are required for HTML output")
+		}
+		fb := p.flamebearer(options.MaxNodes)
+		return flamebearer.FlamebearerToStandaloneHTML(&fb, options.Assets, w)
+	}
+	return fmt.Errorf("%w: %q", ErrUnknownOutputFormat, options.Format)
+}
+
+func (p *Profile) flamebearer(maxNodes int) flamebearer.FlamebearerProfile {
+	return flamebearer.NewProfile(flamebearer.ProfileConfig{
+		Name:     p.Name,
+		Tree:     p.Tree,
+		MaxNodes: maxNodes,
+		Metadata: p.Metadata,
+	})
+}
+
+// collapsed joins the stack, given from the leaf to the root.
+func collapsed(stack []string) string {
+	for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+		stack[i], stack[j] = stack[j], stack[i]
+	}
+	return strings.Join(stack, ";")
+}
@@ -0,0 +1,409 @@
+// Package otlp converts OpenTelemetry profiles (OTLP) to trees.
+package otlp
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	collectorv1 "go.opentelemetry.io/proto/otlp/collector/profiles/v1development"
+	commonv1 "go.opentelemetry.io/proto/otlp/common/v1"
+	profilesv1 "go.opentelemetry.io/proto/otlp/profiles/v1development"
+	resourcev1 "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/pyroscope-io/pyroscope/pkg/flameql"
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+const (
+	// ContentType of the serialized export request.
+	ContentType = "application/x-protobuf"
+
+	// ServiceNameAttribute is the resource attribute
+	// holding the application name.
+	ServiceNameAttribute = "service.name"
+	// DefaultAppName is used when a resource has no service name.
+	DefaultAppName = "unknown_service"
+)
+
+// RawProfile implements ingestion.RawProfile for OTLP profiles.
+type RawProfile struct {
+	// RawData is a serialized ExportProfilesServiceRequest.
+	RawData []byte
+	// Request, if set, takes precedence over RawData.
+	Request *collectorv1.ExportProfilesServiceRequest
+}
+
+// Parse converts every profile of the request. Resource, scope,
+// profile, and sample attributes are mapped to the segment key labels,
+// and the sample types are appended to the application name.
+func (p *RawProfile) Parse(ctx context.Context, putter storage.Putter, _ storage.MetricsExporter, md ingestion.Metadata) error {
+	req := p.Request
+	if req == nil {
+		req = new(collectorv1.ExportProfilesServiceRequest)
+		if err := proto.Unmarshal(p.RawData, req); err != nil {
+			return fmt.Errorf("decoding export request: %w", err)
+		}
+	}
+	return Convert(ctx, putter, md, req)
+}
+
+// Bytes returns the serialized export request.
+func (p *RawProfile) Bytes() ([]byte, error) {
+	if p.RawData == nil && p.Request != nil {
+		b, err := proto.Marshal(p.Request)
+		if err != nil {
+			return nil, err
+		}
+		p.RawData = b
+	}
+	return p.RawData, nil
+}
+
+// ContentType returns the HTTP ContentType of the profile.
+func (*RawProfile) ContentType() string { return ContentType }
+
+// Convert puts the profiles of the export request. Labels of md.Key are
+// added to every profile, and md.Key application name is only used if
+// a resource has no service name.
+func Convert(ctx context.Context, putter storage.Putter, md ingestion.Metadata, req *collectorv1.ExportProfilesServiceRequest) error {
+	c := converter{
+		putter: putter,
+		md:     md,
+		dict:   req.Dictionary,
+	}
+	if c.dict == nil {
+		c.dict = new(profilesv1.ProfilesDictionary)
+	}
+	for _, rp := range req.ResourceProfiles {
+		resourceLabels := ResourceLabels(md.Key, rp.Resource)
+		for _, sp := range rp.ScopeProfiles {
+			scopeLabels := copyLabels(resourceLabels)
+			if sp.Scope != nil {
+				addAttributes(scopeLabels, sp.Scope.Attributes)
+			}
+			for _, x := range sp.Profiles {
+				if err := c.convert(ctx, x, scopeLabels); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// ResourceLabels returns the labels shared by all the profiles of the
+// resource: labels of key, if any, and the resource attributes. The service
+// name is mapped to the application name.
+func ResourceLabels(key *segment.Key, r *resourcev1.Resource) map[string]string {
+	labels := make(map[string]string)
+	if key != nil {
+		for k, v := range key.Labels() {
+			labels[k] = v
+		}
+	}
+	if r != nil {
+		addAttributes(labels, r.Attributes)
+	}
+	if v, ok := labels[labelName(ServiceNameAttribute)]; ok {
+		labels[flameql.ReservedTagKeyName] = appName(v)
+		delete(labels, labelName(ServiceNameAttribute))
+	}
+	if labels[flameql.ReservedTagKeyName] == "" {
+		labels[flameql.ReservedTagKeyName] = DefaultAppName
+	}
+	return labels
+}
+
+// NewResource creates a resource of the labels: ResourceLabels of
+// the resource are equal to the labels. The application name is
+// mapped to the service name.
+func NewResource(labels map[string]string) *resourcev1.Resource {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	r := &resourcev1.Resource{Attributes: make([]*commonv1.KeyValue, 0, len(keys))}
+	for _, k := range keys {
+		attr := k
+		if k == flameql.ReservedTagKeyName {
+			attr = ServiceNameAttribute
+		}
+		r.Attributes = append(r.Attributes, &commonv1.KeyValue{
+			Key:   attr,
+			Value: &commonv1.AnyValue{Value: &commonv1.AnyValue_StringValue{StringValue: labels[k]}},
+		})
+	}
+	return r
+}
+
+type converter struct {
+	putter storage.Putter
+	md     ingestion.Metadata
+	dict   *profilesv1.ProfilesDictionary
+}
+
+// sampleGroup holds trees of the samples sharing the same attributes.
+type sampleGroup struct {
+	labels map[string]string
+	trees  []*tree.Tree
+}
+
+func (c *converter) convert(ctx context.Context, x *profilesv1.Profile, labels map[string]string) error {
+	if len(x.SampleType) == 0 {
+		return nil
+	}
+	profileLabels := copyLabels(labels)
+	if err := c.addAttributeIndices(profileLabels, x.AttributeIndices); err != nil {
+		return err
+	}
+
+	groups := make(map[string]*sampleGroup)
+	stack := make([][]byte, 0, 16)
+	for _, s := range x.Sample {
+		var err error
+		if stack, err = c.appendStack(stack[:0], x, s); err != nil {
+			return err
+		}
+		k := groupKey(s.AttributeIndices)
+		g, ok := groups[k]
+		if !ok {
+			g = &sampleGroup{
+				labels: copyLabels(profileLabels),
+				trees:  make([]*tree.Tree, len(x.SampleType)),
+			}
+			if err = c.addAttributeIndices(g.labels, s.AttributeIndices); err != nil {
+				return err
+			}
+			groups[k] = g
+		}
+		values := s.Value
+		if len(values) == 0 && len(s.TimestampsUnixNano) > 0 {
+			// Each timestamp denotes a single occurrence.
+			values = []int64{int64(len(s.TimestampsUnixNano))}
+		}
+		for i, v := range values {
+			if i >= len(g.trees) || v <= 0 || len(stack) == 0 {
+				continue
+			}
+			if g.trees[i] == nil {
+				g.trees[i] = tree.New()
+			}
+			g.trees[i].InsertStack(stack, uint64(v))
+		}
+	}
+
+	startTime, endTime := c.md.StartTime, c.md.EndTime
+	if x.TimeNanos > 0 {
+		startTime = time.Unix(0, x.TimeNanos)
+		endTime = startTime.Add(time.Duration(x.DurationNanos))
+		if x.DurationNanos <= 0 {
+			endTime = startTime.Add(10 * time.Second)
+		}
+	}
+
+	for i, vt := range x.SampleType {
+		sampleType, err := c.str(vt.TypeStrindex)
+		if err != nil {
+			return err
+		}
+		unit, err := c.str(vt.UnitStrindex)
+		if err != nil {
+			return err
+		}
+		pi := storage.PutInput{
+			StartTime:       startTime,
+			EndTime:         endTime,
+			SpyName:         c.md.SpyName,
+			SampleRate:      c.md.SampleRate,
+			Units:           metadata.Units(unit),
+			AggregationType: metadata.SumAggregationType,
+		}
+		if cfg, ok := tree.DefaultSampleTypeMapping[sampleType]; ok {
+			if cfg.Units != "" {
+				pi.Units = cfg.Units
+			}
+			if cfg.Aggregation != "" {
+				pi.AggregationType = cfg.Aggregation
+			}
+			if cfg.Sampled {
+				if r := c.sampleRate(x); r > 0 {
+					pi.SampleRate = r
+				}
+			}
+			if cfg.DisplayName != "" {
+				sampleType = cfg.DisplayName
+			}
+		}
+		if pi.Units == "" {
+			pi.Units = metadata.SamplesUnits
+		}
+		pi.SampleType = sampleType
+		for _, g := range groups {
+			if g.trees[i] == nil {
+				continue
+			}
+			l := copyLabels(g.labels)
+			l[flameql.ReservedTagKeyName] += "." + sampleType
+			input := pi
+			input.Key = segment.NewKey(l)
+			input.Val = g.trees[i]
+			if err = c.putter.Put(ctx, &input); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// appendStack appends the sample stack frames to dst, root first.
+func (c *converter) appendStack(dst [][]byte, x *profilesv1.Profile, s *profilesv1.Sample) ([][]byte, error) {
+	start, n := int(s.LocationsStartIndex), int(s.LocationsLength)
+	if start < 0 || n < 0 || start+n > len(x.LocationIndices) {
+		return nil, fmt.Errorf("sample locations are out of range: %d+%d", start, n)
+	}
+	// Locations go from the leaf to the root.
+	for i := start + n - 1; i >= start; i-- {
+		li := x.LocationIndices[i]
+		if li < 0 || int(li) >= len(c.dict.LocationTable) {
+			return nil, fmt.Errorf("location index is invalid: %d", li)
+		}
+		loc := c.dict.LocationTable[li]
+		// The last line is the caller into which
+		// the preceding ones were inlined.
+		for j := len(loc.Line) - 1; j >= 0; j-- {
+			fi := loc.Line[j].FunctionIndex
+			if fi < 0 || int(fi) >= len(c.dict.FunctionTable) {
+				return nil, fmt.Errorf("function index is invalid: %d", fi)
+			}
+			name, err := c.str(c.dict.FunctionTable[fi].NameStrindex)
+			if err != nil {
+				return nil, err
+			}
+			if name != "" {
+				dst = append(dst, []byte(name))
+			}
+		}
+	}
+	return dst, nil
+}
+
+func (c *converter) sampleRate(x *profilesv1.Profile) uint32 {
+	if x.Period <= 0 || x.PeriodType == nil {
+		return 0
+	}
+	sampleUnit := time.Nanosecond
+	u, _ := c.str(x.PeriodType.UnitStrindex)
+	switch u {
+	case "microseconds":
+		sampleUnit = time.Microsecond
+	case "milliseconds":
+		sampleUnit = time.Millisecond
+	case "seconds":
+		sampleUnit = time.Second
+	}
+	return uint32(time.Second / (sampleUnit * time.Duration(x.Period)))
+}
+
+func (c *converter) str(i int32) (string, error) {
+	if i == 0 && len(c.dict.StringTable) == 0 {
+		return "", nil
+	}
+	if i < 0 || int(i) >= len(c.dict.StringTable) {
+		return "", fmt.Errorf("string index is invalid: %d", i)
+	}
+	return c.dict.StringTable[i], nil
+}
+
+func (c *converter) addAttributeIndices(labels map[string]string, indices []int32) error {
+	for _, i := range indices {
+		if i < 0 || int(i) >= len(c.dict.AttributeTable) {
+			return fmt.Errorf("attribute index is invalid: %d", i)
+		}
+		addAttribute(labels, c.dict.AttributeTable[i])
+	}
+	return nil
+}
+
+func addAttributes(labels map[string]string, attrs []*commonv1.KeyValue) {
+	for _, kv := range attrs {
+		addAttribute(labels, kv)
+	}
+}
+
+// addAttribute adds the attribute with a scalar value to labels.
+func addAttribute(labels map[string]string, kv *commonv1.KeyValue) {
+	var v string
+	switch x := kv.GetValue().GetValue().(type) {
+	case *commonv1.AnyValue_StringValue:
+		v = x.StringValue
+	case *commonv1.AnyValue_IntValue:
+		v = strconv.FormatInt(x.IntValue, 10)
+	case *commonv1.AnyValue_BoolValue:
+		v = strconv.FormatBool(x.BoolValue)
+	case *commonv1.AnyValue_DoubleValue:
+		v = strconv.FormatFloat(x.DoubleValue, 'g', -1, 64)
+	default:
+		return
+	}
+	k := labelName(kv.Key)
+	if v == "" || k == "" || flameql.IsTagKeyReserved(k) {
+		return
+	}
+	labels[k] = v
+}
+
+// labelName replaces characters not allowed in tag keys with
+// underscores, e.g. "host.name" becomes "host_name".
+func labelName(k string) string {
+	return strings.Map(func(r rune) rune {
+		if flameql.IsTagKeyRuneAllowed(r) {
+			return r
+		}
+		return '_'
+	}, k)
+}
+
+// appName replaces characters not allowed in application names.
+func appName(n string) string {
+	return strings.Map(func(r rune) rune {
+		if flameql.IsAppNameRuneAllowed(r) {
+			return r
+		}
+		return '_'
+	}, n)
+}
+
+func groupKey(indices []int32) string {
+	if len(indices) == 0 {
+		return ""
+	}
+	s := make([]int, len(indices))
+	for i, v := range indices {
+		s[i] = int(v)
+	}
+	sort.Ints(s)
+	var b strings.Builder
+	for _, v := range s {
+		b.WriteString(strconv.Itoa(v))
+		b.WriteByte(',')
+	}
+	return b.String()
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	c := make(map[string]string, len(labels))
+	for k, v := range labels {
+		c[k] = v
+	}
+	return c
+}
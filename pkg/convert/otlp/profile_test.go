@@ -0,0 +1,151 @@
+package otlp_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	collectorv1 "go.opentelemetry.io/proto/otlp/collector/profiles/v1development"
+	commonv1 "go.opentelemetry.io/proto/otlp/common/v1"
+	profilesv1 "go.opentelemetry.io/proto/otlp/profiles/v1development"
+	resourcev1 "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/pyroscope-io/pyroscope/pkg/convert/otlp"
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+type mockPutter struct{ actual []*storage.PutInput }
+
+func (m *mockPutter) Put(_ context.Context, p *storage.PutInput) error {
+	m.actual = append(m.actual, p)
+	return nil
+}
+
+func stringAttr(k, v string) *commonv1.KeyValue {
+	return &commonv1.KeyValue{Key: k, Value: &commonv1.AnyValue{Value: &commonv1.AnyValue_StringValue{StringValue: v}}}
+}
+
+// exportRequest creates a request with a CPU profile of two
+// samples: main;foo;bar and main;baz, the latter has an attribute.
+func exportRequest() *collectorv1.ExportProfilesServiceRequest {
+	strings := []string{"", "samples", "count", "cpu", "nanoseconds", "main", "foo", "bar", "baz", "alloc_space", "bytes"}
+	fn := func(name int32) *profilesv1.Function { return &profilesv1.Function{NameStrindex: name} }
+	loc := func(fns ...int32) *profilesv1.Location {
+		l := new(profilesv1.Location)
+		for _, f := range fns {
+			l.Line = append(l.Line, &profilesv1.Line{FunctionIndex: f})
+		}
+		return l
+	}
+	return &collectorv1.ExportProfilesServiceRequest{
+		Dictionary: &profilesv1.ProfilesDictionary{
+			StringTable:   strings,
+			FunctionTable: []*profilesv1.Function{fn(5), fn(6), fn(7), fn(8)},
+			// bar is inlined into foo.
+			LocationTable:  []*profilesv1.Location{loc(0), loc(2, 1), loc(3)},
+			AttributeTable: []*commonv1.KeyValue{stringAttr("thread.name", "worker")},
+		},
+		ResourceProfiles: []*profilesv1.ResourceProfiles{{
+			Resource: &resourcev1.Resource{Attributes: []*commonv1.KeyValue{
+				stringAttr("service.name", "my-svc"),
+				stringAttr("host.name", "node-1"),
+			}},
+			ScopeProfiles: []*profilesv1.ScopeProfiles{{
+				Profiles: []*profilesv1.Profile{{
+					SampleType: []*profilesv1.ValueType{
+						{TypeStrindex: 1, UnitStrindex: 2},
+						{TypeStrindex: 9, UnitStrindex: 10},
+					},
+					PeriodType:      &profilesv1.ValueType{TypeStrindex: 3, UnitStrindex: 4},
+					Period:          10000000,
+					TimeNanos:       time.Unix(1000, 0).UnixNano(),
+					DurationNanos:   int64(10 * time.Second),
+					LocationIndices: []int32{1, 0, 2, 0},
+					Sample: []*profilesv1.Sample{
+						{LocationsStartIndex: 0, LocationsLength: 2, Value: []int64{3, 1024}},
+						{LocationsStartIndex: 2, LocationsLength: 2, Value: []int64{2, 0}, AttributeIndices: []int32{0}},
+					},
+				}},
+			}},
+		}},
+	}
+}
+
+var _ = Describe("OTLP", func() {
+	var (
+		putter *mockPutter
+		md     ingestion.Metadata
+	)
+
+	BeforeEach(func() {
+		putter = new(mockPutter)
+		md = ingestion.Metadata{
+			Key:        segment.NewKey(map[string]string{"__name__": "fallback", "env": "test"}),
+			SpyName:    "otlp",
+			SampleRate: 100,
+		}
+	})
+
+	inputs := func() map[string]*storage.PutInput {
+		m := make(map[string]*storage.PutInput)
+		for _, pi := range putter.actual {
+			m[pi.Key.Normalized()] = pi
+		}
+		return m
+	}
+
+	It("maps attributes to labels and sample types to app names", func() {
+		b, err := proto.Marshal(exportRequest())
+		Expect(err).ToNot(HaveOccurred())
+		p := &otlp.RawProfile{RawData: b}
+		Expect(p.Parse(context.Background(), putter, nil, md)).To(Succeed())
+
+		actual := inputs()
+		Expect(actual).To(HaveLen(3))
+
+		cpu := actual["my-svc.cpu{env=test,host_name=node-1}"]
+		Expect(cpu).ToNot(BeNil())
+		Expect(cpu.Val.String()).To(Equal("main;foo;bar 3\n"))
+		Expect(cpu.Units).To(Equal(metadata.SamplesUnits))
+		Expect(cpu.SampleRate).To(Equal(uint32(100)))
+		Expect(cpu.SpyName).To(Equal("otlp"))
+		Expect(cpu.StartTime).To(Equal(time.Unix(1000, 0)))
+		Expect(cpu.EndTime).To(Equal(time.Unix(1010, 0)))
+
+		thread := actual["my-svc.cpu{env=test,host_name=node-1,thread_name=worker}"]
+		Expect(thread).ToNot(BeNil())
+		Expect(thread.Val.String()).To(Equal("main;baz 2\n"))
+
+		alloc := actual["my-svc.alloc_space{env=test,host_name=node-1}"]
+		Expect(alloc).ToNot(BeNil())
+		Expect(alloc.Val.String()).To(Equal("main;foo;bar 1024\n"))
+		Expect(alloc.Units).To(Equal(metadata.BytesUnits))
+	})
+
+	It("uses the key app name for resources without service name", func() {
+		req := exportRequest()
+		req.ResourceProfiles[0].Resource = nil
+		Expect(otlp.Convert(context.Background(), putter, md, req)).To(Succeed())
+		Expect(inputs()).To(HaveKey("fallback.cpu{env=test}"))
+	})
+
+	It("fails on invalid indices", func() {
+		req := exportRequest()
+		req.ResourceProfiles[0].ScopeProfiles[0].Profiles[0].LocationIndices[0] = 42
+		Expect(otlp.Convert(context.Background(), putter, md, req)).ToNot(Succeed())
+	})
+
+	It("serializes the request", func() {
+		p := &otlp.RawProfile{Request: exportRequest()}
+		b, err := p.Bytes()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.ContentType()).To(Equal("application/x-protobuf"))
+		Expect((&otlp.RawProfile{RawData: b}).Parse(context.Background(), putter, nil, md)).To(Succeed())
+		Expect(putter.actual).To(HaveLen(3))
+	})
+})
@@ -0,0 +1,134 @@
+package pprof
+
+import (
+	"context"
+	"hash/fnv"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/util/genericlru"
+)
+
+// DeltaTracker computes deltas of cumulative sample types of profiles that
+// are pushed without the previous profile: the last profile of every series
+// (application, sample type and labels) is kept in memory, and the next one
+// is diffed against it.
+//
+// The number of series tracked is limited: the least recently updated
+// ones are evicted first. Evicted and expired series start over: the
+// first profile of a series is only remembered and is not ingested.
+type DeltaTracker struct {
+	m      sync.Mutex
+	ttl    time.Duration
+	series *genericlru.GenericLRU[string, deltaEntry]
+	now    func() time.Time
+
+	// Profiles of a series are diffed one at a time, while profiles
+	// of different series are diffed concurrently.
+	seriesLocks [deltaSeriesLocks]sync.Mutex
+}
+
+const deltaSeriesLocks = 64
+
+type DeltaTrackerConfig struct {
+	// MaxSeries is the max number of series tracked.
+	MaxSeries int
+	// TTL is the max age of the previous profile of a series.
+	// Older profiles are not used for delta computation.
+	TTL time.Duration
+}
+
+type deltaEntry struct {
+	tree    *tree.Tree
+	updated time.Time
+}
+
+func NewDeltaTracker(c DeltaTrackerConfig) (*DeltaTracker, error) {
+	series, err := genericlru.NewGenericLRU[string, deltaEntry](c.MaxSeries, func(string, *deltaEntry) {})
+	if err != nil {
+		return nil, err
+	}
+	return &DeltaTracker{
+		ttl:    c.TTL,
+		series: series,
+		now:    time.Now,
+	}, nil
+}
+
+// Delta returns the difference between the given cumulative profile
+// and the previous one of the same series. The tree t is retained
+// and must not be modified by the caller.
+//
+// If the series is new, the function returns false. If the total has
+// decreased, which indicates that the process has been restarted, the
+// profile is considered to be the delta itself.
+func (d *DeltaTracker) Delta(key string, t *tree.Tree) (*tree.Tree, bool) {
+	l := d.seriesLock(key)
+	l.Lock()
+	defer l.Unlock()
+	now := d.now()
+	d.m.Lock()
+	prev, ok := d.series.Get(key)
+	d.series.Add(key, &deltaEntry{tree: t, updated: now})
+	d.m.Unlock()
+	if !ok || d.ttl > 0 && now.Sub(prev.updated) > d.ttl {
+		return nil, false
+	}
+	if t.Samples() < prev.tree.Samples() {
+		return t.Clone(big.NewRat(1, 1)), true
+	}
+	// The result is written to prev, t is not changed.
+	return prev.tree.Diff(t), true
+}
+
+func (d *DeltaTracker) seriesLock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return &d.seriesLocks[h.Sum32()%deltaSeriesLocks]
+}
+
+// sampleTypes returns a copy of the sample type config, where cumulative
+// sample types are handled as regular ones, and the names under which
+// they are ingested.
+func (*DeltaTracker) sampleTypes(c map[string]*tree.SampleTypeConfig) (map[string]*tree.SampleTypeConfig, map[string]struct{}) {
+	sampleTypes := make(map[string]*tree.SampleTypeConfig, len(c))
+	cumulative := make(map[string]struct{})
+	for name, stc := range c {
+		if !stc.Cumulative {
+			sampleTypes[name] = stc
+			continue
+		}
+		x := *stc
+		x.Cumulative = false
+		sampleTypes[name] = &x
+		if stc.DisplayName != "" {
+			name = stc.DisplayName
+		}
+		cumulative[name] = struct{}{}
+	}
+	return sampleTypes, cumulative
+}
+
+// deltaPutter replaces trees of cumulative sample types with deltas.
+type deltaPutter struct {
+	storage.Putter
+	tracker    *DeltaTracker
+	cumulative map[string]struct{}
+}
+
+func (p deltaPutter) Put(ctx context.Context, pi *storage.PutInput) error {
+	if _, ok := p.cumulative[pi.SampleType]; !ok {
+		return p.Putter.Put(ctx, pi)
+	}
+	// The tree is retained by the tracker: the putter gets a copy.
+	delta, ok := p.tracker.Delta(pi.Key.Normalized(), pi.Val.Clone(big.NewRat(1, 1)))
+	if !ok {
+		return nil
+	}
+	x := *pi
+	x.Val = delta
+	return p.Putter.Put(ctx, &x)
+}
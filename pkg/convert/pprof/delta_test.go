@@ -0,0 +1,108 @@
+package pprof
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+func cumulativeProfile(stacks ...string) []byte {
+	t := tree.New()
+	for _, s := range stacks {
+		i := strings.LastIndexByte(s, ' ')
+		v, err := strconv.Atoi(s[i+1:])
+		Expect(err).ToNot(HaveOccurred())
+		t.Insert([]byte(s[:i]), uint64(v))
+	}
+	b, err := proto.Marshal(t.Pprof(&tree.PprofMetadata{Type: "alloc_space", Unit: "bytes"}))
+	Expect(err).ToNot(HaveOccurred())
+	return b
+}
+
+var _ = Describe("pprof delta tracker", func() {
+	var tracker *DeltaTracker
+
+	BeforeEach(func() {
+		var err error
+		tracker, err = NewDeltaTracker(DeltaTrackerConfig{MaxSeries: 2, TTL: time.Minute})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	treeOf := func(stacks ...string) *tree.Tree {
+		t := tree.New()
+		for _, s := range stacks {
+			t.Insert([]byte(s), 1)
+		}
+		return t
+	}
+
+	It("computes deltas of consecutive profiles", func() {
+		_, ok := tracker.Delta("a", treeOf("x", "y"))
+		Expect(ok).To(BeFalse())
+		d, ok := tracker.Delta("a", treeOf("x", "y", "y"))
+		Expect(ok).To(BeTrue())
+		Expect(d.String()).To(Equal("y 1\n"))
+	})
+
+	It("handles process restarts", func() {
+		tracker.Delta("a", treeOf("x", "y"))
+		d, ok := tracker.Delta("a", treeOf("z"))
+		Expect(ok).To(BeTrue())
+		Expect(d.String()).To(Equal("z 1\n"))
+	})
+
+	It("forgets expired and evicted series", func() {
+		now := time.Now()
+		tracker.now = func() time.Time { return now }
+		tracker.Delta("a", treeOf("x"))
+		now = now.Add(2 * time.Minute)
+		_, ok := tracker.Delta("a", treeOf("x", "x"))
+		Expect(ok).To(BeFalse())
+
+		tracker.Delta("b", treeOf("x"))
+		tracker.Delta("c", treeOf("x"))
+		_, ok = tracker.Delta("a", treeOf("x", "x", "x"))
+		Expect(ok).To(BeFalse())
+	})
+
+	DescribeTable("ingests deltas of pushed cumulative profiles",
+		func(streaming bool) {
+			md := ingestion.Metadata{Key: segment.NewKey(map[string]string{"__name__": "app", "foo": "bar"})}
+			push := func(stacks ...string) []string {
+				ingester := new(mockIngester)
+				p := &RawProfile{
+					RawData:         cumulativeProfile(stacks...),
+					StreamingParser: streaming,
+					DeltaTracker:    tracker,
+				}
+				Expect(p.Parse(context.Background(), ingester, nil, md)).To(Succeed())
+				var r []string
+				for _, pi := range ingester.actual {
+					Expect(pi.Key.Normalized()).To(Equal("app.alloc_space{foo=bar}"))
+					for _, line := range strings.Split(pi.Val.String(), "\n") {
+						if line != "" {
+							r = append(r, line)
+						}
+					}
+				}
+				return r
+			}
+
+			Expect(push("a;b 10")).To(BeEmpty())
+			Expect(push("a;b 15", "a;c 5")).To(ConsistOf("a;b 5", "a;c 5"))
+			Expect(push("a;b 15", "a;c 5")).To(BeEmpty())
+			Expect(push("a;b 3")).To(ConsistOf("a;b 3"))
+		},
+		Entry("parser", false),
+		Entry("streaming parser", true),
+	)
+})
@@ -1,14 +1,20 @@
 package pprof
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"mime/multipart"
+	"os"
 	"sort"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
 	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
 )
 
@@ -288,3 +294,60 @@ var _ = Describe("custom pprof parsing", func() {
 		Expect(input.Val.String()).To(ContainSubstring("parserOnHeadersComplete;parserOnIncoming 524448"))
 	})
 })
+
+var _ = Describe("pprof size limits", func() {
+	var b []byte
+
+	BeforeEach(func() {
+		var err error
+		b, err = os.ReadFile("testdata/cpu.pb.gz")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	md := ingestion.Metadata{Key: segment.NewKey(map[string]string{"__name__": "app"})}
+
+	It("parses profiles within the limit", func() {
+		p := &RawProfile{RawData: b, StreamingParser: true, MaxDecompressedSize: 1 << 20}
+		Expect(p.Parse(context.Background(), new(mockIngester), nil, md)).To(Succeed())
+	})
+
+	It("rejects profiles that decompress beyond the limit", func() {
+		p := &RawProfile{RawData: b, StreamingParser: true, MaxDecompressedSize: int64(len(b))}
+		err := p.Parse(context.Background(), new(mockIngester), nil, md)
+		Expect(errors.Is(err, ingestion.ErrProfileTooLarge)).To(BeTrue())
+	})
+
+	It("rejects forms exceeding the memory budget", func() {
+		var buf bytes.Buffer
+		w := multipart.NewWriter(&buf)
+		fw, err := w.CreateFormFile("profile", "profile.pprof")
+		Expect(err).ToNot(HaveOccurred())
+		_, _ = fw.Write(b)
+		Expect(w.Close()).To(Succeed())
+		p := &RawProfile{
+			FormDataContentType: w.FormDataContentType(),
+			RawData:             buf.Bytes(),
+			StreamingParser:     true,
+			MemoryBudget:        int64(buf.Len() + len(b)/2),
+		}
+		err = p.Parse(context.Background(), new(mockIngester), nil, md)
+		Expect(errors.Is(err, ingestion.ErrProfileTooLarge)).To(BeTrue())
+	})
+
+	It("decompresses profiles loaded from forms", func() {
+		var buf bytes.Buffer
+		w := multipart.NewWriter(&buf)
+		fw, err := w.CreateFormFile("profile", "profile.pprof")
+		Expect(err).ToNot(HaveOccurred())
+		_, _ = fw.Write(b)
+		Expect(w.Close()).To(Succeed())
+		p := &RawProfile{FormDataContentType: w.FormDataContentType(), StreamingParser: true, MemoryBudget: 1 << 20}
+		Expect(p.Load(bytes.NewReader(buf.Bytes()))).To(Succeed())
+		Expect(p.Profile[:2]).ToNot(Equal(b[:2]))
+		Expect(p.Parse(context.Background(), new(mockIngester), nil, md)).To(Succeed())
+
+		p = &RawProfile{FormDataContentType: w.FormDataContentType(), StreamingParser: true, MaxDecompressedSize: int64(len(b))}
+		err = p.Load(bytes.NewReader(buf.Bytes()))
+		Expect(errors.Is(err, ingestion.ErrProfileTooLarge)).To(BeTrue())
+	})
+})
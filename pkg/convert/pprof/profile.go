@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/pyroscope-io/pyroscope/pkg/convert/pprof/streaming"
 	"github.com/pyroscope-io/pyroscope/pkg/stackbuilder"
 	"io"
@@ -36,6 +38,17 @@ type RawProfile struct {
 	PoolStreamingParser bool
 	ArenasEnabled       bool
 	SampleTypeConfig    map[string]*tree.SampleTypeConfig
+
+	// MaxDecompressedSize limits the size of a profile after decompression.
+	// Only honored by the streaming parser. Zero means no limit.
+	MaxDecompressedSize int64
+	// MemoryBudget limits the total size of the raw data, profiles read
+	// from the multipart form, and a decompressed profile. Only honored
+	// by the streaming parser. Zero means no limit.
+	MemoryBudget int64
+	// DeltaTracker, if set, is used to compute deltas of cumulative
+	// sample types when the profile comes without PreviousProfile.
+	DeltaTracker *DeltaTracker
 }
 
 func (p *RawProfile) ContentType() string {
@@ -125,6 +138,11 @@ func (p *RawProfile) Parse(ctx context.Context, putter storage.Putter, _ storage
 
 	if p.parser == nil {
 		sampleTypes := p.getSampleTypes()
+		if p.DeltaTracker != nil && len(p.PreviousProfile) == 0 {
+			var cumulative map[string]struct{}
+			sampleTypes, cumulative = p.DeltaTracker.sampleTypes(sampleTypes)
+			putter = deltaPutter{Putter: putter, tracker: p.DeltaTracker, cumulative: cumulative}
+		}
 		if p.StreamingParser {
 			config := streaming.ParserConfig{
 				SpyName:     md.SpyName,
@@ -132,6 +150,8 @@ func (p *RawProfile) Parse(ctx context.Context, putter storage.Putter, _ storage
 				Putter:      putter,
 				SampleTypes: sampleTypes,
 				Formatter:   streaming.StackFrameFormatterForSpyName(md.SpyName),
+
+				MaxDecompressedSize: p.decompressionLimit(),
 			}
 			if p.PoolStreamingParser {
 				parser := streaming.VTStreamingParserFromPool(config)
@@ -169,13 +189,13 @@ func (p *RawProfile) Parse(ctx context.Context, putter storage.Putter, _ storage
 			// include regular ones.
 			cumulativeOnly := true
 			if err := p.parser.ParsePprof(ctx, md.StartTime, md.EndTime, p.PreviousProfile, cumulativeOnly); err != nil {
-				return err
+				return parseError(err)
 			}
 		}
 	}
 
 	if err := p.parser.ParsePprof(ctx, md.StartTime, md.EndTime, p.Profile, false); err != nil {
-		return err
+		return parseError(err)
 	}
 
 	// Propagate parser to the next profile, if it is present.
@@ -199,14 +219,25 @@ func (p *RawProfile) ParseWithWriteBatch(c context.Context, wb stackbuilder.Writ
 		SampleTypes:   p.getSampleTypes(),
 		Formatter:     streaming.StackFrameFormatterForSpyName(md.SpyName),
 		ArenasEnabled: true,
+
+		MaxDecompressedSize: p.decompressionLimit(),
 	})
 	defer parser.FreeArena()
-	return parser.ParseWithWriteBatch(streaming.ParseWriteBatchInput{
+	return parseError(parser.ParseWithWriteBatch(streaming.ParseWriteBatchInput{
 		Context:   c,
 		StartTime: md.StartTime, EndTime: md.EndTime,
 		Profile: p.Profile, Previous: p.PreviousProfile,
 		WriteBatchFactory: wb,
-	})
+	}))
+}
+
+// parseError reports profiles exceeding the
+// decompression limit as ingestion.ErrProfileTooLarge.
+func parseError(err error) error {
+	if errors.Is(err, streaming.ErrDecompressedSizeLimit) {
+		return fmt.Errorf("%w: %v", ingestion.ErrProfileTooLarge, err)
+	}
+	return err
 }
 
 func (p *RawProfile) getSampleTypes() map[string]*tree.SampleTypeConfig {
@@ -240,35 +271,119 @@ func (p *RawProfile) handleRawData() (cont bool, err error) {
 	return true, nil
 }
 
+// decompressionLimit returns the max size of a decompressed profile
+// that fits into the memory budget.
+func (p *RawProfile) decompressionLimit() int64 {
+	limit := p.MaxDecompressedSize
+	if p.MemoryBudget > 0 {
+		used := len(p.RawData)
+		if p.RawData == nil || p.FormDataContentType != "" {
+			// Otherwise, Profile refers to RawData.
+			used += len(p.Profile) + len(p.PreviousProfile)
+		}
+		remaining := p.MemoryBudget - int64(used)
+		if remaining <= 0 {
+			// Nothing left: any gzip-compressed profile is rejected.
+			remaining = 1
+		}
+		if limit == 0 || remaining < limit {
+			limit = remaining
+		}
+	}
+	return limit
+}
+
+// Load reads the profile from r: a multipart form, if FormDataContentType
+// is set, or a pprof profile otherwise. Profiles are decompressed as they
+// are read, therefore neither r nor compressed profiles are kept in memory.
+// The decompressed profiles are limited to MaxDecompressedSize and the
+// memory budget: ingestion.ErrProfileTooLarge is returned if exceeded.
+func (p *RawProfile) Load(r io.Reader) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.FormDataContentType != "" {
+		return p.loadForm(r)
+	}
+	var err error
+	p.Profile, err = p.readProfile(r)
+	// The decompressed profile is sent as is by Bytes.
+	p.RawData = p.Profile
+	return err
+}
+
 func (p *RawProfile) loadPprofFromForm() error {
+	return p.loadForm(bytes.NewReader(p.RawData))
+}
+
+// loadForm loads Profile, PreviousProfile, and SampleTypeConfig
+// from the multipart form parts. Other parts are skipped.
+func (p *RawProfile) loadForm(r io.Reader) error {
 	boundary, err := form.ParseBoundary(p.FormDataContentType)
 	if err != nil {
 		return err
 	}
-
-	f, err := multipart.NewReader(bytes.NewReader(p.RawData), boundary).ReadForm(32 << 20)
-	if err != nil {
-		return err
+	mr := multipart.NewReader(r, boundary)
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch part.FormName() {
+		case formFieldProfile:
+			if p.Profile == nil {
+				p.Profile, err = p.readProfile(part)
+			}
+		case formFieldPreviousProfile:
+			if p.PreviousProfile == nil {
+				p.PreviousProfile, err = p.readProfile(part)
+			}
+		case formFieldSampleTypeConfig:
+			if p.SampleTypeConfig == nil {
+				err = p.readSampleTypeConfig(part)
+			}
+		}
+		if err != nil {
+			return err
+		}
 	}
-	defer func() {
-		_ = f.RemoveAll()
-	}()
+}
 
-	p.Profile, err = form.ReadField(f, formFieldProfile)
-	if err != nil {
-		return err
+// readProfile reads the profile, which must fit into the memory budget
+// along with the raw data and the profiles that have been read already.
+func (p *RawProfile) readProfile(r io.Reader) ([]byte, error) {
+	limit := p.MaxDecompressedSize
+	if p.MemoryBudget > 0 {
+		remaining := p.MemoryBudget - int64(len(p.RawData)+len(p.Profile)+len(p.PreviousProfile))
+		if remaining <= 0 {
+			return nil, ingestion.ErrProfileTooLarge
+		}
+		if limit == 0 || remaining < limit {
+			limit = remaining
+		}
 	}
-	p.PreviousProfile, err = form.ReadField(f, formFieldPreviousProfile)
+	b, err := streaming.ReadProfile(r, limit)
 	if err != nil {
-		return err
+		return nil, parseError(err)
+	}
+	if len(b) == 0 {
+		return nil, nil
 	}
+	return b, nil
+}
 
-	r, err := form.ReadField(f, formFieldSampleTypeConfig)
-	if err != nil || r == nil {
-		return err
+func (p *RawProfile) readSampleTypeConfig(r io.Reader) error {
+	if p.MemoryBudget > 0 {
+		r = io.LimitReader(r, p.MemoryBudget)
 	}
 	var config map[string]*tree.SampleTypeConfig
-	if err = json.Unmarshal(r, &config); err != nil {
+	switch err := json.NewDecoder(r).Decode(&config); {
+	case err == io.EOF:
+		// The field is empty.
+		return nil
+	case err != nil:
 		return err
 	}
 	p.SampleTypeConfig = config
@@ -1,9 +1,11 @@
 package streaming
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/pyroscope-io/pyroscope/pkg/stackbuilder"
 	"github.com/pyroscope-io/pyroscope/pkg/storage"
@@ -30,6 +32,10 @@ const (
 
 var PPROFBufPool = bytebufferpool.Pool{}
 
+// ErrDecompressedSizeLimit indicates that the profile
+// decompresses beyond ParserConfig.MaxDecompressedSize.
+var ErrDecompressedSizeLimit = errors.New("decompressed profile exceeds the size limit")
+
 type ParserConfig struct {
 	Putter        storage.Putter
 	SpyName       string
@@ -37,6 +43,9 @@ type ParserConfig struct {
 	SampleTypes   map[string]*tree.SampleTypeConfig
 	Formatter     StackFormatter
 	ArenasEnabled bool
+	// MaxDecompressedSize limits the size of a gzip-compressed profile
+	// after decompression. Zero means no limit.
+	MaxDecompressedSize int64
 }
 
 type VTStreamingParser struct {
@@ -49,6 +58,8 @@ type VTStreamingParser struct {
 	Formatter         StackFormatter
 	ArenasEnabled     bool
 
+	maxDecompressedSize int64
+
 	sampleTypesFilter func(string) bool
 
 	startTime      time.Time
@@ -99,7 +110,7 @@ func (p *VTStreamingParser) ParsePprof(ctx context.Context, startTime, endTime t
 	p.ctx = ctx
 	p.cumulativeOnly = cumulativeOnly
 
-	err = decompress(bs, func(profile []byte) error {
+	err = decompress(bs, p.maxDecompressedSize, func(profile []byte) error {
 		p.profile = profile
 		err := p.parsePprofDecompressed()
 		p.profile = nil
@@ -322,6 +333,7 @@ func (p *VTStreamingParser) put(stIndex int, st *valueType, l Labels, t *tree.Tr
 		}
 	}
 	pi.Key = p.buildName(sampleType, p.ResolveLabels(l))
+	pi.SampleType = sampleType
 	err = p.putter.Put(p.ctx, &pi)
 	return sampleTypeConfig.Cumulative, err
 }
@@ -432,6 +444,7 @@ func (p *VTStreamingParser) Reset(config ParserConfig) {
 	p.sampleTypesFilter = filterKnownSamples(config.SampleTypes)
 	p.Formatter = config.Formatter
 	p.ArenasEnabled = config.ArenasEnabled
+	p.maxDecompressedSize = config.MaxDecompressedSize
 	if config.ArenasEnabled {
 		p.arena = arenahelper.NewArenaWrapper()
 		p.previousCache.arena = p.arena
@@ -470,7 +483,34 @@ func StackFrameFormatterForSpyName(spyName string) StackFormatter {
 	return StackFrameFormatterGo
 }
 
-func decompress(bs []byte, f func([]byte) error) error {
+// ReadProfile reads the profile from r, decompressing it as it is read
+// if it is gzip-compressed. ErrDecompressedSizeLimit is returned if the
+// profile exceeds limit bytes, unless limit is zero.
+func ReadProfile(r io.Reader, limit int64) ([]byte, error) {
+	br := bufio.NewReader(r)
+	var pr io.Reader = br
+	if h, err := br.Peek(2); err == nil && h[0] == 0x1f && h[1] == 0x8b {
+		gzipr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create pprof profile zip reader: %w", err)
+		}
+		defer gzipr.Close()
+		pr = gzipr
+	}
+	if limit > 0 {
+		pr = io.LimitReader(pr, limit+1)
+	}
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(pr); err != nil {
+		return nil, fmt.Errorf("failed to read pprof profile: %w", err)
+	}
+	if limit > 0 && int64(buf.Len()) > limit {
+		return nil, fmt.Errorf("%w of %d bytes", ErrDecompressedSizeLimit, limit)
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(bs []byte, limit int64, f func([]byte) error) error {
 	var err error
 	if len(bs) < 2 {
 		err = fmt.Errorf("failed to read pprof profile header")
@@ -480,9 +520,15 @@ func decompress(bs []byte, f func([]byte) error) error {
 		if err != nil {
 			err = fmt.Errorf("failed to create pprof profile zip reader: %w", err)
 		} else {
+			var r io.Reader = gzipr
+			if limit > 0 {
+				r = io.LimitReader(gzipr, limit+1)
+			}
 			buf := PPROFBufPool.Get()
-			if _, err = io.Copy(buf, gzipr); err != nil {
+			if _, err = io.Copy(buf, r); err != nil {
 				err = fmt.Errorf("failed to decompress gzip: %w", err)
+			} else if limit > 0 && int64(buf.Len()) > limit {
+				err = fmt.Errorf("%w of %d bytes", ErrDecompressedSizeLimit, limit)
 			} else {
 				err = f(buf.Bytes())
 			}
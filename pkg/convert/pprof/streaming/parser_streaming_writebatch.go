@@ -45,7 +45,7 @@ func (p *VTStreamingParser) ParseWithWriteBatch(input ParseWriteBatchInput) (err
 }
 
 func (p *VTStreamingParser) parseWB(profile []byte, prev bool) (err error) {
-	return decompress(profile, func(profile []byte) error {
+	return decompress(profile, p.maxDecompressedSize, func(profile []byte) error {
 		p.profile = profile
 		p.prev = prev
 		err := p.parseDecompressedWB()
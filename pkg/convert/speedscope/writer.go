@@ -0,0 +1,56 @@
+package speedscope
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+const exporter = "pyroscope"
+
+// Encode writes the tree as a Speedscope file with a single sampled profile.
+func Encode(w io.Writer, t *tree.Tree, name string, units metadata.Units) error {
+	prof := profile{
+		Type: profileSampled,
+		Name: name,
+		Unit: unitFromMetadata(units),
+	}
+	var frames []frame
+	index := make(map[string]int)
+	t.IterateStacks(func(_ string, self uint64, stack []string) {
+		// The stack goes from the leaf to the root.
+		s := make(sample, len(stack))
+		for i, n := range stack {
+			fid, ok := index[n]
+			if !ok {
+				fid = len(frames)
+				index[n] = fid
+				frames = append(frames, frame{Name: n})
+			}
+			s[len(stack)-1-i] = float64(fid)
+		}
+		prof.Samples = append(prof.Samples, s)
+		prof.Weights = append(prof.Weights, float64(self))
+		prof.EndValue += float64(self)
+	})
+	return json.NewEncoder(w).Encode(speedscopeFile{
+		Schema:   schema,
+		Shared:   shared{Frames: frames},
+		Profiles: []profile{prof},
+		Name:     name,
+		Exporter: exporter,
+	})
+}
+
+func unitFromMetadata(u metadata.Units) unit {
+	switch u {
+	case metadata.BytesUnits:
+		return unitBytes
+	case metadata.LockNanosecondsUnits:
+		return unitNanoseconds
+	default:
+		return unitNone
+	}
+}
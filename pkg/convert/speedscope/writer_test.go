@@ -0,0 +1,33 @@
+package speedscope
+
+import (
+	"bytes"
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+)
+
+var _ = Describe("Speedscope encoder", func() {
+	It("encodes trees that can be parsed back", func() {
+		t := tree.New()
+		t.Insert([]byte("a;b"), 1)
+		t.Insert([]byte("a;b;c"), 2)
+		t.Insert([]byte("a;d"), 4096)
+
+		var buf bytes.Buffer
+		Expect(Encode(&buf, t, "app", metadata.BytesUnits)).To(Succeed())
+
+		ingester := new(mockIngester)
+		md := ingestion.Metadata{Key: segment.NewKey(map[string]string{"__name__": "app"})}
+		Expect((&RawProfile{RawData: buf.Bytes()}).Parse(context.Background(), ingester, nil, md)).To(Succeed())
+		Expect(ingester.actual).To(HaveLen(1))
+		Expect(ingester.actual[0].Units).To(Equal(metadata.BytesUnits))
+		Expect(ingester.actual[0].Val.String()).To(Equal(t.String()))
+	})
+})
@@ -2,7 +2,9 @@ package exporter
 
 import (
 	"fmt"
+	"reflect"
 	"regexp"
+	"sync"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
@@ -16,10 +18,15 @@ import (
 
 // MetricsExporter exports profiling metrics via Prometheus.
 // It is safe for concurrent use.
-type MetricsExporter struct{ rules map[string]*rule }
+type MetricsExporter struct {
+	m     sync.RWMutex
+	reg   prometheus.Registerer
+	rules map[string]*rule
+}
 
 type rule struct {
 	name   string
+	config config.MetricsExportRule
 	qry    *flameql.Query
 	node   *regexp.Regexp
 	labels []string
@@ -40,44 +47,103 @@ type observer struct {
 // Evaluate call will be a noop.
 func NewExporter(rules config.MetricsExportRules, reg prometheus.Registerer) (*MetricsExporter, error) {
 	e := MetricsExporter{
+		reg:   reg,
 		rules: make(map[string]*rule),
 	}
-	if rules == nil {
-		return &e, nil
+	if err := e.ApplyRules(rules); err != nil {
+		return nil, err
 	}
+	return &e, nil
+}
+
+// ApplyRules replaces the export rules. The rules are validated first:
+// if any of them is invalid, the exporter is not changed. Counters of
+// the rules that have not changed are retained, counters of removed
+// and modified rules are unregistered.
+func (e *MetricsExporter) ApplyRules(rules config.MetricsExportRules) error {
+	e.m.Lock()
+	defer e.m.Unlock()
+	next := make(map[string]*rule, len(rules))
 	for name, r := range rules {
-		if !model.IsValidMetricName(model.LabelValue(name)) {
-			return nil, fmt.Errorf("%q is not a valid metric name", name)
+		if x, ok := e.rules[name]; ok && reflect.DeepEqual(x.config, r) {
+			next[name] = x
+			continue
 		}
-		qry, err := flameql.ParseQuery(r.Expr)
+		x, err := newRule(name, r)
 		if err != nil {
-			return nil, fmt.Errorf("rule %q: invalid expression %q: %w", name, r.Expr, err)
+			return err
 		}
-		var node *regexp.Regexp
-		if !(r.Node == "total" || r.Node == "") {
-			node, err = regexp.Compile(r.Node)
-			if err != nil {
-				return nil, fmt.Errorf("node must be either 'total' or a valid regexp: %w", err)
-			}
+		next[name] = x
+	}
+	if e.reg == nil && len(next) > 0 {
+		return fmt.Errorf("metrics registry is required to export metrics")
+	}
+	for name, r := range e.rules {
+		if next[name] != r {
+			e.reg.Unregister(r.ctr)
+		}
+	}
+	registered := make([]*rule, 0, len(next))
+	for name, r := range next {
+		if e.rules[name] == r {
+			continue
 		}
-		if err = validateTagKeys(r.GroupBy); err != nil {
-			return nil, fmt.Errorf("rule %q: invalid label: %w", name, err)
+		if err := e.reg.Register(r.ctr); err != nil {
+			e.restoreRules(next, registered)
+			return fmt.Errorf("rule %q: %w", name, err)
 		}
-		c := prometheus.NewCounterVec(prometheus.CounterOpts{Name: name}, r.GroupBy)
-		if err = reg.Register(c); err != nil {
-			return nil, err
+		registered = append(registered, r)
+	}
+	e.rules = next
+	return nil
+}
+
+// restoreRules unregisters counters of the rules that have been registered,
+// and registers again counters of the current rules that are not in next.
+func (e *MetricsExporter) restoreRules(next map[string]*rule, registered []*rule) {
+	for _, r := range registered {
+		e.reg.Unregister(r.ctr)
+	}
+	for name, r := range e.rules {
+		if next[name] != r {
+			// The counter has been registered before,
+			// therefore it can be registered again.
+			_ = e.reg.Register(r.ctr)
 		}
-		e.rules[name] = &rule{
-			qry:    qry,
-			node:   node,
-			labels: r.GroupBy,
-			ctr:    c,
+	}
+}
+
+func newRule(name string, r config.MetricsExportRule) (*rule, error) {
+	if !model.IsValidMetricName(model.LabelValue(name)) {
+		return nil, fmt.Errorf("%q is not a valid metric name", name)
+	}
+	qry, err := flameql.ParseQuery(r.Expr)
+	if err != nil {
+		return nil, fmt.Errorf("rule %q: invalid expression %q: %w", name, r.Expr, err)
+	}
+	var node *regexp.Regexp
+	if !(r.Node == "total" || r.Node == "") {
+		node, err = regexp.Compile(r.Node)
+		if err != nil {
+			return nil, fmt.Errorf("node must be either 'total' or a valid regexp: %w", err)
 		}
 	}
-	return &e, nil
+	if err = validateTagKeys(r.GroupBy); err != nil {
+		return nil, fmt.Errorf("rule %q: invalid label: %w", name, err)
+	}
+	return &rule{
+		name:   name,
+		config: r,
+		qry:    qry,
+		node:   node,
+		labels: r.GroupBy,
+		ctr:    prometheus.NewCounterVec(prometheus.CounterOpts{Name: name}, r.GroupBy),
+	}, nil
 }
 
-func (e MetricsExporter) Evaluate(input *storage.PutInput) (storage.SampleObserver, bool) {
+func (e *MetricsExporter) Evaluate(input *storage.PutInput) (storage.SampleObserver, bool) {
+	e.m.RLock()
+	defer e.m.RUnlock()
 	if len(e.rules) == 0 {
 		return nil, false
 	}
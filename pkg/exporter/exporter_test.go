@@ -113,6 +113,55 @@ func TestSampleUnits(t *testing.T) {
 	requireRuleCounterValue(t, exporter, testRuleName, k, 0.05)
 }
 
+func TestApplyRules(t *testing.T) {
+	rules := config.MetricsExportRules{
+		testRuleName:  {Expr: `app.name.cpu`},
+		"another_app": {Expr: `another.app.cpu`},
+	}
+
+	reg := prometheus.NewRegistry()
+	exporter, _ := NewExporter(rules, reg)
+	k := observe(exporter, "app.name.cpu{foo=bar}")
+	requireRuleCounterValue(t, exporter, testRuleName, k, 5)
+
+	err := exporter.ApplyRules(config.MetricsExportRules{
+		testRuleName:   {Expr: `app.name.cpu`},
+		"invalid-name": {Expr: `app.name.cpu`},
+	})
+	if err == nil {
+		t.Fatal("Expected error")
+	}
+	if _, ok := exporter.rules["another_app"]; !ok {
+		t.Fatal("Invalid rules must not be applied")
+	}
+
+	err = exporter.ApplyRules(config.MetricsExportRules{
+		testRuleName: {Expr: `app.name.cpu`},
+		"app_name_cpu_foo": {
+			Expr:    `app.name.cpu`,
+			GroupBy: []string{"foo"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := exporter.rules["another_app"]; ok {
+		t.Fatal("Expected rule to be removed")
+	}
+	observe(exporter, "app.name.cpu{foo=bar}")
+	// The counter of the unchanged rule is retained.
+	requireRuleCounterValue(t, exporter, testRuleName, k, 10)
+	requireRuleCounterValue(t, exporter, "app_name_cpu_foo", k, 5)
+
+	// Removed rules are unregistered and can be added again.
+	if err = exporter.ApplyRules(rules); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if n, _ := testutil.GatherAndCount(reg); n != 1 {
+		t.Fatalf("Expected 1 metric, got %d", n)
+	}
+}
+
 func getCounter(e *MetricsExporter, name string, k *segment.Key) prometheus.Counter {
 	r, ok := e.rules[name]
 	if !ok {
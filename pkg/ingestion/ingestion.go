@@ -30,6 +30,9 @@ const (
 	FormatLines      Format = "lines"
 	FormatGroups     Format = "groups"
 	FormatSpeedscope Format = "speedscope"
+	FormatOTLP       Format = "otlp"
+	FormatCPUProfile Format = "cpuprofile"
+	FormatGecko      Format = "gecko"
 )
 
 type RawProfile interface {
@@ -49,6 +52,10 @@ type Metadata struct {
 	AggregationType metadata.AggregationType
 }
 
+// ErrProfileTooLarge indicates that the profile exceeds the size limits
+// configured for the ingestion requests.
+var ErrProfileTooLarge = errors.New("profile is too large")
+
 type Error struct{ Err error }
 
 func (e Error) Error() string { return e.Err.Error() }
@@ -2,49 +2,181 @@ package ingestion
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
+// AckMode defines how many sinks must accept a profile
+// for the ingestion request to succeed.
+type AckMode string
+
+const (
+	// AckAll requires every sink to accept the profile.
+	AckAll AckMode = "all"
+	// AckAny requires at least one sink to accept the profile.
+	AckAny AckMode = "any"
+	// AckQuorum requires the majority of sinks to accept the profile.
+	AckQuorum AckMode = "quorum"
+)
+
+func ParseAckMode(s string) (AckMode, error) {
+	switch m := AckMode(strings.ToLower(s)); m {
+	case AckAll, AckAny, AckQuorum:
+		return m, nil
+	case "":
+		return AckAny, nil
+	}
+	return "", fmt.Errorf("unknown ack mode %q, supported modes: all, any, quorum", s)
+}
+
+type ParallelizerConfig struct {
+	Mode AckMode
+	// Timeout is the deadline of ingestion into a single sink.
+	// Zero means no deadline.
+	Timeout time.Duration
+}
+
+// Sink is a named ingester the Parallelizer ingests profiles to.
+type Sink struct {
+	Name     string
+	Ingester Ingester
+}
+
 type Parallelizer struct {
-	log       *logrus.Logger
-	ingesters []Ingester
+	log    *logrus.Logger
+	config ParallelizerConfig
+
+	m     sync.RWMutex
+	sinks []Sink
 }
 
-func NewParallelizer(log *logrus.Logger, ingesters ...Ingester) *Parallelizer {
+func NewParallelizer(log *logrus.Logger, c ParallelizerConfig, sinks ...Sink) *Parallelizer {
+	if c.Mode == "" {
+		c.Mode = AckAny
+	}
 	return &Parallelizer{
-		log:       log,
-		ingesters: ingesters,
+		log:    log,
+		config: c,
+		sinks:  sinks,
 	}
 }
 
-func (p *Parallelizer) Ingest(ctx context.Context, in *IngestInput) error {
-	var wg sync.WaitGroup
-	wg.Add(len(p.ingesters))
+// SetSinks replaces the sinks profiles are ingested to. Ingestion calls
+// in progress are not affected and complete with the previous sinks.
+func (p *Parallelizer) SetSinks(sinks ...Sink) {
+	p.m.Lock()
+	p.sinks = sinks
+	p.m.Unlock()
+}
+
+var errSinkTimeout = errors.New("sink deadline exceeded")
 
-	// TODO(eh-am): add timeouts for each individual call
-	for _, putter := range p.ingesters {
-		// https://golang.org/doc/faq#closures_and_goroutines
-		putter := putter
-		in := in
+// SinkError is an error of ingestion into the named sink.
+type SinkError struct {
+	Sink string
+	Err  error
+}
+
+func (e SinkError) Error() string { return e.Sink + ": " + e.Err.Error() }
+
+func (e SinkError) Unwrap() error { return e.Err }
+
+// ParallelizerError is returned when not enough sinks accepted
+// the profile. It wraps errors of all the failed sinks.
+type ParallelizerError struct {
+	Mode   AckMode
+	Sinks  int
+	Errors []SinkError
+}
 
-		go func() {
-			defer wg.Done()
-			err := p.safeIngest(ctx, in, putter)
-			if err != nil {
-				p.log.Error("Failed to parallelize put: ", err)
-			}
-		}()
+func (e *ParallelizerError) Error() string {
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "profile rejected by %d of %d sinks (ack mode %q)", len(e.Errors), e.Sinks, e.Mode)
+	for i, err := range e.Errors {
+		if i == 0 {
+			b.WriteString(": ")
+		} else {
+			b.WriteString("; ")
+		}
+		b.WriteString(err.Error())
 	}
+	return b.String()
+}
+
+func (e *ParallelizerError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
 
-	wg.Wait()
+func (p *Parallelizer) Ingest(ctx context.Context, in *IngestInput) error {
+	p.m.RLock()
+	sinks := p.sinks
+	p.m.RUnlock()
+	results := make(chan SinkError, len(sinks))
+	for _, s := range sinks {
+		go func(s Sink) {
+			results <- SinkError{Sink: s.Name, Err: p.ingestWithTimeout(ctx, in, s.Ingester)}
+		}(s)
+	}
 
+	var failed []SinkError
+	for range sinks {
+		if r := <-results; r.Err != nil {
+			p.log.WithError(r.Err).WithField("sink", r.Sink).Error("failed to ingest profile")
+			failed = append(failed, r)
+		}
+	}
+	if !p.acknowledged(len(sinks), len(sinks)-len(failed)) {
+		return &ParallelizerError{
+			Mode:   p.config.Mode,
+			Sinks:  len(sinks),
+			Errors: failed,
+		}
+	}
 	return nil
 }
 
+func (p *Parallelizer) acknowledged(sinks, succeeded int) bool {
+	switch p.config.Mode {
+	case AckAll:
+		return succeeded == sinks
+	case AckQuorum:
+		return succeeded > sinks/2
+	default:
+		return succeeded > 0 || sinks == 0
+	}
+}
+
+// ingestWithTimeout stops waiting for the ingester once the deadline
+// is exceeded, even if it does not respect context cancellation.
+func (p *Parallelizer) ingestWithTimeout(ctx context.Context, in *IngestInput, ingester Ingester) error {
+	if p.config.Timeout <= 0 {
+		return p.safeIngest(ctx, in, ingester)
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- p.safeIngest(ctx, in, ingester) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return Error{Err: errSinkTimeout}
+		}
+		return ctx.Err()
+	}
+}
+
 // This is required since ingester.Ingest may panic
 func (*Parallelizer) safeIngest(ctx context.Context, input *IngestInput, ingester Ingester) (err error) {
 	defer func() {
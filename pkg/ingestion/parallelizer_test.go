@@ -2,8 +2,10 @@ package ingestion_test
 
 import (
 	"context"
+	"errors"
 	"io/ioutil"
 	"sync"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -55,10 +57,88 @@ var _ = Describe("Parallelizer", func() {
 		mock1 := mockPutter{Fn: fn}
 		mock2 := mockPutter{Fn: fn}
 
-		p := ingestion.NewParallelizer(logger, mock1, mock2)
+		p := ingestion.NewParallelizer(logger, ingestion.ParallelizerConfig{},
+			ingestion.Sink{Name: "mock1", Ingester: mock1},
+			ingestion.Sink{Name: "mock2", Ingester: mock2})
 
 		wg.Add(2)
 		p.Ingest(context.TODO(), pi)
 		wg.Wait()
 	})
+
+	Context("ack modes", func() {
+		var (
+			logger  *logrus.Logger
+			errSink = errors.New("sink failed")
+		)
+
+		BeforeEach(func() {
+			logger = logrus.New()
+			logger.SetOutput(ioutil.Discard)
+		})
+
+		sink := func(name string, err error) ingestion.Sink {
+			return ingestion.Sink{Name: name, Ingester: mockPutter{Fn: func(context.Context, *ingestion.IngestInput) error {
+				return err
+			}}}
+		}
+
+		ingest := func(mode ingestion.AckMode, sinks ...ingestion.Sink) error {
+			p := ingestion.NewParallelizer(logger, ingestion.ParallelizerConfig{Mode: mode}, sinks...)
+			return p.Ingest(context.TODO(), new(ingestion.IngestInput))
+		}
+
+		It("requires every sink to succeed in all mode", func() {
+			Expect(ingest(ingestion.AckAll, sink("a", nil), sink("b", nil))).To(Succeed())
+			err := ingest(ingestion.AckAll, sink("a", nil), sink("b", errSink))
+			Expect(err).To(MatchError(errSink))
+			Expect(err.Error()).To(ContainSubstring("b: sink failed"))
+		})
+
+		It("requires one sink to succeed in any mode", func() {
+			Expect(ingest(ingestion.AckAny, sink("a", errSink), sink("b", nil))).To(Succeed())
+			err := ingest(ingestion.AckAny, sink("a", errSink), sink("b", ingestion.Error{Err: errSink}))
+			Expect(err).To(HaveOccurred())
+			Expect(ingestion.IsIngestionError(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("rejected by 2 of 2 sinks"))
+		})
+
+		It("requires the majority of sinks to succeed in quorum mode", func() {
+			Expect(ingest(ingestion.AckQuorum, sink("a", errSink), sink("b", nil), sink("c", nil))).To(Succeed())
+			Expect(ingest(ingestion.AckQuorum, sink("a", errSink), sink("b", errSink), sink("c", nil))).ToNot(Succeed())
+			Expect(ingest(ingestion.AckQuorum, sink("a", errSink), sink("b", nil))).ToNot(Succeed())
+		})
+
+		It("does not wait for sinks exceeding the deadline", func() {
+			block := make(chan struct{})
+			defer close(block)
+			slow := ingestion.Sink{Name: "slow", Ingester: mockPutter{Fn: func(context.Context, *ingestion.IngestInput) error {
+				<-block
+				return nil
+			}}}
+			p := ingestion.NewParallelizer(logger, ingestion.ParallelizerConfig{
+				Mode:    ingestion.AckAll,
+				Timeout: 10 * time.Millisecond,
+			}, sink("fast", nil), slow)
+			err := p.Ingest(context.TODO(), new(ingestion.IngestInput))
+			Expect(ingestion.IsIngestionError(err)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("slow: sink deadline exceeded"))
+		})
+
+		It("ingests to the replaced sinks", func() {
+			p := ingestion.NewParallelizer(logger, ingestion.ParallelizerConfig{Mode: ingestion.AckAll}, sink("a", nil))
+			Expect(p.Ingest(context.TODO(), new(ingestion.IngestInput))).To(Succeed())
+			p.SetSinks(sink("a", nil), sink("b", errSink))
+			err := p.Ingest(context.TODO(), new(ingestion.IngestInput))
+			Expect(err).To(MatchError(errSink))
+			Expect(err.Error()).To(ContainSubstring("rejected by 1 of 2 sinks"))
+		})
+
+		It("parses ack modes", func() {
+			Expect(ingestion.ParseAckMode("")).To(Equal(ingestion.AckAny))
+			Expect(ingestion.ParseAckMode("Quorum")).To(Equal(ingestion.AckQuorum))
+			_, err := ingestion.ParseAckMode("some")
+			Expect(err).To(HaveOccurred())
+		})
+	})
 })
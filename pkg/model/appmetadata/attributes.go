@@ -0,0 +1,71 @@
+package appmetadata
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Attributes is a set of arbitrary key value pairs,
+// stored in the database as a JSON object.
+type Attributes map[string]string
+
+func (Attributes) GormDataType() string { return "text" }
+
+// Value implements driver.Valuer. The keys are sorted, therefore
+// equal attributes are always encoded to the same string.
+func (a Attributes) Value() (driver.Value, error) {
+	if len(a) == 0 {
+		return "{}", nil
+	}
+	b, err := json.Marshal(map[string]string(a))
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner. Empty attributes are decoded to nil.
+func (a *Attributes) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*a = nil
+		return nil
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		return fmt.Errorf("unsupported attributes type %T", src)
+	}
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		return fmt.Errorf("invalid attributes: %w", err)
+	}
+	if len(m) == 0 {
+		m = nil
+	}
+	*a = m
+	return nil
+}
+
+// likeEscaper escapes the SQL LIKE wildcards, '!' is the escape character.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// LikePattern returns a SQL LIKE pattern matching the encoded attributes
+// that contain the key value pair. Quotes in keys and values are escaped
+// in JSON, therefore the pattern only matches a whole key and value.
+// The pattern must be used with ESCAPE '!'.
+func LikePattern(k, v string) (string, error) {
+	key, err := json.Marshal(k)
+	if err != nil {
+		return "", err
+	}
+	value, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return "%" + likeEscaper.Replace(string(key)+":"+string(value)) + "%", nil
+}
@@ -0,0 +1,48 @@
+package appmetadata
+
+import (
+	"net/url"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/util/attime"
+)
+
+// Filter selects application metadata records.
+// Zero value matches all the records.
+type Filter struct {
+	// Name is the application fully qualified name.
+	Name string
+	// UpdatedSince matches records updated at or after the time.
+	UpdatedSince time.Time
+	// Attributes must be all present in a record.
+	Attributes map[string]string
+}
+
+// ParseFilter creates a filter from the query parameters: "name" and
+// "updateTime" (RFC3339, unix or relative time) are handled specially, any other
+// parameter is an attribute.
+func ParseFilter(q url.Values) Filter {
+	var f Filter
+	for k := range q {
+		v := q.Get(k)
+		if v == "" {
+			continue
+		}
+		switch k {
+		case "name":
+			f.Name = v
+		case "updateTime":
+			if t, err := time.Parse(time.RFC3339, v); err == nil {
+				f.UpdatedSince = t
+			} else {
+				f.UpdatedSince = attime.Parse(v)
+			}
+		default:
+			if f.Attributes == nil {
+				f.Attributes = make(map[string]string)
+			}
+			f.Attributes[k] = v
+		}
+	}
+	return f
+}
@@ -0,0 +1,54 @@
+package appmetadata
+
+// LabelMapping maps application metadata attributes
+// to the segment key labels, and vice versa.
+type LabelMapping map[string]string
+
+// ErdaLabelMapping describes applications deployed by Erda.
+var ErdaLabelMapping = LabelMapping{
+	"orgID":       "DICE_ORG_ID",
+	"orgName":     "DICE_ORG_NAME",
+	"workspace":   "DICE_WORKSPACE",
+	"projectID":   "DICE_PROJECT_ID",
+	"projectName": "DICE_PROJECT_NAME",
+	"appID":       "DICE_APPLICATION_ID",
+	"appName":     "DICE_APPLICATION_NAME",
+	"clusterName": "DICE_CLUSTER_NAME",
+	"serviceName": "DICE_SERVICE",
+	"podIP":       "POD_IP",
+}
+
+// NewLabelMapping creates a mapping of attributes to labels.
+// ErdaLabelMapping is used if m is empty and erda is true,
+// otherwise no labels are mapped.
+func NewLabelMapping(m map[string]string, erda bool) LabelMapping {
+	if len(m) == 0 && erda {
+		return ErdaLabelMapping
+	}
+	return m
+}
+
+// Attributes returns the attributes of the mapped labels.
+func (m LabelMapping) Attributes(labels map[string]string) Attributes {
+	var a Attributes
+	for attr, label := range m {
+		if v, ok := labels[label]; ok && v != "" {
+			if a == nil {
+				a = make(Attributes)
+			}
+			a[attr] = v
+		}
+	}
+	return a
+}
+
+// Labels returns the labels of the mapped attributes.
+func (m LabelMapping) Labels(a Attributes) map[string]string {
+	labels := make(map[string]string, len(a))
+	for attr, v := range a {
+		if label, ok := m[attr]; ok && v != "" {
+			labels[label] = v
+		}
+	}
+	return labels
+}
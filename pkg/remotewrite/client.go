@@ -6,8 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -16,6 +18,7 @@ import (
 	"github.com/pyroscope-io/pyroscope/pkg/config"
 	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
 )
 
 var (
@@ -30,13 +33,38 @@ type Client struct {
 	config  config.RemoteWriteTarget
 	client  *http.Client
 	metrics *clientMetrics
+
+	// spool, if set, keeps profiles that failed to upload
+	// after all retries and resends them in background.
+	spool *spool
+	wg    sync.WaitGroup
+	stop  chan struct{}
 }
 
+const (
+	defaultMaxRetries   = 3
+	defaultMinBackoff   = 500 * time.Millisecond
+	defaultMaxBackoff   = 30 * time.Second
+	defaultSpoolMaxSize = 256 * bytesize.MB
+)
+
 func NewClient(logger logrus.FieldLogger, reg prometheus.Registerer, targetName string, cfg config.RemoteWriteTarget) *Client {
 	// setup defaults
 	if cfg.Timeout == 0 {
 		cfg.Timeout = time.Second * 10
 	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = defaultMaxRetries
+	}
+	if cfg.MinBackoff == 0 {
+		cfg.MinBackoff = defaultMinBackoff
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = defaultMaxBackoff
+	}
+	if cfg.SpoolMaxSize == 0 {
+		cfg.SpoolMaxSize = defaultSpoolMaxSize
+	}
 
 	client := &http.Client{
 		Timeout: cfg.Timeout,
@@ -50,19 +78,93 @@ func NewClient(logger logrus.FieldLogger, reg prometheus.Registerer, targetName
 	metrics := newClientMetrics(reg, targetName, cfg.Address)
 	metrics.mustRegister()
 
-	return &Client{
+	r := &Client{
 		url:     cfg.Address + "/ingest",
 		log:     logger,
 		config:  cfg,
 		client:  client,
 		metrics: metrics,
+		stop:    make(chan struct{}),
 	}
+	if cfg.SpoolPath != "" {
+		r.spool = newSpool(logger, metrics, cfg.SpoolPath, int64(cfg.SpoolMaxSize))
+		r.wg.Add(1)
+		go r.runSpoolResender()
+	}
+	return r
+}
+
+// Stop stops resending of the spooled profiles.
+func (r *Client) Stop() {
+	close(r.stop)
+	r.wg.Wait()
 }
 
+// Ingest sends the profile to the remote target. Failed uploads are
+// retried with exponential backoff; if the target is still unavailable,
+// the profile is spooled to disk (if configured) to be sent later.
 func (r *Client) Ingest(ctx context.Context, in *ingestion.IngestInput) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retryAfter time.Duration
+		if retryAfter, err = r.send(ctx, in); err == nil {
+			if r.spool != nil {
+				r.spool.notify()
+			}
+			return nil
+		}
+		if !isRetryable(err) || attempt >= r.config.MaxRetries {
+			break
+		}
+		delay := r.backoff(attempt)
+		if retryAfter > delay {
+			// The delay requested by the target is honored,
+			// but the upload is not held longer than the max backoff.
+			delay = retryAfter
+			if delay > r.config.MaxBackoff {
+				delay = r.config.MaxBackoff
+			}
+		}
+		r.log.WithError(err).WithField("delay", delay).Debug("retrying upload")
+		r.metrics.retries.Inc()
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+			continue
+		case <-ctx.Done():
+			t.Stop()
+		}
+		break
+	}
+	if r.spool == nil || !isRetryable(err) {
+		return err
+	}
+	if spoolErr := r.spool.put(in); spoolErr != nil {
+		return fmt.Errorf("%w; failed to spool profile: %v", err, spoolErr)
+	}
+	r.log.WithError(err).WithField("key", in.Metadata.Key.Normalized()).Warn("profile upload failed, spooled to be sent later")
+	return nil
+}
+
+// backoff returns the delay before the retry, jittered
+// to prevent clients from retrying simultaneously.
+func (r *Client) backoff(attempt int) time.Duration {
+	d := r.config.MinBackoff
+	for i := 0; i < attempt && d < r.config.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > r.config.MaxBackoff {
+		d = r.config.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// send makes a single upload attempt. Returned is the delay
+// the remote target asked for with the Retry-After header.
+func (r *Client) send(ctx context.Context, in *ingestion.IngestInput) (time.Duration, error) {
 	req, err := r.ingestInputToRequest(in)
 	if err != nil {
-		return fmt.Errorf("%w: %v", ErrConvertPutInputToRequest, err)
+		return 0, fmt.Errorf("%w: %v", ErrConvertPutInputToRequest, err)
 	}
 
 	r.enhanceWithAuth(req)
@@ -84,7 +186,7 @@ func (r *Client) Ingest(ctx context.Context, in *ingestion.IngestInput) error {
 		defer res.Body.Close()
 	}
 	if err != nil {
-		return fmt.Errorf("%w: %v", ErrMakingRequest, err)
+		return 0, fmt.Errorf("%w: %v", ErrMakingRequest, err)
 	}
 
 	duration := time.Since(start)
@@ -95,10 +197,54 @@ func (r *Client) Ingest(ctx context.Context, in *ingestion.IngestInput) error {
 	if !(res.StatusCode >= 200 && res.StatusCode < 300) {
 		// read all the response body
 		respBody, _ := ioutil.ReadAll(res.Body)
-		return fmt.Errorf("%w: %v", ErrNotOkResponse, fmt.Errorf("status code: '%d'. body: '%s'. url: '%s'", res.StatusCode, respBody, req.URL.Redacted()))
+		return parseRetryAfter(res), &statusError{
+			code: res.StatusCode,
+			err:  fmt.Errorf("%w: %v", ErrNotOkResponse, fmt.Errorf("status code: '%d'. body: '%s'. url: '%s'", res.StatusCode, respBody, req.URL.Redacted())),
+		}
 	}
 
-	return nil
+	return 0, nil
+}
+
+type statusError struct {
+	code int
+	err  error
+}
+
+func (e *statusError) Error() string { return e.err.Error() }
+
+func (e *statusError) Unwrap() error { return e.err }
+
+// isRetryable reports whether the upload may succeed if retried:
+// the target is unreachable, overloaded, or failed temporarily.
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrMakingRequest) {
+		return true
+	}
+	var s *statusError
+	if errors.As(err, &s) {
+		return s.code == http.StatusRequestTimeout ||
+			s.code == http.StatusTooManyRequests ||
+			s.code >= 500
+	}
+	return false
+}
+
+func parseRetryAfter(res *http.Response) time.Duration {
+	if res.StatusCode != http.StatusTooManyRequests && res.StatusCode != http.StatusServiceUnavailable {
+		return 0
+	}
+	v := res.Header.Get("Retry-After")
+	if v == "" {
+		return 0
+	}
+	if s, err := strconv.Atoi(v); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
 }
 
 func (r *Client) ingestInputToRequest(in *ingestion.IngestInput) (*http.Request, error) {
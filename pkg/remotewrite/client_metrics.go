@@ -11,6 +11,11 @@ type clientMetrics struct {
 
 	sentBytes    prometheus.Counter
 	responseTime *prometheus.HistogramVec
+	retries      prometheus.Counter
+	spooled      prometheus.Counter
+	spoolDropped prometheus.Counter
+	spoolResent  prometheus.Counter
+	spoolSize    prometheus.Gauge
 }
 
 func newClientMetrics(reg prometheus.Registerer, targetName, targetAddress string) *clientMetrics {
@@ -38,6 +43,46 @@ func newClientMetrics(reg prometheus.Registerer, targetName, targetAddress strin
 		ConstLabels: labels,
 	}, []string{"code"})
 
+	m.retries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   subs,
+		Name:        "retries",
+		Help:        "How many times uploads were retried.",
+		ConstLabels: labels,
+	})
+
+	m.spooled = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   subs,
+		Name:        "spooled",
+		Help:        "How many profiles were spooled to disk after all upload retries failed.",
+		ConstLabels: labels,
+	})
+
+	m.spoolDropped = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   subs,
+		Name:        "spool_dropped",
+		Help:        "How many spooled profiles were discarded because the spool was full or they were rejected.",
+		ConstLabels: labels,
+	})
+
+	m.spoolResent = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   subs,
+		Name:        "spool_resent",
+		Help:        "How many spooled profiles were sent to the remote target.",
+		ConstLabels: labels,
+	})
+
+	m.spoolSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   subs,
+		Name:        "spool_size_bytes",
+		Help:        "The total size of the spooled profiles.",
+		ConstLabels: labels,
+	})
+
 	return m
 }
 
@@ -45,5 +90,10 @@ func (m clientMetrics) mustRegister() {
 	m.reg.MustRegister(
 		m.sentBytes,
 		m.responseTime,
+		m.retries,
+		m.spooled,
+		m.spoolDropped,
+		m.spoolResent,
+		m.spoolSize,
 	)
 }
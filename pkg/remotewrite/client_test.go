@@ -5,7 +5,10 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -263,4 +266,91 @@ var _ = Describe("TrafficShadower", func() {
 			})
 		})
 	})
+
+	Context("retries", func() {
+		var (
+			requests   int32
+			status     []int
+			retryAfter string
+			server     *httptest.Server
+			cfg        config.RemoteWriteTarget
+			in         ingestion.IngestInput
+		)
+
+		BeforeEach(func() {
+			atomic.StoreInt32(&requests, 0)
+			status = nil
+			retryAfter = "1"
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(atomic.AddInt32(&requests, 1))
+				if n <= len(status) {
+					if status[n-1] == http.StatusTooManyRequests {
+						w.Header().Set("Retry-After", retryAfter)
+					}
+					w.WriteHeader(status[n-1])
+				}
+			}))
+			cfg = config.RemoteWriteTarget{
+				Address:    server.URL,
+				MinBackoff: time.Millisecond,
+				MaxBackoff: 10 * time.Millisecond,
+			}
+			in = ingestion.IngestInput{
+				Metadata: ingestion.Metadata{
+					Key: segment.NewKey(map[string]string{"__name__": "myapp"}),
+				},
+				Profile: &profile.RawProfile{RawData: []byte("foo;bar 1\n")},
+			}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("retries temporary failures", func() {
+			status = []int{http.StatusServiceUnavailable, http.StatusBadGateway}
+			client := remotewrite.NewClient(logger, prometheus.NewRegistry(), "targetName", cfg)
+			Expect(client.Ingest(context.TODO(), &in)).To(Succeed())
+			Expect(atomic.LoadInt32(&requests)).To(Equal(int32(3)))
+		})
+
+		It("honors Retry-After header", func() {
+			status = []int{http.StatusTooManyRequests}
+			cfg.MaxBackoff = 2 * time.Second
+			client := remotewrite.NewClient(logger, prometheus.NewRegistry(), "targetName", cfg)
+			start := time.Now()
+			Expect(client.Ingest(context.TODO(), &in)).To(Succeed())
+			Expect(time.Since(start)).To(BeNumerically(">=", time.Second))
+		})
+
+		It("limits Retry-After delay to the max backoff", func() {
+			status = []int{http.StatusTooManyRequests}
+			retryAfter = "3600"
+			client := remotewrite.NewClient(logger, prometheus.NewRegistry(), "targetName", cfg)
+			start := time.Now()
+			Expect(client.Ingest(context.TODO(), &in)).To(Succeed())
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		})
+
+		It("does not retry rejected requests", func() {
+			status = []int{http.StatusBadRequest}
+			client := remotewrite.NewClient(logger, prometheus.NewRegistry(), "targetName", cfg)
+			Expect(client.Ingest(context.TODO(), &in)).To(MatchError(remotewrite.ErrNotOkResponse))
+			Expect(atomic.LoadInt32(&requests)).To(Equal(int32(1)))
+		})
+
+		It("spools profiles and resends them when the target recovers", func() {
+			status = []int{500, 500, 500, 500}
+			cfg.SpoolPath = GinkgoT().TempDir()
+			client := remotewrite.NewClient(logger, prometheus.NewRegistry(), "targetName", cfg)
+			defer client.Stop()
+			Expect(client.Ingest(context.TODO(), &in)).To(Succeed())
+			Expect(atomic.LoadInt32(&requests)).To(BeNumerically(">=", 4))
+			// The spooled profile is resent once the target responds with 200.
+			Eventually(func() ([]os.DirEntry, error) {
+				return os.ReadDir(cfg.SpoolPath)
+			}).Should(BeEmpty())
+			Expect(atomic.LoadInt32(&requests)).To(BeNumerically(">=", 5))
+		})
+	})
 })
@@ -0,0 +1,169 @@
+package remotewrite
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	collectorv1 "go.opentelemetry.io/proto/otlp/collector/profiles/v1development"
+	profilesv1 "go.opentelemetry.io/proto/otlp/profiles/v1development"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/convert/otlp"
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/labels"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/relabel"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+// Filter applies the target relabeling rules and sampling to profiles
+// before passing them on. Filtered out profiles are silently discarded.
+type Filter struct {
+	ingester ingestion.Ingester
+
+	relabelConfigs   []*relabel.Config
+	samplePercentage float64
+
+	dropped *prometheus.CounterVec
+}
+
+// NewFilter creates a Filter of the remote write target. If the target
+// has neither relabeling rules nor sampling configured, the ingester
+// is returned as is.
+func NewFilter(reg prometheus.Registerer, ingester ingestion.Ingester, targetName string, cfg config.RemoteWriteTarget) ingestion.Ingester {
+	if cfg.SamplePercentage <= 0 || cfg.SamplePercentage > 100 {
+		cfg.SamplePercentage = 100
+	}
+	if len(cfg.RelabelConfigs) == 0 && cfg.SamplePercentage == 100 {
+		return ingester
+	}
+	f := &Filter{
+		ingester:         ingester,
+		relabelConfigs:   cfg.RelabelConfigs,
+		samplePercentage: cfg.SamplePercentage,
+		dropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: fmt.Sprintf("%s_filter", subsystem),
+			Name:      "dropped",
+			Help:      "How many profiles were not sent because of relabeling rules or sampling.",
+			ConstLabels: prometheus.Labels{
+				"target_name":    targetName,
+				"target_address": cfg.Address,
+			},
+		}, []string{"reason"}),
+	}
+	reg.MustRegister(f.dropped)
+	return f
+}
+
+// sampleTypeLabel holds the sample type suffix of the application name
+// while the relabeling rules are applied: rules match the application
+// name as it is specified by the user, e.g. "app" instead of "app.cpu".
+const sampleTypeLabel = "__sample_type__"
+
+func (f *Filter) Ingest(ctx context.Context, in *ingestion.IngestInput) error {
+	if f.samplePercentage < 100 && rand.Float64()*100 >= f.samplePercentage {
+		f.dropped.WithLabelValues("sampling").Inc()
+		return nil
+	}
+	if len(f.relabelConfigs) == 0 {
+		return f.ingester.Ingest(ctx, in)
+	}
+	if p, ok := in.Profile.(*otlp.RawProfile); ok {
+		return f.ingestOTLP(ctx, in, p)
+	}
+	lset := f.relabel(in.Metadata.Key.Labels(), hasSampleTypeSuffix(in.Format))
+	if lset == nil {
+		f.dropped.WithLabelValues("relabeling").Inc()
+		return nil
+	}
+	// The input is shared with other targets and must not be modified.
+	relabeled := *in
+	relabeled.Metadata.Key = segment.NewKey(lset)
+	return f.ingester.Ingest(ctx, &relabeled)
+}
+
+// ingestOTLP applies the relabeling rules to the labels of every resource
+// of the export request: the application name of OTLP profiles is only
+// known from the resource attributes. Relabeled labels replace the
+// resource attributes.
+func (f *Filter) ingestOTLP(ctx context.Context, in *ingestion.IngestInput, p *otlp.RawProfile) error {
+	req := p.Request
+	if req == nil {
+		req = new(collectorv1.ExportProfilesServiceRequest)
+		if err := proto.Unmarshal(p.RawData, req); err != nil {
+			return fmt.Errorf("decoding export request: %w", err)
+		}
+	}
+	filtered := &collectorv1.ExportProfilesServiceRequest{Dictionary: req.Dictionary}
+	for _, rp := range req.ResourceProfiles {
+		lset := f.relabel(otlp.ResourceLabels(in.Metadata.Key, rp.Resource), false)
+		if lset == nil {
+			f.dropped.WithLabelValues("relabeling").Inc()
+			continue
+		}
+		filtered.ResourceProfiles = append(filtered.ResourceProfiles, &profilesv1.ResourceProfiles{
+			Resource:      otlp.NewResource(lset),
+			ScopeProfiles: rp.ScopeProfiles,
+			SchemaUrl:     rp.SchemaUrl,
+		})
+	}
+	if len(filtered.ResourceProfiles) == 0 {
+		return nil
+	}
+	relabeled := *in
+	relabeled.Profile = &otlp.RawProfile{Request: filtered}
+	// Labels of the key are added to the resource labels.
+	relabeled.Metadata.Key = segment.NewKey(map[string]string{labels.MetricName: otlp.DefaultAppName})
+	return f.ingester.Ingest(ctx, &relabeled)
+}
+
+// relabel applies the relabeling rules to the labels. If the application
+// name has the sample type suffix, the rules are applied to the name
+// without the suffix. Returned is nil, if the labels are dropped.
+func (f *Filter) relabel(m map[string]string, suffix bool) map[string]string {
+	var sampleType string
+	if suffix {
+		name := m[labels.MetricName]
+		if i := strings.LastIndexByte(name, '.'); i > 0 {
+			m = copyLabels(m)
+			m[labels.MetricName], sampleType = name[:i], name[i+1:]
+			m[sampleTypeLabel] = sampleType
+		}
+	}
+	lset := relabel.Process(labels.FromMap(m), f.relabelConfigs...)
+	if lset == nil || lset.Get(labels.MetricName) == "" {
+		return nil
+	}
+	r := lset.Map()
+	if sampleType = r[sampleTypeLabel]; sampleType != "" {
+		r[labels.MetricName] += "." + sampleType
+	}
+	delete(r, sampleTypeLabel)
+	return r
+}
+
+// hasSampleTypeSuffix reports whether the application name of profiles
+// in the format includes the sample type. Otherwise, the sample types
+// are appended to the name when the profile is parsed.
+func hasSampleTypeSuffix(f ingestion.Format) bool {
+	switch f {
+	case ingestion.FormatPprof,
+		ingestion.FormatJFR,
+		ingestion.FormatOTLP,
+		ingestion.FormatSpeedscope:
+		return false
+	}
+	return true
+}
+
+func copyLabels(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m)+1)
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,155 @@
+package remotewrite_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	collectorv1 "go.opentelemetry.io/proto/otlp/collector/profiles/v1development"
+	commonv1 "go.opentelemetry.io/proto/otlp/common/v1"
+	profilesv1 "go.opentelemetry.io/proto/otlp/profiles/v1development"
+	resourcev1 "go.opentelemetry.io/proto/otlp/resource/v1"
+	"gopkg.in/yaml.v2"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/convert/otlp"
+	"github.com/pyroscope-io/pyroscope/pkg/convert/profile"
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
+	"github.com/pyroscope-io/pyroscope/pkg/remotewrite"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+var _ = Describe("Filter", func() {
+	var (
+		ingester *recordingIngester
+		cfg      config.RemoteWriteTarget
+	)
+
+	BeforeEach(func() {
+		ingester = &recordingIngester{block: make(chan struct{})}
+		close(ingester.block)
+		cfg = config.RemoteWriteTarget{}
+	})
+
+	ingest := func(keys ...string) []string {
+		f := remotewrite.NewFilter(prometheus.NewRegistry(), ingester, "target", cfg)
+		for _, k := range keys {
+			key, err := segment.ParseKey(k)
+			Expect(err).ToNot(HaveOccurred())
+			in := &ingestion.IngestInput{
+				Profile:  new(profile.RawProfile),
+				Metadata: ingestion.Metadata{Key: key},
+			}
+			Expect(f.Ingest(context.Background(), in)).To(Succeed())
+			// The input must not be modified.
+			Expect(in.Metadata.Key.Normalized()).To(Equal(key.Normalized()))
+		}
+		var actual []string
+		for _, in := range ingester.ingested() {
+			actual = append(actual, in.Metadata.Key.Normalized())
+		}
+		return actual
+	}
+
+	It("passes profiles through if nothing is configured", func() {
+		Expect(remotewrite.NewFilter(prometheus.NewRegistry(), ingester, "target", cfg)).To(Equal(ingester))
+	})
+
+	It("applies relabeling rules", func() {
+		Expect(yaml.Unmarshal([]byte(`
+relabel-configs:
+  - source-labels: [__name__]
+    regex: prod-.*
+    action: keep
+  - source-labels: [env]
+    regex: canary
+    action: drop
+  - action: labeldrop
+    regex: pod
+  - source-labels: [__name__]
+    regex: prod-(.*)
+    target-label: __name__
+    replacement: $1
+`), &cfg)).To(Succeed())
+
+		Expect(ingest(
+			"prod-app.cpu{env=production,pod=p-1}",
+			"prod-app.cpu{env=canary,pod=p-2}",
+			"dev-app.cpu{env=production}",
+		)).To(Equal([]string{"app.cpu{env=production}"}))
+	})
+
+	It("drops profiles without application name", func() {
+		Expect(yaml.Unmarshal([]byte(`
+relabel-configs:
+  - action: labeldrop
+    regex: __name__
+`), &cfg)).To(Succeed())
+		Expect(ingest("app.cpu{}")).To(BeEmpty())
+	})
+
+	It("matches application names without the sample type", func() {
+		Expect(yaml.Unmarshal([]byte(`
+relabel-configs:
+  - source-labels: [__name__]
+    regex: app
+    action: keep
+  - source-labels: [__name__]
+    target-label: __name__
+    replacement: renamed-$1
+`), &cfg)).To(Succeed())
+		Expect(ingest("app.cpu{}", "app.alloc_objects{}", "other-app.cpu{}")).
+			To(Equal([]string{"renamed-app.cpu{}", "renamed-app.alloc_objects{}"}))
+	})
+
+	It("applies relabeling rules to OTLP resources", func() {
+		Expect(yaml.Unmarshal([]byte(`
+relabel-configs:
+  - source-labels: [__name__]
+    regex: app
+    action: keep
+  - action: labeldrop
+    regex: host_name
+`), &cfg)).To(Succeed())
+		attr := func(k, v string) *commonv1.KeyValue {
+			return &commonv1.KeyValue{Key: k, Value: &commonv1.AnyValue{Value: &commonv1.AnyValue_StringValue{StringValue: v}}}
+		}
+		resource := func(attrs ...*commonv1.KeyValue) *profilesv1.ResourceProfiles {
+			return &profilesv1.ResourceProfiles{Resource: &resourcev1.Resource{Attributes: attrs}}
+		}
+		req := &collectorv1.ExportProfilesServiceRequest{
+			ResourceProfiles: []*profilesv1.ResourceProfiles{
+				resource(attr("service.name", "app"), attr("host.name", "node-1"), attr("env", "prod")),
+				resource(attr("service.name", "other-app"), attr("env", "prod")),
+				resource(attr("env", "prod")),
+			},
+		}
+		in := &ingestion.IngestInput{
+			Format:   ingestion.FormatOTLP,
+			Profile:  &otlp.RawProfile{Request: req},
+			Metadata: ingestion.Metadata{Key: segment.NewKey(map[string]string{"__name__": otlp.DefaultAppName})},
+		}
+		f := remotewrite.NewFilter(prometheus.NewRegistry(), ingester, "target", cfg)
+		Expect(f.Ingest(context.Background(), in)).To(Succeed())
+		// The input must not be modified.
+		Expect(req.ResourceProfiles).To(HaveLen(3))
+
+		ingested := ingester.ingested()
+		Expect(ingested).To(HaveLen(1))
+		p, ok := ingested[0].Profile.(*otlp.RawProfile)
+		Expect(ok).To(BeTrue())
+		Expect(p.Request.ResourceProfiles).To(HaveLen(1))
+		Expect(otlp.ResourceLabels(ingested[0].Metadata.Key, p.Request.ResourceProfiles[0].Resource)).
+			To(Equal(map[string]string{"__name__": "app", "env": "prod"}))
+	})
+
+	It("samples profiles", func() {
+		cfg.SamplePercentage = 10
+		keys := make([]string, 1000)
+		for i := range keys {
+			keys[i] = "app.cpu{}"
+		}
+		Expect(len(ingest(keys...))).To(BeNumerically("~", 100, 50))
+	})
+})
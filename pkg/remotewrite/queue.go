@@ -2,13 +2,16 @@ package remotewrite
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"runtime/debug"
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/pyroscope-io/pyroscope/pkg/config"
 	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/wal"
 	"github.com/sirupsen/logrus"
 )
 
@@ -18,18 +21,35 @@ type IngestionQueue struct {
 	logger   logrus.FieldLogger
 	ingester ingestion.Ingester
 
-	wg    sync.WaitGroup
-	queue chan *ingestion.IngestInput
-	stop  chan struct{}
+	wg     sync.WaitGroup
+	queue  chan queueItem
+	stop   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// See storage.IngestionQueue.
+	wal        *wal.WAL
+	inflightMu sync.Mutex
+	inflight   map[uint64]*ingestion.IngestInput
+	queueSize  int
 
 	metrics *queueMetrics
 }
 
+type queueItem struct {
+	seq   uint64
+	input *ingestion.IngestInput
+}
+
 // NewIngestionQueue creates an IngestionQueue
 // Notice how a config.RemoteWriteTarget is taken as argument, even though
 // not all fields are used. This is done to simplify the API, as the alternative
 // is to take multiple arguments
-func NewIngestionQueue(logger logrus.FieldLogger, reg prometheus.Registerer, ingester ingestion.Ingester, targetName string, cfg config.RemoteWriteTarget) *IngestionQueue {
+//
+// If w is not nil, inputs are written to the log before Ingest returns,
+// and the inputs that have not been sent before the queue is stopped
+// are sent after it is created again.
+func NewIngestionQueue(logger logrus.FieldLogger, reg prometheus.Registerer, ingester ingestion.Ingester, targetName string, cfg config.RemoteWriteTarget, w *wal.WAL) *IngestionQueue {
 	// Setup defaults
 	if cfg.QueueWorkers == 0 {
 		// This may be a very conservative value
@@ -42,17 +62,26 @@ func NewIngestionQueue(logger logrus.FieldLogger, reg prometheus.Registerer, ing
 	}
 
 	q := IngestionQueue{
-		logger:   logger,
-		ingester: ingester,
-		queue:    make(chan *ingestion.IngestInput, cfg.QueueSize),
-		stop:     make(chan struct{}),
-		metrics:  newQueueMetrics(reg, targetName, cfg.Address),
+		logger:    logger,
+		ingester:  ingester,
+		queue:     make(chan queueItem, cfg.QueueSize),
+		stop:      make(chan struct{}),
+		wal:       w,
+		inflight:  make(map[uint64]*ingestion.IngestInput),
+		queueSize: cfg.QueueSize,
+		metrics:   newQueueMetrics(reg, targetName, cfg.Address),
 	}
+	// Cancellation aborts upload retries of the queued inputs.
+	q.ctx, q.cancel = context.WithCancel(context.Background())
 
 	q.wg.Add(cfg.QueueWorkers)
 	for i := 0; i < cfg.QueueWorkers; i++ {
 		go q.runQueueWorker()
 	}
+	if q.wal != nil {
+		q.wg.Add(1)
+		go q.runWALReader(q.ctx)
+	}
 
 	q.metrics.mustRegister()
 	q.initMetrics(cfg.QueueSize, cfg.QueueWorkers)
@@ -67,33 +96,134 @@ func (q *IngestionQueue) initMetrics(queueSize int, queueWorkers int) {
 
 func (q *IngestionQueue) Stop() {
 	close(q.stop)
+	q.cancel()
 	q.wg.Wait()
+	if q.wal != nil {
+		if err := q.wal.Close(); err != nil {
+			q.logger.WithError(err).Error("failed to close remote write queue WAL")
+		}
+	}
 }
 
+const (
+	// Inputs read from the WAL are retried if they fail to be sent.
+	// Inputs that still fail are kept in the log and sent again once
+	// the queue is created again.
+	maxIngestRetries   = 3
+	ingestRetryBackoff = time.Second
+)
+
+var (
+	// ErrQueueFull is returned if the input can't be queued, because
+	// the queue or its write-ahead log is full.
+	ErrQueueFull = errors.New("remote write queue is full")
+
+	errQueueStopped   = errors.New("remote write queue is stopped")
+	errPanicRecovered = errors.New("panic recovered")
+)
+
+// Ingest queues the input. If the input is dropped, an ingestion.Error
+// is returned, so that the ack mode of the Parallelizer is honored.
 func (q *IngestionQueue) Ingest(ctx context.Context, input *ingestion.IngestInput) error {
+	if q.wal != nil {
+		return q.ingestWAL(ctx, input)
+	}
+	var err error
 	select {
 	case <-ctx.Done():
+		err = ctx.Err()
 	case <-q.stop:
-	case q.queue <- input:
+		err = errQueueStopped
+	case q.queue <- queueItem{input: input}:
 		q.metrics.pendingItems.Inc()
 		// Once input is queued, context cancellation is ignored.
 		return nil
 	default:
 		// Drop data if the queue is full.
+		err = ErrQueueFull
 	}
+	return q.drop(input, err)
+}
 
-	q.logger.WithField("key", input.Metadata.Key.Normalized()).Debugf("dropping since there's not enough space in the queue")
+func (q *IngestionQueue) drop(input *ingestion.IngestInput, err error) error {
+	q.logger.WithField("key", input.Metadata.Key.Normalized()).WithError(err).Debug("dropping profile")
 	q.metrics.droppedItems.Inc()
+	return ingestion.Error{Err: err}
+}
+
+func (q *IngestionQueue) ingestWAL(ctx context.Context, input *ingestion.IngestInput) error {
+	select {
+	case <-ctx.Done():
+		return q.drop(input, ctx.Err())
+	case <-q.stop:
+		return q.drop(input, errQueueStopped)
+	default:
+	}
+	b, err := encodeIngestInput(input)
+	if err != nil {
+		return err
+	}
+	q.inflightMu.Lock()
+	seq, err := q.wal.Write(b)
+	if err == nil {
+		q.metrics.pendingItems.Inc()
+		if len(q.inflight) < q.queueSize {
+			q.inflight[seq] = input
+		}
+	}
+	q.inflightMu.Unlock()
+	switch {
+	case err == nil:
+	case errors.Is(err, wal.ErrFull):
+		return q.drop(input, fmt.Errorf("%w: %v", ErrQueueFull, err))
+	case errors.Is(err, wal.ErrClosed):
+		return q.drop(input, errQueueStopped)
+	default:
+		return ingestion.Error{Err: fmt.Errorf("writing to WAL: %w", err)}
+	}
+	if err = q.wal.Sync(seq); err != nil {
+		return ingestion.Error{Err: fmt.Errorf("syncing WAL: %w", err)}
+	}
 	return nil
 }
 
+// runWALReader queues inputs read from the log.
+func (q *IngestionQueue) runWALReader(ctx context.Context) {
+	defer q.wg.Done()
+	for {
+		seq, data, err := q.wal.Next(ctx)
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, wal.ErrClosed) {
+				q.logger.WithError(err).Error("failed to read remote write queue WAL")
+			}
+			return
+		}
+		q.inflightMu.Lock()
+		input, ok := q.inflight[seq]
+		delete(q.inflight, seq)
+		q.inflightMu.Unlock()
+		if !ok {
+			if input, err = decodeIngestInput(data); err != nil {
+				q.logger.WithError(err).Error("invalid remote write queue WAL record")
+				q.wal.Ack(seq)
+				continue
+			}
+		}
+		select {
+		case q.queue <- queueItem{seq: seq, input: input}:
+		case <-q.stop:
+			return
+		}
+	}
+}
+
 func (q *IngestionQueue) runQueueWorker() {
 	defer q.wg.Done()
 	for {
 		select {
-		case input := <-q.queue:
-			if err := q.safePut(input); err != nil {
-				q.logger.WithField("key", input.Metadata.Key.Normalized()).WithError(err).Error("error happened while ingesting data")
+		case item := <-q.queue:
+			if err := q.ingestItem(item); err != nil {
+				q.logger.WithField("key", item.input.Metadata.Key.Normalized()).WithError(err).Error("error happened while ingesting data")
 			}
 			q.metrics.pendingItems.Dec()
 		case <-q.stop:
@@ -102,12 +232,47 @@ func (q *IngestionQueue) runQueueWorker() {
 	}
 }
 
+// ingestItem sends the queued input. A record read from the WAL is only
+// acknowledged once the input is sent, or if it can't be sent at all.
+func (q *IngestionQueue) ingestItem(item queueItem) error {
+	err := q.safePut(item.input)
+	if q.wal == nil {
+		return err
+	}
+	for i := 0; i < maxIngestRetries && isRetryableIngestError(err); i++ {
+		select {
+		case <-q.stop:
+			return err
+		case <-time.After(ingestRetryBackoff << i):
+		}
+		err = q.safePut(item.input)
+	}
+	if !isRetryableIngestError(err) {
+		q.wal.Ack(item.seq)
+	}
+	return err
+}
+
+// isRetryableIngestError reports whether the input may be sent if retried:
+// the upload was aborted because the queue is stopped, or the remote
+// target failed temporarily.
+func isRetryableIngestError(err error) bool {
+	switch {
+	case err == nil,
+		errors.Is(err, errPanicRecovered):
+		return false
+	case errors.Is(err, context.Canceled),
+		errors.Is(err, context.DeadlineExceeded):
+		return true
+	}
+	return isRetryable(err)
+}
+
 func (q *IngestionQueue) safePut(input *ingestion.IngestInput) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = fmt.Errorf("panic recovered: %v; %v", r, string(debug.Stack()))
+			err = fmt.Errorf("%w: %v; %v", errPanicRecovered, r, string(debug.Stack()))
 		}
 	}()
-	// TODO(kolesnikovae): It's better to derive a context that is cancelled on Stop.
-	return q.ingester.Ingest(context.TODO(), input)
+	return q.ingester.Ingest(q.ctx, input)
 }
@@ -0,0 +1,218 @@
+package remotewrite_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/convert/profile"
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
+	"github.com/pyroscope-io/pyroscope/pkg/remotewrite"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/wal"
+)
+
+type recordingIngester struct {
+	sync.Mutex
+	block  chan struct{}
+	inputs []*ingestion.IngestInput
+}
+
+func (r *recordingIngester) Ingest(_ context.Context, in *ingestion.IngestInput) error {
+	<-r.block
+	r.Lock()
+	defer r.Unlock()
+	r.inputs = append(r.inputs, in)
+	return nil
+}
+
+func (r *recordingIngester) ingested() []*ingestion.IngestInput {
+	r.Lock()
+	defer r.Unlock()
+	return append([]*ingestion.IngestInput(nil), r.inputs...)
+}
+
+// failingIngester fails to ingest the given number of inputs with err.
+type failingIngester struct {
+	recordingIngester
+	err      error
+	failures int
+}
+
+func (f *failingIngester) Ingest(ctx context.Context, in *ingestion.IngestInput) error {
+	f.Lock()
+	if f.failures > 0 {
+		f.failures--
+		f.Unlock()
+		return f.err
+	}
+	f.Unlock()
+	return f.recordingIngester.Ingest(ctx, in)
+}
+
+func (f *failingIngester) pending() int {
+	f.Lock()
+	defer f.Unlock()
+	return f.failures
+}
+
+var errUnavailable = fmt.Errorf("%w: connection refused", remotewrite.ErrMakingRequest)
+
+var _ = Describe("IngestionQueue WAL", func() {
+	var (
+		logger *logrus.Logger
+		dir    string
+	)
+
+	BeforeEach(func() {
+		logger = logrus.New()
+		logger.SetOutput(ioutil.Discard)
+		dir = GinkgoT().TempDir()
+	})
+
+	input := func() *ingestion.IngestInput {
+		return &ingestion.IngestInput{
+			Format:   ingestion.FormatGroups,
+			Profile:  &profile.RawProfile{RawData: []byte("foo;bar 1\n")},
+			Metadata: ingestion.Metadata{Key: segment.NewKey(map[string]string{"__name__": "app.cpu"})},
+		}
+	}
+
+	newQueue := func(ingester ingestion.Ingester) *remotewrite.IngestionQueue {
+		w, err := wal.Open(logger, prometheus.NewRegistry(), dir, wal.Options{})
+		Expect(err).ToNot(HaveOccurred())
+		return remotewrite.NewIngestionQueue(logger, prometheus.NewRegistry(), ingester, "target", config.RemoteWriteTarget{QueueWorkers: 1}, w)
+	}
+
+	It("replays inputs after a crash", func() {
+		crashed := &recordingIngester{block: make(chan struct{})}
+		defer close(crashed.block)
+		q := newQueue(crashed)
+		Expect(q.Ingest(context.Background(), &ingestion.IngestInput{
+			Format:  ingestion.FormatGroups,
+			Profile: &profile.RawProfile{RawData: []byte("foo;bar 1\n")},
+			Metadata: ingestion.Metadata{
+				StartTime:       time.Unix(10, 0),
+				EndTime:         time.Unix(20, 0),
+				Key:             segment.NewKey(map[string]string{"__name__": "app.cpu", "env": "test"}),
+				SpyName:         "gospy",
+				SampleRate:      100,
+				Units:           metadata.SamplesUnits,
+				AggregationType: metadata.SumAggregationType,
+			},
+		})).To(Succeed())
+
+		ingester := &recordingIngester{block: make(chan struct{})}
+		close(ingester.block)
+		q = newQueue(ingester)
+		defer q.Stop()
+		Eventually(ingester.ingested).Should(HaveLen(1))
+
+		in := ingester.ingested()[0]
+		Expect(in.Format).To(Equal(ingestion.FormatGroups))
+		Expect(in.Metadata.Key.Normalized()).To(Equal("app.cpu{env=test}"))
+		Expect(in.Metadata.StartTime.Equal(time.Unix(10, 0))).To(BeTrue())
+		Expect(in.Metadata.EndTime.Equal(time.Unix(20, 0))).To(BeTrue())
+		Expect(in.Metadata.SpyName).To(Equal("gospy"))
+		Expect(in.Metadata.SampleRate).To(Equal(uint32(100)))
+		Expect(in.Metadata.Units).To(Equal(metadata.SamplesUnits))
+		Expect(in.Metadata.AggregationType).To(Equal(metadata.SumAggregationType))
+		b, err := in.Profile.Bytes()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(b)).To(Equal("foo;bar 1\n"))
+		Expect(in.Profile.ContentType()).To(Equal("binary/octet-stream"))
+	})
+
+	It("retries inputs that failed to be sent", func() {
+		f := &failingIngester{recordingIngester: recordingIngester{block: make(chan struct{})}, err: errUnavailable, failures: 1}
+		close(f.block)
+		q := newQueue(f)
+		defer q.Stop()
+		Expect(q.Ingest(context.Background(), input())).To(Succeed())
+		Eventually(f.ingested, 5*time.Second).Should(HaveLen(1))
+	})
+
+	It("replays inputs that were not sent before the queue is stopped", func() {
+		f := &failingIngester{recordingIngester: recordingIngester{block: make(chan struct{})}, err: errUnavailable, failures: 1}
+		close(f.block)
+		q := newQueue(f)
+		Expect(q.Ingest(context.Background(), input())).To(Succeed())
+		Eventually(f.pending).Should(BeZero())
+		q.Stop()
+		Expect(f.ingested()).To(BeEmpty())
+
+		ingester := &recordingIngester{block: make(chan struct{})}
+		close(ingester.block)
+		q = newQueue(ingester)
+		defer q.Stop()
+		Eventually(ingester.ingested).Should(HaveLen(1))
+	})
+
+	It("does not replay inputs rejected by the remote target", func() {
+		f := &failingIngester{recordingIngester: recordingIngester{block: make(chan struct{})}, err: errors.New("bad request"), failures: 1}
+		close(f.block)
+		q := newQueue(f)
+		Expect(q.Ingest(context.Background(), input())).To(Succeed())
+		Eventually(f.pending).Should(BeZero())
+		q.Stop()
+
+		ingester := &recordingIngester{block: make(chan struct{})}
+		close(ingester.block)
+		q = newQueue(ingester)
+		defer q.Stop()
+		Consistently(ingester.ingested, 500*time.Millisecond).Should(BeEmpty())
+	})
+})
+
+var _ = Describe("IngestionQueue", func() {
+	var logger *logrus.Logger
+
+	BeforeEach(func() {
+		logger = logrus.New()
+		logger.SetOutput(ioutil.Discard)
+	})
+
+	input := func() *ingestion.IngestInput {
+		return &ingestion.IngestInput{
+			Format:   ingestion.FormatGroups,
+			Profile:  &profile.RawProfile{RawData: []byte("foo;bar 1\n")},
+			Metadata: ingestion.Metadata{Key: segment.NewKey(map[string]string{"__name__": "app.cpu"})},
+		}
+	}
+
+	It("fails ingestion of the remote sink if the queue is full", func() {
+		blocked := &recordingIngester{block: make(chan struct{})}
+		defer close(blocked.block)
+		q := remotewrite.NewIngestionQueue(logger, prometheus.NewRegistry(), blocked, "target",
+			config.RemoteWriteTarget{QueueWorkers: 1, QueueSize: 1}, nil)
+		// One input is held by the worker, and another one fills the queue.
+		Expect(q.Ingest(context.Background(), input())).To(Succeed())
+		Eventually(func() error { return q.Ingest(context.Background(), input()) }).
+			Should(MatchError(remotewrite.ErrQueueFull))
+
+		local := &recordingIngester{block: make(chan struct{})}
+		close(local.block)
+		sinks := []ingestion.Sink{
+			{Name: "local", Ingester: local},
+			{Name: "remote", Ingester: q},
+		}
+		err := ingestion.NewParallelizer(logger, ingestion.ParallelizerConfig{Mode: ingestion.AckAll}, sinks...).
+			Ingest(context.Background(), input())
+		Expect(err).To(MatchError(remotewrite.ErrQueueFull))
+		Expect(ingestion.IsIngestionError(err)).To(BeTrue())
+
+		Expect(ingestion.NewParallelizer(logger, ingestion.ParallelizerConfig{Mode: ingestion.AckAny}, sinks...).
+			Ingest(context.Background(), input())).To(Succeed())
+		Expect(local.ingested()).To(HaveLen(2))
+	})
+})
@@ -0,0 +1,120 @@
+package remotewrite
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/util/varint"
+)
+
+// ingestInputFormatV1 is the version of IngestInput WAL records.
+const ingestInputFormatV1 = 1
+
+var errReplayedProfile = errors.New("replayed profile can only be sent to a remote target")
+
+// replayedProfile is a profile read from the WAL: it can't be parsed,
+// as the remote client only needs its bytes and content type.
+type replayedProfile struct {
+	data        []byte
+	contentType string
+}
+
+func (*replayedProfile) Parse(context.Context, storage.Putter, storage.MetricsExporter, ingestion.Metadata) error {
+	return errReplayedProfile
+}
+
+func (p *replayedProfile) Bytes() ([]byte, error) { return p.data, nil }
+
+func (p *replayedProfile) ContentType() string { return p.contentType }
+
+func encodeIngestInput(in *ingestion.IngestInput) ([]byte, error) {
+	b, err := in.Profile.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	vw := varint.NewWriter()
+	_, _ = vw.Write(&buf, ingestInputFormatV1)
+	_, _ = vw.Write(&buf, uint64(in.Metadata.StartTime.UnixNano()))
+	_, _ = vw.Write(&buf, uint64(in.Metadata.EndTime.UnixNano()))
+	_, _ = vw.Write(&buf, uint64(in.Metadata.SampleRate))
+	for _, s := range []string{
+		string(in.Format),
+		in.Metadata.Key.Normalized(),
+		in.Metadata.SpyName,
+		string(in.Metadata.Units),
+		string(in.Metadata.AggregationType),
+		in.Profile.ContentType(),
+	} {
+		_, _ = vw.Write(&buf, uint64(len(s)))
+		buf.WriteString(s)
+	}
+	// The profile goes last: it's read to the end.
+	buf.Write(b)
+	return buf.Bytes(), nil
+}
+
+func decodeIngestInput(b []byte) (*ingestion.IngestInput, error) {
+	r := bufio.NewReader(bytes.NewReader(b))
+	v, err := varint.Read(r)
+	if err != nil {
+		return nil, err
+	}
+	if v != ingestInputFormatV1 {
+		return nil, fmt.Errorf("unknown format version %d", v)
+	}
+	var n [3]uint64
+	for i := range n {
+		if n[i], err = varint.Read(r); err != nil {
+			return nil, err
+		}
+	}
+	var s [6]string
+	for i := range s {
+		l, err := varint.Read(r)
+		if err != nil {
+			return nil, err
+		}
+		if l > uint64(len(b)) {
+			return nil, fmt.Errorf("invalid string length %d", l)
+		}
+		p := make([]byte, l)
+		if _, err = io.ReadFull(r, p); err != nil {
+			return nil, err
+		}
+		s[i] = string(p)
+	}
+	key, err := segment.ParseKey(s[1])
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return &ingestion.IngestInput{
+		Format: ingestion.Format(s[0]),
+		Profile: &replayedProfile{
+			data:        data,
+			contentType: s[5],
+		},
+		Metadata: ingestion.Metadata{
+			StartTime:       time.Unix(0, int64(n[0])),
+			EndTime:         time.Unix(0, int64(n[1])),
+			Key:             key,
+			SpyName:         s[2],
+			SampleRate:      uint32(n[2]),
+			Units:           metadata.Units(s[3]),
+			AggregationType: metadata.AggregationType(s[4]),
+		},
+	}, nil
+}
@@ -0,0 +1,223 @@
+package remotewrite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
+)
+
+const spoolFileExt = ".profile"
+
+var errProfileTooLarge = errors.New("profile exceeds max spool size")
+
+// spool is a bounded directory of profiles that failed to upload.
+// Every profile is stored in a separate file, encoded as a WAL record;
+// files are named after the time they were written.
+type spool struct {
+	logger  logrus.FieldLogger
+	metrics *clientMetrics
+	dir     string
+	maxSize int64
+	// notifyCh is signalled when an upload succeeds:
+	// the target is likely to have recovered.
+	notifyCh chan struct{}
+
+	mu    sync.Mutex
+	files []spoolFile
+	size  int64
+	last  int64
+}
+
+type spoolFile struct {
+	name string
+	size int64
+}
+
+func newSpool(logger logrus.FieldLogger, metrics *clientMetrics, dir string, maxSize int64) *spool {
+	s := spool{
+		logger:   logger,
+		metrics:  metrics,
+		dir:      dir,
+		maxSize:  maxSize,
+		notifyCh: make(chan struct{}, 1),
+	}
+	if err := s.load(); err != nil {
+		logger.WithError(err).Error("failed to load spooled profiles")
+	}
+	return &s
+}
+
+func (s *spool) load() error {
+	entries, err := os.ReadDir(s.dir)
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		return nil
+	default:
+		return err
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, spoolFileExt) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			return err
+		}
+		if t, err := strconv.ParseInt(strings.TrimSuffix(name, spoolFileExt), 10, 64); err == nil && t > s.last {
+			s.last = t
+		}
+		s.files = append(s.files, spoolFile{name: name, size: fi.Size()})
+		s.size += fi.Size()
+	}
+	sort.Slice(s.files, func(i, j int) bool { return s.files[i].name < s.files[j].name })
+	s.metrics.spoolSize.Set(float64(s.size))
+	return nil
+}
+
+func (s *spool) notify() {
+	select {
+	case s.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// put writes the profile to the spool. If the spool is full,
+// the oldest profiles are discarded to free up space.
+func (s *spool) put(in *ingestion.IngestInput) error {
+	b, err := encodeIngestInput(in)
+	if err != nil {
+		return err
+	}
+	n := int64(len(b))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n > s.maxSize {
+		s.metrics.spoolDropped.Inc()
+		return errProfileTooLarge
+	}
+	for s.size+n > s.maxSize && len(s.files) > 0 {
+		if err = s.removeLocked(s.files[0].name); err != nil {
+			return err
+		}
+		s.metrics.spoolDropped.Inc()
+	}
+	if err = os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+	t := time.Now().UnixNano()
+	if t <= s.last {
+		t = s.last + 1
+	}
+	s.last = t
+	name := fmt.Sprintf("%020d%s", t, spoolFileExt)
+	tmp := filepath.Join(s.dir, name+".tmp")
+	if err = os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		return err
+	}
+	s.files = append(s.files, spoolFile{name: name, size: n})
+	s.size += n
+	s.metrics.spooled.Inc()
+	s.metrics.spoolSize.Set(float64(s.size))
+	return nil
+}
+
+// peek returns the oldest spooled profile.
+func (s *spool) peek() (name string, in *ingestion.IngestInput, ok bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.files) == 0 {
+		return "", nil, false, nil
+	}
+	name = s.files[0].name
+	b, err := os.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return name, nil, true, err
+	}
+	in, err = decodeIngestInput(b)
+	return name, in, true, err
+}
+
+func (s *spool) remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.removeLocked(name)
+}
+
+func (s *spool) removeLocked(name string) error {
+	for i, f := range s.files {
+		if f.name != name {
+			continue
+		}
+		if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		s.files = append(s.files[:i], s.files[i+1:]...)
+		s.size -= f.size
+		s.metrics.spoolSize.Set(float64(s.size))
+		return nil
+	}
+	return nil
+}
+
+// runSpoolResender resends the spooled profiles when an upload
+// succeeds, and periodically, to find out if the target recovered.
+func (r *Client) runSpoolResender() {
+	defer r.wg.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-r.stop
+		cancel()
+	}()
+	ticker := time.NewTicker(r.config.MaxBackoff)
+	defer ticker.Stop()
+	for {
+		r.resendSpooled(ctx)
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		case <-r.spool.notifyCh:
+		}
+	}
+}
+
+func (r *Client) resendSpooled(ctx context.Context) {
+	for ctx.Err() == nil {
+		name, in, ok, err := r.spool.peek()
+		if !ok {
+			return
+		}
+		if err == nil {
+			if _, err = r.send(ctx, in); err == nil {
+				r.metrics.spoolResent.Inc()
+			} else if isRetryable(err) {
+				return
+			}
+		}
+		if err != nil {
+			r.log.WithError(err).WithField("file", name).Error("discarding spooled profile")
+			r.metrics.spoolDropped.Inc()
+		}
+		if err = r.spool.remove(name); err != nil {
+			r.log.WithError(err).Error("failed to remove spooled profile")
+			return
+		}
+	}
+}
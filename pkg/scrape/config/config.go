@@ -137,6 +137,17 @@ func DefaultConfig() *Config {
 					},
 				},
 			},
+			"threadcreate": {
+				Path:   "/debug/pprof/threadcreate",
+				Params: nil,
+				SampleTypes: map[string]*profile.SampleTypeConfig{
+					"threadcreate": {
+						DisplayName: "threads",
+						Units:       metadata.ObjectsUnits,
+						Aggregation: metadata.AverageAggregationType,
+					},
+				},
+			},
 			"mutex": {
 				Path:   "/debug/pprof/mutex",
 				Params: nil,
@@ -210,6 +221,9 @@ type Config struct {
 	// ProfilesRelabelConfigs []*relabel.Config `yaml:"profiles-relabel-configs,omitempty"`
 }
 
+// Profile describes how a profile is scraped from targets and ingested.
+// Besides the built-in Go profiles, scrape configs may declare custom
+// profiles, e.g. served by fgprof or a non-Go pprof exporter.
 type Profile struct {
 	Path string `yaml:"path,omitempty"`
 	// A set of query parameters with which the target is scraped.
@@ -270,6 +284,20 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		}
 	}
 
+	for name, p := range c.Profiles {
+		if p == nil {
+			return fmt.Errorf("empty or null configuration of profile %q", name)
+		}
+		if !strings.HasPrefix(p.Path, "/") {
+			return fmt.Errorf("path of profile %q must start with '/'", name)
+		}
+	}
+	for _, name := range c.EnabledProfiles {
+		if _, ok := c.Profiles[name]; !ok {
+			return fmt.Errorf("enabled profile %q is not defined", name)
+		}
+	}
+
 	if c.UseDeltaProfiles {
 		enableDeltaProfiles(c.Profiles)
 	}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestConfigProfiles(t *testing.T) {
+	var c Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(`
+job-name: job
+enabled-profiles: [cpu, goroutines, fgprof]
+profiles:
+  cpu:
+    params:
+      seconds: ["5"]
+  fgprof:
+    path: /debug/fgprof
+    params:
+      format: [pprof]
+    sample-types:
+      time:
+        display-name: wall
+        units: samples
+        sampled: true
+`), &c))
+
+	// Built-in profiles are merged with the overrides.
+	require.Equal(t, "/debug/pprof/profile", c.Profiles["cpu"].Path)
+	require.Equal(t, []string{"5"}, c.Profiles["cpu"].Params["seconds"])
+	require.Equal(t, "/debug/pprof/threadcreate", c.Profiles["threadcreate"].Path)
+	fgprof := c.Profiles["fgprof"]
+	require.Equal(t, "/debug/fgprof", fgprof.Path)
+	require.Equal(t, "wall", fgprof.SampleTypes["time"].DisplayName)
+	require.True(t, fgprof.SampleTypes["time"].Sampled)
+
+	for _, tc := range []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{
+			name:  "undefined profile",
+			input: "{job-name: job, enabled-profiles: [fgprof]}",
+			err:   `enabled profile "fgprof" is not defined`,
+		},
+		{
+			name:  "no path",
+			input: "{job-name: job, profiles: {fgprof: {params: {seconds: [\"10\"]}}}}",
+			err:   `path of profile "fgprof" must start with '/'`,
+		},
+		{
+			name:  "null profile",
+			input: "{job-name: job, profiles: {fgprof: null}}",
+			err:   `empty or null configuration of profile "fgprof"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.EqualError(t, yaml.UnmarshalStrict([]byte(tc.input), new(Config)), tc.err)
+		})
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2016 The Prometheus Authors
+// Copyright 2021 The Pyroscope Authors
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/discovery"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/discovery/refresh"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/discovery/targetgroup"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/model"
+)
+
+const (
+	dnsNameLabel            = model.MetaLabelPrefix + "dns_name"
+	dnsSrvRecordPrefix      = model.MetaLabelPrefix + "dns_srv_record_"
+	dnsSrvRecordTargetLabel = dnsSrvRecordPrefix + "target"
+	dnsSrvRecordPortLabel   = dnsSrvRecordPrefix + "port"
+)
+
+// DefaultSDConfig is the default DNS SD configuration.
+var DefaultSDConfig = SDConfig{
+	RefreshInterval: model.Duration(30 * time.Second),
+	Type:            "SRV",
+}
+
+func init() {
+	discovery.RegisterConfig(&SDConfig{})
+}
+
+// SDConfig is the configuration for DNS based service discovery.
+type SDConfig struct {
+	Names           []string       `yaml:"names"`
+	RefreshInterval model.Duration `yaml:"refresh-interval,omitempty"`
+	Type            string         `yaml:"type"`
+	// Port is the port of the targets discovered with A and AAAA
+	// records. It is ignored for SRV records.
+	Port int `yaml:"port"`
+}
+
+// Name returns the name of the Config.
+func (*SDConfig) Name() string { return "dns" }
+
+// NewDiscoverer returns a Discoverer for the Config.
+func (c *SDConfig) NewDiscoverer(opts discovery.DiscovererOptions) (discovery.Discoverer, error) {
+	return NewDiscovery(*c, opts.Logger), nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *SDConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultSDConfig
+	type plain SDConfig
+	err := unmarshal((*plain)(c))
+	if err != nil {
+		return err
+	}
+	if len(c.Names) == 0 {
+		return errors.New("DNS-SD config must contain at least one name")
+	}
+	switch strings.ToUpper(c.Type) {
+	case "SRV":
+	case "A", "AAAA":
+		if c.Port == 0 {
+			return errors.New("a port is required in DNS-SD configs for all record types except SRV")
+		}
+	default:
+		return fmt.Errorf("invalid DNS-SD records type %s", c.Type)
+	}
+	return nil
+}
+
+// resolver performs DNS lookups. It is satisfied by net.Resolver.
+type resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+}
+
+// Discovery periodically performs DNS-SD requests. It implements
+// the Discoverer interface.
+type Discovery struct {
+	*refresh.Discovery
+	names    []string
+	port     int
+	qtype    string
+	logger   logrus.FieldLogger
+	resolver resolver
+}
+
+// NewDiscovery returns a new Discovery which periodically refreshes its targets.
+func NewDiscovery(conf SDConfig, logger logrus.FieldLogger) *Discovery {
+	d := &Discovery{
+		names:    conf.Names,
+		qtype:    strings.ToUpper(conf.Type),
+		port:     conf.Port,
+		logger:   logger,
+		resolver: net.DefaultResolver,
+	}
+	d.Discovery = refresh.NewDiscovery(
+		logger,
+		"dns",
+		time.Duration(conf.RefreshInterval),
+		d.refresh,
+	)
+	return d
+}
+
+func (d *Discovery) refresh(ctx context.Context) ([]*targetgroup.Group, error) {
+	var (
+		wg  sync.WaitGroup
+		ch  = make(chan *targetgroup.Group)
+		tgs = make([]*targetgroup.Group, 0, len(d.names))
+	)
+
+	wg.Add(len(d.names))
+	for _, name := range d.names {
+		go func(n string) {
+			defer wg.Done()
+			if err := d.refreshOne(ctx, n, ch); err != nil && !errors.Is(err, context.Canceled) {
+				d.logger.WithError(err).WithField("name", n).Error("error refreshing DNS targets")
+			}
+		}(name)
+	}
+
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+
+	for tg := range ch {
+		tgs = append(tgs, tg)
+	}
+	return tgs, nil
+}
+
+func (d *Discovery) refreshOne(ctx context.Context, name string, ch chan<- *targetgroup.Group) error {
+	tg := &targetgroup.Group{Source: name}
+	switch d.qtype {
+	case "SRV":
+		// The name is looked up as is, without the service and protocol.
+		_, records, err := d.resolver.LookupSRV(ctx, "", "", name)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+		for _, record := range records {
+			target := strings.TrimSuffix(record.Target, ".")
+			port := strconv.Itoa(int(record.Port))
+			tg.Targets = append(tg.Targets, model.LabelSet{
+				model.AddressLabel:      model.LabelValue(net.JoinHostPort(target, port)),
+				dnsNameLabel:            model.LabelValue(name),
+				dnsSrvRecordTargetLabel: model.LabelValue(target),
+				dnsSrvRecordPortLabel:   model.LabelValue(port),
+			})
+		}
+	case "A", "AAAA":
+		network := "ip4"
+		if d.qtype == "AAAA" {
+			network = "ip6"
+		}
+		ips, err := d.resolver.LookupIP(ctx, network, name)
+		if err != nil && !isNotFound(err) {
+			return err
+		}
+		for _, ip := range ips {
+			tg.Targets = append(tg.Targets, model.LabelSet{
+				model.AddressLabel:      model.LabelValue(net.JoinHostPort(ip.String(), strconv.Itoa(d.port))),
+				dnsNameLabel:            model.LabelValue(name),
+				dnsSrvRecordTargetLabel: "",
+				dnsSrvRecordPortLabel:   "",
+			})
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case ch <- tg:
+	}
+	return nil
+}
+
+// isNotFound reports whether the name does not exist: in this case
+// the target group is emptied instead of keeping the stale targets.
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
@@ -0,0 +1,180 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/discovery/targetgroup"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/model"
+)
+
+type mockResolver struct {
+	srv map[string][]*net.SRV
+	ip  map[string][]net.IP
+}
+
+func (r mockResolver) LookupSRV(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+	records, ok := r.srv[name]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return name, records, nil
+}
+
+func (r mockResolver) LookupIP(_ context.Context, network, host string) ([]net.IP, error) {
+	if host == "fail.example.com" {
+		return nil, &net.DNSError{Err: "server misbehaving", Name: host, IsTemporary: true}
+	}
+	var ips []net.IP
+	for _, ip := range r.ip[host] {
+		if (ip.To4() != nil) == (network == "ip4") {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, nil
+}
+
+func TestDNSRefresh(t *testing.T) {
+	resolver := mockResolver{
+		srv: map[string][]*net.SRV{
+			"_pprof._tcp.example.com": {
+				{Target: "db1.example.com.", Port: 6060},
+				{Target: "db2.example.com.", Port: 6061},
+			},
+		},
+		ip: map[string][]net.IP{
+			"app.example.com": {net.ParseIP("192.0.2.2"), net.ParseIP("2001:db8::2")},
+		},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		config   SDConfig
+		expected []*targetgroup.Group
+	}{
+		{
+			name:   "SRV",
+			config: SDConfig{Names: []string{"_pprof._tcp.example.com"}, Type: "SRV"},
+			expected: []*targetgroup.Group{{
+				Source: "_pprof._tcp.example.com",
+				Targets: []model.LabelSet{
+					{
+						model.AddressLabel:      "db1.example.com:6060",
+						dnsNameLabel:            "_pprof._tcp.example.com",
+						dnsSrvRecordTargetLabel: "db1.example.com",
+						dnsSrvRecordPortLabel:   "6060",
+					},
+					{
+						model.AddressLabel:      "db2.example.com:6061",
+						dnsNameLabel:            "_pprof._tcp.example.com",
+						dnsSrvRecordTargetLabel: "db2.example.com",
+						dnsSrvRecordPortLabel:   "6061",
+					},
+				},
+			}},
+		},
+		{
+			name:     "SRV name does not exist",
+			config:   SDConfig{Names: []string{"_pprof._tcp.missing.com"}, Type: "SRV"},
+			expected: []*targetgroup.Group{{Source: "_pprof._tcp.missing.com"}},
+		},
+		{
+			name:   "A",
+			config: SDConfig{Names: []string{"app.example.com", "fail.example.com"}, Type: "A", Port: 4040},
+			expected: []*targetgroup.Group{{
+				Source: "app.example.com",
+				Targets: []model.LabelSet{{
+					model.AddressLabel:      "192.0.2.2:4040",
+					dnsNameLabel:            "app.example.com",
+					dnsSrvRecordTargetLabel: "",
+					dnsSrvRecordPortLabel:   "",
+				}},
+			}},
+		},
+		{
+			name:   "AAAA",
+			config: SDConfig{Names: []string{"app.example.com"}, Type: "AAAA", Port: 4040},
+			expected: []*targetgroup.Group{{
+				Source: "app.example.com",
+				Targets: []model.LabelSet{{
+					model.AddressLabel:      "[2001:db8::2]:4040",
+					dnsNameLabel:            "app.example.com",
+					dnsSrvRecordTargetLabel: "",
+					dnsSrvRecordPortLabel:   "",
+				}},
+			}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d := NewDiscovery(tc.config, logrus.New())
+			d.resolver = resolver
+			tgs, err := d.refresh(context.Background())
+			require.NoError(t, err)
+			sort.Slice(tgs, func(i, j int) bool { return tgs[i].Source < tgs[j].Source })
+			require.Equal(t, tc.expected, tgs)
+		})
+	}
+}
+
+func TestSDConfigUnmarshalYAML(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		input    string
+		expected SDConfig
+		err      error
+	}{
+		{
+			name:  "defaults",
+			input: "names: [_pprof._tcp.example.com]",
+			expected: SDConfig{
+				Names:           []string{"_pprof._tcp.example.com"},
+				RefreshInterval: DefaultSDConfig.RefreshInterval,
+				Type:            "SRV",
+			},
+		},
+		{
+			name:  "A",
+			input: "{names: [app.example.com], type: A, port: 4040, refresh-interval: 1m}",
+			expected: SDConfig{
+				Names:           []string{"app.example.com"},
+				RefreshInterval: model.Duration(time.Minute),
+				Type:            "A",
+				Port:            4040,
+			},
+		},
+		{
+			name:  "no names",
+			input: "type: SRV",
+			err:   errors.New("DNS-SD config must contain at least one name"),
+		},
+		{
+			name:  "no port",
+			input: "{names: [app.example.com], type: A}",
+			err:   errors.New("a port is required in DNS-SD configs for all record types except SRV"),
+		},
+		{
+			name:  "invalid type",
+			input: "{names: [app.example.com], type: MX}",
+			err:   errors.New("invalid DNS-SD records type MX"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var c SDConfig
+			err := yaml.UnmarshalStrict([]byte(tc.input), &c)
+			if tc.err != nil {
+				require.EqualError(t, err, tc.err.Error())
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, c)
+		})
+	}
+}
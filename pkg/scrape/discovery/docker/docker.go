@@ -0,0 +1,329 @@
+// Copyright 2020 The Prometheus Authors
+// Copyright 2021 The Pyroscope Authors
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/discovery"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/discovery/refresh"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/discovery/targetgroup"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/model"
+	"github.com/pyroscope-io/pyroscope/pkg/util/strutil"
+)
+
+const (
+	dockerLabel                     = model.MetaLabelPrefix + "docker_"
+	dockerLabelContainerPrefix      = dockerLabel + "container_"
+	dockerLabelContainerID          = dockerLabelContainerPrefix + "id"
+	dockerLabelContainerName        = dockerLabelContainerPrefix + "name"
+	dockerLabelContainerNetworkMode = dockerLabelContainerPrefix + "network_mode"
+	dockerLabelContainerLabelPrefix = dockerLabelContainerPrefix + "label_"
+	dockerLabelNetworkPrefix        = dockerLabel + "network_"
+	dockerLabelNetworkID            = dockerLabelNetworkPrefix + "id"
+	dockerLabelNetworkName          = dockerLabelNetworkPrefix + "name"
+	dockerLabelNetworkScope         = dockerLabelNetworkPrefix + "scope"
+	dockerLabelNetworkInternal      = dockerLabelNetworkPrefix + "internal"
+	dockerLabelNetworkIngress       = dockerLabelNetworkPrefix + "ingress"
+	dockerLabelNetworkLabelPrefix   = dockerLabelNetworkPrefix + "label_"
+	dockerLabelNetworkIP            = dockerLabelNetworkPrefix + "ip"
+	dockerLabelPortPrefix           = dockerLabel + "port_"
+	dockerLabelPortPrivate          = dockerLabelPortPrefix + "private"
+	dockerLabelPortPublic           = dockerLabelPortPrefix + "public"
+	dockerLabelPortPublicIP         = dockerLabelPortPrefix + "public_ip"
+
+	// dockerAPIHost is the host of the API URLs if the daemon is
+	// listening on a unix socket.
+	dockerAPIHost = "docker"
+)
+
+// DefaultSDConfig is the default Docker SD configuration.
+var DefaultSDConfig = SDConfig{
+	Host:               "unix:///var/run/docker.sock",
+	Port:               80,
+	HostNetworkingHost: "localhost",
+	RefreshInterval:    model.Duration(60 * time.Second),
+}
+
+func init() {
+	discovery.RegisterConfig(&SDConfig{})
+}
+
+// Filter is the configuration for filtering containers,
+// as supported by the Docker Engine API.
+type Filter struct {
+	Name   string   `yaml:"name"`
+	Values []string `yaml:"values"`
+}
+
+// SDConfig is the configuration for Docker based service discovery.
+type SDConfig struct {
+	// Host is the address of the Docker daemon: either a unix
+	// socket (unix:///path) or an HTTP endpoint (http://host:port).
+	Host string `yaml:"host"`
+	// Port is the port of the targets of containers that do not
+	// expose any TCP port.
+	Port int `yaml:"port"`
+	// HostNetworkingHost is the address of the targets of containers
+	// that run in the host networking mode.
+	HostNetworkingHost string         `yaml:"host-networking-host"`
+	Filters            []Filter       `yaml:"filters"`
+	RefreshInterval    model.Duration `yaml:"refresh-interval,omitempty"`
+}
+
+// Name returns the name of the Config.
+func (*SDConfig) Name() string { return "docker" }
+
+// NewDiscoverer returns a Discoverer for the Config.
+func (c *SDConfig) NewDiscoverer(opts discovery.DiscovererOptions) (discovery.Discoverer, error) {
+	return NewDiscovery(c, opts.Logger)
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *SDConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultSDConfig
+	type plain SDConfig
+	err := unmarshal((*plain)(c))
+	if err != nil {
+		return err
+	}
+	if c.Host == "" {
+		return errors.New("host missing")
+	}
+	if _, err = url.Parse(c.Host); err != nil {
+		return err
+	}
+	for _, f := range c.Filters {
+		if f.Name == "" || len(f.Values) == 0 {
+			return errors.New("docker SD configuration filter name and values cannot be empty")
+		}
+	}
+	return nil
+}
+
+// Discovery periodically lists containers of the Docker daemon.
+// It implements the Discoverer interface.
+type Discovery struct {
+	*refresh.Discovery
+	client             *http.Client
+	baseURL            string
+	port               int
+	hostNetworkingHost string
+	filters            string
+}
+
+// NewDiscovery returns a new Discovery which periodically refreshes its targets.
+func NewDiscovery(conf *SDConfig, logger logrus.FieldLogger) (*Discovery, error) {
+	u, err := url.Parse(conf.Host)
+	if err != nil {
+		return nil, err
+	}
+	d := Discovery{
+		client:             &http.Client{Timeout: time.Duration(conf.RefreshInterval)},
+		port:               conf.Port,
+		hostNetworkingHost: conf.HostNetworkingHost,
+	}
+	switch u.Scheme {
+	case "unix":
+		var dialer net.Dialer
+		d.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, "unix", u.Path)
+			},
+		}
+		d.baseURL = "http://" + dockerAPIHost
+	case "tcp", "http":
+		d.baseURL = "http://" + u.Host
+	case "https":
+		d.baseURL = "https://" + u.Host
+	default:
+		return nil, fmt.Errorf("unsupported docker host scheme %q", u.Scheme)
+	}
+	if len(conf.Filters) > 0 {
+		filters := make(map[string][]string, len(conf.Filters))
+		for _, f := range conf.Filters {
+			filters[f.Name] = append(filters[f.Name], f.Values...)
+		}
+		b, err := json.Marshal(filters)
+		if err != nil {
+			return nil, err
+		}
+		d.filters = string(b)
+	}
+	d.Discovery = refresh.NewDiscovery(
+		logger,
+		"docker",
+		time.Duration(conf.RefreshInterval),
+		d.refresh,
+	)
+	return &d, nil
+}
+
+// container is a subset of the container summary returned
+// by the Docker Engine API.
+type container struct {
+	ID         string            `json:"Id"`
+	Names      []string          `json:"Names"`
+	Labels     map[string]string `json:"Labels"`
+	Ports      []port            `json:"Ports"`
+	HostConfig struct {
+		NetworkMode string `json:"NetworkMode"`
+	} `json:"HostConfig"`
+	NetworkSettings struct {
+		Networks map[string]endpoint `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+type port struct {
+	IP          string `json:"IP"`
+	PrivatePort uint16 `json:"PrivatePort"`
+	PublicPort  uint16 `json:"PublicPort"`
+	Type        string `json:"Type"`
+}
+
+type endpoint struct {
+	NetworkID string `json:"NetworkID"`
+	IPAddress string `json:"IPAddress"`
+}
+
+type network struct {
+	ID       string            `json:"Id"`
+	Name     string            `json:"Name"`
+	Scope    string            `json:"Scope"`
+	Internal bool              `json:"Internal"`
+	Ingress  bool              `json:"Ingress"`
+	Labels   map[string]string `json:"Labels"`
+}
+
+func (d *Discovery) refresh(ctx context.Context) ([]*targetgroup.Group, error) {
+	tg := &targetgroup.Group{Source: "Docker"}
+
+	var containers []container
+	query := url.Values{}
+	if d.filters != "" {
+		query.Set("filters", d.filters)
+	}
+	if err := d.get(ctx, "/containers/json", query, &containers); err != nil {
+		return nil, fmt.Errorf("error while listing containers: %w", err)
+	}
+	var networks []network
+	if err := d.get(ctx, "/networks", nil, &networks); err != nil {
+		return nil, fmt.Errorf("error while computing network labels: %w", err)
+	}
+	networkLabels := make(map[string]model.LabelSet, len(networks))
+	for _, n := range networks {
+		labels := model.LabelSet{
+			dockerLabelNetworkID:       model.LabelValue(n.ID),
+			dockerLabelNetworkName:     model.LabelValue(n.Name),
+			dockerLabelNetworkScope:    model.LabelValue(n.Scope),
+			dockerLabelNetworkInternal: model.LabelValue(strconv.FormatBool(n.Internal)),
+			dockerLabelNetworkIngress:  model.LabelValue(strconv.FormatBool(n.Ingress)),
+		}
+		for k, v := range n.Labels {
+			labels[model.LabelName(dockerLabelNetworkLabelPrefix+strutil.SanitizeLabelName(k))] = model.LabelValue(v)
+		}
+		networkLabels[n.ID] = labels
+	}
+
+	for _, c := range containers {
+		if len(c.Names) == 0 {
+			continue
+		}
+		commonLabels := model.LabelSet{
+			dockerLabelContainerID:          model.LabelValue(c.ID),
+			dockerLabelContainerName:        model.LabelValue(c.Names[0]),
+			dockerLabelContainerNetworkMode: model.LabelValue(c.HostConfig.NetworkMode),
+		}
+		for k, v := range c.Labels {
+			commonLabels[model.LabelName(dockerLabelContainerLabelPrefix+strutil.SanitizeLabelName(k))] = model.LabelValue(v)
+		}
+
+		for _, n := range c.NetworkSettings.Networks {
+			newLabels := func() model.LabelSet {
+				labels := model.LabelSet{dockerLabelNetworkIP: model.LabelValue(n.IPAddress)}
+				labels = labels.Merge(commonLabels)
+				return labels.Merge(networkLabels[n.NetworkID])
+			}
+
+			var added bool
+			for _, p := range c.Ports {
+				if p.Type != "tcp" {
+					continue
+				}
+				privatePort := strconv.FormatUint(uint64(p.PrivatePort), 10)
+				labels := newLabels()
+				labels[dockerLabelPortPrivate] = model.LabelValue(privatePort)
+				if p.PublicPort > 0 {
+					labels[dockerLabelPortPublic] = model.LabelValue(strconv.FormatUint(uint64(p.PublicPort), 10))
+					labels[dockerLabelPortPublicIP] = model.LabelValue(p.IP)
+				}
+				labels[model.AddressLabel] = model.LabelValue(net.JoinHostPort(n.IPAddress, privatePort))
+				tg.Targets = append(tg.Targets, labels)
+				added = true
+			}
+			if added {
+				continue
+			}
+
+			// The fallback port is used if the container does not expose
+			// any TCP port. Containers in the host networking mode do not
+			// have ports at all.
+			host := n.IPAddress
+			if c.HostConfig.NetworkMode == "host" {
+				host = d.hostNetworkingHost
+			}
+			labels := newLabels()
+			labels[model.AddressLabel] = model.LabelValue(net.JoinHostPort(host, strconv.Itoa(d.port)))
+			tg.Targets = append(tg.Targets, labels)
+		}
+	}
+
+	return []*targetgroup.Group{tg}, nil
+}
+
+func (d *Discovery) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	u := d.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("docker daemon returned HTTP status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,133 @@
+package docker
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/model"
+)
+
+const (
+	containersResponse = `[
+  {
+    "Id": "c1",
+    "Names": ["/app"],
+    "Labels": {"com.example.team": "profiling"},
+    "Ports": [
+      {"IP": "0.0.0.0", "PrivatePort": 4040, "PublicPort": 14040, "Type": "tcp"},
+      {"PrivatePort": 4041, "Type": "udp"}
+    ],
+    "HostConfig": {"NetworkMode": "bridge"},
+    "NetworkSettings": {"Networks": {"bridge": {"NetworkID": "n1", "IPAddress": "172.17.0.2"}}}
+  },
+  {
+    "Id": "c2",
+    "Names": ["/agent"],
+    "HostConfig": {"NetworkMode": "host"},
+    "NetworkSettings": {"Networks": {"host": {"NetworkID": "n2", "IPAddress": ""}}}
+  },
+  {
+    "Id": "c3",
+    "Names": ["/worker"],
+    "HostConfig": {"NetworkMode": "bridge"},
+    "NetworkSettings": {"Networks": {"bridge": {"NetworkID": "n1", "IPAddress": "172.17.0.3"}}}
+  }
+]`
+
+	networksResponse = `[
+  {"Id": "n1", "Name": "bridge", "Scope": "local", "Labels": {"com.example.env": "dev"}},
+  {"Id": "n2", "Name": "host", "Scope": "local"}
+]`
+)
+
+// newDockerServer starts a fake Docker daemon listening on a unix socket.
+func newDockerServer(t *testing.T, filters *string) string {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		*filters = r.URL.Query().Get("filters")
+		_, _ = w.Write([]byte(containersResponse))
+	})
+	mux.HandleFunc("/networks", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(networksResponse))
+	})
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	l, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+	ts := httptest.NewUnstartedServer(mux)
+	ts.Listener = l
+	ts.Start()
+	t.Cleanup(ts.Close)
+	return "unix://" + socket
+}
+
+func TestDockerRefresh(t *testing.T) {
+	var filters string
+	cfg := DefaultSDConfig
+	cfg.Host = newDockerServer(t, &filters)
+	cfg.Filters = []Filter{{Name: "label", Values: []string{"com.example.team=profiling"}}}
+
+	d, err := NewDiscovery(&cfg, logrus.New())
+	require.NoError(t, err)
+	tgs, err := d.refresh(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, `{"label":["com.example.team=profiling"]}`, filters)
+
+	require.Len(t, tgs, 1)
+	require.Equal(t, "Docker", tgs[0].Source)
+	bridgeLabels := model.LabelSet{
+		dockerLabelNetworkID:                              "n1",
+		dockerLabelNetworkName:                            "bridge",
+		dockerLabelNetworkScope:                           "local",
+		dockerLabelNetworkInternal:                        "false",
+		dockerLabelNetworkIngress:                         "false",
+		dockerLabelNetworkLabelPrefix + "com_example_env": "dev",
+	}
+	require.Equal(t, []model.LabelSet{
+		model.LabelSet{
+			model.AddressLabel:                                   "172.17.0.2:4040",
+			dockerLabelContainerID:                               "c1",
+			dockerLabelContainerName:                             "/app",
+			dockerLabelContainerNetworkMode:                      "bridge",
+			dockerLabelContainerLabelPrefix + "com_example_team": "profiling",
+			dockerLabelNetworkIP:                                 "172.17.0.2",
+			dockerLabelPortPrivate:                               "4040",
+			dockerLabelPortPublic:                                "14040",
+			dockerLabelPortPublicIP:                              "0.0.0.0",
+		}.Merge(bridgeLabels),
+		{
+			model.AddressLabel:              "localhost:80",
+			dockerLabelContainerID:          "c2",
+			dockerLabelContainerName:        "/agent",
+			dockerLabelContainerNetworkMode: "host",
+			dockerLabelNetworkIP:            "",
+			dockerLabelNetworkID:            "n2",
+			dockerLabelNetworkName:          "host",
+			dockerLabelNetworkScope:         "local",
+			dockerLabelNetworkInternal:      "false",
+			dockerLabelNetworkIngress:       "false",
+		},
+		model.LabelSet{
+			model.AddressLabel:              "172.17.0.3:80",
+			dockerLabelContainerID:          "c3",
+			dockerLabelContainerName:        "/worker",
+			dockerLabelContainerNetworkMode: "bridge",
+			dockerLabelNetworkIP:            "172.17.0.3",
+		}.Merge(bridgeLabels),
+	}, tgs[0].Targets)
+}
+
+func TestDockerRefreshError(t *testing.T) {
+	cfg := DefaultSDConfig
+	cfg.Host = "unix://" + filepath.Join(t.TempDir(), "missing.sock")
+	d, err := NewDiscovery(&cfg, logrus.New())
+	require.NoError(t, err)
+	_, err = d.refresh(context.Background())
+	require.Error(t, err)
+}
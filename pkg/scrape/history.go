@@ -0,0 +1,117 @@
+package scrape
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+)
+
+// scrapeHistorySize is the number of recent scrape results kept per target.
+const scrapeHistorySize = 16
+
+// ScrapeResult describes a scrape of a target.
+type ScrapeResult struct {
+	Time     time.Time
+	Duration time.Duration
+	// Size of the scraped profile in bytes.
+	Size int64
+	// StatusCode of the HTTP response. Zero, if the request failed.
+	StatusCode int
+	// Samples is the total value of the profile ingested per sample type,
+	// e.g. the number of CPU samples, or the heap size in bytes. If the
+	// profile is ingested asynchronously, e.g. by a remote write target,
+	// the samples are not counted.
+	Samples map[string]uint64
+	// ScrapeError is the error that occurred when scraping the target.
+	ScrapeError error
+	// IngestError is the error that occurred when ingesting the
+	// scraped profile, e.g. if the profile could not be parsed.
+	IngestError error
+}
+
+// Err returns the error of the scrape, if any.
+func (r ScrapeResult) Err() error {
+	if r.ScrapeError != nil {
+		return r.ScrapeError
+	}
+	return r.IngestError
+}
+
+// scrapeHistory is a ring buffer of the recent scrape results.
+type scrapeHistory struct {
+	results []ScrapeResult
+	next    int
+}
+
+func (h *scrapeHistory) add(r ScrapeResult) {
+	if len(h.results) < scrapeHistorySize {
+		h.results = append(h.results, r)
+		return
+	}
+	h.results[h.next] = r
+	h.next = (h.next + 1) % scrapeHistorySize
+}
+
+// list returns the results, from the oldest to the latest one.
+func (h *scrapeHistory) list() []ScrapeResult {
+	results := make([]ScrapeResult, 0, len(h.results))
+	results = append(results, h.results[h.next:]...)
+	return append(results, h.results[:h.next]...)
+}
+
+// countingProfile counts samples of the profile written to storage.
+type countingProfile struct {
+	ingestion.RawProfile
+	counter *sampleCounter
+}
+
+func (p countingProfile) Parse(ctx context.Context, putter storage.Putter, exporter storage.MetricsExporter, md ingestion.Metadata) error {
+	return p.RawProfile.Parse(ctx, countingPutter{Putter: putter, counter: p.counter}, exporter, md)
+}
+
+// sampleCounter counts samples per sample type. A counter is shared by
+// all the profiles scraped from a target: a parser of cumulative profiles
+// keeps the putter of the first profile and reuses it for the next ones.
+type sampleCounter struct {
+	m       sync.Mutex
+	samples map[string]uint64
+}
+
+func (c *sampleCounter) add(sampleType string, n uint64) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.samples == nil {
+		c.samples = make(map[string]uint64)
+	}
+	c.samples[sampleType] += n
+}
+
+// reset returns the samples counted and resets the counter.
+func (c *sampleCounter) reset() map[string]uint64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+	samples := c.samples
+	c.samples = nil
+	return samples
+}
+
+type countingPutter struct {
+	storage.Putter
+	counter *sampleCounter
+}
+
+func (p countingPutter) Put(ctx context.Context, pi *storage.PutInput) error {
+	// The tree may be modified once it is written.
+	var n uint64
+	if pi.Val != nil {
+		n = pi.Val.Samples()
+	}
+	if err := p.Putter.Put(ctx, pi); err != nil {
+		return err
+	}
+	p.counter.add(pi.SampleType, n)
+	return nil
+}
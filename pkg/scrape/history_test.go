@@ -0,0 +1,110 @@
+package scrape
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime/pprof"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/config"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/discovery/targetgroup"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/model"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+)
+
+func TestScrapeHistory(t *testing.T) {
+	var h scrapeHistory
+	require.Empty(t, h.list())
+	for i := 0; i < scrapeHistorySize+3; i++ {
+		h.add(ScrapeResult{Size: int64(i)})
+	}
+	results := h.list()
+	require.Len(t, results, scrapeHistorySize)
+	for i, r := range results {
+		require.Equal(t, int64(i+3), r.Size)
+	}
+}
+
+type putterFunc func(context.Context, *storage.PutInput) error
+
+func (fn putterFunc) Put(ctx context.Context, pi *storage.PutInput) error { return fn(ctx, pi) }
+
+type mockIngester struct{}
+
+func (mockIngester) Ingest(ctx context.Context, in *ingestion.IngestInput) error {
+	putter := putterFunc(func(context.Context, *storage.PutInput) error { return nil })
+	return in.Profile.Parse(ctx, putter, nil, in.Metadata)
+}
+
+func TestScrapeAndReport(t *testing.T) {
+	var goroutines bytes.Buffer
+	require.NoError(t, pprof.Lookup("goroutine").WriteTo(&goroutines, 0))
+	responses := []func(http.ResponseWriter){
+		func(w http.ResponseWriter) { _, _ = w.Write(goroutines.Bytes()) },
+		func(w http.ResponseWriter) { w.WriteHeader(http.StatusInternalServerError) },
+		func(w http.ResponseWriter) { _, _ = w.Write([]byte("not a profile")) },
+	}
+	var n int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		responses[atomic.AddInt64(&n, 1)-1](w)
+	}))
+	defer ts.Close()
+	u, err := url.Parse(ts.URL)
+	require.NoError(t, err)
+
+	cfg := config.DefaultConfig()
+	cfg.JobName = "job"
+	cfg.EnabledProfiles = []string{"goroutines"}
+	targets, errs := TargetsFromGroup(&targetgroup.Group{Targets: []model.LabelSet{{
+		model.AddressLabel: model.LabelValue(u.Host),
+		model.AppNameLabel: "app",
+	}}}, cfg)
+	require.Empty(t, errs)
+	require.Len(t, targets, 1)
+	target := targets[0]
+
+	m := newMetrics(prometheus.NewRegistry())
+	sp, err := newScrapePool(cfg, mockIngester{}, logrus.New(), m)
+	require.NoError(t, err)
+	l := sp.newScrapeLoop(sp.newScraper(target, time.Second, 0), cfg.ScrapeInterval, time.Second)
+	// The first scrape of profiles that are captured immediately is skipped.
+	for i := 0; i <= len(responses); i++ {
+		l.scrapeAndReport(target)
+	}
+
+	history := target.History()
+	require.Len(t, history, 3)
+
+	require.NoError(t, history[0].Err())
+	require.Equal(t, http.StatusOK, history[0].StatusCode)
+	require.Equal(t, int64(goroutines.Len()), history[0].Size)
+	require.NotZero(t, history[0].Samples["goroutines"])
+
+	require.Equal(t, http.StatusInternalServerError, history[1].StatusCode)
+	require.Error(t, history[1].ScrapeError)
+	require.Nil(t, history[1].Samples)
+
+	require.Equal(t, http.StatusOK, history[2].StatusCode)
+	require.NoError(t, history[2].ScrapeError)
+	require.Error(t, history[2].IngestError)
+
+	require.Equal(t, HealthBad, target.Health())
+	require.Equal(t, history[2].IngestError, target.LastError())
+	require.Equal(t, 1.0, testutil.ToFloat64(sp.poolMetrics.scrapesFailed))
+	require.Equal(t, 1.0, testutil.ToFloat64(sp.poolMetrics.ingestionsFailed))
+	up := m.up.WithLabelValues("job", "/debug/pprof/goroutine", u.Host)
+	require.Equal(t, 0.0, testutil.ToFloat64(up))
+	lastSuccess := m.lastSuccess.WithLabelValues("job", "/debug/pprof/goroutine", u.Host)
+	require.Equal(t, float64(history[0].Time.Unix()), testutil.ToFloat64(lastSuccess))
+}
@@ -43,6 +43,7 @@ type Manager struct {
 	scrapeConfigs map[string]*config.Config
 	scrapePools   map[string]*scrapePool
 	targetSets    map[string][]*targetgroup.Group
+	sharding      Sharding
 
 	reloadC chan struct{}
 }
@@ -103,7 +104,7 @@ func (m *Manager) reload() {
 		wg.Add(1)
 		// Run the sync in parallel as these take a while and at high load can't catch up.
 		go func(sp *scrapePool, groups []*targetgroup.Group) {
-			sp.Sync(groups)
+			sp.Sync(groups, m.sharding)
 			wg.Done()
 		}(m.scrapePools[setName], groups)
 	}
@@ -154,6 +155,26 @@ func (m *Manager) ApplyConfig(cfg []*config.Config) error {
 	return nil
 }
 
+// ApplySharding changes the set of targets scraped by the manager:
+// targets of other shards are stopped, targets of the shard of this
+// server are started. If sharding has not changed, the call is noop.
+func (m *Manager) ApplySharding(s Sharding) {
+	m.mtxScrape.Lock()
+	changed := m.sharding != s
+	m.sharding = s
+	m.mtxScrape.Unlock()
+	if changed {
+		m.reload()
+	}
+}
+
+// Sharding returns the sharding configuration of the manager.
+func (m *Manager) Sharding() Sharding {
+	m.mtxScrape.Lock()
+	defer m.mtxScrape.Unlock()
+	return m.sharding
+}
+
 // TargetsAll returns active and dropped targets grouped by job_name.
 func (m *Manager) TargetsAll() map[string][]*Target {
 	m.mtxScrape.Lock()
@@ -191,6 +212,19 @@ func (m *Manager) TargetsActive() map[string][]*Target {
 	return targets
 }
 
+// TargetsOtherShards returns the targets assigned to other shards,
+// which are not scraped by this server.
+func (m *Manager) TargetsOtherShards() map[string][]*Target {
+	m.mtxScrape.Lock()
+	defer m.mtxScrape.Unlock()
+
+	targets := make(map[string][]*Target, len(m.scrapePools))
+	for tset, sp := range m.scrapePools {
+		targets[tset] = sp.OtherShardTargets()
+	}
+	return targets
+}
+
 // TargetsDropped returns the dropped targets during relabelling.
 func (m *Manager) TargetsDropped() map[string][]*Target {
 	m.mtxScrape.Lock()
@@ -3,6 +3,8 @@ package scrape
 import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/model"
 )
 
 type metrics struct {
@@ -21,11 +23,15 @@ type metrics struct {
 	// Metrics shared by scrape loops.
 	scrapes              *prometheus.CounterVec
 	scrapesFailed        *prometheus.CounterVec
+	ingestionsFailed     *prometheus.CounterVec
 	scrapeIntervalLength *prometheus.SummaryVec
 	// Metrics specific to targets.
 	profileSize    *prometheus.SummaryVec
 	profileSamples *prometheus.SummaryVec
 	scrapeDuration *prometheus.SummaryVec
+	// Metrics of individual targets.
+	up          *prometheus.GaugeVec
+	lastSuccess *prometheus.GaugeVec
 }
 
 type poolMetrics struct {
@@ -37,6 +43,7 @@ type poolMetrics struct {
 
 	scrapes              prometheus.Counter
 	scrapesFailed        prometheus.Counter
+	ingestionsFailed     prometheus.Counter
 	scrapeIntervalLength prometheus.Observer
 }
 
@@ -44,11 +51,15 @@ type targetMetrics struct {
 	profileSize    prometheus.Observer
 	profileSamples prometheus.Observer
 	scrapeDuration prometheus.Observer
+
+	up          prometheus.Gauge
+	lastSuccess prometheus.Gauge
 }
 
 func newMetrics(r prometheus.Registerer) *metrics {
 	poolLabels := []string{"scrape_job"}
 	targetLabels := []string{"scrape_job", "profile_path"}
+	instanceLabels := []string{"scrape_job", "profile_path", "instance"}
 	return &metrics{
 		pools: promauto.With(r).NewCounter(prometheus.CounterOpts{
 			Name: "pyroscope_scrape_target_pools_total",
@@ -98,6 +109,10 @@ func newMetrics(r prometheus.Registerer) *metrics {
 			Name: "pyroscope_scrape_target_pool_scrapes_failed_total",
 			Help: "Total number of scrapes failed.",
 		}, poolLabels),
+		ingestionsFailed: promauto.With(r).NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_scrape_target_pool_ingestions_failed_total",
+			Help: "Total number of scraped profiles that failed to be ingested.",
+		}, poolLabels),
 		scrapeIntervalLength: promauto.With(r).NewSummaryVec(prometheus.SummaryOpts{
 			Name:       "pyroscope_scrape_target_pool_scrape_interval_length_seconds",
 			Help:       "Actual intervals between scrapes.",
@@ -119,6 +134,15 @@ func newMetrics(r prometheus.Registerer) *metrics {
 			Help:       "Actual duration of profile scraping.",
 			Objectives: map[float64]float64{0.01: 0.001, 0.05: 0.005, 0.5: 0.05, 0.90: 0.01, 0.99: 0.001},
 		}, targetLabels),
+
+		up: promauto.With(r).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "pyroscope_scrape_target_up",
+			Help: "Whether the profile was scraped and ingested successfully at the last scrape: 1 if so, 0 otherwise.",
+		}, instanceLabels),
+		lastSuccess: promauto.With(r).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "pyroscope_scrape_target_last_success_timestamp_seconds",
+			Help: "Time of the last scrape that was successful.",
+		}, instanceLabels),
 	}
 }
 
@@ -132,14 +156,36 @@ func (m *metrics) poolMetrics(jobName string) *poolMetrics {
 
 		scrapes:              m.scrapes.WithLabelValues(jobName),
 		scrapesFailed:        m.scrapesFailed.WithLabelValues(jobName),
+		ingestionsFailed:     m.ingestionsFailed.WithLabelValues(jobName),
 		scrapeIntervalLength: m.scrapeIntervalLength.WithLabelValues(jobName),
 	}
 }
 
-func (m *metrics) targetMetrics(jobName, profilePath string) *targetMetrics {
+func (m *metrics) targetMetrics(jobName string, t *Target) *targetMetrics {
+	instance := t.labels.Get(model.InstanceLabel)
 	return &targetMetrics{
-		profileSize:    m.profileSize.WithLabelValues(jobName, profilePath),
-		profileSamples: m.profileSamples.WithLabelValues(jobName, profilePath),
-		scrapeDuration: m.scrapeDuration.WithLabelValues(jobName, profilePath),
+		profileSize:    m.profileSize.WithLabelValues(jobName, t.config.Path),
+		profileSamples: m.profileSamples.WithLabelValues(jobName, t.config.Path),
+		scrapeDuration: m.scrapeDuration.WithLabelValues(jobName, t.config.Path),
+
+		up:          m.up.WithLabelValues(jobName, t.config.Path, instance),
+		lastSuccess: m.lastSuccess.WithLabelValues(jobName, t.config.Path, instance),
+	}
+}
+
+// deleteInstanceMetrics deletes metrics of the target
+// once it is not scraped anymore.
+func (m *metrics) deleteInstanceMetrics(jobName string, t *Target) {
+	instance := t.labels.Get(model.InstanceLabel)
+	m.up.DeleteLabelValues(jobName, t.config.Path, instance)
+	m.lastSuccess.DeleteLabelValues(jobName, t.config.Path, instance)
+}
+
+func (m *targetMetrics) report(r ScrapeResult) {
+	if r.Err() != nil {
+		m.up.Set(0)
+		return
 	}
+	m.up.Set(1)
+	m.lastSuccess.Set(float64(r.Time.Unix()))
 }
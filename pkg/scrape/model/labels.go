@@ -95,6 +95,10 @@ const (
 	ProfileLabelPrefix = "__profile_"
 	ProfilePathLabel   = ProfileLabelPrefix + "path__"
 	ProfileNameLabel   = ProfileLabelPrefix + "name__"
+	// ProfilesLabel is a comma-separated list of profiles to be
+	// scraped from the target. If set, it overrides the profiles
+	// enabled in the scrape config.
+	ProfilesLabel = "__profiles__"
 )
 
 // A LabelName is a key for a LabelSet or Metric.  It has a value associated
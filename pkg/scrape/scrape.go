@@ -65,6 +65,8 @@ type scrapePool struct {
 	// set of hashes.
 	activeTargets  map[uint64]*Target
 	droppedTargets []*Target
+	// Targets that are assigned to other shards.
+	otherShardTargets []*Target
 }
 
 func newScrapePool(cfg *config.Config, p ingestion.Ingester, logger logrus.FieldLogger, m *metrics) (*scrapePool, error) {
@@ -126,6 +128,12 @@ func (sp *scrapePool) DroppedTargets() []*Target {
 	return sp.droppedTargets
 }
 
+func (sp *scrapePool) OtherShardTargets() []*Target {
+	sp.targetMtx.Lock()
+	defer sp.targetMtx.Unlock()
+	return sp.otherShardTargets
+}
+
 // stop terminates all scrapers and returns after they all terminated.
 func (sp *scrapePool) stop() {
 	sp.mtx.Lock()
@@ -137,6 +145,7 @@ func (sp *scrapePool) stop() {
 	for fp, l := range sp.loops {
 		go func(l *scrapeLoop) {
 			l.stop()
+			sp.metrics.deleteInstanceMetrics(sp.config.JobName, l.scraper.Target)
 			wg.Done()
 		}(l)
 		delete(sp.loops, fp)
@@ -159,6 +168,7 @@ func (sp *scrapePool) stop() {
 	sp.metrics.poolSyncFailed.DeleteLabelValues(sp.config.JobName)
 	sp.metrics.poolTargetsAdded.DeleteLabelValues(sp.config.JobName)
 	sp.metrics.scrapesFailed.DeleteLabelValues(sp.config.JobName)
+	sp.metrics.ingestionsFailed.DeleteLabelValues(sp.config.JobName)
 }
 
 // reload the scrape pool with the given scrape configuration. The target state is preserved
@@ -213,7 +223,7 @@ func (sp *scrapePool) newScraper(t *Target, timeout time.Duration, bodySizeLimit
 		client:        sp.client,
 		timeout:       timeout,
 		bodySizeLimit: bodySizeLimit,
-		targetMetrics: sp.metrics.targetMetrics(sp.config.JobName, t.config.Path),
+		targetMetrics: sp.metrics.targetMetrics(sp.config.JobName, t),
 		ingester:      sp.ingester,
 		key:           segment.NewKey(t.Labels().Map()),
 		spyName:       t.SpyName(),
@@ -223,7 +233,8 @@ func (sp *scrapePool) newScraper(t *Target, timeout time.Duration, bodySizeLimit
 
 // Sync converts target groups into actual scrape targets and synchronizes
 // the currently running scraper with the resulting set and returns all scraped and dropped targets.
-func (sp *scrapePool) Sync(tgs []*targetgroup.Group) {
+// Only targets of the shard of this server are scraped.
+func (sp *scrapePool) Sync(tgs []*targetgroup.Group, sharding Sharding) {
 	sp.mtx.Lock()
 	defer sp.mtx.Unlock()
 	start := time.Now()
@@ -231,6 +242,7 @@ func (sp *scrapePool) Sync(tgs []*targetgroup.Group) {
 	sp.targetMtx.Lock()
 	var all []*Target
 	sp.droppedTargets = []*Target{}
+	sp.otherShardTargets = nil
 	for _, tg := range tgs {
 		targets, failures := TargetsFromGroup(tg, sp.config)
 		for _, err := range failures {
@@ -238,9 +250,12 @@ func (sp *scrapePool) Sync(tgs []*targetgroup.Group) {
 		}
 		sp.poolMetrics.poolSyncFailed.Add(float64(len(failures)))
 		for _, t := range targets {
-			if t.Labels().Len() > 0 {
+			switch {
+			case t.Labels().Len() > 0 && sharding.Owns(t):
 				all = append(all, t)
-			} else if t.DiscoveredLabels().Len() > 0 {
+			case t.Labels().Len() > 0:
+				sp.otherShardTargets = append(sp.otherShardTargets, t)
+			case t.DiscoveredLabels().Len() > 0:
 				sp.droppedTargets = append(sp.droppedTargets, t)
 			}
 		}
@@ -292,6 +307,7 @@ func (sp *scrapePool) sync(targets []*Target) {
 			wg.Add(1)
 			go func(l *scrapeLoop) {
 				l.stop()
+				sp.metrics.deleteInstanceMetrics(sp.config.JobName, l.scraper.Target)
 				wg.Done()
 			}(sp.loops[hash])
 			delete(sp.loops, hash)
@@ -382,7 +398,14 @@ func (sl *scrapeLoop) scrapeAndReport(t *Target) {
 		endTime = now.Round(sl.interval)
 		startTime = endTime.Add(-1 * sl.interval)
 	}
-	err := sl.scrape(startTime, endTime)
+	r := sl.scrape(startTime, endTime)
+	if sl.ctx.Err() != nil {
+		// The loop has been stopped during the scrape.
+		return
+	}
+	r.Time = now
+	r.Duration = time.Since(now)
+	err := r.Err()
 	t.mtx.Lock()
 	defer t.mtx.Unlock()
 	if err == nil {
@@ -392,27 +415,29 @@ func (sl *scrapeLoop) scrapeAndReport(t *Target) {
 	}
 	t.lastError = err
 	t.lastScrape = now
-	t.lastScrapeDuration = time.Since(now)
-	sl.scraper.targetMetrics.scrapeDuration.Observe(sl.scraper.Target.lastScrapeDuration.Seconds())
+	t.lastScrapeDuration = r.Duration
+	t.history.add(r)
+	sl.scraper.targetMetrics.scrapeDuration.Observe(r.Duration.Seconds())
+	sl.scraper.targetMetrics.report(r)
 }
 
-func (sl *scrapeLoop) scrape(startTime, endTime time.Time) error {
+func (sl *scrapeLoop) scrape(startTime, endTime time.Time) ScrapeResult {
 	ctx, cancel := context.WithTimeout(sl.ctx, sl.timeout)
 	defer cancel()
 	sl.poolMetrics.scrapes.Inc()
 	buf := bytes.NewBuffer(make([]byte, 0, 64<<10))
-	switch err := sl.scraper.scrape(ctx, buf); {
-	case err == nil:
-	case errors.Is(err, context.Canceled):
-		sl.scraper.profile = nil
-		return nil
-	default:
-		sl.poolMetrics.scrapesFailed.Inc()
-		sl.logger.WithError(err).WithField("target", sl.scraper.Target.String()).Debug("scraping failed")
+	var r ScrapeResult
+	r.StatusCode, r.ScrapeError = sl.scraper.scrape(ctx, buf)
+	if r.ScrapeError != nil {
 		sl.scraper.profile = nil
-		return err
+		if !errors.Is(r.ScrapeError, context.Canceled) {
+			sl.poolMetrics.scrapesFailed.Inc()
+			sl.logger.WithError(r.ScrapeError).WithField("target", sl.scraper.Target.String()).Debug("scraping failed")
+		}
+		return r
 	}
 
+	r.Size = int64(buf.Len())
 	sl.scraper.targetMetrics.profileSize.Observe(float64(buf.Len()))
 	if sl.scraper.profile == nil {
 		sl.scraper.profile = &pprof.RawProfile{
@@ -422,8 +447,9 @@ func (sl *scrapeLoop) scrape(startTime, endTime time.Time) error {
 
 	profile := sl.scraper.profile
 	sl.scraper.profile = profile.Push(buf.Bytes(), sl.scraper.cumulative)
-	return sl.scraper.ingester.Ingest(ctx, &ingestion.IngestInput{
-		Profile: profile,
+	sl.scraper.samples.reset()
+	r.IngestError = sl.scraper.ingester.Ingest(ctx, &ingestion.IngestInput{
+		Profile: countingProfile{RawProfile: profile, counter: &sl.scraper.samples},
 		Metadata: ingestion.Metadata{
 			SpyName:   sl.scraper.spyName,
 			Key:       sl.scraper.key,
@@ -431,6 +457,12 @@ func (sl *scrapeLoop) scrape(startTime, endTime time.Time) error {
 			EndTime:   endTime,
 		},
 	})
+	if r.IngestError != nil {
+		sl.poolMetrics.ingestionsFailed.Inc()
+		sl.logger.WithError(r.IngestError).WithField("target", sl.scraper.Target.String()).Debug("ingestion failed")
+	}
+	r.Samples = sl.scraper.samples.reset()
+	return r
 }
 
 func (sl *scrapeLoop) stop() {
@@ -447,6 +479,7 @@ type scraper struct {
 	cumulative bool
 	spyName    string
 	key        *segment.Key
+	samples    sampleCounter
 
 	client  *http.Client
 	req     *http.Request
@@ -458,11 +491,12 @@ type scraper struct {
 	*targetMetrics
 }
 
-func (s *scraper) scrape(ctx context.Context, dst *bytes.Buffer) error {
+// scrape fetches the profile and returns the HTTP status code of the response.
+func (s *scraper) scrape(ctx context.Context, dst *bytes.Buffer) (int, error) {
 	if s.req == nil {
 		req, err := http.NewRequest("GET", s.URL().String(), nil)
 		if err != nil {
-			return err
+			return 0, err
 		}
 		req.Header.Set("User-Agent", UserAgent)
 		s.req = req
@@ -470,24 +504,24 @@ func (s *scraper) scrape(ctx context.Context, dst *bytes.Buffer) error {
 
 	resp, err := s.client.Do(s.req.WithContext(ctx))
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer func() {
 		_ = resp.Body.Close()
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned HTTP status %s", resp.Status)
+		return resp.StatusCode, fmt.Errorf("server returned HTTP status %s", resp.Status)
 	}
 	if s.bodySizeLimit <= 0 {
 		s.bodySizeLimit = math.MaxInt64
 	}
 	n, err := io.Copy(dst, io.LimitReader(resp.Body, s.bodySizeLimit))
 	if err != nil {
-		return err
+		return resp.StatusCode, err
 	}
 	if n >= s.bodySizeLimit {
-		return errBodySizeLimit
+		return resp.StatusCode, errBodySizeLimit
 	}
-	return nil
+	return resp.StatusCode, nil
 }
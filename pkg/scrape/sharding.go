@@ -0,0 +1,80 @@
+package scrape
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/model"
+)
+
+// Sharding distributes scrape targets across multiple servers that share
+// the same scrape configuration: every server only scrapes the targets of
+// its own shard. A target is assigned to the shard equal to the MD5 hash
+// of its address modulo the number of shards, just like the hashmod
+// relabeling action does.
+//
+// The zero value disables sharding: all targets are scraped.
+type Sharding struct {
+	// Shards is the total number of shards.
+	Shards uint64
+	// Index of the shard owned by this server.
+	Index uint64
+}
+
+var errInvalidSharding = errors.New("invalid scrape sharding configuration")
+
+// NewSharding creates Sharding of the configuration. If the peer list is
+// specified, the number of shards is the number of peers, and the index
+// is the position of the server address in the sorted list, therefore
+// all the servers must have the same list.
+func NewSharding(c config.ScrapeSharding) (Sharding, error) {
+	if len(c.Peers) == 0 {
+		if c.Shards < 0 || c.ShardIndex < 0 || c.Shards > 0 && c.ShardIndex >= c.Shards {
+			return Sharding{}, fmt.Errorf("%w: shard index %d is out of range [0, %d)",
+				errInvalidSharding, c.ShardIndex, c.Shards)
+		}
+		return Sharding{Shards: uint64(c.Shards), Index: uint64(c.ShardIndex)}, nil
+	}
+	self := c.Self
+	if self == "" {
+		var err error
+		if self, err = os.Hostname(); err != nil {
+			return Sharding{}, fmt.Errorf("%w: %v", errInvalidSharding, err)
+		}
+	}
+	peers := make(map[string]struct{}, len(c.Peers))
+	for _, p := range c.Peers {
+		peers[p] = struct{}{}
+	}
+	sorted := make([]string, 0, len(peers))
+	for p := range peers {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+	for i, p := range sorted {
+		if p == self {
+			return Sharding{Shards: uint64(len(sorted)), Index: uint64(i)}, nil
+		}
+	}
+	return Sharding{}, fmt.Errorf("%w: %q is not in the peer list", errInvalidSharding, self)
+}
+
+// Enabled reports whether targets are sharded.
+func (s Sharding) Enabled() bool { return s.Shards > 1 }
+
+// Shard returns the index of the shard the target is assigned to.
+func (s Sharding) Shard(t *Target) uint64 {
+	if !s.Enabled() {
+		return s.Index
+	}
+	h := md5.Sum([]byte(t.labels.Get(model.AddressLabel)))
+	return binary.BigEndian.Uint64(h[md5.Size-8:]) % s.Shards
+}
+
+// Owns reports whether the target is assigned to the shard of this server.
+func (s Sharding) Owns(t *Target) bool { return s.Shard(t) == s.Index }
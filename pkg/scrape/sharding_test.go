@@ -0,0 +1,98 @@
+package scrape
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	sc "github.com/pyroscope-io/pyroscope/pkg/scrape/config"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/labels"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/model"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/relabel"
+)
+
+func TestNewSharding(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		config   config.ScrapeSharding
+		expected Sharding
+		err      bool
+	}{
+		{name: "disabled"},
+		{
+			name:     "static",
+			config:   config.ScrapeSharding{Shards: 3, ShardIndex: 2},
+			expected: Sharding{Shards: 3, Index: 2},
+		},
+		{
+			name:   "index out of range",
+			config: config.ScrapeSharding{Shards: 3, ShardIndex: 3},
+			err:    true,
+		},
+		{
+			name:   "negative index",
+			config: config.ScrapeSharding{Shards: 3, ShardIndex: -1},
+			err:    true,
+		},
+		{
+			name: "peers",
+			config: config.ScrapeSharding{
+				Peers: []string{"c:4040", "a:4040", "b:4040", "a:4040"},
+				Self:  "b:4040",
+			},
+			expected: Sharding{Shards: 3, Index: 1},
+		},
+		{
+			name: "self is not a peer",
+			config: config.ScrapeSharding{
+				Peers: []string{"a:4040", "b:4040"},
+				Self:  "c:4040",
+			},
+			err: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := NewSharding(tc.config)
+			if tc.err {
+				require.True(t, errors.Is(err, errInvalidSharding))
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, s)
+		})
+	}
+}
+
+func TestShardingOwns(t *testing.T) {
+	const shards = 3
+	hashmod := relabel.DefaultRelabelConfig
+	hashmod.Action = relabel.HashMod
+	hashmod.SourceLabels = model.LabelNames{model.AddressLabel}
+	hashmod.TargetLabel = "__tmp_hash"
+	hashmod.Modulus = shards
+
+	owned := make([]int, shards)
+	for i := 0; i < 100; i++ {
+		ls := labels.FromStrings(model.AddressLabel, fmt.Sprintf("10.0.0.%d:4040", i))
+		target := NewTarget(ls, ls, &sc.Profile{})
+		var owners int
+		for index := uint64(0); index < shards; index++ {
+			if (Sharding{Shards: shards, Index: index}).Owns(target) {
+				owners++
+				owned[index]++
+				// The assignment must match the one of hashmod relabeling.
+				expected := relabel.Process(ls, &hashmod).Get("__tmp_hash")
+				require.Equal(t, expected, strconv.FormatUint(index, 10))
+			}
+		}
+		require.Equal(t, 1, owners)
+		require.True(t, Sharding{}.Owns(target))
+	}
+	for _, n := range owned {
+		require.NotZero(t, n)
+	}
+}
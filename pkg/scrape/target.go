@@ -61,6 +61,7 @@ type Target struct {
 	lastScrape         time.Time
 	lastScrapeDuration time.Duration
 	health             TargetHealth
+	history            scrapeHistory
 }
 
 // NewTarget creates a reasonably configured target for querying.
@@ -195,6 +196,13 @@ func (t *Target) Health() TargetHealth {
 	return t.health
 }
 
+// History returns the recent scrape results, from the oldest to the latest one.
+func (t *Target) History() []ScrapeResult {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+	return t.history.list()
+}
+
 // intervalAndTimeout returns the interval and timeout derived from
 // the targets labels.
 func (t *Target) intervalAndTimeout(defaultInterval, defaultDuration time.Duration) (time.Duration, time.Duration, error) {
@@ -416,7 +424,7 @@ func buildConfig(cfg *config.Config, profileName string, lbls map[string]string)
 	case "false":
 		return nil, false
 	default:
-		if !cfg.IsProfileEnabled(profileName) {
+		if !isProfileEnabled(cfg, profileName, lbls) {
 			return nil, false
 		}
 	}
@@ -435,18 +443,31 @@ func buildConfig(cfg *config.Config, profileName string, lbls map[string]string)
 	for k, v := range c.Params {
 		params[k] = v
 	}
+	pp := prefix + "param_"
 	for k, v := range lbls {
-		pp := prefix + "param"
 		if !strings.HasPrefix(k, pp) {
 			continue
 		}
-		ks := k[len(pp):]
-		if len(params[k]) > 0 {
-			params[ks][0] = v
-		} else {
-			params[ks] = []string{v}
-		}
+		// Values are replaced, not modified in place:
+		// the slices are shared with the scrape config.
+		params[k[len(pp):]] = []string{v}
 	}
 	c.Params = params
 	return &c, true
 }
+
+// isProfileEnabled reports whether the profile is to be scraped from the
+// target. The set of profiles enabled in the scrape config can be overridden
+// per target with ProfilesLabel, e.g. set from a pod annotation.
+func isProfileEnabled(cfg *config.Config, profileName string, lbls map[string]string) bool {
+	profiles, ok := lbls[model.ProfilesLabel]
+	if !ok {
+		return cfg.IsProfileEnabled(profileName)
+	}
+	for _, p := range strings.Split(profiles, ",") {
+		if strings.TrimSpace(p) == profileName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+package scrape
+
+import (
+	"net/url"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/config"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/discovery/targetgroup"
+	"github.com/pyroscope-io/pyroscope/pkg/scrape/model"
+)
+
+func TestTargetsFromGroupProfiles(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.JobName = "job"
+	cfg.EnabledProfiles = []string{"cpu", "mem"}
+	cfg.Profiles["fgprof"] = &config.Profile{
+		Path:   "/debug/fgprof",
+		Params: url.Values{"format": []string{"pprof"}, "seconds": []string{"10"}},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		labels   model.LabelSet
+		expected []string
+	}{
+		{
+			name:     "enabled in scrape config",
+			expected: []string{"/debug/pprof/heap", "/debug/pprof/profile?seconds=10"},
+		},
+		{
+			name: "profiles label",
+			labels: model.LabelSet{
+				model.ProfilesLabel: "goroutines, threadcreate,fgprof,unknown",
+			},
+			expected: []string{
+				"/debug/fgprof?format=pprof&seconds=10",
+				"/debug/pprof/goroutine",
+				"/debug/pprof/threadcreate",
+			},
+		},
+		{
+			name: "profile labels",
+			labels: model.LabelSet{
+				model.ProfilesLabel:              "cpu,mem",
+				"__profile_mem_enabled":          "false",
+				"__profile_fgprof_enabled":       "true",
+				"__profile_fgprof_path":          "/fgprof",
+				"__profile_fgprof_param_seconds": "5",
+			},
+			expected: []string{
+				"/debug/pprof/profile?seconds=10",
+				"/fgprof?format=pprof&seconds=5",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ls := model.LabelSet{
+				model.AddressLabel: "localhost:6060",
+				model.AppNameLabel: "app",
+			}.Merge(tc.labels)
+			targets, errs := TargetsFromGroup(&targetgroup.Group{Targets: []model.LabelSet{ls}}, cfg)
+			require.Empty(t, errs)
+			actual := make([]string, 0, len(targets))
+			for _, target := range targets {
+				actual = append(actual, target.URL().RequestURI())
+			}
+			sort.Strings(actual)
+			require.Equal(t, tc.expected, actual)
+		})
+	}
+
+	// The scrape config must not be modified by the target overrides.
+	require.Equal(t, url.Values{"format": []string{"pprof"}, "seconds": []string{"10"}}, cfg.Profiles["fgprof"].Params)
+}
@@ -27,12 +27,14 @@ import (
 	metrics "github.com/slok/go-http-metrics/metrics/prometheus"
 	"github.com/slok/go-http-metrics/middleware"
 	"github.com/slok/go-http-metrics/middleware/std"
+	"google.golang.org/grpc"
 	"gorm.io/gorm"
 
 	"github.com/pyroscope-io/pyroscope/pkg/api"
 	"github.com/pyroscope-io/pyroscope/pkg/api/authz"
 	"github.com/pyroscope-io/pyroscope/pkg/api/router"
 	"github.com/pyroscope-io/pyroscope/pkg/config"
+	pprofconv "github.com/pyroscope-io/pyroscope/pkg/convert/pprof"
 	"github.com/pyroscope-io/pyroscope/pkg/model"
 	"github.com/pyroscope-io/pyroscope/pkg/scrape"
 	"github.com/pyroscope-io/pyroscope/pkg/scrape/labels"
@@ -59,6 +61,7 @@ type Controller struct {
 	ingestser  ingestion.Ingester
 	log        *logrus.Logger
 	httpServer *http.Server
+	grpcServer *grpc.Server
 	db         *gorm.DB
 	notifier   Notifier
 	metricsMdw middleware.Middleware
@@ -84,6 +87,7 @@ type Controller struct {
 
 	scrapeManager *scrape.Manager
 	historyMgr    history.Manager
+	pprofDelta    *pprofconv.DeltaTracker
 }
 
 type Config struct {
@@ -122,6 +126,27 @@ type TargetsResponse struct {
 	LastScrape         time.Time           `json:"lastScrape"`
 	LastError          string              `json:"lastError"`
 	LastScrapeDuration string              `json:"lastScrapeDuration"`
+	// Shard is only set if scrape sharding is enabled.
+	Shard *TargetShard `json:"shard,omitempty"`
+	// History of the recent scrapes, only set on request.
+	History []TargetScrape `json:"history,omitempty"`
+}
+
+type TargetShard struct {
+	// Index of the shard the target is assigned to.
+	Index uint64 `json:"index"`
+	// Owned indicates whether the target is scraped by this server.
+	Owned bool `json:"owned"`
+}
+
+type TargetScrape struct {
+	Time        time.Time         `json:"time"`
+	Duration    string            `json:"duration"`
+	Bytes       int64             `json:"bytes"`
+	StatusCode  int               `json:"statusCode,omitempty"`
+	Samples     map[string]uint64 `json:"samples,omitempty"`
+	ScrapeError string            `json:"scrapeError,omitempty"`
+	IngestError string            `json:"ingestError,omitempty"`
 }
 
 func New(c Config) (*Controller, error) {
@@ -166,6 +191,15 @@ func New(c Config) (*Controller, error) {
 	if ctrl.signupDefaultRole, err = model.ParseRole(c.Configuration.Auth.SignupDefaultRole); err != nil {
 		return nil, fmt.Errorf("default signup role is invalid: %w", err)
 	}
+	if c.Configuration.PprofDelta.Enabled {
+		ctrl.pprofDelta, err = pprofconv.NewDeltaTracker(pprofconv.DeltaTrackerConfig{
+			MaxSeries: c.Configuration.PprofDelta.MaxSeries,
+			TTL:       c.Configuration.PprofDelta.TTL,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("pprof delta tracker: %w", err)
+		}
+	}
 
 	return &ctrl, nil
 }
@@ -239,18 +273,8 @@ func (ctrl *Controller) serverMux() (http.Handler, error) {
 		apiRouter.RegisterApplicationHandlers()
 	}
 
-	ingestRouter := r.Path("/ingest").Subrouter()
-	ingestRouter.Use(ctrl.drainMiddleware)
-	if ctrl.config.Auth.Ingestion.Enabled {
-		ingestRouter.Use(
-			ctrl.ingestionAuthMiddleware(),
-			authz.NewAuthorizer(ctrl.log, httputils.NewDefaultHelper(ctrl.log)).RequireOneOf(
-				authz.Role(model.AdminRole),
-				authz.Role(model.AgentRole),
-			))
-	}
-
-	ingestRouter.Methods(http.MethodPost).Handler(ctrl.ingestHandler())
+	ctrl.ingestionRouter(r, "/ingest").Methods(http.MethodPost).Handler(ctrl.ingestHandler())
+	ctrl.ingestionRouter(r, otlpProfilesPath).Methods(http.MethodPost).Handler(ctrl.otlpHandler())
 
 	// Routes not protected with auth. Drained at shutdown.
 	insecureRoutes, err := ctrl.getAuthRoutes()
@@ -367,36 +391,95 @@ func (ctrl *Controller) serverMux() (http.Handler, error) {
 	return r, nil
 }
 
+// activeTargetsHandler responds with the targets scraped by the server.
+// If scrape sharding is enabled, targets of other shards are included
+// on request with the all-shards parameter. Recent scrapes of targets
+// are included on request with the history parameter.
 func (ctrl *Controller) activeTargetsHandler(w http.ResponseWriter, r *http.Request) {
-	targets := ctrl.scrapeManager.TargetsActive()
+	sharding := ctrl.scrapeManager.Sharding()
+	withHistory := r.URL.Query().Get("history") == "true"
 	resp := []TargetsResponse{}
-	for k, v := range targets {
-		for _, t := range v {
-			var lastError string
-			if t.LastError() != nil {
-				lastError = t.LastError().Error()
+	appendTargets := func(targets map[string][]*scrape.Target) {
+		for k, v := range targets {
+			for _, t := range v {
+				var lastError string
+				if t.LastError() != nil {
+					lastError = t.LastError().Error()
+				}
+				x := TargetsResponse{
+					Job:                k,
+					TargetURL:          t.URL().String(),
+					DiscoveredLabels:   t.DiscoveredLabels(),
+					Labels:             t.Labels(),
+					Health:             t.Health(),
+					LastScrape:         t.LastScrape(),
+					LastError:          lastError,
+					LastScrapeDuration: t.LastScrapeDuration().String(),
+				}
+				if sharding.Enabled() {
+					x.Shard = &TargetShard{
+						Index: sharding.Shard(t),
+						Owned: sharding.Owns(t),
+					}
+				}
+				if withHistory {
+					x.History = targetHistory(t)
+				}
+				resp = append(resp, x)
 			}
-			resp = append(resp, TargetsResponse{
-				Job:                k,
-				TargetURL:          t.URL().String(),
-				DiscoveredLabels:   t.DiscoveredLabels(),
-				Labels:             t.Labels(),
-				Health:             t.Health(),
-				LastScrape:         t.LastScrape(),
-				LastError:          lastError,
-				LastScrapeDuration: t.LastScrapeDuration().String(),
-			})
 		}
 	}
+	appendTargets(ctrl.scrapeManager.TargetsActive())
+	if sharding.Enabled() && r.URL.Query().Get("all-shards") == "true" {
+		appendTargets(ctrl.scrapeManager.TargetsOtherShards())
+	}
 	ctrl.httpUtils.WriteResponseJSON(r, w, resp)
 }
 
+func targetHistory(t *scrape.Target) []TargetScrape {
+	results := t.History()
+	history := make([]TargetScrape, 0, len(results))
+	for _, r := range results {
+		x := TargetScrape{
+			Time:       r.Time,
+			Duration:   r.Duration.String(),
+			Bytes:      r.Size,
+			StatusCode: r.StatusCode,
+			Samples:    r.Samples,
+		}
+		if r.ScrapeError != nil {
+			x.ScrapeError = r.ScrapeError.Error()
+		}
+		if r.IngestError != nil {
+			x.IngestError = r.IngestError.Error()
+		}
+		history = append(history, x)
+	}
+	return history
+}
+
 func (ctrl *Controller) exportedMetricsHandler(w http.ResponseWriter, r *http.Request) {
 	promhttp.InstrumentMetricHandler(ctrl.exportedMetrics,
 		promhttp.HandlerFor(ctrl.exportedMetrics, promhttp.HandlerOpts{})).
 		ServeHTTP(w, r)
 }
 
+// ingestionRouter creates a router for the path
+// protected with the ingestion auth, if enabled.
+func (ctrl *Controller) ingestionRouter(r *mux.Router, path string) *mux.Router {
+	ingestRouter := r.Path(path).Subrouter()
+	ingestRouter.Use(ctrl.drainMiddleware)
+	if ctrl.config.Auth.Ingestion.Enabled {
+		ingestRouter.Use(
+			ctrl.ingestionAuthMiddleware(),
+			authz.NewAuthorizer(ctrl.log, httputils.NewDefaultHelper(ctrl.log)).RequireOneOf(
+				authz.Role(model.AdminRole),
+				authz.Role(model.AgentRole),
+			))
+	}
+	return ingestRouter
+}
+
 func (ctrl *Controller) getAuthRoutes() ([]route, error) {
 	authRoutes := []route{
 		{"/login", ctrl.loginHandler},
@@ -486,6 +569,12 @@ func (ctrl *Controller) startSync(serveSync chan struct{}) error {
 		ErrorLog:       golog.New(w, "", 0),
 	}
 
+	if ctrl.config.OTLP.GRPCBindAddr != "" {
+		if err = ctrl.startOTLPServer(); err != nil {
+			return fmt.Errorf("OTLP gRPC server: %w", err)
+		}
+	}
+
 	updates.StartVersionUpdateLoop()
 
 	if serveSync != nil {
@@ -509,6 +598,9 @@ func (ctrl *Controller) startSync(serveSync chan struct{}) error {
 func (ctrl *Controller) Stop() error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
+	if ctrl.grpcServer != nil {
+		ctrl.grpcServer.GracefulStop()
+	}
 	return ctrl.httpServer.Shutdown(ctx)
 }
 
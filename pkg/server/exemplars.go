@@ -16,7 +16,7 @@ func (ctrl *Controller) exemplarsHandler() ExemplarsHandler {
 		ExemplarsGetter:  ctrl.storage,
 		ExemplarsMerger:  ctrl.storage,
 		ExemplarsQuerier: ctrl.storage,
-		HeatmapBuilder:   NoopHeatmapBuilder{},
+		HeatmapBuilder:   heatmap.Builder{},
 	}
 }
 
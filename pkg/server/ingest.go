@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"github.com/go-kit/kit/log/logrus"
 	"io"
@@ -16,11 +17,16 @@ import (
 	"github.com/go-kit/log/level"
 
 	"github.com/pyroscope-io/pyroscope/pkg/agent/types"
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/convert/cpuprofile"
+	"github.com/pyroscope-io/pyroscope/pkg/convert/gecko"
 	"github.com/pyroscope-io/pyroscope/pkg/convert/jfr"
+	"github.com/pyroscope-io/pyroscope/pkg/convert/otlp"
 	"github.com/pyroscope-io/pyroscope/pkg/convert/pprof"
 	"github.com/pyroscope-io/pyroscope/pkg/convert/profile"
 	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
 	"github.com/pyroscope-io/pyroscope/pkg/server/httputils"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
 	"github.com/pyroscope-io/pyroscope/pkg/util/attime"
@@ -29,22 +35,26 @@ import (
 type ingestHandler struct {
 	log       log.Logger
 	ingester  ingestion.Ingester
+	limits    config.IngestLimits
+	delta     *pprof.DeltaTracker
 	onSuccess func(*ingestion.IngestInput)
 	httpUtils httputils.ErrorUtils
 }
 
 func (ctrl *Controller) ingestHandler() http.Handler {
-	return NewIngestHandler(logrus.NewLogger(ctrl.log), ctrl.ingestser, func(pi *ingestion.IngestInput) {
+	return NewIngestHandler(logrus.NewLogger(ctrl.log), ctrl.ingestser, ctrl.config.IngestLimits, ctrl.pprofDelta, func(pi *ingestion.IngestInput) {
 		ctrl.StatsInc("ingest")
 		ctrl.StatsInc("ingest:" + pi.Metadata.SpyName)
 		ctrl.appStats.Add(hashString(pi.Metadata.Key.AppName()))
 	}, ctrl.httpUtils)
 }
 
-func NewIngestHandler(l log.Logger, p ingestion.Ingester, onSuccess func(*ingestion.IngestInput), httpUtils httputils.ErrorUtils) http.Handler {
+func NewIngestHandler(l log.Logger, p ingestion.Ingester, limits config.IngestLimits, delta *pprof.DeltaTracker, onSuccess func(*ingestion.IngestInput), httpUtils httputils.ErrorUtils) http.Handler {
 	return ingestHandler{
 		log:       l,
 		ingester:  p,
+		limits:    limits,
+		delta:     delta,
 		onSuccess: onSuccess,
 		httpUtils: httpUtils,
 	}
@@ -52,7 +62,12 @@ func NewIngestHandler(l log.Logger, p ingestion.Ingester, onSuccess func(*ingest
 
 func (h ingestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	input, err := h.ingestInputFromRequest(r)
-	if err != nil {
+	switch {
+	case err == nil:
+	case errors.Is(err, ingestion.ErrProfileTooLarge):
+		h.httpUtils.WriteError(r, w, http.StatusRequestEntityTooLarge, err, "ingestion request rejected")
+		return
+	default:
 		h.httpUtils.WriteError(r, w, http.StatusBadRequest, err, "invalid parameter")
 		return
 	}
@@ -61,6 +76,12 @@ func (h ingestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	switch {
 	case err == nil:
 		h.onSuccess(input)
+	case errors.Is(err, ingestion.ErrProfileTooLarge):
+		h.httpUtils.WriteError(r, w, http.StatusRequestEntityTooLarge, err, "ingestion request rejected")
+	case storage.IsCardinalityLimitError(err):
+		h.httpUtils.WriteError(r, w, http.StatusUnprocessableEntity, err, "ingestion request rejected")
+	case storage.IsOutOfSpaceError(err):
+		h.httpUtils.WriteError(r, w, http.StatusInsufficientStorage, err, "ingestion request rejected")
 	case ingestion.IsIngestionError(err):
 		h.httpUtils.WriteError(r, w, http.StatusInternalServerError, err, "error happened while ingesting data")
 	default:
@@ -128,11 +149,6 @@ func (h ingestHandler) ingestInputFromRequest(r *http.Request) (*ingestion.Inges
 		input.Metadata.AggregationType = metadata.SumAggregationType
 	}
 
-	b, err := copyBody(r)
-	if err != nil {
-		return nil, err
-	}
-
 	format := q.Get("format")
 	contentType := r.Header.Get("Content-Type")
 	switch {
@@ -144,36 +160,57 @@ func (h ingestHandler) ingestInputFromRequest(r *http.Request) (*ingestion.Inges
 		input.Format = ingestion.FormatTree
 	case format == "lines":
 		input.Format = ingestion.FormatLines
-
 	case format == "jfr":
 		input.Format = ingestion.FormatJFR
+	case format == "pprof":
+		input.Format = ingestion.FormatPprof
+		input.Profile, err = h.loadPprof(r, "")
+	case format == "speedscope":
+		input.Format = ingestion.FormatSpeedscope
+	case format == "cpuprofile":
+		input.Format = ingestion.FormatCPUProfile
+	case format == "gecko":
+		input.Format = ingestion.FormatGecko
+	case format == "otlp":
+		input.Format = ingestion.FormatOTLP
+	case strings.Contains(contentType, "multipart/form-data"):
+		input.Profile, err = h.loadPprof(r, contentType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if input.Profile != nil {
+		// Pprof profiles are read from the body as they are decompressed.
+		return &input, nil
+	}
+
+	b, err := copyBody(r, int64(h.limits.MemoryBudget))
+	if err != nil {
+		return nil, err
+	}
+	switch input.Format {
+	case ingestion.FormatJFR:
 		input.Profile = &jfr.RawProfile{
 			FormDataContentType: contentType,
 			RawData:             b,
 		}
-
-	case format == "pprof":
-		input.Format = ingestion.FormatPprof
-		input.Profile = &pprof.RawProfile{
+	case ingestion.FormatSpeedscope:
+		input.Profile = &speedscope.RawProfile{
 			RawData: b,
 		}
-
-	case format == "speedscope":
-		input.Format = ingestion.FormatSpeedscope
-		input.Profile = &speedscope.RawProfile{
+	case ingestion.FormatCPUProfile:
+		input.Profile = &cpuprofile.RawProfile{
 			RawData: b,
 		}
-
-	case strings.Contains(contentType, "multipart/form-data"):
-		input.Profile = &pprof.RawProfile{
-			FormDataContentType: contentType,
-			RawData:             b,
-			StreamingParser:     true,
-			PoolStreamingParser: true,
+	case ingestion.FormatGecko:
+		input.Profile = &gecko.RawProfile{
+			RawData: b,
 		}
-	}
-
-	if input.Profile == nil {
+	case ingestion.FormatOTLP:
+		input.Profile = &otlp.RawProfile{
+			RawData: b,
+		}
+	default:
 		input.Profile = &profile.RawProfile{
 			Format:  input.Format,
 			RawData: b,
@@ -183,9 +220,48 @@ func (h ingestHandler) ingestInputFromRequest(r *http.Request) (*ingestion.Inges
 	return &input, nil
 }
 
-func copyBody(r *http.Request) ([]byte, error) {
-	buf := bytes.NewBuffer(make([]byte, 0, 64<<10))
-	if _, err := io.Copy(buf, r.Body); err != nil {
+// loadPprof reads the pprof profile, or the multipart form if the content
+// type is given, from the request body. Only the decompressed profiles are
+// kept in memory, within the decompressed size limit and the memory budget.
+func (h ingestHandler) loadPprof(r *http.Request, contentType string) (*pprof.RawProfile, error) {
+	p := &pprof.RawProfile{
+		FormDataContentType: contentType,
+		StreamingParser:     true,
+		PoolStreamingParser: true,
+		MaxDecompressedSize: int64(h.limits.MaxDecompressedSize),
+		MemoryBudget:        int64(h.limits.MemoryBudget),
+		DeltaTracker:        h.delta,
+	}
+	if err := p.Load(r.Body); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// copyBody reads the request body, which is limited to maxSize bytes,
+// unless it is zero. The buffer grows as the body is read, therefore
+// the declared content length does not make it allocate more memory.
+func copyBody(r *http.Request, maxSize int64) ([]byte, error) {
+	if maxSize > 0 && r.ContentLength > maxSize {
+		return nil, fmt.Errorf("%w: request body exceeds %d bytes", ingestion.ErrProfileTooLarge, maxSize)
+	}
+	size := int64(64 << 10)
+	if r.ContentLength >= 0 && r.ContentLength < size {
+		size = r.ContentLength
+	}
+	if maxSize > 0 && maxSize < size {
+		size = maxSize
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+	body := io.Reader(r.Body)
+	if maxSize > 0 {
+		body = http.MaxBytesReader(nil, r.Body, maxSize)
+	}
+	if _, err := buf.ReadFrom(body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, fmt.Errorf("%w: request body exceeds %d bytes", ingestion.ErrProfileTooLarge, maxSize)
+		}
 		return nil, err
 	}
 	return buf.Bytes(), nil
@@ -15,6 +15,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/go-kit/log"
 	"github.com/klauspost/compress/gzip"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -25,7 +26,9 @@ import (
 	"github.com/pyroscope-io/pyroscope/pkg/config"
 	"github.com/pyroscope-io/pyroscope/pkg/exporter"
 	"github.com/pyroscope-io/pyroscope/pkg/health"
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
 	"github.com/pyroscope-io/pyroscope/pkg/parser"
+	"github.com/pyroscope-io/pyroscope/pkg/server/httputils"
 	"github.com/pyroscope-io/pyroscope/pkg/storage"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
 	"github.com/pyroscope-io/pyroscope/pkg/testing"
@@ -421,3 +424,98 @@ var _ = Describe("server", func() {
 		})
 	})
 })
+
+var _ = Describe("ingest limits", func() {
+	var (
+		ingester *mockIngester
+		handler  http.Handler
+	)
+
+	BeforeEach(func() {
+		ingester = new(mockIngester)
+		handler = NewIngestHandler(log.NewNopLogger(), ingester,
+			config.IngestLimits{MemoryBudget: 1 << 10}, nil,
+			func(*ingestion.IngestInput) {},
+			httputils.NewDefaultHelper(logrus.StandardLogger()))
+	})
+
+	post := func(body []byte) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/ingest?name=app&format=pprof", bytes.NewReader(body))
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	compress := func(b []byte) []byte {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		_, _ = gw.Write(b)
+		Expect(gw.Close()).To(Succeed())
+		return buf.Bytes()
+	}
+
+	It("rejects requests exceeding the memory budget", func() {
+		Expect(post(make([]byte, 2<<10))).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(ingester.inputs).To(BeEmpty())
+	})
+
+	It("accepts requests within the memory budget", func() {
+		Expect(post(make([]byte, 1<<9))).To(Equal(http.StatusOK))
+		Expect(ingester.inputs).To(HaveLen(1))
+	})
+
+	It("rejects profiles decompressing beyond the memory budget", func() {
+		body := compress(make([]byte, 2<<10))
+		Expect(len(body)).To(BeNumerically("<", 1<<10))
+		Expect(post(body)).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(ingester.inputs).To(BeEmpty())
+	})
+
+	It("rejects multipart forms exceeding the memory budget", func() {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		for _, name := range []string{"profile", "prev_profile"} {
+			fw, err := mw.CreateFormFile(name, "profile.pprof")
+			Expect(err).ToNot(HaveOccurred())
+			_, _ = fw.Write(compress(make([]byte, 3<<8)))
+		}
+		Expect(mw.Close()).To(Succeed())
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/ingest?name=app", &buf)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		handler.ServeHTTP(w, r)
+		Expect(w.Code).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(ingester.inputs).To(BeEmpty())
+	})
+})
+
+var _ = Describe("ingest cardinality limits", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		It("rejects requests exceeding the limits", func() {
+			(*cfg).Server.CardinalityLimits.ValuesPerLabel = 2
+			storageConfig := storage.NewConfig(&(*cfg).Server)
+			s, err := storage.New(storageConfig, logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller), storage.NoopApplicationMetadataService{})
+			Expect(err).ToNot(HaveOccurred())
+			defer s.Close()
+			q := storage.NewIngestionQueue(logrus.StandardLogger(), s, prometheus.NewRegistry(), storageConfig, nil)
+			defer q.Stop()
+			e, _ := exporter.NewExporter(nil, nil)
+			handler := NewIngestHandler(log.NewNopLogger(), parser.New(logrus.StandardLogger(), q, e),
+				config.IngestLimits{}, nil,
+				func(*ingestion.IngestInput) {},
+				httputils.NewDefaultHelper(logrus.StandardLogger()))
+
+			post := func(name string) int {
+				w := httptest.NewRecorder()
+				u := "/ingest?format=lines&name=" + url.QueryEscape(name)
+				r := httptest.NewRequest(http.MethodPost, u, bytes.NewBufferString("foo;bar\n"))
+				handler.ServeHTTP(w, r)
+				return w.Code
+			}
+
+			Expect(post("app{a=1}")).To(Equal(http.StatusOK))
+			Expect(post("app{a=2}")).To(Equal(http.StatusOK))
+			Expect(post("app{a=3}")).To(Equal(http.StatusUnprocessableEntity))
+		})
+	})
+})
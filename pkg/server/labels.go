@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/pyroscope-io/pyroscope/pkg/flameql"
 	"github.com/pyroscope-io/pyroscope/pkg/server/httputils"
 	"github.com/pyroscope-io/pyroscope/pkg/storage"
 	"github.com/pyroscope-io/pyroscope/pkg/util/attime"
@@ -26,17 +27,26 @@ func NewLabelsHandler(s storage.LabelsGetter, httpUtils httputils.Utils) http.Ha
 
 		keys := make([]string, 0)
 		if in.Query != "" {
+			// Only query parse errors are caused by the request.
+			if _, err := flameql.ParseQuery(in.Query); err != nil {
+				httpUtils.WriteInvalidParameterError(r, w, err)
+				return
+			}
 			output, err := s.GetKeysByQuery(ctx, in)
 			if err != nil {
-				httpUtils.WriteInvalidParameterError(r, w, err)
+				httpUtils.WriteInternalServerError(r, w, err, "failed to retrieve labels")
 				return
 			}
 			keys = append(keys, output.Keys...)
 		} else {
-			s.GetKeys(ctx, func(k string) bool {
+			err := s.GetKeys(ctx, func(k string) bool {
 				keys = append(keys, k)
 				return true
 			})
+			if err != nil {
+				httpUtils.WriteInternalServerError(r, w, err, "failed to retrieve labels")
+				return
+			}
 		}
 
 		b, err := json.Marshal(keys)
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/server/httputils"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+)
+
+type mockLabelsGetter struct{ err error }
+
+func (m mockLabelsGetter) GetKeys(context.Context, func(string) bool) error { return m.err }
+
+func (m mockLabelsGetter) GetKeysByQuery(context.Context, storage.GetLabelKeysByQueryInput) (storage.GetLabelKeysByQueryOutput, error) {
+	return storage.GetLabelKeysByQueryOutput{Keys: []string{"foo"}}, m.err
+}
+
+var _ = Describe("labels handler", func() {
+	get := func(s storage.LabelsGetter, query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/labels?query="+query, nil)
+		NewLabelsHandler(s, httputils.NewDefaultHelper(logrus.StandardLogger())).ServeHTTP(w, r)
+		return w
+	}
+
+	It("returns label keys", func() {
+		w := get(mockLabelsGetter{}, "app.cpu")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal(`["foo"]`))
+	})
+
+	It("rejects invalid queries", func() {
+		Expect(get(mockLabelsGetter{}, "app.cpu%7B").Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("returns storage errors as internal errors", func() {
+		w := get(mockLabelsGetter{err: errors.New("storage error")}, "app.cpu")
+		Expect(w.Code).To(Equal(http.StatusInternalServerError))
+	})
+})
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/pyroscope-io/pyroscope/pkg/flameql"
 	"github.com/pyroscope-io/pyroscope/pkg/server/httputils"
 	"github.com/pyroscope-io/pyroscope/pkg/storage"
 	"github.com/pyroscope-io/pyroscope/pkg/util/attime"
@@ -32,17 +33,26 @@ func NewLabelValuesHandler(s storage.LabelValuesGetter, httpUtils httputils.Util
 
 		values := make([]string, 0)
 		if in.Query != "" {
+			// Only query parse errors are caused by the request.
+			if _, err := flameql.ParseQuery(in.Query); err != nil {
+				httpUtils.WriteInvalidParameterError(r, w, err)
+				return
+			}
 			output, err := s.GetValuesByQuery(ctx, in)
 			if err != nil {
-				httpUtils.WriteInvalidParameterError(r, w, err)
+				httpUtils.WriteInternalServerError(r, w, err, "failed to retrieve label values")
 				return
 			}
 			values = append(values, output.Values...)
 		} else {
-			s.GetValues(ctx, in.Label, func(v string) bool {
+			err := s.GetValues(ctx, in.Label, func(v string) bool {
 				values = append(values, v)
 				return true
 			})
+			if err != nil {
+				httpUtils.WriteInternalServerError(r, w, err, "failed to retrieve label values")
+				return
+			}
 		}
 
 		b, err := json.Marshal(values)
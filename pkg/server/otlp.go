@@ -0,0 +1,216 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log/logrus"
+	"github.com/go-kit/log"
+	collectorv1 "go.opentelemetry.io/proto/otlp/collector/profiles/v1development"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcmetadata "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/pyroscope-io/pyroscope/pkg/agent/types"
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/convert/otlp"
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
+	"github.com/pyroscope-io/pyroscope/pkg/model"
+	"github.com/pyroscope-io/pyroscope/pkg/server/httputils"
+	"github.com/pyroscope-io/pyroscope/pkg/service"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+)
+
+// otlpProfilesPath is the OTLP/HTTP profiles export path.
+const otlpProfilesPath = "/v1development/profiles"
+
+type otlpHandler struct {
+	log       log.Logger
+	ingester  ingestion.Ingester
+	limits    config.IngestLimits
+	onSuccess func(*ingestion.IngestInput)
+	httpUtils httputils.ErrorUtils
+}
+
+func (ctrl *Controller) otlpHandler() http.Handler {
+	return NewOTLPHandler(logrus.NewLogger(ctrl.log), ctrl.ingestser, ctrl.config.IngestLimits, ctrl.onOTLPIngest, ctrl.httpUtils)
+}
+
+// NewOTLPHandler creates a handler of OTLP/HTTP profiles export
+// requests. Only the binary protobuf encoding is supported. The request
+// body size is limited by the ingestion memory budget.
+func NewOTLPHandler(l log.Logger, p ingestion.Ingester, limits config.IngestLimits, onSuccess func(*ingestion.IngestInput), httpUtils httputils.ErrorUtils) http.Handler {
+	return otlpHandler{
+		log:       l,
+		ingester:  p,
+		limits:    limits,
+		onSuccess: onSuccess,
+		httpUtils: httpUtils,
+	}
+}
+
+var errOTLPContentType = errors.New("only " + otlp.ContentType + " content type is supported")
+
+func (h otlpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if ct := r.Header.Get("Content-Type"); !strings.HasPrefix(ct, otlp.ContentType) {
+		h.httpUtils.WriteError(r, w, http.StatusUnsupportedMediaType, errOTLPContentType, "invalid content type")
+		return
+	}
+	b, err := copyBody(r, int64(h.limits.MemoryBudget))
+	switch {
+	case err == nil:
+	case errors.Is(err, ingestion.ErrProfileTooLarge):
+		h.httpUtils.WriteError(r, w, http.StatusRequestEntityTooLarge, err, "ingestion request rejected")
+		return
+	default:
+		h.httpUtils.WriteError(r, w, http.StatusBadRequest, err, "failed to read request body")
+		return
+	}
+	req := new(collectorv1.ExportProfilesServiceRequest)
+	if err = proto.Unmarshal(b, req); err != nil {
+		h.httpUtils.WriteError(r, w, http.StatusBadRequest, err, "invalid export request")
+		return
+	}
+
+	input := newOTLPIngestInput(req, b)
+	err = h.ingester.Ingest(r.Context(), input)
+	switch {
+	case err == nil:
+		h.onSuccess(input)
+	case storage.IsCardinalityLimitError(err):
+		h.httpUtils.WriteError(r, w, http.StatusUnprocessableEntity, err, "ingestion request rejected")
+		return
+	case storage.IsOutOfSpaceError(err):
+		h.httpUtils.WriteError(r, w, http.StatusInsufficientStorage, err, "ingestion request rejected")
+		return
+	case ingestion.IsIngestionError(err):
+		h.httpUtils.WriteError(r, w, http.StatusInternalServerError, err, "error happened while ingesting data")
+		return
+	default:
+		h.httpUtils.WriteError(r, w, http.StatusBadRequest, err, "error happened while parsing request body")
+		return
+	}
+
+	resp, err := proto.Marshal(new(collectorv1.ExportProfilesServiceResponse))
+	if err != nil {
+		h.httpUtils.WriteError(r, w, http.StatusInternalServerError, err, "failed to encode response")
+		return
+	}
+	w.Header().Set("Content-Type", otlp.ContentType)
+	_, _ = w.Write(resp)
+}
+
+// newOTLPIngestInput creates an ingestion input of the export request.
+// The actual application names and labels are taken from the request
+// resources, the key only holds the name used for resources without it.
+func newOTLPIngestInput(req *collectorv1.ExportProfilesServiceRequest, b []byte) *ingestion.IngestInput {
+	now := time.Now()
+	return &ingestion.IngestInput{
+		Format: ingestion.FormatOTLP,
+		Profile: &otlp.RawProfile{
+			RawData: b,
+			Request: req,
+		},
+		Metadata: ingestion.Metadata{
+			StartTime:       now,
+			EndTime:         now,
+			Key:             segment.NewKey(map[string]string{"__name__": otlp.DefaultAppName}),
+			SpyName:         "otlp",
+			SampleRate:      types.DefaultSampleRate,
+			Units:           metadata.SamplesUnits,
+			AggregationType: metadata.SumAggregationType,
+		},
+	}
+}
+
+func (ctrl *Controller) onOTLPIngest(pi *ingestion.IngestInput) {
+	ctrl.StatsInc("ingest")
+	ctrl.StatsInc("ingest:" + pi.Metadata.SpyName)
+}
+
+// otlpProfilesService implements the OTLP gRPC profiles service.
+type otlpProfilesService struct {
+	collectorv1.UnimplementedProfilesServiceServer
+	ctrl *Controller
+}
+
+func (s otlpProfilesService) Export(ctx context.Context, req *collectorv1.ExportProfilesServiceRequest) (*collectorv1.ExportProfilesServiceResponse, error) {
+	if atomic.LoadUint32(&s.ctrl.drained) > 0 {
+		return nil, status.Error(codes.Unavailable, "server is shutting down")
+	}
+	input := newOTLPIngestInput(req, nil)
+	err := s.ctrl.ingestser.Ingest(ctx, input)
+	switch {
+	case err == nil:
+		s.ctrl.onOTLPIngest(input)
+		return new(collectorv1.ExportProfilesServiceResponse), nil
+	case storage.IsCardinalityLimitError(err), storage.IsOutOfSpaceError(err):
+		return nil, status.Error(codes.ResourceExhausted, err.Error())
+	case ingestion.IsIngestionError(err):
+		return nil, status.Error(codes.Internal, err.Error())
+	default:
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+}
+
+// startOTLPServer starts the gRPC server receiving OTLP profiles.
+func (ctrl *Controller) startOTLPServer() error {
+	lis, err := net.Listen("tcp", ctrl.config.OTLP.GRPCBindAddr)
+	if err != nil {
+		return err
+	}
+	var opts []grpc.ServerOption
+	if n := ctrl.config.IngestLimits.MemoryBudget; n > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(int(n)))
+	}
+	if ctrl.config.Auth.Ingestion.Enabled {
+		opts = append(opts, grpc.UnaryInterceptor(ctrl.otlpAuthInterceptor()))
+	}
+	ctrl.grpcServer = grpc.NewServer(opts...)
+	collectorv1.RegisterProfilesServiceServer(ctrl.grpcServer, otlpProfilesService{ctrl: ctrl})
+	go func() {
+		if err := ctrl.grpcServer.Serve(lis); err != nil {
+			ctrl.log.WithError(err).Error("OTLP gRPC server")
+		}
+	}()
+	return nil
+}
+
+// otlpAuthInterceptor authenticates requests with the API key passed
+// in the authorization metadata, as the ingestion HTTP endpoints do.
+func (ctrl *Controller) otlpAuthInterceptor() grpc.UnaryServerInterceptor {
+	as := service.NewCachingAuthService(ctrl.authService, service.CachingAuthServiceConfig{
+		Size: ctrl.config.Auth.Ingestion.CacheSize,
+		TTL:  ctrl.config.Auth.Ingestion.CacheTTL,
+	})
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var token string
+		if md, ok := grpcmetadata.FromIncomingContext(ctx); ok {
+			for _, v := range md.Get("authorization") {
+				if p := strings.SplitN(v, " ", 2); len(p) == 2 && strings.EqualFold(p[0], "bearer") {
+					token = p[1]
+				}
+			}
+		}
+		if token == "" {
+			return nil, status.Error(codes.Unauthenticated, model.ErrCredentialsInvalid.Error())
+		}
+		k, err := as.APIKeyFromToken(ctx, token)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		if k.Role != model.AdminRole && k.Role != model.AgentRole {
+			return nil, status.Error(codes.PermissionDenied, model.ErrPermissionDenied.Error())
+		}
+		return handler(model.WithAPIKey(ctx, k), req)
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/go-kit/log"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	collectorv1 "go.opentelemetry.io/proto/otlp/collector/profiles/v1development"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
+	"github.com/pyroscope-io/pyroscope/pkg/server/httputils"
+	"github.com/sirupsen/logrus"
+)
+
+type mockIngester struct{ inputs []*ingestion.IngestInput }
+
+func (m *mockIngester) Ingest(_ context.Context, in *ingestion.IngestInput) error {
+	m.inputs = append(m.inputs, in)
+	return nil
+}
+
+var _ = Describe("OTLP handler", func() {
+	var (
+		ingester *mockIngester
+		handler  http.Handler
+	)
+
+	BeforeEach(func() {
+		ingester = new(mockIngester)
+		handler = NewOTLPHandler(log.NewNopLogger(), ingester, config.IngestLimits{MemoryBudget: 1 << 20}, func(*ingestion.IngestInput) {},
+			httputils.NewDefaultHelper(logrus.StandardLogger()))
+	})
+
+	post := func(contentType string, body []byte) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, otlpProfilesPath, bytes.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	It("ingests export requests", func() {
+		b, err := proto.Marshal(new(collectorv1.ExportProfilesServiceRequest))
+		Expect(err).ToNot(HaveOccurred())
+		w := post("application/x-protobuf", b)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Type")).To(Equal("application/x-protobuf"))
+		Expect(proto.Unmarshal(w.Body.Bytes(), new(collectorv1.ExportProfilesServiceResponse))).To(Succeed())
+		Expect(ingester.inputs).To(HaveLen(1))
+		Expect(ingester.inputs[0].Format).To(Equal(ingestion.FormatOTLP))
+	})
+
+	It("rejects unsupported content types", func() {
+		Expect(post("application/json", []byte("{}")).Code).To(Equal(http.StatusUnsupportedMediaType))
+		Expect(ingester.inputs).To(BeEmpty())
+	})
+
+	It("rejects malformed requests", func() {
+		Expect(post("application/x-protobuf", []byte{0xff}).Code).To(Equal(http.StatusBadRequest))
+		Expect(ingester.inputs).To(BeEmpty())
+	})
+
+	It("rejects requests exceeding the memory budget", func() {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, otlpProfilesPath, bytes.NewReader(make([]byte, 2<<20)))
+		r.Header.Set("Content-Type", "application/x-protobuf")
+		// The size is only known once the body is read.
+		r.ContentLength = -1
+		handler.ServeHTTP(w, r)
+		Expect(w.Code).To(Equal(http.StatusRequestEntityTooLarge))
+		Expect(ingester.inputs).To(BeEmpty())
+	})
+})
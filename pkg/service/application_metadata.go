@@ -3,7 +3,6 @@ package service
 import (
 	"context"
 	"errors"
-	"net/url"
 
 	"github.com/pyroscope-io/pyroscope/pkg/model"
 	"github.com/pyroscope-io/pyroscope/pkg/model/appmetadata"
@@ -18,34 +17,27 @@ func NewApplicationMetadataService(db *gorm.DB) ApplicationMetadataService {
 	return ApplicationMetadataService{db: db}
 }
 
-func (svc ApplicationMetadataService) List(ctx context.Context) (apps []appmetadata.ApplicationMetadata, err error) {
+// List returns application metadata records matching the filter,
+// most recently updated first.
+func (svc ApplicationMetadataService) List(ctx context.Context, filter appmetadata.Filter) (apps []appmetadata.ApplicationMetadata, err error) {
 	tx := svc.db.WithContext(ctx)
-	query, ok := ctx.Value("query").(url.Values)
-	if ok {
-		if query.Get("projectID") != "" {
-			tx = tx.Where("project_id = ?", query.Get("projectID"))
-		}
-		if query.Get("workspace") != "" {
-			tx = tx.Where("workspace = ?", query.Get("workspace"))
-		}
-		if query.Get("orgID") != "" {
-			tx = tx.Where("org_id = ?", query.Get("orgID"))
-		}
-		if query.Get("appID") != "" {
-			tx = tx.Where("app_id = ?", query.Get("appID"))
-		}
-		if query.Get("podIP") != "" {
-			tx = tx.Where("pod_ip = ?", query.Get("podIP"))
-		}
-		if query.Get("name") != "" {
-			tx = tx.Where("name = ?", query.Get("name"))
-		}
-		if query.Get("updateTime") != "" {
-			tx = tx.Where("updated_at >= ?", query.Get("updateTime"))
+	if filter.Name != "" {
+		tx = tx.Where("name = ?", filter.Name)
+	}
+	if !filter.UpdatedSince.IsZero() {
+		tx = tx.Where("updated_at >= ?", filter.UpdatedSince)
+	}
+	for k, v := range filter.Attributes {
+		pattern, err := appmetadata.LikePattern(k, v)
+		if err != nil {
+			return nil, err
 		}
+		tx = tx.Where("attributes LIKE ? ESCAPE '!'", pattern)
+	}
+	if err = tx.Order("updated_at desc").Find(&apps).Error; err != nil {
+		return nil, err
 	}
-	result := tx.Order("updated_at desc").Find(&apps)
-	return apps, result.Error
+	return apps, nil
 }
 
 func (svc ApplicationMetadataService) Get(ctx context.Context, name string) (appmetadata.ApplicationMetadata, error) {
@@ -55,7 +47,7 @@ func (svc ApplicationMetadataService) Get(ctx context.Context, name string) (app
 	}
 
 	tx := svc.db.WithContext(ctx)
-	res := tx.Where("fq_name = ?", name).First(&app)
+	res := tx.Where("name = ?", name).First(&app)
 
 	switch {
 	case errors.Is(res.Error, gorm.ErrRecordNotFound):
@@ -72,19 +64,11 @@ func (svc ApplicationMetadataService) CreateOrUpdate(ctx context.Context, applic
 
 	tx := svc.db.WithContext(ctx)
 
+	// A record per application instance, identified by the attributes.
 	// Only update the field if it's populated
-	return tx.Where(appmetadata.ApplicationMetadata{
-		FQName:      application.FQName,
-		ProjectID:   application.ProjectID,
-		ProjectName: application.ProjectName,
-		OrgID:       application.OrgID,
-		OrgName:     application.OrgName,
-		Workspace:   application.Workspace,
-		AppID:       application.AppID,
-		SpyName:     application.SpyName,
-		ServiceName: application.ServiceName,
-		PodIP:       application.PodIP,
-	}).Assign(application).FirstOrCreate(&appmetadata.ApplicationMetadata{}).Error
+	return tx.Where("name = ? AND attributes = ?", application.FQName, application.Attributes).
+		Assign(application).
+		FirstOrCreate(&appmetadata.ApplicationMetadata{}).Error
 }
 
 func (svc ApplicationMetadataService) Delete(ctx context.Context, name string) error {
@@ -93,5 +77,5 @@ func (svc ApplicationMetadataService) Delete(ctx context.Context, name string) e
 	}
 
 	tx := svc.db.WithContext(ctx)
-	return tx.Where("fq_name = ?", name).Delete(appmetadata.ApplicationMetadata{}).Error
+	return tx.Where("name = ?", name).Delete(appmetadata.ApplicationMetadata{}).Error
 }
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/pyroscope-io/pyroscope/pkg/model/appmetadata"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
 )
 
 type ApplicationMetadataWriter interface {
@@ -40,7 +41,7 @@ func NewApplicationMetadataCacheService(config ApplicationMetadataCacheServiceCo
 // * data is different from what's in the cache
 // Otherwise it does nothing
 func (svc *ApplicationMetadataCacheService) CreateOrUpdate(ctx context.Context, application appmetadata.ApplicationMetadata) error {
-	key := application.ToSegmentKey().Normalized()
+	key := cacheKey(application)
 	if _, ok := svc.cache.get(key); ok {
 		return nil
 	}
@@ -51,7 +52,7 @@ func (svc *ApplicationMetadataCacheService) CreateOrUpdate(ctx context.Context,
 	return svc.writeToBoth(ctx, application)
 }
 
-func (svc *ApplicationMetadataCacheService) List(ctx context.Context) (apps []appmetadata.ApplicationMetadata, err error) {
+func (svc *ApplicationMetadataCacheService) List(context.Context, appmetadata.Filter) (apps []appmetadata.ApplicationMetadata, err error) {
 	return nil, nil
 }
 
@@ -60,10 +61,20 @@ func (svc *ApplicationMetadataCacheService) writeToBoth(ctx context.Context, app
 	if err := svc.appSvc.CreateOrUpdate(ctx, application); err != nil {
 		return err
 	}
-	svc.cache.put(application.ToSegmentKey().Normalized(), application)
+	svc.cache.put(cacheKey(application), application)
 	return nil
 }
 
+// cacheKey identifies the application instance.
+func cacheKey(application appmetadata.ApplicationMetadata) string {
+	labels := make(map[string]string, len(application.Attributes)+1)
+	for k, v := range application.Attributes {
+		labels[k] = v
+	}
+	labels["__name__"] = application.FQName
+	return segment.NewKey(labels).Normalized()
+}
+
 // isTheSame check if 2 applications have the same data
 // TODO(eh-am): update to a more robust comparison function
 // See https://pkg.go.dev/reflect#DeepEqual for its drawbacks
@@ -2,6 +2,9 @@ package service_test
 
 import (
 	"context"
+	"net/url"
+	"path/filepath"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -9,6 +12,9 @@ import (
 	"github.com/pyroscope-io/pyroscope/pkg/model/appmetadata"
 	"github.com/pyroscope-io/pyroscope/pkg/service"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 var _ = Describe("ApplicationMetadataService", func() {
@@ -30,7 +36,7 @@ var _ = Describe("ApplicationMetadataService", func() {
 	}
 
 	assertNumOfApps := func(num int) []appmetadata.ApplicationMetadata {
-		apps, err := svc.List(context.TODO())
+		apps, err := svc.List(context.TODO(), appmetadata.Filter{})
 		Expect(err).ToNot(HaveOccurred())
 		Expect(len(apps)).To(Equal(num))
 		return apps
@@ -128,4 +134,111 @@ var _ = Describe("ApplicationMetadataService", func() {
 		})
 	})
 
+	Context("attributes", func() {
+		instance := func(workspace, podIP string) appmetadata.ApplicationMetadata {
+			a := app
+			a.Attributes = appmetadata.Attributes{"workspace": workspace, "podIP": podIP}
+			return a
+		}
+
+		It("keeps a record per application instance", func() {
+			ctx := context.TODO()
+			Expect(svc.CreateOrUpdate(ctx, instance("PROD", "10.0.0.1"))).To(Succeed())
+			Expect(svc.CreateOrUpdate(ctx, instance("PROD", "10.0.0.1"))).To(Succeed())
+			Expect(svc.CreateOrUpdate(ctx, instance("PROD", "10.0.0.2"))).To(Succeed())
+			Expect(svc.CreateOrUpdate(ctx, instance("TEST", "10.0.0.3"))).To(Succeed())
+			assertNumOfApps(3)
+		})
+
+		It("lists applications matching the filter", func() {
+			ctx := context.TODO()
+			Expect(svc.CreateOrUpdate(ctx, instance("PROD", "10.0.0.1"))).To(Succeed())
+			Expect(svc.CreateOrUpdate(ctx, instance("TEST", "10.0.0.2"))).To(Succeed())
+			other := instance("PROD", "10.0.0.3")
+			other.FQName = "other"
+			Expect(svc.CreateOrUpdate(ctx, other)).To(Succeed())
+
+			apps, err := svc.List(ctx, appmetadata.ParseFilter(url.Values{"workspace": []string{"PROD"}}))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(apps).To(HaveLen(2))
+
+			apps, err = svc.List(ctx, appmetadata.Filter{
+				Name:       "myapp",
+				Attributes: map[string]string{"workspace": "PROD"},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(apps).To(HaveLen(1))
+			Expect(apps[0].Attributes).To(Equal(appmetadata.Attributes{"workspace": "PROD", "podIP": "10.0.0.1"}))
+
+			apps, err = svc.List(ctx, appmetadata.Filter{Attributes: map[string]string{"workspace": "PR_D"}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(apps).To(BeEmpty())
+
+			apps, err = svc.List(ctx, appmetadata.Filter{Attributes: map[string]string{"podIP": "10.0.0"}})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(apps).To(BeEmpty())
+
+			apps, err = svc.List(ctx, appmetadata.Filter{UpdatedSince: time.Now().Add(time.Hour)})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(apps).To(BeEmpty())
+		})
+
+		It("maps attributes to segment key labels", func() {
+			a := instance("PROD", "10.0.0.1")
+			Expect(a.ToSegmentKey(appmetadata.ErdaLabelMapping).Normalized()).
+				To(Equal("myapp{DICE_WORKSPACE=PROD,POD_IP=10.0.0.1}"))
+			m := appmetadata.NewLabelMapping(map[string]string{"workspace": "env"}, true)
+			Expect(a.ToSegmentKey(m).Normalized()).To(Equal("myapp{env=PROD}"))
+			Expect(m.Attributes(map[string]string{"env": "PROD", "POD_IP": "10.0.0.1"})).
+				To(Equal(appmetadata.Attributes{"workspace": "PROD"}))
+		})
+	})
+})
+
+var _ = Describe("ApplicationMetadata attributes migration", func() {
+	s := new(testSuite)
+	BeforeEach(func() {
+		s.path = filepath.Join(GinkgoT().TempDir(), "pyroscope.sqlite3")
+		// The database created before the application metadata attributes.
+		db, err := gorm.Open(sqlite.Open(s.path), &gorm.Config{Logger: logger.Discard})
+		Expect(err).ToNot(HaveOccurred())
+		for _, stmt := range []string{
+			"CREATE TABLE migrations (id VARCHAR(255) PRIMARY KEY)",
+			"INSERT INTO migrations VALUES ('1638496809'), ('1641917891'), ('1661975049'), ('1663269650'), ('1667213046')",
+			`CREATE TABLE erda_profile_app (id INTEGER PRIMARY KEY, name TEXT NOT NULL, spy_name TEXT, sample_rate INTEGER,
+				units TEXT, aggregation_type TEXT, sample_type TEXT, is_deleted NUMERIC, created_at DATETIME, updated_at DATETIME,
+				deleted_at INTEGER, org_id TEXT, org_name TEXT, project_id TEXT, project_name TEXT, app_id TEXT, app_name TEXT,
+				workspace TEXT, cluster_name TEXT, service_name TEXT, pod_ip TEXT)`,
+			`INSERT INTO erda_profile_app (id, name, spy_name, sample_rate, units, aggregation_type, sample_type, is_deleted,
+				created_at, updated_at, deleted_at, org_id, org_name, project_id, project_name, app_id, app_name, workspace,
+				cluster_name, service_name, pod_ip)
+				VALUES (1, 'myapp.cpu', 'gospy', 100, 'samples', 'sum', 'cpu', 0, '2022-11-01 00:00:00', '2022-11-01 00:00:00',
+				0, '1', 'erda', '2', '', '', '', 'PROD', '', 'api', '10.0.0.1')`,
+		} {
+			Expect(db.Exec(stmt).Error).ToNot(HaveOccurred())
+		}
+		sqlDB, err := db.DB()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sqlDB.Close()).ToNot(HaveOccurred())
+		s.BeforeEach()
+	})
+	AfterEach(s.AfterEach)
+
+	It("moves Erda columns to attributes", func() {
+		svc := service.NewApplicationMetadataService(s.DB())
+		apps, err := svc.List(context.TODO(), appmetadata.Filter{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(apps).To(HaveLen(1))
+		Expect(apps[0].FQName).To(Equal("myapp.cpu"))
+		Expect(apps[0].SpyName).To(Equal("gospy"))
+		Expect(apps[0].Attributes).To(Equal(appmetadata.Attributes{
+			"orgID":       "1",
+			"orgName":     "erda",
+			"projectID":   "2",
+			"workspace":   "PROD",
+			"serviceName": "api",
+			"podIP":       "10.0.0.1",
+		}))
+		Expect(s.DB().Migrator().HasTable("erda_profile_app")).To(BeFalse())
+	})
 })
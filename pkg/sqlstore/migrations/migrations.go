@@ -1,10 +1,10 @@
 package migrations
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/go-gormigrate/gormigrate/v2"
-	"github.com/pyroscope-io/pyroscope/pkg/model/appmetadata"
 	"gorm.io/gorm"
 
 	"github.com/pyroscope-io/pyroscope/pkg/config"
@@ -52,6 +52,7 @@ func Migrate(db *gorm.DB, c *config.Server) error {
 		createAnnotationsTableMigration(),
 		addIndexesUniqueTableMigration(),
 		createApplicationMetadataTableMigration(),
+		migrateApplicationMetadataAttributesMigration(),
 	}).Migrate()
 }
 
@@ -153,25 +154,157 @@ func addIndexesUniqueTableMigration() *gormigrate.Migration {
 	}
 }
 
-func createApplicationMetadataTableMigration() *gormigrate.Migration {
-	//type applicationMetadata struct {
-	//	ID              uint   `gorm:"primarykey"`
-	//	FQName          string `gorm:"uniqueIndex;not null;default:null"`
-	//	SpyName         string
-	//	SampleRate      uint32
-	//	Units           string
-	//	AggregationType string
-	//	CreatedAt       time.Time
-	//	UpdatedAt       time.Time
-	//}
+// erdaApplicationMetadata is the application metadata schema
+// with the attributes of applications deployed by Erda.
+type erdaApplicationMetadata struct {
+	ID              uint   `gorm:"primarykey"`
+	FQName          string `gorm:"column:name;index,unique;not null;default:null"`
+	SpyName         string `gorm:"column:spy_name"`
+	SampleRate      uint32 `gorm:"column:sample_rate"`
+	Units           string `gorm:"column:units"`
+	AggregationType string `gorm:"column:aggregation_type"`
+	SampleType      string `gorm:"column:sample_type"`
+	IsDeleted       bool   `gorm:"column:is_deleted"`
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	DeletedAt       uint64 `gorm:"column:deleted_at"`
+	OrgID           string `gorm:"column:org_id"`
+	OrgName         string `gorm:"column:org_name"`
+	ProjectID       string `gorm:"column:project_id"`
+	ProjectName     string `gorm:"column:project_name"`
+	AppID           string `gorm:"column:app_id"`
+	AppName         string `gorm:"column:app_name"`
+	Workspace       string `gorm:"column:workspace"`
+	ClusterName     string `gorm:"column:cluster_name"`
+	ServiceName     string `gorm:"column:service_name"`
+	PodIP           string `gorm:"column:pod_ip"`
+}
 
+const erdaApplicationMetadataTable = "erda_profile_app"
+
+func createApplicationMetadataTableMigration() *gormigrate.Migration {
 	return &gormigrate.Migration{
 		ID: "1667213046",
 		Migrate: func(tx *gorm.DB) error {
-			return tx.AutoMigrate(&appmetadata.ApplicationMetadata{})
+			return tx.Table(erdaApplicationMetadataTable).AutoMigrate(&erdaApplicationMetadata{})
 		},
 		Rollback: func(tx *gorm.DB) error {
-			return tx.Migrator().DropTable(&appmetadata.ApplicationMetadata{})
+			return tx.Migrator().DropTable(erdaApplicationMetadataTable)
+		},
+	}
+}
+
+// migrateApplicationMetadataAttributesMigration moves application metadata
+// to a table where the Erda specific columns are replaced with generic
+// attributes, stored as a JSON object.
+func migrateApplicationMetadataAttributesMigration() *gormigrate.Migration {
+	type applicationMetadata struct {
+		ID              uint   `gorm:"primarykey"`
+		FQName          string `gorm:"column:name;index;not null;default:null"`
+		SpyName         string `gorm:"column:spy_name"`
+		SampleRate      uint32 `gorm:"column:sample_rate"`
+		Units           string `gorm:"column:units"`
+		AggregationType string `gorm:"column:aggregation_type"`
+		SampleType      string `gorm:"column:sample_type"`
+		IsDeleted       bool   `gorm:"column:is_deleted"`
+		CreatedAt       time.Time
+		UpdatedAt       time.Time
+		DeletedAt       uint64 `gorm:"column:deleted_at"`
+		Attributes      string `gorm:"column:attributes;type:text;not null;default:'{}'"`
+	}
+
+	const table = "application_metadata"
+	// Attribute names match the former JSON field names.
+	attributes := func(a *erdaApplicationMetadata) map[string]*string {
+		return map[string]*string{
+			"orgID":       &a.OrgID,
+			"orgName":     &a.OrgName,
+			"projectID":   &a.ProjectID,
+			"projectName": &a.ProjectName,
+			"appID":       &a.AppID,
+			"appName":     &a.AppName,
+			"workspace":   &a.Workspace,
+			"clusterName": &a.ClusterName,
+			"serviceName": &a.ServiceName,
+			"podIP":       &a.PodIP,
+		}
+	}
+
+	return &gormigrate.Migration{
+		ID: "1792331540",
+		Migrate: func(tx *gorm.DB) error {
+			if err := tx.Table(table).AutoMigrate(&applicationMetadata{}); err != nil {
+				return err
+			}
+			var apps []erdaApplicationMetadata
+			if err := tx.Table(erdaApplicationMetadataTable).Find(&apps).Error; err != nil {
+				return err
+			}
+			for _, a := range apps {
+				attrs := make(map[string]string)
+				for k, v := range attributes(&a) {
+					if *v != "" {
+						attrs[k] = *v
+					}
+				}
+				b, err := json.Marshal(attrs)
+				if err != nil {
+					return err
+				}
+				err = tx.Table(table).Create(&applicationMetadata{
+					ID:              a.ID,
+					FQName:          a.FQName,
+					SpyName:         a.SpyName,
+					SampleRate:      a.SampleRate,
+					Units:           a.Units,
+					AggregationType: a.AggregationType,
+					SampleType:      a.SampleType,
+					IsDeleted:       a.IsDeleted,
+					CreatedAt:       a.CreatedAt,
+					UpdatedAt:       a.UpdatedAt,
+					DeletedAt:       a.DeletedAt,
+					Attributes:      string(b),
+				}).Error
+				if err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropTable(erdaApplicationMetadataTable)
+		},
+		Rollback: func(tx *gorm.DB) error {
+			if err := tx.Table(erdaApplicationMetadataTable).AutoMigrate(&erdaApplicationMetadata{}); err != nil {
+				return err
+			}
+			var apps []applicationMetadata
+			if err := tx.Table(table).Find(&apps).Error; err != nil {
+				return err
+			}
+			for _, a := range apps {
+				var attrs map[string]string
+				if err := json.Unmarshal([]byte(a.Attributes), &attrs); err != nil {
+					return err
+				}
+				x := erdaApplicationMetadata{
+					ID:              a.ID,
+					FQName:          a.FQName,
+					SpyName:         a.SpyName,
+					SampleRate:      a.SampleRate,
+					Units:           a.Units,
+					AggregationType: a.AggregationType,
+					SampleType:      a.SampleType,
+					IsDeleted:       a.IsDeleted,
+					CreatedAt:       a.CreatedAt,
+					UpdatedAt:       a.UpdatedAt,
+					DeletedAt:       a.DeletedAt,
+				}
+				for k, v := range attributes(&x) {
+					*v = attrs[k]
+				}
+				if err := tx.Table(erdaApplicationMetadataTable).Create(&x).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropTable(table)
 		},
 	}
 }
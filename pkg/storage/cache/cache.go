@@ -5,57 +5,52 @@ import (
 	"context"
 	"fmt"
 	"io"
-	"log"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/ClickHouse/clickhouse-go/v2"
-	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/types"
 	"github.com/sirupsen/logrus"
-	"github.com/valyala/bytebufferpool"
 
 	"github.com/pyroscope-io/pyroscope/pkg/storage/cache/lfu"
 )
 
+// defaultBatchSize is the max number of items written to the DB at once.
+const defaultBatchSize = 1 << 10 // 1K items
+
 type Cache struct {
-	ch      types.ClickHouseDB
+	db      types.DB
 	lfu     *lfu.Cache
+	writer  *writer
 	metrics *Metrics
 	codec   Codec
 
 	prefix string
 	ttl    time.Duration
 
-	evictionsDone chan struct{}
-	writeBackDone chan struct{}
-	flushOnce     sync.Once
+	flushOnce sync.Once
 }
 
-type ClickHouseConfig struct {
-	types.ClickHouseDB
+type Config struct {
+	DB types.DB
 	*Metrics
 	Codec
 
-	// Prefix for badger DB keys.
+	// Prefix for DB keys.
 	Prefix string
 	// TTL specifies number of seconds an item can reside in cache after
 	// the last access. An obsolete item is evicted. Setting TTL to less
 	// than a second disables time-based eviction.
 	TTL time.Duration
-}
 
-// ClickHouseCodec is a shorthand of coder-decoder. A Codec implementation
-// is responsible for type conversions and binary representation.
-type ClickHouseCodec interface {
-	Serialize(w io.Writer, key string, value interface{}) error
-	Deserialize(r io.Reader, key string) (interface{}, error)
-	// New returns a new instance of the type. The method is
-	// called by GetOrCreate when an item can not be found by
-	// the given key.
-	New(key string) interface{}
+	// Modified items are written to the DB asynchronously, in batches
+	// of WriteBatchSize items, at least once per WriteInterval. Writes
+	// block once there are MaxPending items not yet written.
+	WriteBatchSize int
+	WriteInterval  time.Duration
+	MaxPending     int
 }
 
 // Codec is a shorthand of coder-decoder. A Codec implementation
@@ -80,116 +75,92 @@ type Metrics struct {
 	EvictionsDuration prometheus.Observer
 }
 
-func NewClickHouse(c ClickHouseConfig) *Cache {
+func New(c Config) *Cache {
 	cache := &Cache{
-		lfu:           lfu.New(),
-		ch:            c.ClickHouseDB,
-		codec:         c.Codec,
-		metrics:       c.Metrics,
-		prefix:        c.Prefix,
-		ttl:           c.TTL,
-		evictionsDone: make(chan struct{}),
-		writeBackDone: make(chan struct{}),
+		lfu:     lfu.New(),
+		db:      c.DB,
+		codec:   c.Codec,
+		metrics: c.Metrics,
+		prefix:  c.Prefix,
+		ttl:     c.TTL,
 	}
-
-	evictionChannel := make(chan lfu.Eviction)
-	writeBackChannel := make(chan lfu.Eviction)
-
-	// eviction channel for saving cache items to disk
-	cache.lfu.EvictionChannel = evictionChannel
-	cache.lfu.WriteBackChannel = writeBackChannel
+	// Modified items are buffered by the writer when they are put to the
+	// cache, and serialized once they are written: the LFU cache only keeps
+	// the most used items and can drop any of them at any time, as the
+	// writer persists modifications.
+	cache.writer = newWriter(c.DB, cache.encode, c.WriteBatchSize, c.MaxPending, c.WriteInterval)
 	cache.lfu.TTL = int64(c.TTL.Seconds())
-
-	// start a goroutine for saving the evicted cache items to disk
-	go func() {
-		for e := range evictionChannel {
-			// TODO(kolesnikovae): these errors should be at least logged.
-			//  Perhaps, it will be better if we move it outside of the cache.
-			//  Taking into account that writes almost always happen in batches,
-			//  We should definitely take advantage of BadgerDB write batch API.
-			//  Also, WriteBack and Evict could be combined. We also could
-			//  consider moving caching to storage/db.
-			//if err := cache.saveToDisk(e.Key, e.Value); err != nil {
-			//	log.Println("error saving to disk:", err)
-			//}
-			log.Println("evicting", e.Key)
-		}
-		close(cache.evictionsDone)
-	}()
-
-	// start a goroutine for saving the evicted cache items to disk
-	go func() {
-		for e := range writeBackChannel {
-			if err := cache.saveToDisk(e.Key, e.Value); err != nil {
-				log.Println("error write back to disk:", err)
-			}
-		}
-		close(cache.writeBackDone)
-	}()
-
 	return cache
 }
 
-func (cache *Cache) Put(key string, val interface{}) {
-	if err := cache.saveToDisk(key, val); err != nil {
-		logrus.Errorf("error saving to disk, key: %s, err: %v", key, err)
+// Put puts the value to the cache and buffers it to be written to the DB.
+// The call fails, if the value can't be buffered because the buffer is full
+// and the DB does not accept writes.
+func (cache *Cache) Put(key string, val interface{}) error {
+	k := cache.prefix + key
+	if err := cache.writer.add(rowKey{key: k}, entry{key: key, value: val, t: time.Now()}); err != nil {
+		return err
 	}
 	cache.lfu.Set(key, val)
+	return nil
 }
 
-func (cache *Cache) PutWithTime(key string, val interface{}, t time.Time) {
-	if err := cache.saveToDiskWithTime(key, val, t); err != nil {
-		logrus.Errorf("error saving to disk, key: %s, err: %v", key, err)
+func (cache *Cache) PutWithTime(key string, val interface{}, t time.Time) error {
+	k := cache.prefix + key
+	if err := cache.writer.add(versionRowKey(k, t), entry{key: key, value: val, t: t}); err != nil {
+		return err
 	}
-	cache.lfu.Set(key+fmt.Sprintf(":%d", t.Unix()), val)
+	cache.lfu.Set(versionKey(key, t), val)
+	return nil
 }
 
-func (cache *Cache) saveToDisk(key string, val interface{}) error {
-	b := bytebufferpool.Get()
-	defer bytebufferpool.Put(b)
-	if err := cache.codec.Serialize(b, key, val); err != nil {
-		return fmt.Errorf("serialization: %w", err)
-	}
-	cache.metrics.DBWrites.Observe(float64(b.Len()))
-	return cache.ch.Update(func(conn clickhouse.Conn) error {
-		return conn.Exec(context.Background(), "insert into "+cache.ch.FQDN()+" values (?, ?, ?)", cache.prefix+key, b.String(), time.Now())
-	})
+// versionKey returns the cache key of the value version at time t.
+func versionKey(key string, t time.Time) string {
+	return key + ":" + strconv.FormatInt(t.Unix(), 10)
 }
 
-func (cache *Cache) saveToDiskWithTime(key string, val interface{}, t time.Time) error {
-	b := bytebufferpool.Get()
-	defer bytebufferpool.Put(b)
-	if err := cache.codec.SerializeWithTime(b, key, val, t); err != nil {
-		return fmt.Errorf("serialization: %w", err)
+// encode serializes the value to be written to the DB.
+func (cache *Cache) encode(k rowKey, e entry) ([]byte, error) {
+	var b bytes.Buffer
+	var err error
+	if k.versioned {
+		err = cache.codec.SerializeWithTime(&b, e.key, e.value, e.t)
+	} else {
+		err = cache.codec.Serialize(&b, e.key, e.value)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("serialization: %w", err)
 	}
 	cache.metrics.DBWrites.Observe(float64(b.Len()))
-	return cache.ch.Update(func(conn clickhouse.Conn) error {
-		return conn.Exec(context.Background(), "insert into "+cache.ch.FQDN()+" values (?, ?, ?)", cache.prefix+key, b.String(), t)
-	})
+	return b.Bytes(), nil
 }
 
+func versionRowKey(key string, t time.Time) rowKey {
+	return rowKey{key: key, t: t.UnixNano(), versioned: true}
+}
+
+// Sync writes all the modified items to the DB in batches.
 func (cache *Cache) Sync() error {
-	return cache.lfu.Iterate(func(k string, v interface{}) error {
-		return cache.saveToDisk(k, v)
-	})
+	return cache.writer.flush()
 }
 
+// Flush writes all the modified items to the DB and empties the cache.
+// Items put to the cache after the call are written to the DB immediately.
 func (cache *Cache) Flush() {
 	cache.flushOnce.Do(func() {
-		// Make sure there is no pending items.
-		close(cache.lfu.WriteBackChannel)
-		<-cache.writeBackDone
-		// evict all the items in cache
+		if err := cache.writer.close(); err != nil {
+			logrus.WithError(err).Error("failed to write cache items to the DB")
+		}
 		cache.lfu.Evict(cache.lfu.Len())
-		close(cache.lfu.EvictionChannel)
-		// wait until all evictions are done
-		<-cache.evictionsDone
 	})
 }
 
 // Evict performs cache evictions. The difference between Evict and WriteBack is that evictions happen when cache grows
 // above allowed threshold and write-back calls happen constantly, making pyroscope more crash-resilient.
 // See https://github.com/pyroscope-io/pyroscope/issues/210 for more context
+//
+// Evicted items are not lost: all the modifications are buffered by the writer
+// until they are written to the DB, and are served from there in the meantime.
 func (cache *Cache) Evict(percent float64) {
 	timer := prometheus.NewTimer(prometheus.ObserverFunc(cache.metrics.EvictionsDuration.Observe))
 	cache.lfu.Evict(int(float64(cache.lfu.Len()) * percent))
@@ -198,14 +169,35 @@ func (cache *Cache) Evict(percent float64) {
 
 func (cache *Cache) WriteBack() {
 	timer := prometheus.NewTimer(prometheus.ObserverFunc(cache.metrics.WriteBackDuration.Observe))
+	if err := cache.writer.flush(); err != nil {
+		logrus.WithError(err).Error("failed to write cache items to the DB")
+	}
 	cache.lfu.WriteBack()
 	timer.ObserveDuration()
 }
 
 func (cache *Cache) Delete(key string) error {
 	cache.lfu.Delete(key)
-	return cache.ch.Update(func(conn clickhouse.Conn) error {
-		return conn.Exec(context.Background(), "delete from "+cache.ch.FQDN()+" where key = ?", cache.prefix+key)
+	k := cache.prefix + key
+	return cache.writer.delete(matchKey(k), func() error {
+		return cache.db.Delete(context.Background(), k)
+	})
+}
+
+// DeleteAt removes the versions of the key with the given timestamps
+// both from the cache and the DB.
+func (cache *Cache) DeleteAt(key string, ts ...time.Time) error {
+	versions := make(map[rowKey]struct{}, len(ts))
+	k := cache.prefix + key
+	for _, t := range ts {
+		cache.lfu.Delete(versionKey(key, t))
+		versions[versionRowKey(k, t)] = struct{}{}
+	}
+	return cache.writer.delete(func(r rowKey) bool {
+		_, ok := versions[r]
+		return ok
+	}, func() error {
+		return cache.db.DeleteAt(context.Background(), k, ts...)
 	})
 }
 
@@ -217,24 +209,10 @@ func (cache *Cache) Discard(key string) {
 // In both cache and database
 func (cache *Cache) DiscardPrefix(prefix string) error {
 	cache.lfu.DeletePrefix(prefix)
-	return cache.dropPrefixCH(cache.prefix + prefix)
-}
-
-func (cache *Cache) dropPrefixCH(prefix string) error {
-	var err error
-	for more := true; more; {
-		if more, err = cache.dropPrefixBatchCH(prefix); err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func (cache *Cache) dropPrefixBatchCH(prefix string) (bool, error) {
-	err := cache.ch.Update(func(conn clickhouse.Conn) error {
-		return conn.Exec(context.Background(), "delete from "+cache.ch.FQDN()+" where key like '?%'", prefix)
+	p := cache.prefix + prefix
+	return cache.writer.delete(matchPrefix(p), func() error {
+		return cache.db.DeletePrefix(context.Background(), p)
 	})
-	return false, err
 }
 
 func (cache *Cache) GetOrCreate(key string) (interface{}, error) {
@@ -255,105 +233,70 @@ func (cache *Cache) LookupWithTime(key string, t time.Time) (interface{}, bool)
 	return v, v != nil && err == nil
 }
 
-func (cache *Cache) LookupWithTimeLimit(key string, st, et time.Time, limit int) ([]interface{}, error) {
-	var rows driver.Rows
-	var err error
-	//interval := (et.Unix() - st.Unix()) / int64(limit)
-	err = cache.ch.View(func(conn clickhouse.Conn) error {
-		rows, err = conn.Query(context.Background(), "select k, v, timestamp from "+cache.ch.FQDN()+"_all where k = ? and timestamp >= ? and timestamp <= ? order by timestamp asc", cache.prefix+key, st, et)
-		return err
-	})
-	if err != nil {
+func (cache *Cache) LookupWithTimeLimit(key string, st, et time.Time, _ int) ([]interface{}, error) {
+	if err := cache.writer.sync(cache.prefix + key); err != nil {
 		return nil, err
 	}
 	res := make([]interface{}, 0)
-	for rows.Next() {
-		var row TableRow
-		if err := rows.ScanStruct(&row); err != nil {
-			return nil, err
-		}
-		var val interface{}
-		val, err = cache.codec.Deserialize(bytes.NewBufferString(row.V), strings.TrimPrefix(row.K, cache.prefix)+":"+fmt.Sprintf("%d", row.Timestamp.Unix()))
+	err := cache.db.Range(context.Background(), []string{cache.prefix + key}, st, et, 0, func(row types.Row) error {
+		val, err := cache.lookupRow(row)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		res = append(res, val)
-		cache.lfu.Set(strings.TrimPrefix(row.K, cache.prefix)+":"+fmt.Sprintf("%d", row.Timestamp.Unix()), val)
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return res, nil
 }
 
-func (cache *Cache) LookupByKeys(keys []string, st, et time.Time, limit int) (map[string][]interface{}, error) {
-	var rows driver.Rows
-	var err error
+// Version is a value of the key at the given point in time.
+type Version struct {
+	Time  time.Time
+	Value interface{}
+}
+
+// LookupByKeys loads versions of the keys within the given time range. If limit
+// is positive, the time range is divided into limit intervals, and only the
+// first version of every key within an interval is returned.
+func (cache *Cache) LookupByKeys(keys []string, st, et time.Time, limit int) (map[string][]Version, error) {
 	keysWithPrefix := make([]string, 0, len(keys))
 	for _, k := range keys {
 		keysWithPrefix = append(keysWithPrefix, cache.prefix+k)
 	}
-	interval := (et.Unix() - st.Unix()) / int64(limit)
-	err = cache.ch.View(func(conn clickhouse.Conn) error {
-		rows, err = conn.Query(context.Background(), "select first_value(k) as firstk, first_value(v) as v, toStartOfInterval(timestamp, INTERVAL "+fmt.Sprintf("%d", interval)+" second) as timestamp from "+cache.ch.FQDN()+"_all where k in ? and timestamp >= ? and timestamp <= ? group by k, timestamp order by timestamp asc", keysWithPrefix, st, et)
-		return err
-	})
-	if err != nil {
+	// Rows not yet written would be missing in the range.
+	if err := cache.writer.sync(keysWithPrefix...); err != nil {
 		return nil, err
 	}
-	res := make(map[string][]interface{}, 0)
-	for rows.Next() {
-		var row TableRow
-		if err := rows.ScanStruct(&row); err != nil {
-			return nil, err
-		}
-		var val interface{}
-		val, err = cache.codec.Deserialize(bytes.NewBufferString(row.V), strings.TrimPrefix(row.FirstK, cache.prefix)+":"+fmt.Sprintf("%d", row.Timestamp.Unix()))
+	var step time.Duration
+	if limit > 0 {
+		step = et.Sub(st) / time.Duration(limit)
+	}
+	res := make(map[string][]Version)
+	err := cache.db.Range(context.Background(), keysWithPrefix, st, et, step, func(row types.Row) error {
+		val, err := cache.lookupRow(row)
 		if err != nil {
-			return nil, err
-		}
-		k := strings.TrimPrefix(row.FirstK, cache.prefix)
-		if _, ok := res[k]; !ok {
-			res[k] = make([]interface{}, 0)
+			return err
 		}
-		res[k] = append(res[k], val)
-		cache.lfu.Set(k+":"+fmt.Sprintf("%d", row.Timestamp.Unix()), val)
+		k := strings.TrimPrefix(row.Key, cache.prefix)
+		res[k] = append(res[k], Version{Time: row.Timestamp, Value: val})
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 	return res, nil
 }
 
-type TableRow struct {
-	FirstK    string    `db:"firstk" ch:"firstk"`
-	K         string    `db:"k" ch:"k"`
-	V         string    `db:"v" ch:"v"`
-	Timestamp time.Time `db:"timestamp" ch:"timestamp"`
-}
-
-func (cache *Cache) iterate(key string, createNotFound bool) (interface{}, error) {
-	cache.metrics.ReadsCounter.Inc()
-	return cache.lfu.GetOrSet(key, func() (interface{}, error) {
-		cache.metrics.MissesCounter.Inc()
-		var rows driver.Rows
-		var err error
-		err = cache.ch.View(func(conn clickhouse.Conn) error {
-			rows, err = conn.Query(context.Background(), "select k, v, max(timestamp) as timestamp from "+cache.ch.FQDN()+"_all where k = ? group by (k,v) limit 1", cache.prefix+key)
-			return err
-		})
-		if err != nil {
-			return nil, err
-		}
-		defer rows.Close()
-		recordNotFound := true
-		var row TableRow
-		if rows.Next() {
-			recordNotFound = false
-			if err := rows.ScanStruct(&row); err != nil {
-				return nil, err
-			}
-		}
-		if recordNotFound && createNotFound {
-			return cache.codec.New(key), nil
-		}
-
-		cache.metrics.DBReads.Observe(float64(len(row.V)))
-		return cache.codec.Deserialize(bytes.NewBufferString(row.V), key)
+// lookupRow returns the cached value of the row version, if any: the row may
+// be stale, if the value has been modified but not yet written to the DB.
+func (cache *Cache) lookupRow(row types.Row) (interface{}, error) {
+	key := strings.TrimPrefix(row.Key, cache.prefix)
+	return cache.lfu.GetOrSet(versionKey(key, row.Timestamp), func() (interface{}, error) {
+		cache.metrics.DBReads.Observe(float64(len(row.Value)))
+		return cache.codec.DeserializeWithTime(bytes.NewReader(row.Value), key, row.Timestamp)
 	})
 }
 
@@ -365,54 +308,40 @@ func (cache *Cache) get(key string, createNotFound bool) (interface{}, error) {
 	cache.metrics.ReadsCounter.Inc()
 	return cache.lfu.GetOrSet(key, func() (interface{}, error) {
 		cache.metrics.MissesCounter.Inc()
-		var row TableRow
-		var buf []byte
-		err := cache.ch.View(func(conn clickhouse.Conn) error {
-			if err := conn.QueryRow(context.Background(), "select k, v, max(timestamp) as timestamp from "+cache.ch.FQDN()+"_all where k = ? group by (k,v) limit 1", cache.prefix+key).ScanStruct(&row); err != nil {
-				return err
-			}
-			buf = []byte(row.V)
-			return nil
-		})
+		if v, ok := cache.writer.lookup(rowKey{key: cache.prefix + key}); ok {
+			return v, nil
+		}
+		row, ok, err := cache.db.Get(context.Background(), cache.prefix+key)
 		switch {
-		default:
-			return nil, err
-		case err == nil:
 		case err != nil:
-			if createNotFound {
-				return cache.codec.New(key), nil
-			}
+			return nil, err
+		case !ok && createNotFound:
+			return cache.codec.New(key), nil
+		case !ok:
 			return nil, nil
 		}
-		cache.metrics.DBReads.Observe(float64(len(buf)))
-		return cache.codec.Deserialize(bytes.NewReader(buf), key)
+		cache.metrics.DBReads.Observe(float64(len(row.Value)))
+		return cache.codec.Deserialize(bytes.NewReader(row.Value), key)
 	})
 }
 
 func (cache *Cache) getWithTime(key string, t time.Time, createNotFound bool) (interface{}, error) {
-	return cache.lfu.GetOrSet(key+fmt.Sprintf(":%d", t.Unix()), func() (interface{}, error) {
+	return cache.lfu.GetOrSet(versionKey(key, t), func() (interface{}, error) {
 		cache.metrics.MissesCounter.Inc()
-		var row TableRow
-		var buf []byte
-		err := cache.ch.View(func(conn clickhouse.Conn) error {
-			if err := conn.QueryRow(context.Background(), "select k, v, timestamp from "+cache.ch.FQDN()+"_all where k = ? and timestamp= ? limit 1", cache.prefix+key, t).ScanStruct(&row); err != nil {
-				return err
-			}
-			buf = []byte(row.V)
-			return nil
-		})
+		if v, ok := cache.writer.lookup(versionRowKey(cache.prefix+key, t)); ok {
+			return v, nil
+		}
+		row, ok, err := cache.db.GetAt(context.Background(), cache.prefix+key, t)
 		switch {
-		default:
-			return nil, err
-		case err == nil:
 		case err != nil:
-			if createNotFound {
-				return cache.codec.New(key), nil
-			}
+			return nil, err
+		case !ok && createNotFound:
+			return cache.codec.New(key), nil
+		case !ok:
 			return nil, nil
 		}
-		cache.metrics.DBReads.Observe(float64(len(buf)))
-		return cache.codec.DeserializeWithTime(bytes.NewReader(buf), key, t)
+		cache.metrics.DBReads.Observe(float64(len(row.Value)))
+		return cache.codec.DeserializeWithTime(bytes.NewReader(row.Value), key, t)
 	})
 }
 
@@ -4,20 +4,22 @@
 package cache
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"os"
 	"path/filepath"
 	"strconv"
+	"sync/atomic"
 	"time"
 
-	"github.com/dgraph-io/badger/v2"
-	"github.com/dgraph-io/badger/v2/options"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/embedded"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/types"
 	"github.com/pyroscope-io/pyroscope/pkg/testing"
 )
 
@@ -51,16 +53,9 @@ var _ = Describe("cache", func() {
 	var c *Cache
 	testing.WithConfig(func(cfg **config.Config) {
 		JustBeforeEach(func() {
-			badgerPath := filepath.Join((*cfg).Server.StoragePath)
-			err := os.MkdirAll(badgerPath, 0o755)
-			Expect(err).ToNot(HaveOccurred())
-
-			badgerOptions := badger.DefaultOptions(badgerPath)
-			badgerOptions = badgerOptions.WithTruncate(false)
-			badgerOptions = badgerOptions.WithSyncWrites(false)
-			badgerOptions = badgerOptions.WithCompression(options.ZSTD)
-
-			db, err := badger.Open(badgerOptions)
+			db, err := embedded.Open(embedded.Options{
+				Path: filepath.Join((*cfg).Server.StoragePath),
+			})
 			Expect(err).ToNot(HaveOccurred())
 
 			reg := prometheus.NewRegistry()
@@ -149,3 +144,232 @@ var _ = Describe("cache", func() {
 		})
 	})
 })
+
+// countingCodec counts serializations.
+type countingCodec struct {
+	fakeCodec
+	n *int32
+}
+
+func (c countingCodec) Serialize(w io.Writer, k string, v interface{}) error {
+	atomic.AddInt32(c.n, 1)
+	return c.fakeCodec.Serialize(w, k, v)
+}
+
+// failingDB fails to send write batches once sends reaches zero.
+type failingDB struct {
+	*embedded.DB
+	sends *int32
+}
+
+func (d failingDB) NewWriteBatch(ctx context.Context) (types.WriteBatch, error) {
+	b, err := d.DB.NewWriteBatch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return failingBatch{WriteBatch: b, sends: d.sends}, nil
+}
+
+type failingBatch struct {
+	types.WriteBatch
+	sends *int32
+}
+
+func (b failingBatch) Send() error {
+	if atomic.AddInt32(b.sends, -1) < 0 {
+		_ = b.WriteBatch.Abort()
+		return errors.New("send failed")
+	}
+	return b.WriteBatch.Send()
+}
+
+var _ = Describe("cache write-behind", func() {
+	var (
+		c   *Cache
+		db  *embedded.DB
+		cfg Config
+	)
+
+	BeforeEach(func() {
+		var err error
+		db, err = embedded.Open(embedded.Options{InMemory: true})
+		Expect(err).ToNot(HaveOccurred())
+		reg := prometheus.NewRegistry()
+		cfg = Config{
+			DB:            db,
+			Codec:         fakeCodec{},
+			Prefix:        "p:",
+			WriteInterval: time.Hour,
+			Metrics: &Metrics{
+				MissesCounter: promauto.With(reg).NewCounter(prometheus.CounterOpts{Name: "cache_test_miss"}),
+				ReadsCounter:  promauto.With(reg).NewCounter(prometheus.CounterOpts{Name: "cache_test_read"}),
+				DBWrites:      promauto.With(reg).NewHistogram(prometheus.HistogramOpts{Name: "cache_test_write"}),
+				DBReads:       promauto.With(reg).NewHistogram(prometheus.HistogramOpts{Name: "cache_test_reads"}),
+
+				EvictionsDuration: promauto.With(reg).NewSummary(prometheus.SummaryOpts{Name: "cache_test_evictions"}),
+				WriteBackDuration: promauto.With(reg).NewSummary(prometheus.SummaryOpts{Name: "cache_test_writeback"}),
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		c = New(cfg)
+	})
+
+	AfterEach(func() {
+		c.Flush()
+		Expect(db.Close()).ToNot(HaveOccurred())
+	})
+
+	stored := func(k string) string {
+		row, ok, err := db.Get(context.Background(), "p:"+k)
+		Expect(err).ToNot(HaveOccurred())
+		if !ok {
+			return ""
+		}
+		return string(row.Value)
+	}
+
+	It("coalesces writes until synced", func() {
+		t := testing.SimpleTime(10)
+		c.Put("foo", "1")
+		c.Put("foo", "2")
+		c.PutWithTime("bar", "1", t)
+		c.PutWithTime("bar", "2", t)
+		Expect(stored("foo")).To(BeEmpty())
+
+		c.Evict(1)
+		v, err := c.GetOrCreate("foo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(v).To(Equal("2"))
+		v, err = c.GetOrCreateWithTime("bar", t)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(v).To(Equal("2"))
+
+		Expect(c.Sync()).ToNot(HaveOccurred())
+		Expect(stored("foo")).To(Equal("2"))
+		versions, err := c.LookupByKeys([]string{"bar"}, t, t, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(versions["bar"]).To(HaveLen(1))
+		Expect(versions["bar"][0].Value).To(Equal("2"))
+	})
+
+	Context("when items are modified", func() {
+		var n int32
+		BeforeEach(func() {
+			n = 0
+			cfg.Codec = countingCodec{n: &n}
+		})
+
+		It("serializes them once they are written", func() {
+			for i := 0; i < 10; i++ {
+				c.Put("foo", strconv.Itoa(i))
+			}
+			Expect(atomic.LoadInt32(&n)).To(BeZero())
+			Expect(c.Sync()).ToNot(HaveOccurred())
+			Expect(atomic.LoadInt32(&n)).To(Equal(int32(1)))
+			Expect(stored("foo")).To(Equal("9"))
+		})
+
+		Context("when a write batch fails", func() {
+			var sends int32
+			BeforeEach(func() {
+				sends = 1
+				cfg.DB = failingDB{DB: db, sends: &sends}
+				cfg.WriteBatchSize = 10
+				cfg.MaxPending = 100
+			})
+
+			It("retries only the batches not written", func() {
+				for i := 0; i < 30; i++ {
+					c.Put(strconv.Itoa(i), strconv.Itoa(i))
+				}
+				Expect(c.Sync()).To(HaveOccurred())
+				Expect(atomic.LoadInt32(&n)).To(Equal(int32(20)))
+
+				atomic.StoreInt32(&sends, 10)
+				Expect(c.Sync()).ToNot(HaveOccurred())
+				Expect(atomic.LoadInt32(&n)).To(Equal(int32(40)))
+				for i := 0; i < 30; i++ {
+					Expect(stored(strconv.Itoa(i))).To(Equal(strconv.Itoa(i)))
+				}
+			})
+
+			Context("when the pending items limit is reached", func() {
+				BeforeEach(func() {
+					sends = 0
+					cfg.MaxPending = 10
+				})
+
+				It("fails the blocked writes", func() {
+					for i := 0; i < 10; i++ {
+						Expect(c.Put(strconv.Itoa(i), strconv.Itoa(i))).ToNot(HaveOccurred())
+					}
+					Expect(c.Put("10", "10")).To(MatchError(ContainSubstring("send failed")))
+				})
+			})
+		})
+	})
+
+	It("writes versions before range lookups", func() {
+		c.PutWithTime("foo", "1", testing.SimpleTime(10))
+		c.PutWithTime("foo", "2", testing.SimpleTime(20))
+		versions, err := c.LookupByKeys([]string{"foo"}, testing.SimpleTime(0), testing.SimpleTime(30), 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(versions["foo"]).To(HaveLen(2))
+	})
+
+	It("writes only the items of the looked up keys", func() {
+		c.PutWithTime("foo", "1", testing.SimpleTime(10))
+		c.Put("bar", "1")
+		_, err := c.LookupByKeys([]string{"foo"}, testing.SimpleTime(0), testing.SimpleTime(30), 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stored("bar")).To(BeEmpty())
+		Expect(c.Sync()).ToNot(HaveOccurred())
+		Expect(stored("bar")).To(Equal("1"))
+	})
+
+	Context("when the batch size is reached", func() {
+		BeforeEach(func() {
+			cfg.WriteBatchSize = 10
+		})
+
+		It("writes the batch", func() {
+			for i := 0; i < 10; i++ {
+				c.Put(strconv.Itoa(i), strconv.Itoa(i))
+			}
+			Eventually(func() string {
+				return stored("9")
+			}).Should(Equal("9"))
+		})
+
+		It("blocks writes until the pending items are written", func() {
+			cfg.MaxPending = 10
+			for i := 0; i < 100; i++ {
+				c.Put(strconv.Itoa(i), strconv.Itoa(i))
+			}
+			Expect(c.Sync()).ToNot(HaveOccurred())
+			for i := 0; i < 100; i++ {
+				Expect(stored(strconv.Itoa(i))).To(Equal(strconv.Itoa(i)))
+			}
+		})
+	})
+
+	It("does not write deleted items", func() {
+		c.Put("foo", "1")
+		c.PutWithTime("bar", "1", testing.SimpleTime(10))
+		Expect(c.Delete("foo")).ToNot(HaveOccurred())
+		Expect(c.DeleteAt("bar", testing.SimpleTime(10))).ToNot(HaveOccurred())
+		Expect(c.Sync()).ToNot(HaveOccurred())
+		Expect(stored("foo")).To(BeEmpty())
+		Expect(stored("bar")).To(BeEmpty())
+	})
+
+	It("writes all the items on flush", func() {
+		c.Put("foo", "1")
+		c.Flush()
+		Expect(stored("foo")).To(Equal("1"))
+		c.Put("bar", "1")
+		Expect(stored("bar")).To(Equal("1"))
+	})
+})
@@ -0,0 +1,334 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/types"
+)
+
+const (
+	// defaultWriteInterval is the max time a row may stay buffered.
+	defaultWriteInterval = 5 * time.Second
+	// defaultMaxPending is the number of buffered rows at which
+	// writes block until the buffer is flushed.
+	defaultMaxPending = 4 * defaultBatchSize
+)
+
+// rowKey identifies a buffered row. Rows of unversioned values are
+// written with the time of the last write, therefore all the writes
+// of such a value are coalesced into a single row.
+type rowKey struct {
+	key       string
+	t         int64
+	versioned bool
+}
+
+// entry is a modified value to be written to the DB.
+type entry struct {
+	key   string // Cache key, without the prefix.
+	value interface{}
+	t     time.Time // Row timestamp.
+}
+
+// writer buffers modified values to be written to the DB. Writes of the
+// same key and version are coalesced: values are serialized only when the
+// buffer is flushed in write batches, once the batch size is reached, or
+// the write interval elapses. When the buffer grows beyond the max pending
+// rows limit, writes are blocked until the rows are flushed; if the flush
+// fails, the blocked writes fail with the flush error.
+type writer struct {
+	db         types.DB
+	encode     func(rowKey, entry) ([]byte, error)
+	batchSize  int
+	maxPending int
+	interval   time.Duration
+
+	// flushMu serializes flushes and deletes: a row must not be written
+	// after the key has been deleted.
+	flushMu sync.Mutex
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	pending  map[rowKey]entry
+	inflight map[rowKey]entry
+	keys     map[string]int // Number of pending rows per key.
+	closed   bool
+	flushes  uint64 // Number of completed flushes.
+	flushErr error  // Error of the last flush.
+
+	full chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newWriter(db types.DB, encode func(rowKey, entry) ([]byte, error), batchSize, maxPending int, interval time.Duration) *writer {
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	if maxPending < batchSize {
+		maxPending = defaultMaxPending
+		if maxPending < batchSize {
+			maxPending = batchSize
+		}
+	}
+	if interval <= 0 {
+		interval = defaultWriteInterval
+	}
+	w := &writer{
+		db:         db,
+		encode:     encode,
+		batchSize:  batchSize,
+		maxPending: maxPending,
+		interval:   interval,
+		pending:    make(map[rowKey]entry),
+		keys:       make(map[string]int),
+		full:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	w.cond = sync.NewCond(&w.mu)
+	go w.run()
+	return w
+}
+
+func (w *writer) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		case <-w.full:
+		}
+		if err := w.flush(); err != nil {
+			logrus.WithError(err).Error("failed to write cache items to the DB")
+		}
+	}
+}
+
+// close stops the writer and flushes the buffered rows. Rows added
+// after the writer is closed are written to the DB immediately.
+func (w *writer) close() error {
+	close(w.stop)
+	<-w.done
+	w.mu.Lock()
+	w.closed = true
+	w.cond.Broadcast()
+	w.mu.Unlock()
+	return w.flush()
+}
+
+func (w *writer) add(k rowKey, e entry) error {
+	w.mu.Lock()
+	if _, ok := w.pending[k]; !ok {
+		flushes := w.flushes
+		for len(w.pending) >= w.maxPending && !w.closed {
+			if w.flushes != flushes && w.flushErr != nil {
+				err := w.flushErr
+				w.mu.Unlock()
+				return fmt.Errorf("failed to write cache items to the DB: %w", err)
+			}
+			w.notify()
+			w.cond.Wait()
+		}
+		if w.closed {
+			w.mu.Unlock()
+			return w.put(k, e)
+		}
+		w.keys[k.key]++
+	}
+	w.pending[k] = e
+	if len(w.pending) >= w.batchSize {
+		w.notify()
+	}
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *writer) notify() {
+	select {
+	case w.full <- struct{}{}:
+	default:
+	}
+}
+
+// lookup returns the buffered value, if any.
+func (w *writer) lookup(k rowKey) (interface{}, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if e, ok := w.pending[k]; ok {
+		return e.value, true
+	}
+	e, ok := w.inflight[k]
+	return e.value, ok
+}
+
+// sync writes the buffered rows of the given keys.
+func (w *writer) sync(keys ...string) error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	w.mu.Lock()
+	found := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		if w.keys[k] > 0 {
+			found[k] = struct{}{}
+		}
+	}
+	w.mu.Unlock()
+	if len(found) == 0 {
+		return nil
+	}
+	return w.flushLocked(func(k rowKey) bool {
+		_, ok := found[k.key]
+		return ok
+	})
+}
+
+func (w *writer) flush() error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	return w.flushLocked(nil)
+}
+
+// flushLocked writes the buffered rows matching the predicate,
+// or all of them, if the predicate is nil.
+func (w *writer) flushLocked(match func(rowKey) bool) error {
+	w.mu.Lock()
+	if len(w.pending) == 0 {
+		w.mu.Unlock()
+		return nil
+	}
+	if match == nil {
+		w.inflight, w.pending = w.pending, make(map[rowKey]entry, len(w.pending))
+		w.keys = make(map[string]int)
+	} else {
+		w.inflight = make(map[rowKey]entry)
+		for k, e := range w.pending {
+			if match(k) {
+				w.inflight[k] = e
+				w.removePending(k)
+			}
+		}
+	}
+	w.cond.Broadcast()
+	w.mu.Unlock()
+
+	unsent, err := w.write(w.inflight)
+
+	w.mu.Lock()
+	// Rows not yet written are retried with the
+	// next flush, unless they have been overwritten.
+	for k, e := range unsent {
+		if _, ok := w.pending[k]; !ok {
+			w.pending[k] = e
+			w.keys[k.key]++
+		}
+	}
+	w.inflight = nil
+	w.flushes++
+	w.flushErr = err
+	w.cond.Broadcast()
+	w.mu.Unlock()
+	return err
+}
+
+func (w *writer) removePending(k rowKey) {
+	delete(w.pending, k)
+	if w.keys[k.key]--; w.keys[k.key] == 0 {
+		delete(w.keys, k.key)
+	}
+}
+
+// write writes the entries in batches. If a batch fails,
+// the entries that have not been written are returned.
+func (w *writer) write(entries map[rowKey]entry) (map[rowKey]entry, error) {
+	unsent := make(map[rowKey]entry, len(entries))
+	for k, e := range entries {
+		unsent[k] = e
+	}
+	keys := make([]rowKey, 0, w.batchSize)
+	for k := range entries {
+		if keys = append(keys, k); len(keys) < w.batchSize {
+			continue
+		}
+		if err := w.writeBatch(keys, unsent); err != nil {
+			return unsent, err
+		}
+		keys = keys[:0]
+	}
+	if len(keys) > 0 {
+		if err := w.writeBatch(keys, unsent); err != nil {
+			return unsent, err
+		}
+	}
+	return nil, nil
+}
+
+// writeBatch writes the entries of the keys and removes them from the map.
+// Entries that can't be serialized are dropped, as a retry would fail too.
+func (w *writer) writeBatch(keys []rowKey, entries map[rowKey]entry) error {
+	batch, err := w.db.NewWriteBatch(context.Background())
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		b, err := w.encode(k, entries[k])
+		if err != nil {
+			logrus.WithError(err).WithField("key", k.key).Error("error saving to disk")
+			delete(entries, k)
+			continue
+		}
+		if err = batch.Append(types.Row{Key: k.key, Value: b, Timestamp: entries[k].t}); err != nil {
+			_ = batch.Abort()
+			return err
+		}
+	}
+	if err = batch.Send(); err != nil {
+		return err
+	}
+	for _, k := range keys {
+		delete(entries, k)
+	}
+	return nil
+}
+
+// put writes the entry to the DB immediately.
+func (w *writer) put(k rowKey, e entry) error {
+	b, err := w.encode(k, e)
+	if err != nil {
+		return err
+	}
+	return w.db.Put(context.Background(), types.Row{Key: k.key, Value: b, Timestamp: e.t})
+}
+
+// delete removes the buffered rows matching the predicate and calls fn,
+// which is expected to delete the rows from the DB.
+func (w *writer) delete(match func(rowKey) bool, fn func() error) error {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	w.mu.Lock()
+	for k := range w.pending {
+		if match(k) {
+			w.removePending(k)
+		}
+	}
+	w.cond.Broadcast()
+	w.mu.Unlock()
+	return fn()
+}
+
+func matchKey(key string) func(rowKey) bool {
+	return func(k rowKey) bool { return k.key == key }
+}
+
+func matchPrefix(prefix string) func(rowKey) bool {
+	return func(k rowKey) bool { return strings.HasPrefix(k.key, prefix) }
+}
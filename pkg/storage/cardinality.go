@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/twmb/murmur3"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/dimension"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/util/hyperloglog"
+)
+
+const (
+	hllPrecision = 14
+	hashSeed     = 6231912
+)
+
+type hashString string
+
+func (hs hashString) Sum64() uint64 {
+	return murmur3.SeedSum64(hashSeed, []byte(hs))
+}
+
+// cardinality enforces the series and label values limits, and keeps
+// estimates of the number of series and label values ingested since
+// the server start.
+type cardinality struct {
+	sync.Mutex
+	// Known values of label keys. A key is loaded from the labels
+	// index on first use, only if the values limit is set.
+	values map[string]map[string]struct{}
+	// New series of applications that passed the limit check and
+	// have not been added to the application dimension yet.
+	reserved map[string]map[string]struct{}
+	series   map[string]*hyperloglog.HyperLogLogPlus
+	labels   map[string]*hyperloglog.HyperLogLogPlus
+}
+
+// CardinalityReport lists applications and label keys ordered
+// by the estimated cardinality.
+type CardinalityReport struct {
+	Apps   []CardinalityEstimate `json:"apps"`
+	Labels []CardinalityEstimate `json:"labels"`
+}
+
+// CardinalityEstimate is the approximate number of distinct series of
+// an application, or distinct values of a label key.
+type CardinalityEstimate struct {
+	Name  string `json:"name"`
+	Count uint64 `json:"count"`
+}
+
+func newCardinality() *cardinality {
+	return &cardinality{
+		values:   make(map[string]map[string]struct{}),
+		reserved: make(map[string]map[string]struct{}),
+		series:   make(map[string]*hyperloglog.HyperLogLogPlus),
+		labels:   make(map[string]*hyperloglog.HyperLogLogPlus),
+	}
+}
+
+// IsCardinalityLimitError reports whether the ingestion
+// request is rejected because of the cardinality limits.
+func IsCardinalityLimitError(err error) bool {
+	return errors.Is(err, errCardinalityLimit)
+}
+
+// checkCardinality returns an error if the key introduces a new series to
+// the application with the max number of series, or a new value to a
+// label key with the max number of values. Otherwise, the new series is
+// reserved and counts against the limit until it is released, and the new
+// label values are added to the known ones: the check and the reservation
+// are made atomically.
+func (s *Storage) checkCardinality(ctx context.Context, k *segment.Key) error {
+	limits := s.config.cardinality
+	if limits.SeriesPerApp <= 0 && limits.ValuesPerLabel <= 0 {
+		return nil
+	}
+	s.cardinality.Lock()
+	defer s.cardinality.Unlock()
+	app, sk := k.AppName(), k.SegmentKey()
+	var newSeries bool
+	if limits.SeriesPerApp > 0 {
+		n, ok := s.seriesCount(k)
+		reserved := s.cardinality.reserved[app]
+		if _, r := reserved[sk]; !ok && !r {
+			if n += len(reserved); n >= limits.SeriesPerApp {
+				return fmt.Errorf("%w: application %s has %d series", errCardinalityLimit, app, n)
+			}
+			newSeries = true
+		}
+	}
+	newValues := make(map[string]string)
+	for key, value := range k.Labels() {
+		if limits.ValuesPerLabel <= 0 {
+			break
+		}
+		if key == "__name__" {
+			continue
+		}
+		values, err := s.labelValues(ctx, key)
+		if err != nil {
+			return err
+		}
+		if _, ok := values[value]; ok {
+			continue
+		}
+		if len(values) >= limits.ValuesPerLabel {
+			return fmt.Errorf("%w: label %s has %d values", errCardinalityLimit, key, len(values))
+		}
+		newValues[key] = value
+	}
+	for key, value := range newValues {
+		s.cardinality.values[key][value] = struct{}{}
+	}
+	if newSeries {
+		reserved, ok := s.cardinality.reserved[app]
+		if !ok {
+			reserved = make(map[string]struct{})
+			s.cardinality.reserved[app] = reserved
+		}
+		reserved[sk] = struct{}{}
+	}
+	return nil
+}
+
+// releaseSeries removes the series reservation made by checkCardinality.
+// Must be called once the series is added to the application dimension.
+func (s *Storage) releaseSeries(k *segment.Key) {
+	s.cardinality.Lock()
+	defer s.cardinality.Unlock()
+	app := k.AppName()
+	if reserved, ok := s.cardinality.reserved[app]; ok {
+		delete(reserved, k.SegmentKey())
+		if len(reserved) == 0 {
+			delete(s.cardinality.reserved, app)
+		}
+	}
+}
+
+// seriesCount returns the number of series of the application,
+// and whether the key belongs to an existing series.
+func (s *Storage) seriesCount(k *segment.Key) (int, bool) {
+	d, ok := s.lookupAppDimension(k.AppName())
+	if !ok {
+		return 0, false
+	}
+	return d.Len(), d.Has(dimension.Key(k.SegmentKey()))
+}
+
+// labelValues returns the known values of the label key.
+// The caller must hold the cardinality lock.
+func (s *Storage) labelValues(ctx context.Context, key string) (map[string]struct{}, error) {
+	if values, ok := s.cardinality.values[key]; ok {
+		return values, nil
+	}
+	values := make(map[string]struct{})
+	err := s.labels.GetValues(ctx, key, func(v string) bool {
+		values[v] = struct{}{}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read label values: %w", err)
+	}
+	s.cardinality.values[key] = values
+	return values, nil
+}
+
+// deleteLabel removes the key value label pair from the labels index.
+func (s *Storage) deleteLabel(ctx context.Context, key, value string) error {
+	if err := s.labels.Delete(ctx, key, value); err != nil {
+		return err
+	}
+	s.cardinality.Lock()
+	delete(s.cardinality.values[key], value)
+	s.cardinality.Unlock()
+	return nil
+}
+
+func (s *Storage) observeCardinality(k *segment.Key) {
+	s.cardinality.Lock()
+	defer s.cardinality.Unlock()
+	observe(s.cardinality.series, k.AppName(), k.SegmentKey())
+	for key, value := range k.Labels() {
+		if key != "__name__" {
+			observe(s.cardinality.labels, key, value)
+		}
+	}
+}
+
+func observe(m map[string]*hyperloglog.HyperLogLogPlus, name, v string) {
+	h, ok := m[name]
+	if !ok {
+		h, _ = hyperloglog.NewPlus(hllPrecision)
+		m[name] = h
+	}
+	h.Add(hashString(v))
+}
+
+// CardinalityReport returns the applications with the largest number of
+// series and the label keys with the largest number of values, ingested
+// since the server start. The numbers are estimates.
+func (s *Storage) CardinalityReport(limit int) CardinalityReport {
+	s.cardinality.Lock()
+	defer s.cardinality.Unlock()
+	return CardinalityReport{
+		Apps:   topEstimates(s.cardinality.series, limit),
+		Labels: topEstimates(s.cardinality.labels, limit),
+	}
+}
+
+func topEstimates(m map[string]*hyperloglog.HyperLogLogPlus, limit int) []CardinalityEstimate {
+	estimates := make([]CardinalityEstimate, 0, len(m))
+	for name, h := range m {
+		estimates = append(estimates, CardinalityEstimate{Name: name, Count: h.Count()})
+	}
+	sort.Slice(estimates, func(i, j int) bool {
+		if estimates[i].Count == estimates[j].Count {
+			return estimates[i].Name < estimates[j].Name
+		}
+		return estimates[i].Count > estimates[j].Count
+	})
+	if limit > 0 && len(estimates) > limit {
+		estimates = estimates[:limit]
+	}
+	return estimates
+}
@@ -0,0 +1,222 @@
+// Package clickhouse implements the storage backend on top of ClickHouse.
+// Every DB is a table with (k, v, timestamp) columns; writes go to the
+// local table, while reads are served by the distributed "_all" table.
+// Deletes are executed on the local tables of all the cluster shards.
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/types"
+)
+
+const defaultMaxBatchCount = 512000
+
+type DB struct {
+	conn    driver.Conn
+	table   string
+	cluster string
+}
+
+// New creates a DB instance for the table. The table is specified with
+// the database name, e.g. "pyroscope.trees". If cluster is empty, the
+// local table is assumed to hold all the data.
+func New(conn driver.Conn, table, cluster string) *DB {
+	return &DB{conn: conn, table: table, cluster: cluster}
+}
+
+type tableRow struct {
+	K         string    `ch:"k"`
+	V         string    `ch:"v"`
+	Timestamp time.Time `ch:"timestamp"`
+}
+
+func (r tableRow) row() types.Row {
+	return types.Row{Key: r.K, Value: []byte(r.V), Timestamp: r.Timestamp}
+}
+
+func (d *DB) distributed() string { return d.table + "_all" }
+
+// deleteFrom returns the beginning of a delete statement removing rows
+// from the local tables of all the cluster shards: a distributed table
+// does not support deletes.
+func (d *DB) deleteFrom() string {
+	if d.cluster == "" {
+		return "delete from " + d.table
+	}
+	return "delete from " + d.table + " on cluster " + d.cluster
+}
+
+func (d *DB) Put(ctx context.Context, rows ...types.Row) error {
+	if len(rows) == 1 {
+		r := rows[0]
+		return d.conn.Exec(ctx, "insert into "+d.table+" values (?, ?, ?)", r.Key, string(r.Value), r.Timestamp)
+	}
+	b, err := d.NewWriteBatch(ctx)
+	if err != nil {
+		return err
+	}
+	for _, r := range rows {
+		if err = b.Append(r); err != nil {
+			_ = b.Abort()
+			return err
+		}
+	}
+	return b.Send()
+}
+
+func (d *DB) Get(ctx context.Context, key string) (types.Row, bool, error) {
+	return d.queryRow(ctx, "select k, v, timestamp from "+d.distributed()+" where k = ? order by timestamp desc limit 1", key)
+}
+
+func (d *DB) GetAt(ctx context.Context, key string, t time.Time) (types.Row, bool, error) {
+	return d.queryRow(ctx, "select k, v, timestamp from "+d.distributed()+" where k = ? and timestamp = ? limit 1", key, t)
+}
+
+func (d *DB) queryRow(ctx context.Context, query string, args ...interface{}) (types.Row, bool, error) {
+	rows, err := d.conn.Query(ctx, query, args...)
+	if err != nil {
+		return types.Row{}, false, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return types.Row{}, false, rows.Err()
+	}
+	var r tableRow
+	if err = rows.ScanStruct(&r); err != nil {
+		return types.Row{}, false, err
+	}
+	return r.row(), true, nil
+}
+
+func (d *DB) Range(ctx context.Context, keys []string, st, et time.Time, step time.Duration, fn func(types.Row) error) error {
+	var query string
+	if s := int64(step.Seconds()); s > 0 {
+		query = fmt.Sprintf("select k, argMin(v, timestamp) as v, min(timestamp) as ts from %s"+
+			" where k in ? and timestamp >= ? and timestamp <= ?"+
+			" group by k, toStartOfInterval(timestamp, INTERVAL %d second) order by ts asc", d.distributed(), s)
+	} else {
+		query = "select k, v, timestamp from " + d.distributed() +
+			" where k in ? and timestamp >= ? and timestamp <= ? order by timestamp asc"
+	}
+	rows, err := d.conn.Query(ctx, query, keys, st, et)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r tableRow
+		if err = rows.Scan(&r.K, &r.V, &r.Timestamp); err != nil {
+			return err
+		}
+		if err = fn(r.row()); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (d *DB) Scan(ctx context.Context, prefix string, fn func(types.Row) bool) error {
+	rows, err := d.conn.Query(ctx, "select k, argMax(v, timestamp) as v, max(timestamp) as timestamp from "+d.distributed()+
+		" where startsWith(k, ?) group by k order by k", prefix)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r tableRow
+		if err = rows.ScanStruct(&r); err != nil {
+			return err
+		}
+		if !fn(r.row()) {
+			return nil
+		}
+	}
+	return rows.Err()
+}
+
+func (d *DB) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return d.conn.Exec(ctx, d.deleteFrom()+" where k in ?", keys)
+}
+
+func (d *DB) DeleteAt(ctx context.Context, key string, ts ...time.Time) error {
+	if len(ts) == 0 {
+		return nil
+	}
+	return d.conn.Exec(ctx, d.deleteFrom()+" where k = ? and timestamp in ?", key, ts)
+}
+
+func (d *DB) DeletePrefix(ctx context.Context, prefix string) error {
+	return d.conn.Exec(ctx, d.deleteFrom()+" where startsWith(k, ?)", prefix)
+}
+
+// Size returns the size of the active parts of the local table. If the
+// cluster is specified, the parts of all the shards are accounted; only
+// one replica of a shard is queried, as the replicas hold the same data.
+func (d *DB) Size(ctx context.Context) (int64, error) {
+	database, table, _ := strings.Cut(d.table, ".")
+	if d.cluster == "" {
+		return d.querySize(ctx, "select sum(bytes_on_disk) from system.parts"+
+			" where active and database = ? and table = ?", database, table)
+	}
+	return d.querySize(ctx, "select sum(bytes_on_disk) from cluster(?, system.parts)"+
+		" where active and database = ? and table = ?", d.cluster, database, table)
+}
+
+// PrefixSizes returns the uncompressed size of the rows: ClickHouse
+// does not track the size of the individual rows on disk. The call
+// reads all the rows with the prefix and should be made sparingly.
+func (d *DB) PrefixSizes(ctx context.Context, prefix string, sep byte) (map[string]int64, error) {
+	rows, err := d.conn.Query(ctx, "select splitByChar(?, substring(k, ?))[1] as g, sum(length(k) + length(v)) from "+d.distributed()+
+		" where startsWith(k, ?) group by g", string(sep), len(prefix)+1, prefix)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	sizes := make(map[string]int64)
+	for rows.Next() {
+		var (
+			g    string
+			size uint64
+		)
+		if err = rows.Scan(&g, &size); err != nil {
+			return nil, err
+		}
+		sizes[g] = int64(size)
+	}
+	return sizes, rows.Err()
+}
+
+func (d *DB) querySize(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	var size uint64
+	if err := d.conn.QueryRow(ctx, query, args...).Scan(&size); err != nil {
+		return 0, err
+	}
+	return int64(size), nil
+}
+
+func (d *DB) NewWriteBatch(ctx context.Context) (types.WriteBatch, error) {
+	b, err := d.conn.PrepareBatch(ctx, "insert into "+d.table+" values (?, ?, ?)")
+	if err != nil {
+		return nil, err
+	}
+	return writeBatch{b}, nil
+}
+
+func (*DB) MaxBatchCount() int64 { return defaultMaxBatchCount }
+
+func (d *DB) Close() error { return d.conn.Close() }
+
+type writeBatch struct{ driver.Batch }
+
+func (b writeBatch) Append(r types.Row) error {
+	return b.Batch.Append(r.Key, string(r.Value), r.Timestamp)
+}
@@ -22,8 +22,7 @@ func (c treeCodec) Serialize(w io.Writer, k string, v interface{}) error {
 	if err != nil {
 		return err
 	}
-	c.dicts.Put(key, d)
-	return nil
+	return c.dicts.Put(key, d)
 }
 
 func (c treeCodec) SerializeWithTime(w io.Writer, k string, v interface{}, t time.Time) error {
@@ -33,8 +32,7 @@ func (c treeCodec) SerializeWithTime(w io.Writer, k string, v interface{}, t tim
 	if err != nil {
 		return err
 	}
-	c.dicts.PutWithTime(key, d, t)
-	return nil
+	return c.dicts.PutWithTime(key, d, t)
 }
 
 func (c treeCodec) Deserialize(r io.Reader, k string) (interface{}, error) {
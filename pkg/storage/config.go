@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/pyroscope-io/pyroscope/pkg/config"
+	"github.com/pyroscope-io/pyroscope/pkg/model/appmetadata"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/cache"
 	"github.com/sirupsen/logrus"
 )
@@ -25,14 +26,25 @@ type Config struct {
 	exemplarsBatchSize      int
 	exemplarsBatchQueueSize int
 	exemplarsBatchDuration  time.Duration
+	quota                   config.StorageQuota
+	cardinality             config.CardinalityLimits
+	appMetadataLabels       appmetadata.LabelMapping
+	rollups                 bool
 
+	backend string
 	chAddrs []string
 	db      string
 
-	//NewBadger     func(name string, p Prefix, codec cache.Codec) (BadgerDBWithCache, error)
-	NewClickHouse func(name string, p Prefix, codec cache.Codec) (ClickHouseDBWithCache, error)
+	// NewDB overrides the storage backend selected with the config.
+	NewDB func(name string, p Prefix, codec cache.Codec) (DBWithCache, error)
 }
 
+// Supported storage backends.
+const (
+	BackendClickHouse = "clickhouse"
+	BackendEmbedded   = "embedded"
+)
+
 // NewConfig returns a new storage config from a server config
 func NewConfig(server *config.Server) *Config {
 	level := logrus.ErrorLevel
@@ -55,7 +67,12 @@ func NewConfig(server *config.Server) *Config {
 		exemplarsBatchSize:      server.ExemplarsBatchSize,
 		exemplarsBatchQueueSize: server.ExemplarsBatchQueueSize,
 		exemplarsBatchDuration:  server.ExemplarsBatchDuration,
+		quota:                   server.StorageQuota,
+		cardinality:             server.CardinalityLimits,
+		appMetadataLabels:       appmetadata.NewLabelMapping(server.AppMetadataLabels, server.AppMetadataErdaLabels),
+		rollups:                 server.StorageRollups,
 		inMemory:                false,
+		backend:                 server.StorageBackend,
 		db:                      "pyroscope",
 	}
 }
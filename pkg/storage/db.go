@@ -2,19 +2,22 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/ClickHouse/clickhouse-go/v2"
-	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	ch "github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/dgraph-io/badger/v2"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/pyroscope-io/pyroscope/pkg/storage/types"
 	"github.com/sirupsen/logrus"
 
 	"github.com/pyroscope-io/pyroscope/pkg/config"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/cache"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/clickhouse"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/embedded"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/types"
 	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
 )
 
@@ -22,7 +25,7 @@ type db struct {
 	name   string
 	logger logrus.FieldLogger
 
-	clickhouse.Conn
+	backend types.DB
 	*cache.Cache
 
 	lastGC  bytesize.ByteSize
@@ -49,10 +52,20 @@ func (p Prefix) trim(k []byte) ([]byte, bool) {
 	return nil, false
 }
 
-func (s *Storage) newClickHouse(name string, p Prefix, codec cache.Codec) (ClickHouseDBWithCache, error) {
-	logger := logrus.New()
-	logger.SetLevel(s.config.badgerLogLevel)
+// newDB returns a function creating DB instances with the backend
+// specified in the config.
+func (s *Storage) newDB() (func(name string, p Prefix, codec cache.Codec) (DBWithCache, error), error) {
+	switch s.config.backend {
+	case BackendClickHouse, "":
+		return s.newClickHouse, nil
+	case BackendEmbedded:
+		return s.newEmbedded, nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", s.config.backend)
+	}
+}
 
+func (s *Storage) newClickHouse(name string, p Prefix, codec cache.Codec) (DBWithCache, error) {
 	type ClickhouseConfig struct {
 		ClickhouseAddr             string        `env:"CLICKHOUSE_ADDR" default:"localhost:9000"`
 		ClickhouseUsername         string        `env:"CLICKHOUSE_USERNAME" default:"default"`
@@ -63,23 +76,26 @@ func (s *Storage) newClickHouse(name string, p Prefix, codec cache.Codec) (Click
 		ClickhouseMaxOpenConns     int           `env:"CLICKHOUSE_MAX_OPEN_CONNS" default:"10"`
 		ClickhouseConnMaxLifeTime  time.Duration `env:"CLICKHOUSE_CONN_MAX_LIFETIME" default:"1h"`
 		ClickhouseConnOpenStrategy string        `env:"CLICKHOUSE_CONN_OPEN_STRATEGY" default:"in_order"`
+		// ClickhouseCluster is the cluster of the local tables; it has
+		// to be specified if the data is distributed across shards.
+		ClickhouseCluster string `env:"CLICKHOUSE_CLUSTER"`
 	}
 
 	var chConfig ClickhouseConfig
 	if err := config.Load(&chConfig); err != nil {
 		return nil, err
 	}
-	openStrategy := clickhouse.ConnOpenInOrder
+	openStrategy := ch.ConnOpenInOrder
 	switch chConfig.ClickhouseConnOpenStrategy {
 	case "0", "in_order":
-		openStrategy = clickhouse.ConnOpenInOrder
+		openStrategy = ch.ConnOpenInOrder
 	case "1", "round_robin":
-		openStrategy = clickhouse.ConnOpenRoundRobin
+		openStrategy = ch.ConnOpenRoundRobin
 	}
 
-	options := &clickhouse.Options{
+	options := &ch.Options{
 		Addr: strings.Split(chConfig.ClickhouseAddr, ","),
-		Auth: clickhouse.Auth{
+		Auth: ch.Auth{
 			Username: chConfig.ClickhouseUsername,
 			Password: chConfig.ClickhousePassword,
 		},
@@ -90,45 +106,71 @@ func (s *Storage) newClickHouse(name string, p Prefix, codec cache.Codec) (Click
 		ConnOpenStrategy: openStrategy,
 	}
 
-	ch, err := clickhouse.Open(options)
+	conn, err := ch.Open(options)
+	if err != nil {
+		return nil, err
+	}
+
+	d := s.newDBWithCache(name, clickhouse.New(conn, s.config.db+"."+name, chConfig.ClickhouseCluster), p, codec)
+	return d, nil
+}
+
+func (s *Storage) newEmbedded(name string, p Prefix, codec cache.Codec) (DBWithCache, error) {
+	logger := logrus.New()
+	logger.SetLevel(s.config.badgerLogLevel)
+
+	path := filepath.Join(s.config.badgerBasePath, name)
+	e, err := embedded.Open(embedded.Options{
+		Path:       path,
+		InMemory:   s.config.inMemory,
+		NoTruncate: s.config.badgerNoTruncate,
+		Logger:     logger.WithField("badger", name),
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	var d *db = &db{
+	d := s.newDBWithCache(name, e, p, codec)
+	if !s.config.inMemory {
+		s.maintenanceTask(s.badgerGCTaskInterval, func() {
+			diff := calculateDBSize(path) - d.lastGC
+			if d.lastGC == 0 || s.gcSizeDiff == 0 || diff > s.gcSizeDiff {
+				d.runGC(e, 0.7)
+				d.gcCount.Inc()
+				d.lastGC = calculateDBSize(path)
+			}
+		})
+	}
+
+	return d, nil
+}
+
+func (s *Storage) newDBWithCache(name string, backend types.DB, p Prefix, codec cache.Codec) *db {
+	d := &db{
 		name:    name,
 		logger:  s.logger.WithField("db", name),
-		Conn:    ch,
+		backend: backend,
 		gcCount: s.metrics.gcCount.WithLabelValues(name),
 	}
-
 	if codec != nil {
-		d.Cache = cache.NewClickHouse(cache.ClickHouseConfig{
-			ClickHouseDB: d,
-			Metrics:      s.metrics.createCacheMetrics(name),
-			TTL:          s.cacheTTL,
-			Prefix:       p.String(),
-			Codec:        codec,
+		d.Cache = cache.New(cache.Config{
+			DB:      backend,
+			Metrics: s.metrics.createCacheMetrics(name),
+			TTL:     s.cacheTTL,
+			Prefix:  p.String(),
+			Codec:   codec,
 		})
 	}
-
-	s.maintenanceTask(s.badgerGCTaskInterval, func() {
-		//diff := calculateDBSize(badgerPath) - d.lastGC
-		//if d.lastGC == 0 || s.gcSizeDiff == 0 || diff > s.gcSizeDiff {
-		//	d.runGC(0.7)
-		//	d.gcCount.Inc()
-		//	d.lastGC = calculateDBSize(badgerPath)
-		//}
-	})
-
-	return d, nil
+	return d
 }
 
 func (d *db) Size() bytesize.ByteSize {
-	//// The value is updated once per minute.
-	//lsm, vlog := d.DB.Size()
-	//return bytesize.ByteSize(lsm + vlog)
-	return 0
+	size, err := d.backend.Size(context.Background())
+	if err != nil {
+		d.logger.WithError(err).Warn("failed to get db size")
+		return 0
+	}
+	return bytesize.ByteSize(size)
 }
 
 func (d *db) CacheSize() uint64 {
@@ -139,32 +181,28 @@ func (d *db) Name() string {
 	return d.name
 }
 
-func (d *db) DBInstance() types.ClickHouseDB {
-	return d
+func (d *db) DBInstance() types.DB {
+	return d.backend
 }
+
 func (d *db) CacheInstance() *cache.Cache {
 	return d.Cache
 }
 
-func (d *db) Close() error {
-	return d.Conn.Close()
-}
-
-func (d *db) runGC(discardRatio float64) (reclaimed bool) {
-	//d.logger.Debug("starting badger garbage collection")
-	//for {
-	//	switch err := d.RunValueLogGC(discardRatio); err {
-	//	default:
-	//		d.logger.WithError(err).Warn("failed to run GC")
-	//		return false
-	//	case badger.ErrNoRewrite:
-	//		return reclaimed
-	//	case nil:
-	//		reclaimed = true
-	//		continue
-	//	}
-	//}
-	return true
+func (d *db) runGC(e *embedded.DB, discardRatio float64) (reclaimed bool) {
+	d.logger.Debug("starting badger garbage collection")
+	for {
+		switch err := e.RunValueLogGC(discardRatio); err {
+		default:
+			d.logger.WithError(err).Warn("failed to run GC")
+			return false
+		case badger.ErrNoRewrite:
+			return reclaimed
+		case nil:
+			reclaimed = true
+			continue
+		}
+	}
 }
 
 // TODO(kolesnikovae): filepath.Walk is notoriously slow.
@@ -186,30 +224,3 @@ func calculateDBSize(path string) bytesize.ByteSize {
 	})
 	return bytesize.ByteSize(size)
 }
-
-// TODO
-// SELECT database, formatReadableSize(sum(bytes)) AS size FROM system.parts where database = 'monitor' GROUP BY database;
-func (d *db) calculateDBSizeCH(path string) bytesize.ByteSize {
-	return 0
-}
-
-func (d *db) Update(f func(conn clickhouse.Conn) error) error {
-	return f(d.Conn)
-}
-
-func (d *db) View(f func(conn clickhouse.Conn) error) error {
-	return f(d.Conn)
-}
-
-func (d *db) NewWriteBatch(sql string) (driver.Batch, error) {
-	batch, err := d.Conn.PrepareBatch(context.Background(), sql)
-	return batch, err
-}
-
-func (d *db) MaxBatchCount() int64 {
-	return defaultBatchSize
-}
-
-func (d *db) FQDN() string {
-	return d.name
-}
@@ -16,7 +16,7 @@ func (s *Storage) DebugExport(w http.ResponseWriter, r *http.Request) {
 	}
 
 	n := mux.Vars(r)["db"]
-	var d ClickHouseDBWithCache
+	var d DBWithCache
 	switch n {
 	case "segments":
 		d = s.segments
@@ -39,24 +39,14 @@ func (s *Storage) DebugExport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/octet-stream")
-	_ = d
-	//err := d.View(func(txn *badger.Txn) error {
-	//	item, err := txn.Get([]byte(k[0]))
-	//	if err != nil {
-	//		return err
-	//	}
-	//	return item.Value(func(v []byte) error {
-	//		_, err = io.Copy(w, bytes.NewBuffer(v))
-	//		return err
-	//	})
-	//})
-	//
-	//switch {
-	//case err == nil:
-	//case errors.Is(err, badger.ErrKeyNotFound):
-	//	http.Error(w, fmt.Sprintf("key %q not found in %s", k[0], n), http.StatusNotFound)
-	//default:
-	//	http.Error(w, fmt.Sprintf("failed to export value for key %q: %v", k[0], err), http.StatusInternalServerError)
-	//}
+	row, ok, err := d.DBInstance().Get(r.Context(), k[0])
+	switch {
+	case err != nil:
+		http.Error(w, fmt.Sprintf("failed to export value for key %q: %v", k[0], err), http.StatusInternalServerError)
+	case !ok:
+		http.Error(w, fmt.Sprintf("key %q not found in %s", k[0], n), http.StatusNotFound)
+	default:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write(row.Value)
+	}
 }
@@ -39,6 +39,25 @@ func (d *Dimension) Insert(key Key) {
 	}
 }
 
+// Len returns the number of keys.
+func (d *Dimension) Len() int {
+	d.m.RLock()
+	defer d.m.RUnlock()
+	return len(d.Keys)
+}
+
+// Has reports whether the dimension contains the key.
+func (d *Dimension) Has(key Key) bool {
+	d.m.RLock()
+	defer d.m.RUnlock()
+
+	i := sort.Search(len(d.Keys), func(i int) bool {
+		return bytes.Compare(d.Keys[i], key) >= 0
+	})
+
+	return i < len(d.Keys) && bytes.Equal(d.Keys[i], key)
+}
+
 func (d *Dimension) Delete(key Key) {
 	d.m.Lock()
 	defer d.m.Unlock()
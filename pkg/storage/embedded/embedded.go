@@ -0,0 +1,439 @@
+// Package embedded implements an on-disk storage backend on top of BadgerDB.
+// It requires no external services and is suitable for single-node
+// deployments, development, and tests.
+package embedded
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/options"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/types"
+)
+
+// Every row is stored under the key followed by the separator and
+// the big-endian encoded timestamp (seconds and nanoseconds): this
+// way all the rows of a key are adjacent and ordered by time.
+const (
+	separator  = 0
+	suffixSize = 1 + 8 + 4
+)
+
+type Options struct {
+	// Path to the DB directory. Ignored if InMemory is true.
+	Path       string
+	InMemory   bool
+	NoTruncate bool
+	Logger     logrus.FieldLogger
+}
+
+type DB struct {
+	*badger.DB
+	inMemory bool
+}
+
+func Open(o Options) (*DB, error) {
+	if !o.InMemory {
+		if err := os.MkdirAll(o.Path, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	if o.InMemory {
+		o.Path = ""
+	}
+	badgerOptions := badger.DefaultOptions(o.Path).
+		WithInMemory(o.InMemory).
+		WithTruncate(!o.NoTruncate).
+		WithSyncWrites(false).
+		WithCompactL0OnClose(false).
+		WithCompression(options.ZSTD)
+	if o.Logger != nil {
+		badgerOptions = badgerOptions.WithLogger(o.Logger)
+	}
+	db, err := badger.Open(badgerOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{DB: db, inMemory: o.InMemory}, nil
+}
+
+func encodeKey(k string, t time.Time) []byte {
+	b := make([]byte, len(k)+suffixSize)
+	copy(b, k)
+	b[len(k)] = separator
+	putTime(b[len(k)+1:], t)
+	return b
+}
+
+// keyPrefix returns the prefix all the rows of the key share.
+func keyPrefix(k string) []byte {
+	b := make([]byte, len(k)+1)
+	copy(b, k)
+	b[len(k)] = separator
+	return b
+}
+
+func decodeKey(b []byte) (string, time.Time, bool) {
+	if len(b) < suffixSize || b[len(b)-suffixSize] != separator {
+		return "", time.Time{}, false
+	}
+	n := len(b) - suffixSize
+	return string(b[:n]), getTime(b[n+1:]), true
+}
+
+// putTime writes the timestamp with the sign bit flipped
+// so that negative timestamps are ordered correctly as well.
+func putTime(b []byte, t time.Time) {
+	binary.BigEndian.PutUint64(b, uint64(t.Unix())^1<<63)
+	binary.BigEndian.PutUint32(b[8:], uint32(t.Nanosecond()))
+}
+
+func getTime(b []byte) time.Time {
+	sec := int64(binary.BigEndian.Uint64(b) ^ 1<<63)
+	return time.Unix(sec, int64(binary.BigEndian.Uint32(b[8:]))).UTC()
+}
+
+func (d *DB) Put(_ context.Context, rows ...types.Row) error {
+	return d.Update(func(txn *badger.Txn) error {
+		for _, r := range rows {
+			if err := txn.Set(encodeKey(r.Key, r.Timestamp), r.Value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (d *DB) Get(_ context.Context, key string) (row types.Row, ok bool, err error) {
+	err = d.View(func(txn *badger.Txn) error {
+		p := keyPrefix(key)
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: p, Reverse: true})
+		defer it.Close()
+		it.Seek(append(p, bytes.Repeat([]byte{0xff}, suffixSize-1)...))
+		if !it.ValidForPrefix(p) {
+			return nil
+		}
+		row, ok, err = readRow(it.Item())
+		return err
+	})
+	return row, ok, err
+}
+
+func (d *DB) GetAt(_ context.Context, key string, t time.Time) (row types.Row, ok bool, err error) {
+	err = d.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(encodeKey(key, t))
+		switch {
+		case err == nil:
+		case err == badger.ErrKeyNotFound:
+			return nil
+		default:
+			return err
+		}
+		row, ok, err = readRow(item)
+		return err
+	})
+	return row, ok, err
+}
+
+func readRow(item *badger.Item) (types.Row, bool, error) {
+	k, t, ok := decodeKey(item.Key())
+	if !ok {
+		return types.Row{}, false, nil
+	}
+	v, err := item.ValueCopy(nil)
+	if err != nil {
+		return types.Row{}, false, err
+	}
+	return types.Row{Key: k, Value: v, Timestamp: t}, true, nil
+}
+
+// Range merges the rows of the keys by timestamp: every key is read with
+// its own iterator, and only the current row of every key is kept in memory.
+func (d *DB) Range(ctx context.Context, keys []string, st, et time.Time, step time.Duration, fn func(types.Row) error) error {
+	stepSeconds := int64(step.Seconds())
+	return d.View(func(txn *badger.Txn) error {
+		h := make(rangeHeap, 0, len(keys))
+		defer func() {
+			for _, c := range h {
+				c.it.Close()
+			}
+		}()
+		for i, k := range keys {
+			c := &rangeCursor{
+				index:  i,
+				prefix: keyPrefix(k),
+				end:    encodeKey(k, et),
+				step:   stepSeconds,
+			}
+			c.it = txn.NewIterator(badger.IteratorOptions{Prefix: c.prefix, PrefetchValues: true, PrefetchSize: 100})
+			c.it.Seek(encodeKey(k, st))
+			ok, err := c.next()
+			if err != nil || !ok {
+				c.it.Close()
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			h = append(h, c)
+		}
+		heap.Init(&h)
+		for len(h) > 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			c := h[0]
+			if err := fn(c.row); err != nil {
+				return err
+			}
+			ok, err := c.next()
+			if err != nil {
+				return err
+			}
+			if !ok {
+				heap.Pop(&h).(*rangeCursor).it.Close()
+				continue
+			}
+			heap.Fix(&h, 0)
+		}
+		return nil
+	})
+}
+
+// rangeCursor iterates over the rows of a key within a time range.
+type rangeCursor struct {
+	it     *badger.Iterator
+	index  int
+	prefix []byte
+	end    []byte
+
+	// If step is positive, only the first row of every
+	// step-long interval is returned.
+	step    int64
+	bucket  int64
+	started bool
+
+	row types.Row
+}
+
+// next reads the next row within the range to c.row.
+func (c *rangeCursor) next() (bool, error) {
+	for ; c.it.ValidForPrefix(c.prefix); c.it.Next() {
+		item := c.it.Item()
+		if bytes.Compare(item.Key(), c.end) > 0 {
+			return false, nil
+		}
+		k, t, ok := decodeKey(item.Key())
+		if !ok {
+			continue
+		}
+		if c.step > 0 {
+			bucket := truncate(t.Unix(), c.step)
+			if c.started && bucket == c.bucket {
+				continue
+			}
+			c.bucket = bucket
+		}
+		v, err := item.ValueCopy(nil)
+		if err != nil {
+			return false, err
+		}
+		c.started = true
+		c.row = types.Row{Key: k, Value: v, Timestamp: t}
+		c.it.Next()
+		return true, nil
+	}
+	return false, nil
+}
+
+// rangeHeap orders the cursors by the timestamp of the current row,
+// and then by the order of the keys.
+type rangeHeap []*rangeCursor
+
+func (h rangeHeap) Len() int { return len(h) }
+
+func (h rangeHeap) Less(i, j int) bool {
+	if ti, tj := h[i].row.Timestamp, h[j].row.Timestamp; !ti.Equal(tj) {
+		return ti.Before(tj)
+	}
+	return h[i].index < h[j].index
+}
+
+func (h rangeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *rangeHeap) Push(x interface{}) { *h = append(*h, x.(*rangeCursor)) }
+
+func (h *rangeHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	c := old[n-1]
+	*h = old[:n-1]
+	return c
+}
+
+// truncate returns the start of the interval the timestamp belongs to.
+func truncate(t, interval int64) int64 {
+	r := t % interval
+	if r < 0 {
+		r += interval
+	}
+	return t - r
+}
+
+func (d *DB) Scan(ctx context.Context, prefix string, fn func(types.Row) bool) error {
+	return d.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(prefix), PrefetchValues: true, PrefetchSize: 100})
+		defer it.Close()
+		var (
+			last types.Row
+			has  bool
+		)
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			row, ok, err := readRow(it.Item())
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+			if has && row.Key != last.Key && !fn(last) {
+				return nil
+			}
+			last, has = row, true
+		}
+		if has {
+			fn(last)
+		}
+		return nil
+	})
+}
+
+func (d *DB) Delete(ctx context.Context, keys ...string) error {
+	for _, k := range keys {
+		if err := d.deletePrefix(ctx, keyPrefix(k)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *DB) DeleteAt(_ context.Context, key string, ts ...time.Time) error {
+	if len(ts) == 0 {
+		return nil
+	}
+	batch := d.DB.NewWriteBatch()
+	defer batch.Cancel()
+	for _, t := range ts {
+		if err := batch.Delete(encodeKey(key, t)); err != nil {
+			return err
+		}
+	}
+	return batch.Flush()
+}
+
+func (d *DB) DeletePrefix(ctx context.Context, prefix string) error {
+	return d.deletePrefix(ctx, []byte(prefix))
+}
+
+func (d *DB) deletePrefix(ctx context.Context, p []byte) error {
+	var keys [][]byte
+	err := d.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: p})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			keys = append(keys, it.Item().KeyCopy(nil))
+		}
+		return nil
+	})
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	batch := d.DB.NewWriteBatch()
+	defer batch.Cancel()
+	for _, k := range keys {
+		if err = batch.Delete(k); err != nil {
+			return err
+		}
+	}
+	return batch.Flush()
+}
+
+// Size returns the size of LSM tree and value log files. Badger updates
+// the values once a minute; in-memory DBs report the estimated size of
+// all the rows instead.
+func (d *DB) Size(ctx context.Context) (int64, error) {
+	if d.inMemory {
+		var size int64
+		err := d.estimateSize(ctx, "", func(_ string, n int64) { size += n })
+		return size, err
+	}
+	lsm, vlog := d.DB.Size()
+	return lsm + vlog, nil
+}
+
+func (d *DB) PrefixSizes(ctx context.Context, prefix string, sep byte) (map[string]int64, error) {
+	sizes := make(map[string]int64)
+	err := d.estimateSize(ctx, prefix, func(k string, n int64) {
+		g := k[len(prefix):]
+		if i := strings.IndexByte(g, sep); i >= 0 {
+			g = g[:i]
+		}
+		sizes[g] += n
+	})
+	return sizes, err
+}
+
+// estimateSize calls fn with the estimated size of every row
+// of the keys with the given prefix.
+func (d *DB) estimateSize(ctx context.Context, prefix string, fn func(key string, size int64)) error {
+	return d.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.IteratorOptions{Prefix: []byte(prefix)})
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			item := it.Item()
+			if k, _, ok := decodeKey(item.Key()); ok {
+				fn(k, item.EstimatedSize())
+			}
+		}
+		return nil
+	})
+}
+
+func (d *DB) NewWriteBatch(context.Context) (types.WriteBatch, error) {
+	return &writeBatch{d.DB.NewWriteBatch()}, nil
+}
+
+func (d *DB) MaxBatchCount() int64 { return d.DB.MaxBatchCount() }
+
+func (d *DB) Close() error { return d.DB.Close() }
+
+type writeBatch struct{ *badger.WriteBatch }
+
+func (b *writeBatch) Append(r types.Row) error {
+	// The batch keeps references to the slices until flushed.
+	return b.Set(encodeKey(r.Key, r.Timestamp), append([]byte(nil), r.Value...))
+}
+
+func (b *writeBatch) Send() error { return b.Flush() }
+
+func (b *writeBatch) Abort() error {
+	b.Cancel()
+	return nil
+}
@@ -0,0 +1,16 @@
+package embedded_test
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	testing2 "github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+func TestEmbedded(t *testing.T) {
+	testing2.SetupLogging()
+
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Embedded Suite")
+}
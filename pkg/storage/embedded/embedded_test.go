@@ -0,0 +1,211 @@
+package embedded
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/types"
+	"github.com/pyroscope-io/pyroscope/pkg/testing"
+)
+
+var _ = Describe("embedded DB", func() {
+	var (
+		db  *DB
+		ctx = context.Background()
+	)
+
+	BeforeEach(func() {
+		var err error
+		db, err = Open(Options{InMemory: true})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(db.Close()).ToNot(HaveOccurred())
+	})
+
+	row := func(k string, v string, t time.Time) types.Row {
+		return types.Row{Key: k, Value: []byte(v), Timestamp: t}
+	}
+
+	Context("Get", func() {
+		It("returns the most recent row", func() {
+			Expect(db.Put(ctx,
+				row("foo", "1", testing.SimpleTime(10)),
+				row("foo", "3", testing.SimpleTime(30)),
+				row("foo", "2", testing.SimpleTime(20)),
+				row("foobar", "4", testing.SimpleTime(40)),
+			)).ToNot(HaveOccurred())
+
+			r, ok, err := db.Get(ctx, "foo")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(r).To(Equal(row("foo", "3", testing.SimpleTime(30))))
+
+			_, ok, err = db.Get(ctx, "fo")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ok).To(BeFalse())
+		})
+
+		It("returns the row at the given time", func() {
+			Expect(db.Put(ctx,
+				row("foo", "1", testing.SimpleTime(10)),
+				row("foo", "2", testing.SimpleTime(20)),
+			)).ToNot(HaveOccurred())
+
+			r, ok, err := db.GetAt(ctx, "foo", testing.SimpleTime(10))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(string(r.Value)).To(Equal("1"))
+
+			_, ok, err = db.GetAt(ctx, "foo", testing.SimpleTime(15))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Context("Range", func() {
+		BeforeEach(func() {
+			Expect(db.Put(ctx,
+				row("a", "a0", testing.SimpleTime(0)),
+				row("a", "a1", testing.SimpleTime(10)),
+				row("a", "a2", testing.SimpleTime(20)),
+				row("b", "b1", testing.SimpleTime(15)),
+				row("b", "b2", testing.SimpleTime(25)),
+				row("c", "c1", testing.SimpleTime(15)),
+			)).ToNot(HaveOccurred())
+		})
+
+		collect := func(step time.Duration) []string {
+			var values []string
+			err := db.Range(ctx, []string{"a", "b"}, testing.SimpleTime(10), testing.SimpleTime(25), step, func(r types.Row) error {
+				values = append(values, string(r.Value))
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			return values
+		}
+
+		It("returns rows ordered by time", func() {
+			Expect(collect(0)).To(Equal([]string{"a1", "b1", "a2", "b2"}))
+		})
+
+		It("returns the first row within an interval", func() {
+			Expect(collect(30 * time.Second)).To(Equal([]string{"a1", "b1"}))
+		})
+
+		It("keeps the timestamps of the rows within an interval", func() {
+			var rows []types.Row
+			err := db.Range(ctx, []string{"a", "b"}, testing.SimpleTime(10), testing.SimpleTime(25), 20*time.Second, func(r types.Row) error {
+				rows = append(rows, r)
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rows).To(Equal([]types.Row{
+				row("a", "a1", testing.SimpleTime(10)),
+				row("b", "b1", testing.SimpleTime(15)),
+				row("a", "a2", testing.SimpleTime(20)),
+				row("b", "b2", testing.SimpleTime(25)),
+			}))
+		})
+
+		It("returns rows with the same timestamp in the order of the keys", func() {
+			var values []string
+			err := db.Range(ctx, []string{"c", "b"}, testing.SimpleTime(15), testing.SimpleTime(15), 0, func(r types.Row) error {
+				values = append(values, string(r.Value))
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(values).To(Equal([]string{"c1", "b1"}))
+		})
+	})
+
+	Context("Scan", func() {
+		It("returns the most recent row of every key", func() {
+			Expect(db.Put(ctx,
+				row("l:a", "1", testing.SimpleTime(10)),
+				row("l:a", "2", testing.SimpleTime(20)),
+				row("l:ab", "3", testing.SimpleTime(10)),
+				row("v:a", "4", testing.SimpleTime(10)),
+			)).ToNot(HaveOccurred())
+
+			var rows []types.Row
+			Expect(db.Scan(ctx, "l:", func(r types.Row) bool {
+				rows = append(rows, r)
+				return true
+			})).ToNot(HaveOccurred())
+
+			Expect(rows).To(Equal([]types.Row{
+				row("l:a", "2", testing.SimpleTime(20)),
+				row("l:ab", "3", testing.SimpleTime(10)),
+			}))
+		})
+	})
+
+	Context("Delete", func() {
+		It("removes all rows of the key", func() {
+			Expect(db.Put(ctx,
+				row("foo", "1", testing.SimpleTime(10)),
+				row("foo", "2", testing.SimpleTime(20)),
+				row("foobar", "3", testing.SimpleTime(10)),
+			)).ToNot(HaveOccurred())
+
+			Expect(db.Delete(ctx, "foo")).ToNot(HaveOccurred())
+			_, ok, err := db.Get(ctx, "foo")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ok).To(BeFalse())
+			_, ok, err = db.Get(ctx, "foobar")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+
+			Expect(db.DeletePrefix(ctx, "foo")).ToNot(HaveOccurred())
+			_, ok, err = db.Get(ctx, "foobar")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ok).To(BeFalse())
+		})
+
+		It("removes rows of the key at the given time", func() {
+			Expect(db.Put(ctx,
+				row("foo", "1", testing.SimpleTime(10)),
+				row("foo", "2", testing.SimpleTime(20)),
+				row("foo", "3", testing.SimpleTime(30)),
+			)).ToNot(HaveOccurred())
+
+			Expect(db.DeleteAt(ctx, "foo", testing.SimpleTime(10), testing.SimpleTime(30))).ToNot(HaveOccurred())
+			var values []string
+			err := db.Range(ctx, []string{"foo"}, testing.SimpleTime(0), testing.SimpleTime(40), 0, func(r types.Row) error {
+				values = append(values, string(r.Value))
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(values).To(Equal([]string{"2"}))
+		})
+	})
+
+	Context("Size", func() {
+		It("returns the size of rows of the keys with the prefix by group", func() {
+			Expect(db.Put(ctx,
+				row("s:foo{a=b}", "1", testing.SimpleTime(10)),
+				row("s:foo{a=c}", "2", testing.SimpleTime(20)),
+				row("s:bar{}", "3", testing.SimpleTime(10)),
+				row("t:baz{}", "4", testing.SimpleTime(10)),
+			)).ToNot(HaveOccurred())
+
+			sizes, err := db.PrefixSizes(ctx, "s:", '{')
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sizes).To(HaveLen(2))
+			foo, bar := sizes["foo"], sizes["bar"]
+			Expect(bar).To(BeNumerically(">", 0))
+			Expect(foo).To(BeNumerically(">", bar))
+			sizes, err = db.PrefixSizes(ctx, "x:", '{')
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sizes).To(BeEmpty())
+			total, err := db.Size(ctx)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(total).To(BeNumerically(">", foo))
+		})
+	})
+})
@@ -1,6 +1,10 @@
 package heatmap
 
-import "time"
+import (
+	"math"
+	"sort"
+	"time"
+)
 
 type Heatmap struct {
 	// Values matrix contain values that indicate count of value occurrences,
@@ -47,3 +51,121 @@ type HeatmapColumn struct {
 	Values []uint64
 	Counts []uint64
 }
+
+// NewSketch creates an empty heatmap sketch for the given parameters.
+// The sketch keeps the distinct values observed within every time bucket,
+// therefore the heatmap can be built with any value boundaries.
+func NewSketch(p HeatmapParams) HeatmapSketch {
+	s := HeatmapSketch{HeatmapParams: p}
+	if p.TimeBuckets > 0 && p.EndTime.After(p.StartTime) {
+		s.Columns = make([]HeatmapColumn, p.TimeBuckets)
+	}
+	return s
+}
+
+// Add records value v observed at time t. Values observed
+// outside of the [StartTime:EndTime) range are ignored.
+func (s *HeatmapSketch) Add(t time.Time, v uint64) {
+	if len(s.Columns) == 0 || t.Before(s.StartTime) || !t.Before(s.EndTime) {
+		return
+	}
+	// The product of the duration in nanoseconds and the number
+	// of buckets may overflow int64, e.g. for long time ranges.
+	r := float64(t.Sub(s.StartTime)) / float64(s.EndTime.Sub(s.StartTime))
+	x := int64(r * float64(s.TimeBuckets))
+	if x >= s.TimeBuckets {
+		x = s.TimeBuckets - 1
+	}
+	s.Columns[x].add(v)
+}
+
+func (c *HeatmapColumn) add(v uint64) {
+	i := sort.Search(len(c.Values), func(i int) bool { return c.Values[i] >= v })
+	if i < len(c.Values) && c.Values[i] == v {
+		c.Counts[i]++
+		return
+	}
+	c.Values = append(c.Values, 0)
+	copy(c.Values[i+1:], c.Values[i:])
+	c.Values[i] = v
+	c.Counts = append(c.Counts, 0)
+	copy(c.Counts[i+1:], c.Counts[i:])
+	c.Counts[i] = 1
+}
+
+// Builder creates heatmaps from sketches.
+type Builder struct{}
+
+// BuildFromSketch creates a heatmap from the sketch. If MinValue and MaxValue
+// are not specified, the boundaries of the observed values are used. Nil is
+// returned if the sketch is empty or the heatmap dimensions are not set.
+func (Builder) BuildFromSketch(s HeatmapSketch) *Heatmap {
+	if len(s.Columns) == 0 || s.ValueBuckets <= 0 {
+		return nil
+	}
+	minValue, maxValue := s.MinValue, s.MaxValue
+	if maxValue == 0 {
+		var found bool
+		for _, c := range s.Columns {
+			if n := len(c.Values); n > 0 {
+				if !found || c.Values[0] < minValue {
+					minValue = c.Values[0]
+				}
+				if c.Values[n-1] > maxValue {
+					maxValue = c.Values[n-1]
+				}
+				found = true
+			}
+		}
+		if !found {
+			return nil
+		}
+		switch {
+		case s.MinValue != 0:
+			minValue = s.MinValue
+		case minValue > 0:
+			// Value buckets are left-open: (MinValue:MaxValue].
+			minValue--
+		}
+	}
+	if maxValue <= minValue {
+		return nil
+	}
+	h := Heatmap{
+		Values:       make([][]uint64, len(s.Columns)),
+		TimeBuckets:  s.TimeBuckets,
+		ValueBuckets: s.ValueBuckets,
+		StartTime:    s.StartTime,
+		EndTime:      s.EndTime,
+		MinValue:     minValue,
+		MaxValue:     maxValue,
+	}
+	span := float64(maxValue - minValue)
+	for x, c := range s.Columns {
+		h.Values[x] = make([]uint64, s.ValueBuckets)
+		for i, v := range c.Values {
+			if v <= minValue || v > maxValue {
+				continue
+			}
+			y := int64(math.Ceil(float64(v-minValue)/span*float64(s.ValueBuckets))) - 1
+			if y >= s.ValueBuckets {
+				y = s.ValueBuckets - 1
+			}
+			h.Values[x][y] += c.Counts[i]
+		}
+	}
+	for _, col := range h.Values {
+		for _, n := range col {
+			if n == 0 {
+				continue
+			}
+			if h.MinDepth == 0 || n < h.MinDepth {
+				h.MinDepth = n
+			}
+			if n > h.MaxDepth {
+				h.MaxDepth = n
+			}
+		}
+	}
+	return &h
+}
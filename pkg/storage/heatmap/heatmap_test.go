@@ -0,0 +1,28 @@
+package heatmap
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSketchAddLongRange(t *testing.T) {
+	st := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	s := NewSketch(HeatmapParams{
+		TimeBuckets: 1000,
+		StartTime:   st,
+		EndTime:     st.Add(365 * 24 * time.Hour),
+	})
+	s.Add(st, 1)
+	s.Add(st.Add(364*24*time.Hour), 2)
+	s.Add(s.EndTime.Add(-time.Nanosecond), 3)
+
+	if v := s.Columns[0].Values; len(v) != 1 || v[0] != 1 {
+		t.Errorf("Value was not added to the first bucket: %v", v)
+	}
+	if v := s.Columns[997].Values; len(v) != 1 || v[0] != 2 {
+		t.Errorf("Value was not added to the bucket 997: %v", v)
+	}
+	if v := s.Columns[999].Values; len(v) != 1 || v[0] != 3 {
+		t.Errorf("Value was not added to the last bucket: %v", v)
+	}
+}
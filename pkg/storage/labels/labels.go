@@ -5,129 +5,109 @@ import (
 	"strings"
 	"time"
 
-	"github.com/ClickHouse/clickhouse-go/v2"
+	lru "github.com/hashicorp/golang-lru"
+
 	"github.com/pyroscope-io/pyroscope/pkg/storage/types"
 )
 
 type Labels struct {
-	db types.ClickHouseDB
+	db types.DB
+
+	// stored holds the keys of the rows known to be present in the DB:
+	// a row is only written if it is not there yet, otherwise every put
+	// would add a new version of the row. The least recently used keys
+	// are evicted, and checked against the DB again on the next put.
+	stored *lru.Cache
 }
 
-func New(db types.ClickHouseDB) *Labels {
+// storedCacheSize is the number of stored row keys kept in memory.
+const storedCacheSize = 1 << 16
+
+func New(db types.DB) *Labels {
+	stored, _ := lru.New(storedCacheSize)
 	ll := &Labels{
-		db: db,
+		db:     db,
+		stored: stored,
 	}
 	return ll
 }
 
-func (ll *Labels) PutLabels(labels map[string]string) error {
-	batch, err := ll.db.NewWriteBatch("insert into " + ll.db.FQDN() + " values (?, ?, ?)")
-	if err != nil {
+func (ll *Labels) PutLabels(ctx context.Context, labels map[string]string) error {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
+	keys := make([]string, 0, 2*len(labels))
 	for k, v := range labels {
-		if err := batch.Append("l:"+k, nil, time.Now()); err != nil {
-			return err
-		}
-		if err := batch.Append("v:"+k+":"+v, nil, time.Now()); err != nil {
+		keys = append(keys, "l:"+k, "v:"+k+":"+v)
+	}
+	missing, err := ll.missing(ctx, keys)
+	if err != nil || len(missing) == 0 {
+		return err
+	}
+	batch, err := ll.db.NewWriteBatch(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, k := range missing {
+		if err = batch.Append(types.Row{Key: k, Timestamp: now}); err != nil {
+			_ = batch.Abort()
 			return err
 		}
 	}
-	return batch.Send()
+	if err = batch.Send(); err != nil {
+		return err
+	}
+	for _, k := range missing {
+		ll.stored.Add(k, struct{}{})
+	}
+	return nil
 }
 
-func (ll *Labels) Put(key, val string) {
-	kk := "l:" + key
-	kv := "v:" + key + ":" + val
-	// ks := "h:" + key + ":" + val + ":" + stree
-	err := ll.db.Update(func(conn clickhouse.Conn) error {
-		return conn.Exec(context.Background(), "insert into ? values (?, ?, ?)", ll.db.FQDN(), kk, nil, time.Now())
-	})
-	if err != nil {
-		// TODO: handle
-		panic(err)
-	}
-	err = ll.db.Update(func(conn clickhouse.Conn) error {
-		return conn.Exec(context.Background(), "insert into ? values (?, ?, ?)", ll.db.FQDN(), kv, nil, time.Now())
-	})
-	if err != nil {
-		// TODO: handle
-		panic(err)
+// missing returns the keys of the rows that are not present in the DB.
+func (ll *Labels) missing(ctx context.Context, keys []string) ([]string, error) {
+	var missing []string
+	for _, k := range keys {
+		if _, ok := ll.stored.Get(k); ok {
+			continue
+		}
+		_, ok, err := ll.db.Get(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			missing = append(missing, k)
+			continue
+		}
+		ll.stored.Add(k, struct{}{})
 	}
-	// err = ll.db.Update(func(txn *badger.Txn) error {
-	// 	return txn.SetEntry(badger.NewEntry([]byte(ks), []byte{}))
-	// })
-	// if err != nil {
-	// 	// TODO: handle
-	// 	panic(err)
-	// }
+	return missing, nil
 }
 
-type TableRow struct {
-	K         string    `db:"k" ch:"k"`
-	V         string    `db:"v" ch:"v"`
-	Timestamp time.Time `db:"timestamp" ch:"timestamp"`
+func (ll *Labels) Put(ctx context.Context, key, val string) error {
+	return ll.PutLabels(ctx, map[string]string{key: val})
 }
 
 //revive:disable-next-line:get-return A callback is fine
-func (ll *Labels) GetKeys(cb func(k string) bool) {
-	err := ll.db.View(func(conn clickhouse.Conn) error {
-		rows, err := conn.Query(context.Background(), "select k, v, max(timestamp) as timestamp from "+ll.db.FQDN()+"_all where k like '?%' group by (k,v)", "l:")
-		if err != nil {
-			return err
-		}
-		defer rows.Close()
-		for rows.Next() {
-			var kv TableRow
-			if err := rows.ScanStruct(&kv); err != nil {
-				return err
-			}
-			shouldContinue := cb(string(kv.V[2:]))
-			if !shouldContinue {
-				return nil
-			}
-		}
-		return nil
+func (ll *Labels) GetKeys(ctx context.Context, cb func(k string) bool) error {
+	return ll.db.Scan(ctx, "l:", func(row types.Row) bool {
+		return cb(row.Key[2:])
 	})
-	if err != nil {
-		// TODO: handle
-		panic(err)
-	}
 }
 
 // Delete removes key value label pair from the storage.
 // If the pair can not be found, no error is returned.
-func (ll *Labels) Delete(key, value string) error {
-	return ll.db.Update(func(conn clickhouse.Conn) error {
-		return conn.Exec(context.Background(), "delete from "+ll.db.FQDN()+" where k = ?", "v:"+key+":"+value)
-	})
+func (ll *Labels) Delete(ctx context.Context, key, value string) error {
+	k := "v:" + key + ":" + value
+	err := ll.db.Delete(ctx, k)
+	ll.stored.Remove(k)
+	return err
 }
 
 //revive:disable-next-line:get-return A callback is fine
-func (ll *Labels) GetValues(key string, cb func(v string) bool) {
-	err := ll.db.View(func(conn clickhouse.Conn) error {
-		rows, err := conn.Query(context.Background(), "select k, v, max(timestamp) as timestamp from "+ll.db.FQDN()+"_all where k like ? group by (k,v)", "v:"+key+":"+"%")
-		if err != nil {
-			return err
-		}
-		defer rows.Close()
-		for rows.Next() {
-			var kv TableRow
-			if err := rows.ScanStruct(&kv); err != nil {
-				return err
-			}
-			k := kv.K
-			ks := string(k)
-			li := strings.LastIndex(ks, ":") + 1
-			shouldContinue := cb(ks[li:])
-			if !shouldContinue {
-				return nil
-			}
-		}
-		return nil
+func (ll *Labels) GetValues(ctx context.Context, key string, cb func(v string) bool) error {
+	prefix := "v:" + key + ":"
+	return ll.db.Scan(ctx, prefix, func(row types.Row) bool {
+		return cb(strings.TrimPrefix(row.Key, prefix))
 	})
-	if err != nil {
-		// TODO: handle
-		panic(err)
-	}
 }
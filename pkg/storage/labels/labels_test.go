@@ -0,0 +1,102 @@
+package labels_test
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/embedded"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/labels"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/types"
+)
+
+var _ = Describe("labels", func() {
+	var (
+		db *embedded.DB
+		ll *labels.Labels
+	)
+
+	BeforeEach(func() {
+		var err error
+		db, err = embedded.Open(embedded.Options{InMemory: true})
+		Expect(err).ToNot(HaveOccurred())
+		ll = labels.New(db)
+	})
+
+	AfterEach(func() {
+		Expect(db.Close()).ToNot(HaveOccurred())
+	})
+
+	collect := func(get func(func(string) bool) error) ([]string, error) {
+		var r []string
+		err := get(func(v string) bool {
+			r = append(r, v)
+			return true
+		})
+		return r, err
+	}
+
+	It("returns label keys and values", func() {
+		ctx := context.Background()
+		Expect(ll.PutLabels(ctx, map[string]string{"__name__": "app", "foo": "bar"})).ToNot(HaveOccurred())
+		Expect(ll.Put(ctx, "foo", "baz")).ToNot(HaveOccurred())
+
+		Expect(collect(func(cb func(string) bool) error {
+			return ll.GetKeys(ctx, cb)
+		})).To(Equal([]string{"__name__", "foo"}))
+		Expect(collect(func(cb func(string) bool) error {
+			return ll.GetValues(ctx, "foo", cb)
+		})).To(Equal([]string{"bar", "baz"}))
+
+		Expect(ll.Delete(ctx, "foo", "bar")).ToNot(HaveOccurred())
+		Expect(collect(func(cb func(string) bool) error {
+			return ll.GetValues(ctx, "foo", cb)
+		})).To(Equal([]string{"baz"}))
+	})
+
+	It("writes a label pair only once", func() {
+		ctx := context.Background()
+		l := map[string]string{"__name__": "app", "foo": "bar"}
+		Expect(ll.PutLabels(ctx, l)).ToNot(HaveOccurred())
+		Expect(ll.PutLabels(ctx, l)).ToNot(HaveOccurred())
+		// A new instance has to check the DB.
+		Expect(labels.New(db).PutLabels(ctx, l)).ToNot(HaveOccurred())
+
+		versions := func(k string) int {
+			var n int
+			err := db.Range(ctx, []string{k}, time.Time{}, time.Now(), 0, func(types.Row) error {
+				n++
+				return nil
+			})
+			Expect(err).ToNot(HaveOccurred())
+			return n
+		}
+		Expect(versions("l:foo")).To(Equal(1))
+		Expect(versions("v:foo:bar")).To(Equal(1))
+
+		Expect(ll.Delete(ctx, "foo", "bar")).ToNot(HaveOccurred())
+		Expect(ll.PutLabels(ctx, l)).ToNot(HaveOccurred())
+		Expect(collect(func(cb func(string) bool) error {
+			return ll.GetValues(ctx, "foo", cb)
+		})).To(Equal([]string{"bar"}))
+	})
+
+	It("returns an error if the context is canceled", func() {
+		ctx := context.Background()
+		Expect(ll.PutLabels(ctx, map[string]string{"foo": "bar"})).ToNot(HaveOccurred())
+
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		Expect(ll.PutLabels(ctx, map[string]string{"foo": "baz"})).To(MatchError(context.Canceled))
+		_, err := collect(func(cb func(string) bool) error {
+			return ll.GetKeys(ctx, cb)
+		})
+		Expect(err).To(MatchError(context.Canceled))
+		_, err = collect(func(cb func(string) bool) error {
+			return ll.GetValues(ctx, "foo", cb)
+		})
+		Expect(err).To(MatchError(context.Canceled))
+	})
+})
@@ -12,6 +12,7 @@ type metrics struct {
 	getTotal prometheus.Counter
 
 	retentionTaskDuration prometheus.Summary
+	retentionRemovedTotal *prometheus.CounterVec
 	evictionTaskDuration  prometheus.Summary
 	writeBackTaskDuration prometheus.Summary
 
@@ -51,6 +52,10 @@ func newMetrics(r prometheus.Registerer) *metrics {
 			Help:       "duration of old data deletion",
 			Objectives: map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001},
 		}),
+		retentionRemovedTotal: promauto.With(r).NewCounterVec(prometheus.CounterOpts{
+			Name: "pyroscope_storage_retention_removed_total",
+			Help: "number of segments, trees, and labels removed from storage based on the retention policy",
+		}, name),
 		evictionTaskDuration: promauto.With(r).NewSummary(prometheus.SummaryOpts{
 			Name:       "pyroscope_storage_eviction_task_duration_seconds",
 			Help:       "duration of evictions (triggered when there's memory pressure)",
@@ -2,32 +2,61 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"runtime/debug"
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/wal"
 )
 
 type IngestionQueue struct {
 	logger logrus.FieldLogger
 	putter Putter
 
-	wg    sync.WaitGroup
-	queue chan *PutInput
-	stop  chan struct{}
+	wg     sync.WaitGroup
+	queue  chan queueItem
+	stop   chan struct{}
+	cancel context.CancelFunc
+
+	// wal, if set, persists inputs before they are queued.
+	// Inputs are kept in inflight until they are read from
+	// the log to avoid decoding them.
+	wal        *wal.WAL
+	inflightMu sync.Mutex
+	inflight   map[uint64]*PutInput
+	queueSize  int
 
 	discardedTotal prometheus.Counter
 }
 
+type queueItem struct {
+	seq   uint64
+	input *PutInput
+}
+
 const (
 	defaultQueueSize = 100
 	defaultWorkers   = 1
+
+	// Inputs read from the WAL are retried if they fail to be put.
+	// Inputs that still fail are kept in the log and put again once
+	// the queue is created again.
+	maxPutRetries   = 3
+	putRetryBackoff = time.Second
 )
 
-func NewIngestionQueue(logger logrus.FieldLogger, putter Putter, r prometheus.Registerer, c *Config) *IngestionQueue {
+var errPanicRecovered = errors.New("panic recovered")
+
+// NewIngestionQueue creates a queue of the putter. If w is not nil, inputs
+// are written to the log before Put returns, and the inputs that have not
+// been put before the queue is stopped are put after it is created again.
+func NewIngestionQueue(logger logrus.FieldLogger, putter Putter, r prometheus.Registerer, c *Config, w *wal.WAL) *IngestionQueue {
 	queueSize := c.queueSize
 	if queueSize == 0 {
 		queueSize = defaultQueueSize
@@ -38,10 +67,13 @@ func NewIngestionQueue(logger logrus.FieldLogger, putter Putter, r prometheus.Re
 	}
 
 	q := IngestionQueue{
-		logger: logger,
-		putter: putter,
-		queue:  make(chan *PutInput, queueSize),
-		stop:   make(chan struct{}),
+		logger:    logger,
+		putter:    putter,
+		queue:     make(chan queueItem, queueSize),
+		stop:      make(chan struct{}),
+		wal:       w,
+		inflight:  make(map[uint64]*PutInput),
+		queueSize: queueSize,
 
 		discardedTotal: promauto.With(r).NewCounter(prometheus.CounterOpts{
 			Name: "pyroscope_ingestion_queue_discarded_total",
@@ -53,38 +85,141 @@ func NewIngestionQueue(logger logrus.FieldLogger, putter Putter, r prometheus.Re
 	for i := 0; i < queueWorkers; i++ {
 		go q.runQueueWorker()
 	}
+	if q.wal != nil {
+		var ctx context.Context
+		ctx, q.cancel = context.WithCancel(context.Background())
+		q.wg.Add(1)
+		go q.runWALReader(ctx)
+	}
 
 	return &q
 }
 
 func (s *IngestionQueue) Stop() {
 	close(s.stop)
+	if s.cancel != nil {
+		s.cancel()
+	}
 	s.wg.Wait()
+	if s.wal != nil {
+		if err := s.wal.Close(); err != nil {
+			s.logger.WithError(err).Error("failed to close ingestion queue WAL")
+		}
+	}
 }
 
+// Put queues the input. If the putter is a PutChecker, the input is checked
+// before it is queued, and the check error is returned.
 func (s *IngestionQueue) Put(ctx context.Context, input *PutInput) error {
+	c, ok := s.putter.(PutChecker)
+	if ok {
+		if err := c.CheckPut(ctx, input); err != nil {
+			s.discardedTotal.Inc()
+			return err
+		}
+	}
+	queued, err := s.put(ctx, input)
+	if !queued && ok {
+		c.ReleasePut(input)
+	}
+	return err
+}
+
+// put queues the input and reports whether it has been queued.
+func (s *IngestionQueue) put(ctx context.Context, input *PutInput) (bool, error) {
+	if s.wal != nil {
+		return s.putWAL(ctx, input)
+	}
 	select {
 	case <-ctx.Done():
 	case <-s.stop:
-	case s.queue <- input:
+	case s.queue <- queueItem{input: input}:
 		// Once input is queued, context cancellation is ignored.
-		return nil
+		return true, nil
 	default:
 		// Drop data if the queue is full.
 	}
 	s.discardedTotal.Inc()
-	return nil
+	return false, nil
+}
+
+func (s *IngestionQueue) putWAL(ctx context.Context, input *PutInput) (bool, error) {
+	select {
+	case <-ctx.Done():
+		s.discardedTotal.Inc()
+		return false, ctx.Err()
+	case <-s.stop:
+		s.discardedTotal.Inc()
+		return false, errClosed
+	default:
+	}
+	b, err := encodePutInput(input)
+	if err != nil {
+		return false, err
+	}
+	// The lock is held while writing, so that the reader
+	// can't get the record before it is added to inflight.
+	s.inflightMu.Lock()
+	seq, err := s.wal.Write(b)
+	if err == nil && len(s.inflight) < s.queueSize {
+		s.inflight[seq] = input
+	}
+	s.inflightMu.Unlock()
+	switch {
+	case err == nil:
+	case errors.Is(err, wal.ErrFull):
+		s.discardedTotal.Inc()
+		return false, fmt.Errorf("%w: ingestion queue WAL: %v", errOutOfSpace, err)
+	case errors.Is(err, wal.ErrClosed):
+		s.discardedTotal.Inc()
+		return false, errClosed
+	default:
+		return false, fmt.Errorf("writing to WAL: %w", err)
+	}
+	// The record is written and will be put even if it can't be synced.
+	if err = s.wal.Sync(seq); err != nil {
+		return true, fmt.Errorf("syncing WAL: %w", err)
+	}
+	return true, nil
+}
+
+// runWALReader queues inputs read from the log.
+func (s *IngestionQueue) runWALReader(ctx context.Context) {
+	defer s.wg.Done()
+	for {
+		seq, data, err := s.wal.Next(ctx)
+		if err != nil {
+			if ctx.Err() == nil && !errors.Is(err, wal.ErrClosed) {
+				s.logger.WithError(err).Error("failed to read ingestion queue WAL")
+			}
+			return
+		}
+		s.inflightMu.Lock()
+		input, ok := s.inflight[seq]
+		delete(s.inflight, seq)
+		s.inflightMu.Unlock()
+		if !ok {
+			if input, err = decodePutInput(data); err != nil {
+				s.logger.WithError(err).Error("invalid ingestion queue WAL record")
+				s.wal.Ack(seq)
+				continue
+			}
+		}
+		select {
+		case s.queue <- queueItem{seq: seq, input: input}:
+		case <-s.stop:
+			return
+		}
+	}
 }
 
 func (s *IngestionQueue) runQueueWorker() {
 	defer s.wg.Done()
 	for {
 		select {
-		case input, ok := <-s.queue:
-			if ok {
-				if err := s.safePut(input); err != nil {
-					s.logger.WithField("key", input.Key.Normalized()).WithError(err).Error("error happened while ingesting data")
-				}
+		case item := <-s.queue:
+			if err := s.putItem(item); err != nil {
+				s.logger.WithField("key", item.input.Key.Normalized()).WithError(err).Error("error happened while ingesting data")
 			}
 		case <-s.stop:
 			return
@@ -92,10 +227,44 @@ func (s *IngestionQueue) runQueueWorker() {
 	}
 }
 
+// putItem puts the queued input. A record read from the WAL is only
+// acknowledged once the input is put, or if it can't be put at all.
+func (s *IngestionQueue) putItem(item queueItem) error {
+	err := s.safePut(item.input)
+	if s.wal == nil {
+		return err
+	}
+	for i := 0; i < maxPutRetries && isRetryablePutError(err); i++ {
+		select {
+		case <-s.stop:
+			return err
+		case <-time.After(putRetryBackoff << i):
+		}
+		err = s.safePut(item.input)
+	}
+	if !isRetryablePutError(err) {
+		s.wal.Ack(item.seq)
+	}
+	return err
+}
+
+// isRetryablePutError reports whether the put may succeed if retried:
+// inputs rejected by the storage would be rejected again.
+func isRetryablePutError(err error) bool {
+	switch {
+	case err == nil,
+		errors.Is(err, errPanicRecovered),
+		errors.Is(err, errRetention),
+		IsCardinalityLimitError(err):
+		return false
+	}
+	return true
+}
+
 func (s *IngestionQueue) safePut(input *PutInput) (err error) {
 	defer func() {
 		if r := recover(); r != nil {
-			err = fmt.Errorf("panic recovered: %v; %v", r, string(debug.Stack()))
+			err = fmt.Errorf("%w: %v; %v", errPanicRecovered, r, string(debug.Stack()))
 		}
 	}()
 	// TODO(kolesnikovae): It's better to derive a context that is cancelled on Stop.
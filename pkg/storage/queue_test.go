@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/wal"
+)
+
+type blockingPutter struct {
+	sync.Mutex
+	block chan struct{}
+	keys  []string
+}
+
+func (p *blockingPutter) Put(_ context.Context, pi *PutInput) error {
+	<-p.block
+	p.Lock()
+	defer p.Unlock()
+	p.keys = append(p.keys, pi.Key.Normalized())
+	return nil
+}
+
+func (p *blockingPutter) putKeys() []string {
+	p.Lock()
+	defer p.Unlock()
+	return append([]string(nil), p.keys...)
+}
+
+// failingPutter fails to put the first n inputs.
+type failingPutter struct {
+	blockingPutter
+	failures int
+}
+
+func (p *failingPutter) Put(ctx context.Context, pi *PutInput) error {
+	p.Lock()
+	if p.failures > 0 {
+		p.failures--
+		p.Unlock()
+		return errors.New("put failed")
+	}
+	p.Unlock()
+	return p.blockingPutter.Put(ctx, pi)
+}
+
+var _ = Describe("ingestion queue WAL", func() {
+	var dir string
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+	})
+
+	input := func(app string) *PutInput {
+		t := tree.New()
+		t.Insert([]byte("a;b"), 1)
+		return &PutInput{
+			StartTime:       time.Unix(10, 0),
+			EndTime:         time.Unix(20, 0),
+			Key:             segment.NewKey(map[string]string{"__name__": app}),
+			Val:             t,
+			SpyName:         "gospy",
+			SampleRate:      100,
+			Units:           metadata.SamplesUnits,
+			AggregationType: metadata.SumAggregationType,
+			SampleType:      "cpu",
+		}
+	}
+
+	newQueueWithOptions := func(p Putter, opts wal.Options) *IngestionQueue {
+		w, err := wal.Open(logrus.StandardLogger(), prometheus.NewRegistry(), dir, opts)
+		Expect(err).ToNot(HaveOccurred())
+		return NewIngestionQueue(logrus.StandardLogger(), p, prometheus.NewRegistry(), new(Config), w)
+	}
+
+	newQueue := func(p Putter) *IngestionQueue {
+		return newQueueWithOptions(p, wal.Options{})
+	}
+
+	It("encodes put inputs", func() {
+		b, err := encodePutInput(input("app.cpu"))
+		Expect(err).ToNot(HaveOccurred())
+		pi, err := decodePutInput(b)
+		Expect(err).ToNot(HaveOccurred())
+		expected := input("app.cpu")
+		Expect(pi.Key.Normalized()).To(Equal(expected.Key.Normalized()))
+		Expect(pi.Val.String()).To(Equal(expected.Val.String()))
+		pi.Key, pi.Val, expected.Key, expected.Val = nil, nil, nil, nil
+		Expect(pi.StartTime.Equal(expected.StartTime)).To(BeTrue())
+		Expect(pi.EndTime.Equal(expected.EndTime)).To(BeTrue())
+		pi.StartTime, pi.EndTime, expected.StartTime, expected.EndTime = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+		Expect(pi).To(Equal(expected))
+	})
+
+	It("replays inputs after a crash", func() {
+		crashed := &blockingPutter{block: make(chan struct{})}
+		defer close(crashed.block)
+		q := newQueue(crashed)
+		Expect(q.Put(context.Background(), input("a.cpu"))).To(Succeed())
+		Expect(q.Put(context.Background(), input("b.cpu"))).To(Succeed())
+
+		p := &blockingPutter{block: make(chan struct{})}
+		close(p.block)
+		q = newQueue(p)
+		Eventually(p.putKeys).Should(Equal([]string{"a.cpu{}", "b.cpu{}"}))
+		q.Stop()
+	})
+
+	It("returns an error if the WAL is full", func() {
+		p := &blockingPutter{block: make(chan struct{})}
+		defer close(p.block)
+		q := newQueueWithOptions(p, wal.Options{MaxSize: 1})
+		defer q.Stop()
+		err := q.Put(context.Background(), input("a.cpu"))
+		Expect(IsOutOfSpaceError(err)).To(BeTrue())
+	})
+
+	It("retries inputs that failed to be put", func() {
+		p := &failingPutter{blockingPutter: blockingPutter{block: make(chan struct{})}, failures: 1}
+		close(p.block)
+		q := newQueue(p)
+		defer q.Stop()
+		Expect(q.Put(context.Background(), input("a.cpu"))).To(Succeed())
+		Eventually(p.putKeys, 5*time.Second).Should(Equal([]string{"a.cpu{}"}))
+	})
+
+	It("replays inputs that failed to be put", func() {
+		p := &failingPutter{blockingPutter: blockingPutter{block: make(chan struct{})}, failures: maxPutRetries + 1}
+		close(p.block)
+		q := newQueue(p)
+		Expect(q.Put(context.Background(), input("a.cpu"))).To(Succeed())
+		Eventually(func() int {
+			p.Lock()
+			defer p.Unlock()
+			return p.failures
+		}, 10*time.Second).Should(BeZero())
+		q.Stop()
+		Expect(p.putKeys()).To(BeEmpty())
+
+		p = &failingPutter{blockingPutter: blockingPutter{block: make(chan struct{})}}
+		close(p.block)
+		q = newQueue(p)
+		Eventually(p.putKeys).Should(Equal([]string{"a.cpu{}"}))
+		q.Stop()
+	})
+})
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/util/varint"
+)
+
+// putInputFormatV1 is the version of PutInput WAL records.
+const putInputFormatV1 = 1
+
+func encodePutInput(pi *PutInput) ([]byte, error) {
+	var buf bytes.Buffer
+	vw := varint.NewWriter()
+	_, _ = vw.Write(&buf, putInputFormatV1)
+	_, _ = vw.Write(&buf, uint64(pi.StartTime.UnixNano()))
+	_, _ = vw.Write(&buf, uint64(pi.EndTime.UnixNano()))
+	_, _ = vw.Write(&buf, uint64(pi.SampleRate))
+	for _, s := range []string{
+		pi.Key.Normalized(),
+		pi.SpyName,
+		string(pi.Units),
+		string(pi.AggregationType),
+		pi.SampleType,
+	} {
+		_, _ = vw.Write(&buf, uint64(len(s)))
+		buf.WriteString(s)
+	}
+	// The tree goes last: it's read to the end.
+	if err := pi.Val.SerializeTruncateNoDict(-1, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodePutInput(b []byte) (*PutInput, error) {
+	r := bufio.NewReader(bytes.NewReader(b))
+	v, err := varint.Read(r)
+	if err != nil {
+		return nil, err
+	}
+	if v != putInputFormatV1 {
+		return nil, fmt.Errorf("unknown format version %d", v)
+	}
+	var n [3]uint64
+	for i := range n {
+		if n[i], err = varint.Read(r); err != nil {
+			return nil, err
+		}
+	}
+	var s [5]string
+	for i := range s {
+		l, err := varint.Read(r)
+		if err != nil {
+			return nil, err
+		}
+		if l > uint64(len(b)) {
+			return nil, fmt.Errorf("invalid string length %d", l)
+		}
+		p := make([]byte, l)
+		if _, err = io.ReadFull(r, p); err != nil {
+			return nil, err
+		}
+		s[i] = string(p)
+	}
+	pi := PutInput{
+		StartTime:       time.Unix(0, int64(n[0])),
+		EndTime:         time.Unix(0, int64(n[1])),
+		SampleRate:      uint32(n[2]),
+		SpyName:         s[1],
+		Units:           metadata.Units(s[2]),
+		AggregationType: metadata.AggregationType(s[3]),
+		SampleType:      s[4],
+	}
+	if pi.Key, err = segment.ParseKey(s[0]); err != nil {
+		return nil, err
+	}
+	if pi.Val, err = tree.DeserializeNoDict(r); err != nil {
+		return nil, err
+	}
+	return &pi, nil
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
+)
+
+// quota keeps the outcome of the latest storage size check:
+// ingestion is refused while any of the limits is exceeded.
+type quota struct {
+	sync.RWMutex
+	db   string              // Name of a DB exceeding the quota.
+	apps map[string]struct{} // Applications exceeding the quota.
+}
+
+// IsOutOfSpaceError reports whether the ingestion request is rejected
+// because the storage is running out of disk space or exceeds the quota.
+func IsOutOfSpaceError(err error) bool {
+	return errors.Is(err, errOutOfSpace)
+}
+
+func (s *Storage) checkQuota(appName string) error {
+	s.quota.RLock()
+	defer s.quota.RUnlock()
+	if s.quota.db != "" {
+		return fmt.Errorf("%w: %s db size exceeds %v", errOutOfSpace, s.quota.db, s.config.quota.DB)
+	}
+	if _, ok := s.quota.apps[appName]; ok {
+		return fmt.Errorf("%w: application size exceeds %v", errOutOfSpace, s.config.quota.App)
+	}
+	return nil
+}
+
+func (s *Storage) quotaTask() {
+	if s.config.quota.DB == 0 && s.config.quota.App == 0 {
+		return
+	}
+	s.withContext(func(ctx context.Context) {
+		db := s.dbOverQuota()
+		apps, err := s.appsOverQuota(ctx)
+		if err != nil {
+			s.logger.WithError(err).Warn("failed to calculate applications size")
+			return
+		}
+		s.quota.Lock()
+		s.quota.db, s.quota.apps = db, apps
+		s.quota.Unlock()
+	})
+}
+
+func (s *Storage) dbOverQuota() string {
+	if s.config.quota.DB == 0 {
+		return ""
+	}
+	for _, d := range s.databases() {
+		if d.Size() > s.config.quota.DB {
+			return d.Name()
+		}
+	}
+	return ""
+}
+
+func (s *Storage) appsOverQuota(ctx context.Context) (map[string]struct{}, error) {
+	if s.config.quota.App == 0 {
+		return nil, nil
+	}
+	sizes, err := s.appSizes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	apps := make(map[string]struct{})
+	for appName, size := range sizes {
+		if size > s.config.quota.App {
+			apps[appName] = struct{}{}
+		}
+	}
+	return apps, nil
+}
+
+// appSizes returns the approximate size of segments, trees, dictionaries,
+// and exemplars of every application. Each DB is scanned only once.
+func (s *Storage) appSizes(ctx context.Context) (map[string]bytesize.ByteSize, error) {
+	prefixes := []struct {
+		db     DBWithCache
+		prefix string
+		sep    byte
+	}{
+		{s.segments, segmentPrefix.String(), '{'},
+		{s.trees, treePrefix.String(), '{'},
+		{s.dicts, dictionaryPrefix.String(), '{'},
+		{s.exemplars.db, exemplarDataPrefix.String(), ':'},
+	}
+	sizes := make(map[string]bytesize.ByteSize)
+	for _, p := range prefixes {
+		m, err := p.db.DBInstance().PrefixSizes(ctx, p.prefix, p.sep)
+		if err != nil {
+			return nil, err
+		}
+		for appName, n := range m {
+			sizes[appName] += bytesize.ByteSize(n)
+		}
+	}
+	return sizes, nil
+}
@@ -5,14 +5,15 @@ import (
 	"errors"
 	"time"
 
-	"github.com/dgraph-io/badger/v2"
 	"github.com/prometheus/client_golang/prometheus"
 
+	"github.com/pyroscope-io/pyroscope/pkg/storage/cache"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/dimension"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
 )
 
-const defaultBatchSize = 512000 // 1K items
+// defaultBatchSize is the max number of exemplars removed at once.
+const defaultBatchSize = 1 << 10 // 1K items
 
 func (s *Storage) enforceRetentionPolicy(ctx context.Context, rp *segment.RetentionPolicy) {
 	observer := prometheus.ObserverFunc(s.metrics.retentionTaskDuration.Observe)
@@ -20,7 +21,7 @@ func (s *Storage) enforceRetentionPolicy(ctx context.Context, rp *segment.Retent
 	defer timer.ObserveDuration()
 
 	s.logger.Debug("enforcing retention policy")
-	err := s.iterateOverAllSegments(func(k *segment.Key) error {
+	err := s.iterateOverAllSegments(ctx, func(k *segment.Key) error {
 		return s.deleteSegmentData(ctx, k, rp)
 	})
 
@@ -33,219 +34,140 @@ func (s *Storage) enforceRetentionPolicy(ctx context.Context, rp *segment.Retent
 	}
 }
 
+// deleteSegmentData removes the segment nodes outside the retention period
+// along with their trees and dictionaries.
+//
+// Every segment version is stored separately: a version that has no nodes
+// left is removed entirely, otherwise it is rewritten in place. Once the last
+// version is removed, the segment is deleted together with the dimensions and
+// labels that are not referenced anymore. Segment rollups are handled the
+// same way, and are deleted along with the segment.
 func (s *Storage) deleteSegmentData(ctx context.Context, k *segment.Key, rp *segment.RetentionPolicy) error {
 	sk := k.SegmentKey()
-	cached, ok := s.segments.Lookup(sk)
-	if !ok {
+	keys := []string{sk}
+	for _, r := range rollupResolutions {
+		keys = append(keys, rollupKey(sk, r))
+	}
+	// A segment version can't have nodes before the boundary,
+	// if it has been written after it.
+	versions, err := s.segments.LookupByKeys(keys, minRetentionTime, maxRetentionTime(rp), 0)
+	if err != nil {
+		return err
+	}
+	var deleted, trees int
+	for _, key := range keys {
+		d, t, err := s.deleteSegmentVersions(ctx, key, versions[key], rp)
+		trees += t
+		if err != nil {
+			return err
+		}
+		if key == sk {
+			deleted = d
+		}
+	}
+	s.metrics.retentionRemovedTotal.WithLabelValues("trees").Add(float64(trees))
+	s.metrics.retentionRemovedTotal.WithLabelValues("segments").Add(float64(deleted))
+	if deleted == 0 || deleted < len(versions[sk]) {
 		return nil
 	}
 
-	// Instead of removing every tree in an individual transaction for each
-	// of them, blocking the segment for a long time, we remember them and
-	// drop in batches after the segment is released.
-	//
-	// To avoid a potential inconsistency when DeleteNodesBefore fails in the
-	// process, trees should be removed first. Only after successful commit
-	// segment nodes can be safely removed to guaranty idempotency.
-
-	// TODO(kolesnikovae):
-	//  There is a better way of removing these trees: we could calculate
-	//  non-overlapping prefixes for depth levels, and drop the data
-	//  (both in cache and on disk) using these prefixes.
-	//  Remaining trees (with overlapping prefixes) would be removed
-	//  in batches. That would be especially efficient in cases when data
-	//  removed for a very long period. For example, when retention-period
-	//  is enabled for the first time on a server with historical data.
-
-	type segmentNode struct {
-		depth int
-		time  int64
+	// Make sure there are no versions written after the boundary.
+	if err = s.segments.CacheInstance().Sync(); err != nil {
+		return err
 	}
-
-	nodes := make([]segmentNode, 0)
-	seg := cached.(*segment.Segment)
-	deleted, err := seg.WalkNodesToDelete(rp, func(d int, t time.Time) error {
-		nodes = append(nodes, segmentNode{d, t.Unix()})
-		return nil
-	})
-	if err != nil {
+	_, ok, err := s.segments.DBInstance().Get(ctx, segmentPrefix.String()+sk)
+	if err != nil || ok {
 		return err
 	}
+	removed, err := s.deleteSegmentAndRelatedData(ctx, k)
+	s.metrics.retentionRemovedTotal.WithLabelValues("labels").Add(float64(removed))
+	return err
+}
 
-	if deleted {
-		return s.deleteSegmentAndRelatedData(k)
+// deleteSegmentVersions removes the nodes outside the retention period from
+// the segment versions. The call returns the number of removed versions and
+// trees.
+//
+// Rows of the key are removed with a single call per table: every delete
+// is a mutation of the table, which is expensive. A version is only
+// rewritten, if it has nodes to remove.
+func (s *Storage) deleteSegmentVersions(ctx context.Context, sk string, versions []cache.Version, rp *segment.RetentionPolicy) (deleted, trees int, err error) {
+	var expired []time.Time
+	modified := make([]cache.Version, 0, len(versions))
+	for _, v := range versions {
+		if err = ctx.Err(); err != nil {
+			return 0, 0, err
+		}
+		var nodes int
+		ok, err := v.Value.(*segment.Segment).WalkNodesToDelete(rp, func(int, time.Time) error {
+			nodes++
+			return nil
+		})
+		switch {
+		case err != nil:
+			return 0, 0, err
+		case ok:
+			expired = append(expired, v.Time)
+		case nodes > 0:
+			modified = append(modified, v)
+		}
 	}
 
-	return nil
-	//var removed int
-	//batch := s.trees.NewWriteBatch()
-	//defer func() {
-	//	batch.Cancel()
-	//}()
-	//
-	//for _, n := range nodes {
-	//	treeKey := segment.TreeKey(sk, n.depth, n.time)
-	//	s.trees.Discard(treeKey)
-	//	switch err = batch.Delete(treePrefix.key(treeKey)); {
-	//	case err == nil:
-	//	case errors.Is(err, badger.ErrKeyNotFound):
-	//		continue
-	//	default:
-	//		return err
-	//	}
-	//	// It is not possible to make size estimation without reading
-	//	// the item. Therefore, the call does not report reclaimed space.
-	//	if removed++; removed%defaultBatchSize == 0 {
-	//		if err = batch.Flush(); err != nil {
-	//			return err
-	//		}
-	//		select {
-	//		default:
-	//			batch = s.trees.NewWriteBatch()
-	//		case <-ctx.Done():
-	//			return ctx.Err()
-	//		}
-	//	}
-	//}
-	//
-	//// Flush remaining items, if any: it's important to make sure
-	//// all trees were removed before deleting segment nodes - see
-	//// note on a potential inconsistency above.
-	//if removed%defaultBatchSize != 0 {
-	//	if err = batch.Flush(); err != nil {
-	//		return err
-	//	}
-	//}
-	//
-	//_, err = seg.DeleteNodesBefore(rp)
-	//return err
+	// A version tree holds the data of all the version nodes, and is only
+	// removed along with the version. To avoid a potential inconsistency
+	// when the process fails, trees are removed first: only then versions
+	// can be safely removed to guaranty idempotency.
+	if len(expired) > 0 {
+		if err = s.trees.DeleteAt(sk, expired...); err != nil {
+			return 0, 0, err
+		}
+		if err = s.dicts.DeleteAt(sk, expired...); err != nil {
+			return 0, len(expired), err
+		}
+		if err = s.segments.DeleteAt(sk, expired...); err != nil {
+			return 0, len(expired), err
+		}
+	}
+	for _, v := range modified {
+		seg := v.Value.(*segment.Segment)
+		if _, err = seg.DeleteNodesBefore(rp); err != nil {
+			return len(expired), len(expired), err
+		}
+		if err = s.segments.PutWithTime(sk, seg, v.Time); err != nil {
+			return len(expired), len(expired), err
+		}
+	}
+	return len(expired), len(expired), nil
 }
 
-// reclaimSegmentSpace is aimed to reclaim specified size by removing
-// trees for the given segment. The amount of deleted trees is determined
-// based on the KV item size estimation.
-//
-// Unfortunately, due to the fact that badger DB reclaims disk space
-// eventually, there is no way to juxtapose the actual occupied disk size
-// and the number of items to remove based on their estimated size.
-func (s *Storage) reclaimSegmentSpace(k *segment.Key, size int64) error {
-	return nil
-	//batchSize := s.trees.MaxBatchCount()
-	//batch := s.trees.NewWriteBatch()
-	//defer func() {
-	//	batch.Cancel()
-	//}()
-	//
-	//var (
-	//	removed   int64
-	//	reclaimed int64
-	//	err       error
-	//)
-	//
-	//// Keep track of the most recent removed tree time per every segment level.
-	//rp := &segment.RetentionPolicy{Levels: make(map[int]time.Time)}
-	//err = s.trees.View(func(txn *badger.Txn) error {
-	//	// Lower-level trees come first because of the lexicographical order:
-	//	// from the very first tree to the most recent one, from the lowest
-	//	// level (with highest resolution) to the highest.
-	//	it := txn.NewIterator(badger.IteratorOptions{
-	//		// We count all version so that our estimation is more precise
-	//		// but slightly higher than the actual size in practice,
-	//		// meaning that we delete less data (and reclaim less space);
-	//		// otherwise there is a chance to remove more trees than needed.
-	//		AllVersions: true,
-	//		// The prefix matches all trees in the segment.
-	//		Prefix: treePrefix.key(k.SegmentKey()),
-	//	})
-	//	defer it.Close()
-	//	for it.Rewind(); it.Valid(); it.Next() {
-	//		if size-reclaimed <= 0 {
-	//			return nil
-	//		}
-	//
-	//		item := it.Item()
-	//		if tk, ok := treePrefix.trim(item.Key()); ok {
-	//			treeKey := string(tk)
-	//			s.trees.Discard(treeKey)
-	//			// Update the time boundary for the segment level.
-	//			t, level, err := segment.ParseTreeKey(treeKey)
-	//			if err == nil {
-	//				if t.After(rp.Levels[level]) {
-	//					rp.Levels[level] = t
-	//				}
-	//			}
-	//		}
-	//
-	//		// A key copy must be taken. The slice is reused
-	//		// by iterator but is also used in the batch.
-	//		switch err = batch.Delete(item.KeyCopy(nil)); {
-	//		case err == nil:
-	//		case errors.Is(err, badger.ErrKeyNotFound):
-	//			continue
-	//		default:
-	//			return err
-	//		}
-	//
-	//		reclaimed += item.EstimatedSize()
-	//		if removed++; removed%batchSize == 0 {
-	//			if batch, err = s.flushTreeBatch(batch); err != nil {
-	//				return err
-	//			}
-	//		}
-	//	}
-	//	return nil
-	//})
-	//if err != nil {
-	//	return err
-	//}
-	//
-	//// Flush remaining items, if any: it's important to make sure
-	//// all trees were removed before deleting segment nodes - see
-	//// note on a potential inconsistency above.
-	//if removed%batchSize != 0 {
-	//	if err = batch.Flush(); err != nil {
-	//		return err
-	//	}
-	//}
-	//
-	//if len(rp.Levels) > 0 {
-	//	if cached, ok := s.segments.Lookup(k.SegmentKey()); ok {
-	//		if ok, err = cached.(*segment.Segment).DeleteNodesBefore(rp); ok {
-	//			err = s.deleteSegmentAndRelatedData(k)
-	//		}
-	//	}
-	//}
-	//
-	//return err
-}
+// minRetentionTime is the lower bound of the time range searched
+// for the segment versions to delete.
+var minRetentionTime = time.Unix(0, 0)
 
-// flushTreeBatch commits the changes and returns a new batch. The call returns
-// the batch unchanged in case of an error so that it can be safely cancelled.
-//
-// If the storage was requested to close, errClosed will be returned.
-func (s *Storage) flushTreeBatch(batch *badger.WriteBatch) (*badger.WriteBatch, error) {
-	return nil, nil
-	//if err := batch.Flush(); err != nil {
-	//	return batch, err
-	//}
-	//select {
-	//case <-s.stop:
-	//	return batch, errClosed
-	//default:
-	//	return s.trees.NewWriteBatch(), nil
-	//}
+// maxRetentionTime returns the most recent time boundary of the policy.
+func maxRetentionTime(rp *segment.RetentionPolicy) time.Time {
+	t := rp.AbsoluteTime
+	for _, v := range rp.Levels {
+		if v.After(t) {
+			t = v
+		}
+	}
+	return t
 }
 
-func (s *Storage) iterateOverAllSegments(cb func(*segment.Key) error) error {
+func (s *Storage) iterateOverAllSegments(ctx context.Context, cb func(*segment.Key) error) error {
 	nameKey := "__name__"
 
 	var dimensions []*dimension.Dimension
-	s.labels.GetValues(nameKey, func(v string) bool {
+	err := s.labels.GetValues(ctx, nameKey, func(v string) bool {
 		if d, ok := s.lookupAppDimension(v); ok {
 			dimensions = append(dimensions, d)
 		}
 		return true
 	})
+	if err != nil {
+		return err
+	}
 
 	for _, r := range dimension.Union(dimensions...) {
 		k, err := segment.ParseKey(string(r))
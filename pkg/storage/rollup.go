@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/types"
+)
+
+// Every put is stored as a segment version at the profile start time, which
+// makes reading long time ranges expensive: all the versions within the
+// range have to be loaded and merged. Rollups are segment versions that
+// accumulate all the puts within a time bucket of the rollup resolution,
+// along with their trees. A rollup version keeps the segment tree nodes
+// of the puts, therefore it serves exactly the same data as the versions
+// it replaces, only the number of versions read is reduced.
+//
+// Rollups are written at ingestion, if enabled. Rollup versions are stored
+// in the segments and trees DBs under the segment key with the resolution
+// suffix, e.g. "app{foo=bar}@1h", and are removed along with the segment.
+// Rollups are not backfilled: they are only read for the time ranges they
+// hold all the data of, otherwise the segment versions are read.
+
+type rollupResolution struct {
+	name string
+	d    time.Duration
+}
+
+// rollupResolutions are ordered from the finest to the coarsest.
+var rollupResolutions = []rollupResolution{
+	{name: "1m", d: time.Minute},
+	{name: "1h", d: time.Hour},
+	{name: "1d", d: 24 * time.Hour},
+}
+
+// rollupsSinceSuffix denotes the key of the row holding the time
+// the rollups of the segment have been written since.
+const rollupsSinceSuffix = "@rollups"
+
+func rollupKey(sk string, r rollupResolution) string { return sk + "@" + r.name }
+
+// rollups keeps the time since which rollups of a segment hold all the data:
+// segments created before rollups had been introduced only have rollups of
+// the data ingested afterwards.
+type rollups struct {
+	sync.Mutex
+	since map[string]time.Time
+	// Segments which rollups have been discarded.
+	discarded map[string]struct{}
+}
+
+func newRollups() *rollups {
+	return &rollups{
+		since:     make(map[string]time.Time),
+		discarded: make(map[string]struct{}),
+	}
+}
+
+// pickRollup returns the coarsest rollup resolution not exceeding the
+// query time range. Any resolution satisfies the timeline step, the
+// range only limits the size of the buckets loaded.
+func pickRollup(st, et time.Time) (rollupResolution, bool) {
+	var r rollupResolution
+	var ok bool
+	for _, x := range rollupResolutions {
+		if x.d > et.Sub(st) {
+			break
+		}
+		r, ok = x, true
+	}
+	return r, ok
+}
+
+// initRollups records the time since which the segment rollups are
+// written, unless it is already known. New segments have all the data
+// in rollups.
+func (s *Storage) initRollups(ctx context.Context, sk string, isNew bool) error {
+	s.rollups.Lock()
+	defer s.rollups.Unlock()
+	if _, ok := s.rollups.since[sk]; ok {
+		return nil
+	}
+	k := segmentPrefix.String() + sk + rollupsSinceSuffix
+	row, ok, err := s.segments.DBInstance().Get(ctx, k)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		row = types.Row{Key: k, Timestamp: time.Unix(0, 0)}
+		if !isNew {
+			row.Timestamp = time.Now()
+		}
+		if err = s.segments.DBInstance().Put(ctx, row); err != nil {
+			return err
+		}
+	}
+	s.rollups.since[sk] = row.Timestamp
+	return nil
+}
+
+// discardRollups removes the time since which the segment rollups are
+// written, if any: once rollups are disabled, they miss the data written
+// afterwards. If rollups are enabled again, they only hold the data
+// ingested since then.
+func (s *Storage) discardRollups(ctx context.Context, sk string) error {
+	s.rollups.Lock()
+	defer s.rollups.Unlock()
+	if _, ok := s.rollups.discarded[sk]; ok {
+		return nil
+	}
+	k := segmentPrefix.String() + sk + rollupsSinceSuffix
+	_, ok, err := s.segments.DBInstance().Get(ctx, k)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if err = s.segments.DBInstance().Delete(ctx, k); err != nil {
+			return err
+		}
+	}
+	delete(s.rollups.since, sk)
+	s.rollups.discarded[sk] = struct{}{}
+	return nil
+}
+
+// rollupsSince returns the time since which the segment rollups hold
+// all the data. The call returns false, if the segment has no rollups.
+func (s *Storage) rollupsSince(ctx context.Context, sk string) (time.Time, bool) {
+	s.rollups.Lock()
+	defer s.rollups.Unlock()
+	if t, ok := s.rollups.since[sk]; ok {
+		return t, true
+	}
+	row, ok, err := s.segments.DBInstance().Get(ctx, segmentPrefix.String()+sk+rollupsSinceSuffix)
+	if err != nil {
+		s.logger.WithError(err).WithField("key", sk).Error("failed to get segment rollups")
+		return time.Time{}, false
+	}
+	if !ok {
+		return time.Time{}, false
+	}
+	s.rollups.since[sk] = row.Timestamp
+	return row.Timestamp, true
+}
+
+// forgetRollups removes the cached rollup times of the segments with the
+// given key prefix. Must be called when the segments are deleted.
+func (s *Storage) forgetRollups(prefix string) {
+	s.rollups.Lock()
+	defer s.rollups.Unlock()
+	for k := range s.rollups.since {
+		if strings.HasPrefix(k, prefix) {
+			delete(s.rollups.since, k)
+		}
+	}
+	for k := range s.rollups.discarded {
+		if strings.HasPrefix(k, prefix) {
+			delete(s.rollups.discarded, k)
+		}
+	}
+}
@@ -458,3 +458,18 @@ func (s *Segment) StartTime() time.Time {
 		}
 	}
 }
+
+// Samples returns the number of samples of the nodes covering the entire
+// segment time range.
+func (s *Segment) Samples() uint64 {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	if s.root == nil {
+		return 0
+	}
+	var samples uint64
+	s.root.get(context.Background(), s, s.root.time, s.root.endTime(), func(sn *streeNode, _ *big.Rat) {
+		samples += sn.samples
+	})
+	return samples
+}
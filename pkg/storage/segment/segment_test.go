@@ -99,6 +99,16 @@ var _ = Describe("stree", func() {
 		})
 	})
 
+	Context("Samples", func() {
+		It("returns the number of samples of the segment", func() {
+			s := New()
+			Expect(s.Samples()).To(BeZero())
+			s.Put(testing.SimpleTime(0), testing.SimpleTime(9), 10, putNoOp)
+			s.Put(testing.SimpleTime(100), testing.SimpleTime(199), 20, putNoOp)
+			Expect(s.Samples()).To(Equal(uint64(30)))
+		})
+	})
+
 	Context("DeleteDataBefore", func() {
 		Context("empty segment", func() {
 			It("returns true and no keys", func() {
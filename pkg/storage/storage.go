@@ -25,6 +25,8 @@ var (
 	errRetention  = errors.New("could not write because of retention settings")
 	errOutOfSpace = errors.New("running out of space")
 	errClosed     = errors.New("storage closed")
+
+	errCardinalityLimit = errors.New("cardinality limit exceeded")
 )
 
 type Storage struct {
@@ -34,16 +36,20 @@ type Storage struct {
 	logger *logrus.Logger
 	*metrics
 
-	segments   ClickHouseDBWithCache
-	dimensions ClickHouseDBWithCache
-	dicts      ClickHouseDBWithCache
-	trees      ClickHouseDBWithCache
-	main       ClickHouseDBWithCache
+	segments   DBWithCache
+	dimensions DBWithCache
+	dicts      DBWithCache
+	trees      DBWithCache
+	main       DBWithCache
 	labels     *labels.Labels
 	exemplars  *exemplars
 
 	appSvc ApplicationMetadataSaver
 	hc     *health.Controller
+	quota  quota
+
+	cardinality *cardinality
+	rollups     *rollups
 
 	// Maintenance tasks are executed exclusively to avoid competition:
 	// extensive writing during GC is harmful and deteriorates the
@@ -61,6 +67,7 @@ type storageOptions struct {
 	writeBackTaskInterval     time.Duration
 	evictionTaskInterval      time.Duration
 	retentionTaskInterval     time.Duration
+	quotaTaskInterval         time.Duration
 	cacheTTL                  time.Duration
 	gcSizeDiff                bytesize.ByteSize
 }
@@ -86,7 +93,7 @@ type SampleObserver interface {
 // ApplicationMetadataSaver saves application metadata
 type ApplicationMetadataSaver interface {
 	CreateOrUpdate(ctx context.Context, application appmetadata.ApplicationMetadata) error
-	List(ctx context.Context) (apps []appmetadata.ApplicationMetadata, err error)
+	List(ctx context.Context, filter appmetadata.Filter) (apps []appmetadata.ApplicationMetadata, err error)
 }
 
 func New(c *Config, logger *logrus.Logger, reg prometheus.Registerer, hc *health.Controller, appSvc ApplicationMetadataSaver) (*Storage, error) {
@@ -102,6 +109,9 @@ func New(c *Config, logger *logrus.Logger, reg prometheus.Registerer, hc *health
 			evictionTaskInterval:      20 * time.Second,
 			retentionTaskInterval:     10 * time.Minute,
 			cacheTTL:                  2 * time.Minute,
+			// Storage size is checked against the quota periodically:
+			// application sizes are computed by scanning the data.
+			quotaTaskInterval: time.Hour,
 			// gcSizeDiff specifies the minimal storage size difference that
 			// causes garbage collection to trigger.
 			gcSizeDiff: bytesize.GB,
@@ -114,33 +124,43 @@ func New(c *Config, logger *logrus.Logger, reg prometheus.Registerer, hc *health
 		appSvc:  appSvc,
 	}
 
-	if c.NewClickHouse == nil {
-		c.NewClickHouse = s.newClickHouse
+	if c.quota.CheckInterval > 0 {
+		s.quotaTaskInterval = c.quota.CheckInterval
+	}
+
+	newDB := c.NewDB
+	if newDB == nil {
+		var err error
+		if newDB, err = s.newDB(); err != nil {
+			return nil, err
+		}
 	}
 
 	var err error
-	if s.main, err = c.NewClickHouse(c.db+".main", "", nil); err != nil {
+	if s.main, err = newDB("main", "", nil); err != nil {
 		return nil, err
 	}
-	if s.dicts, err = c.NewClickHouse(c.db+".dicts", dictionaryPrefix, dictionaryCodec{}); err != nil {
+	if s.dicts, err = newDB("dicts", dictionaryPrefix, dictionaryCodec{}); err != nil {
 		return nil, err
 	}
-	if s.dimensions, err = c.NewClickHouse(c.db+".dimensions", dimensionPrefix, dimensionCodec{}); err != nil {
+	if s.dimensions, err = newDB("dimensions", dimensionPrefix, dimensionCodec{}); err != nil {
 		return nil, err
 	}
-	if s.segments, err = c.NewClickHouse(c.db+".segments", segmentPrefix, segmentCodec{}); err != nil {
+	if s.segments, err = newDB("segments", segmentPrefix, segmentCodec{}); err != nil {
 		return nil, err
 	}
-	if s.trees, err = c.NewClickHouse(c.db+".trees", treePrefix, treeCodec{s}); err != nil {
+	if s.trees, err = newDB("trees", treePrefix, treeCodec{s}); err != nil {
 		return nil, err
 	}
-	pdb, err := c.NewClickHouse(c.db+".profiles", exemplarDataPrefix, nil)
+	pdb, err := newDB("profiles", exemplarDataPrefix, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	s.initExemplarsStorage(pdb)
 	s.labels = labels.New(s.main.DBInstance())
+	s.cardinality = newCardinality()
+	s.rollups = newRollups()
 
 	if err = s.migrate(); err != nil {
 		return nil, err
@@ -157,13 +177,9 @@ func New(c *Config, logger *logrus.Logger, reg prometheus.Registerer, hc *health
 	} else {
 		s.periodicTask(s.writeBackTaskInterval, s.writeBackTask)
 	}
-
-	//if !s.config.inMemory {
-	//	s.periodicTask(s.evictionTaskInterval, s.evictionTask(memTotal))
-	//	//s.periodicTask(s.writeBackTaskInterval, s.writeBackTask)
-	//	//s.maintenanceTask(s.retentionTaskInterval, s.retentionTask)
-	//	s.periodicTask(s.metricsUpdateTaskInterval, s.updateMetricsTask)
-	//}
+	s.maintenanceTask(s.retentionTaskInterval, s.retentionTask)
+	s.periodicTask(s.metricsUpdateTaskInterval, s.updateMetricsTask)
+	s.periodicTask(s.quotaTaskInterval, s.quotaTask)
 
 	return s, nil
 }
@@ -179,7 +195,7 @@ func (s *Storage) Close() error {
 	// Exemplars DB does not have a cache but depends on Dictionaries DB as well:
 	// there is no need to force synchronization, as exemplars storage listens to
 	// the s.stop channel and stops synchronously.
-	caches := []ClickHouseDBWithCache{
+	caches := []DBWithCache{
 		s.trees,
 		s.segments,
 		s.dimensions,
@@ -187,7 +203,7 @@ func (s *Storage) Close() error {
 	wg := new(sync.WaitGroup)
 	wg.Add(len(caches))
 	for _, d := range caches {
-		go func(d ClickHouseDBWithCache) {
+		go func(d DBWithCache) {
 			d.CacheInstance().Flush()
 			wg.Done()
 		}(d)
@@ -198,7 +214,7 @@ func (s *Storage) Close() error {
 	s.dicts.CacheInstance().Flush()
 
 	// Close databases. Order does not matter.
-	dbs := []ClickHouseDBWithCache{
+	dbs := []DBWithCache{
 		s.trees,
 		s.segments,
 		s.dimensions,
@@ -209,7 +225,7 @@ func (s *Storage) Close() error {
 	wg = new(sync.WaitGroup)
 	wg.Add(len(dbs))
 	for _, d := range dbs {
-		go func(d ClickHouseDBWithCache) {
+		go func(d DBWithCache) {
 			defer wg.Done()
 			if err := d.DBInstance().Close(); err != nil {
 				s.logger.WithField("name", d.Name()).WithError(err).Error("closing database")
@@ -371,8 +387,8 @@ func (s *Storage) retentionPolicy() *segment.RetentionPolicy {
 			s.config.retentionLevels.Two)
 }
 
-func (s *Storage) databases() []ClickHouseDBWithCache {
-	return []ClickHouseDBWithCache{
+func (s *Storage) databases() []DBWithCache {
+	return []DBWithCache{
 		s.main,
 		s.dimensions,
 		s.segments,
@@ -382,21 +398,21 @@ func (s *Storage) databases() []ClickHouseDBWithCache {
 	}
 }
 
-func (s *Storage) SegmentsInternals() (types.ClickHouseDB, *cache.Cache) {
+func (s *Storage) SegmentsInternals() (types.DB, *cache.Cache) {
 	return s.segments.DBInstance(), s.segments.CacheInstance()
 }
-func (s *Storage) DimensionsInternals() (types.ClickHouseDB, *cache.Cache) {
+func (s *Storage) DimensionsInternals() (types.DB, *cache.Cache) {
 	return s.dimensions.DBInstance(), s.dimensions.CacheInstance()
 }
-func (s *Storage) DictsInternals() (types.ClickHouseDB, *cache.Cache) {
+func (s *Storage) DictsInternals() (types.DB, *cache.Cache) {
 	return s.dicts.DBInstance(), s.dicts.CacheInstance()
 }
-func (s *Storage) TreesInternals() (types.ClickHouseDB, *cache.Cache) {
+func (s *Storage) TreesInternals() (types.DB, *cache.Cache) {
 	return s.trees.DBInstance(), s.trees.CacheInstance()
 }
-func (s *Storage) MainInternals() (types.ClickHouseDB, *cache.Cache) {
+func (s *Storage) MainInternals() (types.DB, *cache.Cache) {
 	return s.main.DBInstance(), s.main.CacheInstance()
 }
-func (s *Storage) ExemplarsInternals() (types.ClickHouseDB, func()) {
+func (s *Storage) ExemplarsInternals() (types.DB, func()) {
 	return s.exemplars.db.DBInstance(), s.exemplars.Sync
 }
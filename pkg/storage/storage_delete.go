@@ -13,43 +13,62 @@ type DeleteInput struct {
 	Key *segment.Key
 }
 
-func (s *Storage) Delete(_ context.Context, di *DeleteInput) error {
-	return s.deleteSegmentAndRelatedData(di.Key)
+func (s *Storage) Delete(ctx context.Context, di *DeleteInput) error {
+	_, err := s.deleteSegmentAndRelatedData(ctx, di.Key)
+	return err
 }
 
-func (s *Storage) deleteSegmentAndRelatedData(k *segment.Key) error {
+// deleteSegmentAndRelatedData removes the segment with all its trees and
+// dictionaries, and the label pairs that are not referenced by any other
+// segment. The call returns the number of removed label pairs.
+func (s *Storage) deleteSegmentAndRelatedData(ctx context.Context, k *segment.Key) (int, error) {
 	sk := k.SegmentKey()
+	// Trees and dictionaries are versioned: cache keys have the version
+	// time suffix, therefore they are discarded by the prefix.
 	if err := s.trees.DiscardPrefix(sk); err != nil {
-		return err
+		return 0, err
+	}
+	if err := s.dicts.DiscardPrefix(sk); err != nil {
+		return 0, err
 	}
+	var removed int
 	for key, value := range k.Labels() {
 		d, ok := s.lookupDimensionKV(key, value)
 		if !ok {
 			continue
 		}
 		d.Delete(dimension.Key(sk))
-
+		dk := key + ":" + value
 		if len(d.Keys) > 0 {
+			if err := s.dimensions.Put(dk, d); err != nil {
+				return removed, err
+			}
 			continue
 		}
 		// There are no more references.
-		if err := s.labels.Delete(key, value); err != nil {
-			return err
+		if err := s.deleteLabel(ctx, key, value); err != nil {
+			return removed, err
 		}
+		if err := s.dimensions.Delete(dk); err != nil {
+			return removed, err
+		}
+		removed++
 		if key == "__name__" {
 			if err := s.dicts.Delete(k.DictKey()); err != nil {
-				return err
+				return removed, err
 			}
 		}
 	}
-	return s.segments.Delete(sk)
+	// Segment rollups are removed along with the segment.
+	s.forgetRollups(sk)
+	return removed, s.segments.DiscardPrefix(sk)
 }
 
 // DeleteApp fully deletes an app
 // It does so by deleting Segments, Dictionaries, Trees, Dimensions and Labels
 // It's an idempotent call, ie. if the app already does not exist, no error is triggered.
 // TODO cancelation?
-func (s *Storage) DeleteApp(_ context.Context, appname string) error {
+func (s *Storage) DeleteApp(ctx context.Context, appname string) error {
 	/***********************************/
 	/*      V a l i d a t i o n s      */
 	/***********************************/
@@ -159,11 +178,14 @@ func (s *Storage) DeleteApp(_ context.Context, appname string) error {
 			// We can only delete the dimension once it's not pointing to any segments
 			if len(d2.Keys) > 0 {
 				s.logger.Debugf("dimension is still pointing to valid segments. not deleting it. \n")
+				if err := s.dimensions.Put(labelKey+":"+labelValue, d2); err != nil {
+					return err
+				}
 				continue
 			}
 
 			s.logger.Debugf("deleting labels %s=%s \n", labelKey, labelValue)
-			if err := s.labels.Delete(labelKey, labelValue); err != nil {
+			if err := s.deleteLabel(ctx, labelKey, labelValue); err != nil {
 				return err
 			}
 
@@ -182,17 +204,23 @@ func (s *Storage) DeleteApp(_ context.Context, appname string) error {
 	}
 
 	s.logger.Debugf("deleting segments with prefix %s\n", appWithCurlyBrackets)
+	s.forgetRollups(appWithCurlyBrackets)
 	if err = s.segments.DiscardPrefix(appWithCurlyBrackets); err != nil {
 		return err
 	}
 
+	s.logger.Debugf("deleting dicts with prefix %s\n", appWithCurlyBrackets)
+	if err = s.dicts.DiscardPrefix(appWithCurlyBrackets); err != nil {
+		return err
+	}
+
 	s.logger.Debugf("deleting dicts %s\n", key.DictKey())
 	if err := s.dicts.Delete(key.DictKey()); err != nil {
 		return err
 	}
 
 	s.logger.Debugf("deleting labels\n")
-	if err := s.labels.Delete("__name__", appname); err != nil {
+	if err := s.deleteLabel(ctx, "__name__", appname); err != nil {
 		return err
 	}
 
@@ -36,12 +36,13 @@ var _ = Describe("storage package", func() {
 		/*************************************/
 		/*  h e l p e r   f u n c t i o n s  */
 		/*************************************/
-		checkSegmentsPresence := func(appname string, presence bool) {
+		checkSegmentsPresence := func(appname string, st time.Time, presence bool) {
 			segmentKey, err := segment.ParseKey(string(appname))
 			Expect(err).ToNot(HaveOccurred())
 			segmentKeyStr := segmentKey.SegmentKey()
 			Expect(segmentKeyStr).To(Equal(appname + "{}"))
-			_, ok := s.segments.Lookup(segmentKeyStr)
+			// Segments are versioned by the profile start time.
+			_, ok := s.segments.LookupWithTime(segmentKeyStr, st)
 
 			if presence {
 				Expect(ok).To(BeTrue())
@@ -61,11 +62,13 @@ var _ = Describe("storage package", func() {
 			return d
 		}
 
-		checkTreesPresence := func(appname string, st time.Time, depth int, presence bool) interface{} {
+		checkTreesPresence := func(appname string, st time.Time, presence bool) interface{} {
 			key, err := segment.ParseKey(appname)
 			Expect(err).ToNot(HaveOccurred())
-			treeKeyName := key.TreeKey(depth, st)
-			t, ok := s.trees.Lookup(treeKeyName)
+			// Trees are keyed by the segment key and versioned
+			// by the time of the segment version.
+			treeKeyName := key.SegmentKey()
+			t, ok := s.trees.LookupWithTime(treeKeyName, st)
 			if presence {
 				Expect(ok).To(BeTrue())
 			} else {
@@ -87,7 +90,8 @@ var _ = Describe("storage package", func() {
 
 		checkLabelsPresence := func(appname string, presence bool) {
 			// this indirectly calls s.labels
-			appnames := s.GetAppNames(context.TODO())
+			appnames, err := s.GetAppNames(context.TODO())
+			Expect(err).ToNot(HaveOccurred())
 
 			// linear scan should be fast enough here
 			found := false
@@ -131,6 +135,9 @@ var _ = Describe("storage package", func() {
 				d := dict.New()
 				s.dicts.Put(appname, d)
 
+				// Tree dictionaries are created once the trees are written.
+				Expect(s.trees.CacheInstance().Sync()).ToNot(HaveOccurred())
+
 				/*******************************/
 				/*  S a n i t y   C h e c k s  */
 				/*******************************/
@@ -140,15 +147,16 @@ var _ = Describe("storage package", func() {
 
 				// Trees
 				Expect(s.trees.CacheSize()).To(Equal(uint64(1)))
-				checkTreesPresence(appname, st, 0, true)
+				checkTreesPresence(appname, st, true)
 
 				// Segments
 				Expect(s.segments.CacheSize()).To(Equal(uint64(1)))
-				checkSegmentsPresence(appname, true)
+				checkSegmentsPresence(appname, st, true)
 
 				// Dicts
 				// I manually inserted a dictionary so it should be fine?
-				Expect(s.dicts.CacheSize()).To(Equal(uint64(1)))
+				// Every tree version has its own dictionary as well.
+				Expect(s.dicts.CacheSize()).To(Equal(uint64(2)))
 				checkDictsPresence(appname, true)
 
 				// Labels
@@ -163,7 +171,7 @@ var _ = Describe("storage package", func() {
 				// Trees
 				// should've been deleted from CACHE
 				Expect(s.trees.CacheSize()).To(Equal(uint64(0)))
-				checkTreesPresence(appname, st, 0, false)
+				checkTreesPresence(appname, st, false)
 
 				// Dimensions
 				Expect(s.dimensions.CacheSize()).To(Equal(uint64(0)))
@@ -175,7 +183,7 @@ var _ = Describe("storage package", func() {
 
 				// Segments
 				Expect(s.segments.CacheSize()).To(Equal(uint64(0)))
-				checkSegmentsPresence(appname, false)
+				checkSegmentsPresence(appname, st, false)
 
 				// Labels
 				checkLabelsPresence(appname, false)
@@ -218,6 +226,9 @@ var _ = Describe("storage package", func() {
 				d := dict.New()
 				s.dicts.Put(appname, d)
 
+				// Tree dictionaries are created once the trees are written.
+				Expect(s.trees.CacheInstance().Sync()).ToNot(HaveOccurred())
+
 				/*******************************/
 				/*  S a n i t y   C h e c k s  */
 				/*******************************/
@@ -228,16 +239,16 @@ var _ = Describe("storage package", func() {
 
 				By("checking trees were created")
 				Expect(s.trees.CacheSize()).To(Equal(uint64(3)))
-				checkTreesPresence(appname, st, 0, true)
+				checkTreesPresence(appname, st, true)
 
 				By("checking segments were created")
 				Expect(s.segments.CacheSize()).To(Equal(uint64(3)))
-				checkSegmentsPresence(appname, true)
+				checkSegmentsPresence(appname, st, true)
 
 				By("checking dicts were created")
 				// Dicts
 				// I manually inserted a dictionary so it should be fine?
-				Expect(s.dicts.CacheSize()).To(Equal(uint64(1)))
+				Expect(s.dicts.CacheSize()).To(Equal(uint64(4)))
 				checkDictsPresence(appname, true)
 
 				// Labels
@@ -254,7 +265,7 @@ var _ = Describe("storage package", func() {
 				// Trees
 				// should've been deleted from CACHE
 				Expect(s.trees.CacheSize()).To(Equal(uint64(0)))
-				checkTreesPresence(appname, st, 0, false)
+				checkTreesPresence(appname, st, false)
 
 				// Dimensions
 				By("checking dimensions were deleted")
@@ -269,7 +280,7 @@ var _ = Describe("storage package", func() {
 				// Segments
 				By("checking segments were deleted")
 				Expect(s.segments.CacheSize()).To(Equal(uint64(0)))
-				checkSegmentsPresence(appname, false)
+				checkSegmentsPresence(appname, st, false)
 
 				// Labels
 				By("checking labels were deleted")
@@ -313,6 +324,9 @@ var _ = Describe("storage package", func() {
 					// (they are normally created when TODO)
 					d := dict.New()
 					s.dicts.Put(appname, d)
+
+					// Tree dictionaries are created once the trees are written.
+					Expect(s.trees.CacheInstance().Sync()).ToNot(HaveOccurred())
 				}
 
 				app1name := "myapp1.cpu"
@@ -331,16 +345,16 @@ var _ = Describe("storage package", func() {
 
 				By("checking trees were created")
 				Expect(s.trees.CacheSize()).To(Equal(uint64(6)))
-				checkTreesPresence(app1name, st, 0, true)
-				checkTreesPresence(app2name, st, 0, true)
+				checkTreesPresence(app1name, st, true)
+				checkTreesPresence(app2name, st, true)
 
 				By("checking segments were created")
 				Expect(s.segments.CacheSize()).To(Equal(uint64(6)))
-				checkSegmentsPresence(app1name, true)
-				checkSegmentsPresence(app2name, true)
+				checkSegmentsPresence(app1name, st, true)
+				checkSegmentsPresence(app2name, st, true)
 
 				By("checking dicts were created")
-				Expect(s.dicts.CacheSize()).To(Equal(uint64(2)))
+				Expect(s.dicts.CacheSize()).To(Equal(uint64(8)))
 				checkDictsPresence(app1name, true)
 				checkDictsPresence(app2name, true)
 
@@ -357,8 +371,8 @@ var _ = Describe("storage package", func() {
 
 				By("checking trees were deleted")
 				Expect(s.trees.CacheSize()).To(Equal(uint64(3)))
-				checkTreesPresence(app1name, st, 0, false)
-				checkTreesPresence(app2name, st, 0, true)
+				checkTreesPresence(app1name, st, false)
+				checkTreesPresence(app2name, st, true)
 
 				// Dimensions
 				By("checking dimensions were deleted")
@@ -393,18 +407,18 @@ var _ = Describe("storage package", func() {
 				}))
 
 				By("checking dicts were deleted")
-				Expect(s.dicts.CacheSize()).To(Equal(uint64(1)))
+				Expect(s.dicts.CacheSize()).To(Equal(uint64(4)))
 				checkDictsPresence(app1name, false)
 				checkDictsPresence(app2name, true)
 
 				By("checking segments were deleted")
 				Expect(s.segments.CacheSize()).To(Equal(uint64(3)))
-				checkSegmentsPresence(app1name, false)
-				checkSegmentsPresence(app2name, true)
+				checkSegmentsPresence(app1name, st, false)
+				checkSegmentsPresence(app2name, st, true)
 
 				By("checking labels were deleted")
 				checkLabelsPresence(app1name, false)
-				checkSegmentsPresence(app2name, true)
+				checkSegmentsPresence(app2name, st, true)
 			})
 		})
 
@@ -445,6 +459,9 @@ var _ = Describe("storage package", func() {
 			d := dict.New()
 			s.dicts.Put(appname, d)
 
+			// Tree dictionaries are created once the trees are written.
+			Expect(s.trees.CacheInstance().Sync()).ToNot(HaveOccurred())
+
 			/*******************************/
 			/*  S a n i t y   C h e c k s  */
 			/*******************************/
@@ -455,14 +472,14 @@ var _ = Describe("storage package", func() {
 
 				By("checking trees were created")
 				Expect(s.trees.CacheSize()).To(Equal(uint64(3)))
-				checkTreesPresence(appname, st, 0, true)
+				checkTreesPresence(appname, st, true)
 
 				By("checking segments were created")
 				Expect(s.segments.CacheSize()).To(Equal(uint64(3)))
-				checkSegmentsPresence(appname, true)
+				checkSegmentsPresence(appname, st, true)
 
 				By("checking dicts were created")
-				Expect(s.dicts.CacheSize()).To(Equal(uint64(1)))
+				Expect(s.dicts.CacheSize()).To(Equal(uint64(4)))
 				checkDictsPresence(appname, true)
 
 				checkLabelsPresence(appname, true)
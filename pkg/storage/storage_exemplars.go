@@ -6,10 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/ClickHouse/clickhouse-go/v2"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 
@@ -17,6 +17,7 @@ import (
 	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/types"
 	"github.com/pyroscope-io/pyroscope/pkg/util/varint"
 )
 
@@ -25,7 +26,7 @@ import (
 const (
 	exemplarDataPrefix      Prefix = "v:"
 	exemplarTimestampPrefix Prefix = "t:"
-	exemplarsCurrentFormat         = 2
+	exemplarsCurrentFormat         = 3
 
 	defaultExemplarsBatchQueueSize = 5
 	defaultExemplarsBatchSize      = 10 << 10 // 10K
@@ -36,8 +37,8 @@ type exemplars struct {
 	logger  *logrus.Logger
 	config  *Config
 	metrics *metrics
-	db      ClickHouseDBWithCache
-	dicts   ClickHouseDBWithCache
+	db      DBWithCache
+	dicts   DBWithCache
 
 	once         sync.Once
 	mu           sync.Mutex
@@ -55,7 +56,7 @@ type exemplarsBatch struct {
 	entries   map[string]*exemplarEntry
 	config    *Config
 	metrics   *metrics
-	dicts     ClickHouseDBWithCache
+	dicts     DBWithCache
 }
 
 type exemplarEntry struct {
@@ -103,7 +104,7 @@ func (e *exemplars) newExemplarsBatch() *exemplarsBatch {
 	}
 }
 
-func (s *Storage) initExemplarsStorage(db ClickHouseDBWithCache) {
+func (s *Storage) initExemplarsStorage(db DBWithCache) {
 	e := exemplars{
 		logger:  s.logger,
 		config:  s.config,
@@ -258,18 +259,42 @@ func (e *exemplars) flush(b *exemplarsBatch) {
 		return
 	}
 	e.logger.Debug("flushing completed batch")
-	err := e.db.Update(func(conn clickhouse.Conn) error {
-		for _, entry := range b.entries {
-			if err := b.writeExemplarToDB(conn, entry); err != nil {
+	err := e.writeBatch(b)
+	if err != nil {
+		e.logger.WithError(err).Error("failed to write exemplars batch")
+	}
+}
+
+func (e *exemplars) writeBatch(b *exemplarsBatch) error {
+	batch, err := e.db.DBInstance().NewWriteBatch(context.Background())
+	if err != nil {
+		return err
+	}
+	dicts := make(map[string]*dict.Dict)
+	for _, entry := range b.entries {
+		d, ok := dicts[entry.AppName]
+		if !ok {
+			x, err := b.dicts.GetOrCreate(entry.AppName)
+			if err != nil {
+				_ = batch.Abort()
 				return err
 			}
+			d = x.(*dict.Dict)
+			dicts[entry.AppName] = d
+		}
+		if err = b.writeExemplarToDB(e.db.DBInstance(), batch, entry, d); err != nil {
+			_ = batch.Abort()
+			return err
 		}
-		return nil
-	})
-
-	if err != nil {
-		e.logger.WithError(err).Error("failed to write exemplars batch")
 	}
+	// Dictionaries are modified when exemplars are serialized.
+	for appName, d := range dicts {
+		if err := b.dicts.Put(appName, d); err != nil {
+			_ = batch.Abort()
+			return err
+		}
+	}
+	return batch.Send()
 }
 
 func (e *exemplars) insert(ctx context.Context, input *PutInput) error {
@@ -286,75 +311,73 @@ func (e *exemplars) insert(ctx context.Context, input *PutInput) error {
 	return err
 }
 
-type TableRow struct {
-	K         string    `db:"k" ch:"k"`
-	V         string    `db:"v" ch:"v"`
-	Timestamp time.Time `db:"timestamp" ch:"timestamp"`
-}
-
 func (e *exemplars) fetch(ctx context.Context, appName string, profileIDs []string, fn func(exemplarEntry) error) error {
 	d, ok := e.dicts.Lookup(appName)
 	if !ok {
 		return nil
 	}
 	dx := d.(*dict.Dict)
-	return e.db.View(func(conn clickhouse.Conn) error {
-		for _, profileID := range profileIDs {
-			if err := ctx.Err(); err != nil {
-				return err
-			}
-			k := exemplarKey(appName, profileID)
-			var row TableRow
-			var buf []byte
-			if err := conn.QueryRow(context.Background(), "select k,v,max(timestamp) as timestamp from "+e.db.FQDN()+"_all where k = ? group by (k,v) limit 1", string(k)).ScanStruct(&row); err != nil {
-				return err
-			}
-			buf = []byte(row.V)
-
-			var x exemplarEntry
-			if err := x.Deserialize(dx, buf); err != nil {
-				return err
-			}
-			x.Key = k
-			x.AppName = appName
-			x.ProfileID = profileID
-			if err := fn(x); err != nil {
-				return err
-			}
+	for _, profileID := range profileIDs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		k := exemplarKey(appName, profileID)
+		row, ok, err := e.db.DBInstance().Get(ctx, string(k))
+		if err != nil {
+			return err
 		}
+		if !ok {
+			continue
+		}
+		// TODO(kolesnikovae): Optimize:
+		//   It makes sense to lookup the dictionary keys only after all
+		//   exemplars fetched and merged.
+		e.metrics.exemplarsReadBytes.Observe(float64(len(row.Value)))
+		var x exemplarEntry
+		if err = x.Deserialize(dx, row.Value); err != nil {
+			return err
+		}
+		x.Key = k
+		x.AppName = appName
+		x.ProfileID = profileID
+		if err = fn(x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scan calls fn for every exemplar of the application matching the filter.
+// Exemplars are filtered before their trees are deserialized.
+func (e *exemplars) scan(ctx context.Context, appName string, filter func(*exemplarEntry) bool, fn func(exemplarEntry) error) error {
+	d, ok := e.dicts.Lookup(appName)
+	if !ok {
 		return nil
-		//for _, profileID := range profileIDs {
-		//	if err := ctx.Err(); err != nil {
-		//		return err
-		//	}
-		//	k := exemplarKey(appName, profileID)
-		//	item, err := txn.Get(k)
-		//	switch {
-		//	default:
-		//		return err
-		//	case errors.Is(err, badger.ErrKeyNotFound):
-		//	case err == nil:
-		//		// TODO(kolesnikovae): Optimize:
-		//		//   It makes sense to lookup the dictionary keys only after all
-		//		//   exemplars fetched and merged.
-		//		err = item.Value(func(val []byte) error {
-		//			e.metrics.exemplarsReadBytes.Observe(float64(len(val)))
-		//			var x exemplarEntry
-		//			if err = x.Deserialize(dx, val); err != nil {
-		//				return err
-		//			}
-		//			x.Key = k
-		//			x.AppName = appName
-		//			x.ProfileID = profileID
-		//			return fn(x)
-		//		})
-		//		if err != nil {
-		//			return err
-		//		}
-		//	}
-		//}
-		//return nil
+	}
+	dx := d.(*dict.Dict)
+	prefix := string(exemplarKey(appName, ""))
+	var err error
+	scanErr := e.db.DBInstance().Scan(ctx, prefix, func(row types.Row) bool {
+		if err = ctx.Err(); err != nil {
+			return false
+		}
+		e.metrics.exemplarsReadBytes.Observe(float64(len(row.Value)))
+		x := exemplarEntry{
+			Key:       []byte(row.Key),
+			AppName:   appName,
+			ProfileID: strings.TrimPrefix(row.Key, prefix),
+		}
+		var ok bool
+		if ok, err = x.deserializeIf(dx, row.Value, filter); err != nil || !ok {
+			return err == nil
+		}
+		err = fn(x)
+		return err == nil
 	})
+	if scanErr != nil {
+		return scanErr
+	}
+	return err
 }
 
 func (e *exemplars) truncateBefore(ctx context.Context, before time.Time) (err error) {
@@ -367,7 +390,7 @@ func (e *exemplars) truncateBefore(ctx context.Context, before time.Time) (err e
 				e.flush(batch)
 			}
 		default:
-			if more, err = e.truncateN(before, defaultBatchSize); err != nil {
+			if more, err = e.truncateN(ctx, before, defaultBatchSize); err != nil {
 				return err
 			}
 		}
@@ -375,53 +398,34 @@ func (e *exemplars) truncateBefore(ctx context.Context, before time.Time) (err e
 	return nil
 }
 
-func (e *exemplars) truncateN(before time.Time, count int) (bool, error) {
+func (e *exemplars) truncateN(ctx context.Context, before time.Time, count int) (bool, error) {
+	beforeTs := before.UnixNano()
+	keys := make([]string, 0, 2*count)
+	err := e.db.DBInstance().Scan(ctx, exemplarTimestampPrefix.String(), func(row types.Row) bool {
+		if len(keys) == cap(keys) {
+			return false
+		}
+		keyTs, exKey, ok := parseExemplarTimestamp([]byte(row.Key))
+		if !ok {
+			return true
+		}
+		if keyTs > beforeTs {
+			return false
+		}
+		keys = append(keys, row.Key, string(exKey))
+		return true
+	})
+	if err != nil {
+		return false, err
+	}
+	if len(keys) == 0 {
+		return false, nil
+	}
+	if err = e.db.DBInstance().Delete(ctx, keys...); err != nil {
+		return false, err
+	}
+	e.metrics.exemplarsRemovedTotal.Add(float64(len(keys) / 2))
 	return true, nil
-	//beforeTs := before.UnixNano()
-	//keys := make([][]byte, 0, 2*count)
-	//err := e.db.View(func(txn *badger.Txn) error {
-	//	it := txn.NewIterator(badger.IteratorOptions{
-	//		Prefix: exemplarTimestampPrefix.bytes(),
-	//	})
-	//	defer it.Close()
-	//	for it.Rewind(); it.Valid(); it.Next() {
-	//		if len(keys) == cap(keys) {
-	//			return nil
-	//		}
-	//		item := it.Item()
-	//		keyTs, exKey, ok := parseExemplarTimestamp(item.Key())
-	//		if !ok {
-	//			continue
-	//		}
-	//		if keyTs > beforeTs {
-	//			break
-	//		}
-	//		keys = append(keys, item.KeyCopy(nil))
-	//		keys = append(keys, exKey)
-	//	}
-	//	return nil
-	//})
-	//
-	//if err != nil {
-	//	return false, err
-	//}
-	//if len(keys) == 0 {
-	//	return false, nil
-	//}
-	//
-	//batch := e.db.NewWriteBatch()
-	//defer batch.Cancel()
-	//for i := range keys {
-	//	if err = batch.Delete(keys[i]); err != nil {
-	//		return false, err
-	//	}
-	//}
-	//
-	//if err = batch.Flush(); err == nil {
-	//	e.metrics.exemplarsRemovedTotal.Add(float64(len(keys) / 2))
-	//}
-	//
-	//return true, err
 }
 
 func (s *Storage) ensureAppSegmentExists(in *PutInput) error {
@@ -437,8 +441,7 @@ func (s *Storage) ensureAppSegmentExists(in *PutInput) error {
 		Units:           in.Units,
 		AggregationType: in.AggregationType,
 	})
-	s.segments.Put(k, st)
-	return err
+	return s.segments.Put(k, st)
 }
 
 func (b *exemplarsBatch) insert(_ context.Context, input *PutInput) error {
@@ -471,38 +474,32 @@ func (b *exemplarsBatch) insert(_ context.Context, input *PutInput) error {
 	return nil
 }
 
-func (b *exemplarsBatch) writeExemplarToDB(conn clickhouse.Conn, e *exemplarEntry) error {
+func (b *exemplarsBatch) writeExemplarToDB(db types.DB, batch types.WriteBatch, e *exemplarEntry, dx *dict.Dict) error {
 	k, ok := exemplarKeyToTimestampKey(e.Key, e.EndTime)
 	if !ok {
 		return fmt.Errorf("invalid exemplar key")
 	}
-	if err := conn.Exec(context.Background(), "insert into pyroscope.profiles"+" values (?, ?, ?)", string(k), "", time.Now()); err != nil {
+	now := time.Now()
+	if err := batch.Append(types.Row{Key: string(k), Timestamp: now}); err != nil {
 		return err
 	}
-	d, err := b.dicts.GetOrCreate(e.AppName)
+	row, ok, err := db.Get(context.Background(), string(e.Key))
 	if err != nil {
 		return err
 	}
-	dx := d.(*dict.Dict)
-
-	var row TableRow
-	var buf []byte
-	if err := conn.QueryRow(context.Background(), "select k,v,max(timestamp) as timestamp from pyroscope.profiles_all"+" where k = ? group by (k,v) limit 1", string(e.Key)).ScanStruct(&row); err == nil {
-		buf = []byte(row.V)
-
+	if ok {
 		var x exemplarEntry
-		if err = x.Deserialize(dx, buf); err == nil {
-			e = x.Merge(e)
-		} else {
+		if err = x.Deserialize(dx, row.Value); err != nil {
 			return err
 		}
+		e = x.Merge(e)
 	}
 
 	r, err := e.Serialize(dx, b.config.maxNodesSerialization)
 	if err != nil {
 		return err
 	}
-	if err := conn.Exec(context.Background(), "insert into pyroscope.profiles_all"+" values (?, ?, ?)", string(e.Key), string(r), time.Now()); err != nil {
+	if err = batch.Append(types.Row{Key: string(e.Key), Value: r, Timestamp: now}); err != nil {
 		return err
 	}
 	b.metrics.exemplarsWriteBytes.Observe(float64(len(r)))
@@ -528,10 +525,16 @@ func (e *exemplarEntry) updateTime(st, et int64) {
 func (e *exemplarEntry) Serialize(d *dict.Dict, maxNodes int) ([]byte, error) {
 	b := bytes.NewBuffer(make([]byte, 0, 1<<10)) // 1 KB.
 	b.WriteByte(exemplarsCurrentFormat)          // Version.
+	// The header goes first, so that exemplars can be
+	// filtered without deserializing the tree.
+	e.serializeHeader(b)
 	if err := e.Tree.SerializeTruncate(d, maxNodes, b); err != nil {
 		return nil, err
 	}
+	return b.Bytes(), nil
+}
 
+func (e *exemplarEntry) serializeHeader(b *bytes.Buffer) {
 	vw := varint.NewWriter()
 	_, _ = vw.Write(b, uint64(e.StartTime))
 	_, _ = vw.Write(b, uint64(e.EndTime))
@@ -551,42 +554,56 @@ func (e *exemplarEntry) Serialize(d *dict.Dict, maxNodes int) ([]byte, error) {
 		_, _ = vw.Write(b, uint64(len(bs)))
 		_, _ = b.Write(bs)
 	}
-
-	return b.Bytes(), nil
 }
 
 func (e *exemplarEntry) Deserialize(d *dict.Dict, b []byte) error {
+	_, err := e.deserializeIf(d, b, nil)
+	return err
+}
+
+// deserializeIf deserializes the exemplar if it matches the filter, which is
+// called before the tree is deserialized, if the exemplar format allows it.
+// A nil filter matches any exemplar.
+func (e *exemplarEntry) deserializeIf(d *dict.Dict, b []byte, filter func(*exemplarEntry) bool) (bool, error) {
 	buf := bytes.NewBuffer(b)
 	v, err := buf.ReadByte()
 	if err != nil {
-		return err
+		return false, err
 	}
 	switch v {
 	case 1:
-		return e.deserializeV1(d, buf)
+		err = e.deserializeTree(d, buf)
 	case 2:
-		return e.deserializeV2(d, buf)
+		if err = e.deserializeTree(d, buf); err == nil {
+			err = e.deserializeHeader(buf)
+		}
+	case 3:
+		if err = e.deserializeHeader(buf); err != nil {
+			return false, err
+		}
+		if filter != nil && !filter(e) {
+			return false, nil
+		}
+		return true, e.deserializeTree(d, buf)
 	default:
-		return fmt.Errorf("unknown exemplar format version %d", v)
+		return false, fmt.Errorf("unknown exemplar format version %d", v)
 	}
-}
-
-func (e *exemplarEntry) deserializeV1(d *dict.Dict, src *bytes.Buffer) error {
-	t, err := tree.Deserialize(d, src)
 	if err != nil {
-		return err
+		return false, err
 	}
-	e.Tree = t
-	return nil
+	return filter == nil || filter(e), nil
 }
 
-func (e *exemplarEntry) deserializeV2(d *dict.Dict, src *bytes.Buffer) error {
+func (e *exemplarEntry) deserializeTree(d *dict.Dict, src *bytes.Buffer) error {
 	t, err := tree.Deserialize(d, src)
 	if err != nil {
 		return err
 	}
 	e.Tree = t
+	return nil
+}
 
+func (e *exemplarEntry) deserializeHeader(src *bytes.Buffer) error {
 	st, err := varint.Read(src)
 	if err != nil {
 		return err
@@ -2,11 +2,14 @@ package storage
 
 import (
 	"context"
+	"fmt"
+	"math/big"
 	"time"
 
 	"github.com/pyroscope-io/pyroscope/pkg/flameql"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/heatmap"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
 )
 
@@ -34,7 +37,61 @@ type QueryExemplarsOutput struct {
 	Telemetry     map[string]interface{}
 }
 
-func (*Storage) QueryExemplars(context.Context, QueryExemplarsInput) (QueryExemplarsOutput, error) {
-	// FIXME(kolesnikovae): Not implemented.
-	return QueryExemplarsOutput{Tree: tree.New()}, nil
+func (s *Storage) QueryExemplars(ctx context.Context, qi QueryExemplarsInput) (out QueryExemplarsOutput, err error) {
+	out.Tree = tree.New()
+	out.HeatmapSketch = heatmap.NewSketch(qi.HeatmapParams)
+	startTime := unixNano(qi.StartTime)
+	endTime := unixNano(qi.EndTime)
+	filter := func(e *exemplarEntry) bool {
+		return exemplarMatchesTimeRange(*e, startTime, endTime) && exemplarMatchesQuery(*e, qi.Query)
+	}
+	err = s.exemplars.scan(ctx, qi.Query.AppName, filter, func(e exemplarEntry) error {
+		v := e.Tree.Samples()
+		if e.StartTime != 0 {
+			out.HeatmapSketch.Add(time.Unix(0, e.StartTime), v)
+		}
+		if qi.ExemplarsSelection.matches(e, v) {
+			out.Tree.Merge(e.Tree)
+			out.Count++
+		}
+		return nil
+	})
+	if err != nil || out.Count == 0 {
+		return out, err
+	}
+
+	r, ok := s.segments.Lookup(segment.AppSegmentKey(qi.Query.AppName))
+	if !ok {
+		return out, fmt.Errorf("no metadata found for app %q", qi.Query.AppName)
+	}
+	out.Metadata = r.(*segment.Segment).GetMetadata()
+	if out.Count > 1 && out.Metadata.AggregationType == metadata.AverageAggregationType {
+		out.Tree = out.Tree.Clone(big.NewRat(1, int64(out.Count)))
+	}
+
+	return out, nil
+}
+
+// exemplarMatchesQuery reports whether the exemplar labels satisfy all the
+// query tag matchers. A missing label is treated as an empty value.
+func exemplarMatchesQuery(e exemplarEntry, q *flameql.Query) bool {
+	for _, m := range q.Matchers {
+		v := e.Labels[m.Key]
+		if m.Key == segment.ProfileIDLabelName {
+			v = e.ProfileID
+		}
+		if !m.Match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches reports whether the exemplar with total value v belongs to the
+// selection. Zero boundaries are not taken into account.
+func (s ExemplarsSelection) matches(e exemplarEntry, v uint64) bool {
+	if v < s.MinValue || (s.MaxValue != 0 && v > s.MaxValue) {
+		return false
+	}
+	return exemplarMatchesTimeRange(e, unixNano(s.StartTime), unixNano(s.EndTime))
 }
@@ -21,6 +21,7 @@ import (
 	"github.com/pyroscope-io/pyroscope/pkg/flameql"
 	"github.com/pyroscope-io/pyroscope/pkg/health"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/dict"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/heatmap"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
@@ -161,6 +162,62 @@ var _ = Describe("Exemplars retrieval", func() {
 				}))
 			})
 		})
+
+		Context("QueryExemplars", func() {
+			query := func(q string, selection ExemplarsSelection) QueryExemplarsOutput {
+				qry, err := flameql.ParseQuery(q)
+				Expect(err).ToNot(HaveOccurred())
+				o, err := s.QueryExemplars(context.Background(), QueryExemplarsInput{
+					Query:              qry,
+					ExemplarsSelection: selection,
+					HeatmapParams: heatmap.HeatmapParams{
+						StartTime:    st.Add(-time.Second),
+						EndTime:      et,
+						TimeBuckets:  1,
+						ValueBuckets: 2,
+					},
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(o.Tree).ToNot(BeNil())
+				return o
+			}
+
+			It("merges exemplars matching the query", func() {
+				defer s.Close()
+
+				o := query(`app.cpu{span_name="foo"}`, ExemplarsSelection{})
+				Expect(o.Count).To(Equal(uint64(2)))
+				Expect(o.Tree.Samples()).To(Equal(uint64(4)))
+				Expect(o.Metadata.SpyName).To(Equal("debugspy"))
+
+				o = query(`app.cpu{span_name!~"f.*"}`, ExemplarsSelection{})
+				Expect(o.Count).To(BeZero())
+			})
+
+			It("filters exemplars by value", func() {
+				defer s.Close()
+
+				o := query(`app.cpu`, ExemplarsSelection{MinValue: 4})
+				Expect(o.Count).To(Equal(uint64(1)))
+				Expect(o.Tree.Samples()).To(Equal(uint64(6)))
+
+				o = query(`app.cpu`, ExemplarsSelection{MaxValue: 4})
+				Expect(o.Count).To(Equal(uint64(1)))
+				Expect(o.Tree.Samples()).To(Equal(uint64(3)))
+			})
+
+			It("builds heatmap of all the matching exemplars", func() {
+				defer s.Close()
+
+				o := query(`app.cpu`, ExemplarsSelection{MinValue: 4})
+				h := heatmap.Builder{}.BuildFromSketch(o.HeatmapSketch)
+				Expect(h).ToNot(BeNil())
+				Expect(h.Values).To(Equal([][]uint64{{1, 1}}))
+				Expect(h.MinValue).To(Equal(uint64(2)))
+				Expect(h.MaxValue).To(Equal(uint64(6)))
+				Expect(h.MaxDepth).To(Equal(uint64(1)))
+			})
+		})
 	})
 })
 
@@ -322,6 +379,37 @@ var _ = Describe("Exemplar serialization", func() {
 				"baz": "qux",
 			}))
 		})
+
+		It("does not deserialize the tree of filtered out exemplars", func() {
+			t := tree.New()
+			t.Insert([]byte("a;b"), uint64(1))
+			e := exemplarEntry{
+				StartTime: testing.SimpleTime(123).UnixNano(),
+				EndTime:   testing.SimpleTime(456).UnixNano(),
+				Tree:      t,
+				Labels:    map[string]string{"foo": "bar"},
+			}
+
+			d := dict.New()
+			b, err := e.Serialize(d, 1<<10)
+			Expect(err).ToNot(HaveOccurred())
+
+			var n exemplarEntry
+			ok, err := n.deserializeIf(d, b, func(x *exemplarEntry) bool {
+				Expect(x.StartTime).To(Equal(e.StartTime))
+				Expect(x.EndTime).To(Equal(e.EndTime))
+				Expect(x.Labels).To(Equal(e.Labels))
+				return false
+			})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ok).To(BeFalse())
+			Expect(n.Tree).To(BeNil())
+
+			ok, err = n.deserializeIf(d, b, func(*exemplarEntry) bool { return true })
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(n.Tree.String()).To(Equal(e.Tree.String()))
+		})
 	})
 
 	Context("exemplars v1 compatibility", func() {
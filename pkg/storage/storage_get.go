@@ -4,13 +4,13 @@ import (
 	"context"
 	"fmt"
 	"math/big"
-	"net/url"
 	"runtime/trace"
 	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/pyroscope-io/pyroscope/pkg/flameql"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/cache"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/dimension"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
@@ -59,16 +59,14 @@ func (s *Storage) Get(ctx context.Context, gi *GetInput) (*GetOutput, error) {
 		"endTime":   gi.EndTime.String(),
 	})
 
-	var dimensionKeys func() []dimension.Key
+	var dimensionKeys func() ([]dimension.Key, error)
 	switch {
 	case gi.Key != nil:
 		logger = logger.WithField("key", gi.Key.Normalized())
 		dimensionKeys = s.dimensionKeysByKey(gi.Key)
 	case gi.Query != nil:
 		logger = logger.WithField("query", gi.Query)
-		// TODO support queries with labels
-		//dimensionKeys = s.dimensionKeysByQuery(ctx, gi.Query)
-		dimensionKeys = s.dimensionKeysByQueryApp(ctx, gi)
+		dimensionKeys = s.dimensionKeysByQuery(ctx, gi.Query)
 	default:
 		// Should never happen.
 		return nil, fmt.Errorf("key or query must be specified")
@@ -98,14 +96,21 @@ func (s *Storage) Get(ctx context.Context, gi *GetInput) (*GetOutput, error) {
 		timelines   = make(map[string]*segment.Timeline)
 	)
 
-	allKeys := dimensionKeys()
+	allKeys, err := dimensionKeys()
+	if err != nil {
+		return nil, err
+	}
 	if len(allKeys) == 0 {
 		return nil, nil
 	}
-	limit := gi.ProfileLimit / len(allKeys)
-	if limit < 1 {
-		// limit must be at least 1
-		limit = 1
+	// The profile limit is shared by all the keys.
+	var limit int
+	if gi.ProfileLimit > 0 {
+		limit = gi.ProfileLimit / len(allKeys)
+		if limit < 1 {
+			// limit must be at least 1
+			limit = 1
+		}
 	}
 	segmentKeys := make([]string, 0)
 	for _, k := range allKeys {
@@ -118,104 +123,53 @@ func (s *Storage) Get(ctx context.Context, gi *GetInput) (*GetOutput, error) {
 		segmentKeys = append(segmentKeys, parsedKey.SegmentKey())
 	}
 
-	allSegments, err := s.segments.LookupByKeys(segmentKeys, gi.StartTime, gi.EndTime, gi.ProfileLimit)
-	if err != nil {
-		return nil, err
-	}
-	_, err = s.dicts.LookupByKeys(segmentKeys, gi.StartTime, gi.EndTime, gi.ProfileLimit)
-	if err != nil {
-		return nil, err
-	}
-	_, err = s.trees.LookupByKeys(segmentKeys, gi.StartTime, gi.EndTime, gi.ProfileLimit)
+	allSegments, segmentKeyOf, err := s.lookupSegments(ctx, segmentKeys, gi, limit)
 	if err != nil {
 		return nil, err
 	}
 
 	for key, segVals := range allSegments {
-		for _, segVal := range segVals {
-			st := segVal.(*segment.Segment)
-			timelineKey := "*"
-			if _, ok := timelines[timelineKey]; !ok {
-				timelines[timelineKey] = segment.GenerateTimeline(gi.StartTime, gi.EndTime)
+		timelineKey := "*"
+		if parsedKey, err := segment.ParseKey(segmentKeyOf(key)); err == nil {
+			if v, ok := parsedKey.Labels()[gi.GroupBy]; ok {
+				timelineKey = v
 			}
-
+		}
+		if _, ok := timelines[timelineKey]; !ok {
+			timelines[timelineKey] = segment.GenerateTimeline(gi.StartTime, gi.EndTime)
+		}
+		for _, v := range segVals {
+			st := v.Value.(*segment.Segment)
 			timeline.PopulateTimeline(st)
 			timelines[timelineKey].PopulateTimeline(st)
 			lastSegment = st
 
-			st.GetContext(ctx, gi.StartTime, gi.EndTime, func(depth int, samples, writes uint64, t time.Time, r *big.Rat) {
-				tk := key
-				var res interface{}
-				var ok bool
-				res, ok = s.trees.LookupWithTime(tk, t)
-				trace.Logf(ctx, traceCatGetCallback, "tree_found=%v time=%d r=%v", ok, t.Unix(), r)
-				if ok {
-					x := res.(*tree.Tree).Clone(r)
-					writesTotal += writes
-					if resultTrie == nil {
-						resultTrie = x
-						return
-					}
-					resultTrie.Merge(x)
-				}
+			// The version tree holds all the data of the version: only the
+			// part proportional to the samples within the range is taken.
+			var found bool
+			sampled := new(big.Rat)
+			st.GetContext(ctx, gi.StartTime, gi.EndTime, func(_ int, samples, writes uint64, _ time.Time, r *big.Rat) {
+				writesTotal += writes
+				sampled.Add(sampled, new(big.Rat).Mul(r, new(big.Rat).SetUint64(samples)))
+				found = true
 			})
+			if !found {
+				continue
+			}
+			res, ok := s.trees.LookupWithTime(key, v.Time)
+			trace.Logf(ctx, traceCatGetCallback, "tree_found=%v time=%d r=%v", ok, v.Time.Unix(), sampled)
+			if !ok {
+				continue
+			}
+			x := res.(*tree.Tree).Clone(versionRatio(st, sampled))
+			if resultTrie == nil {
+				resultTrie = x
+				continue
+			}
+			resultTrie.Merge(x)
 		}
 	}
 
-	//for _, k := range allKeys {
-	//	// TODO: refactor, store `Key`s in dimensions
-	//	parsedKey, err := segment.ParseKey(string(k))
-	//	if err != nil {
-	//		s.logger.Errorf("parse key: %v: %v", string(k), err)
-	//		continue
-	//	}
-	//	key := parsedKey.SegmentKey()
-	//	allSegments, err := s.segments.LookupWithTimeLimit(key, gi.StartTime, gi.EndTime, limit)
-	//	if err != nil {
-	//		return nil, err
-	//	}
-	//	_, err = s.dicts.LookupWithTimeLimit(key, gi.StartTime, gi.EndTime, limit)
-	//	if err != nil {
-	//		return nil, err
-	//	}
-	//	_, err = s.trees.LookupWithTimeLimit(key, gi.StartTime, gi.EndTime, limit)
-	//	if err != nil {
-	//		return nil, err
-	//	}
-	//	for _, segVal := range allSegments {
-	//		st := segVal.(*segment.Segment)
-	//		timelineKey := "*"
-	//		if v, ok := parsedKey.Labels()[gi.GroupBy]; ok {
-	//			timelineKey = v
-	//		}
-	//		if _, ok := timelines[timelineKey]; !ok {
-	//			timelines[timelineKey] = segment.GenerateTimeline(gi.StartTime, gi.EndTime)
-	//		}
-	//
-	//		timeline.PopulateTimeline(st)
-	//		timelines[timelineKey].PopulateTimeline(st)
-	//		lastSegment = st
-	//
-	//		trace.Logf(ctx, traceCatGetCallback, "segment_key=%s", key)
-	//		st.GetContext(ctx, gi.StartTime, gi.EndTime, func(depth int, samples, writes uint64, t time.Time, r *big.Rat) {
-	//			tk := parsedKey.TreeKey()
-	//			var res interface{}
-	//			var ok bool
-	//			res, ok = s.trees.LookupWithTime(tk, t)
-	//			trace.Logf(ctx, traceCatGetCallback, "tree_found=%v time=%d r=%v", ok, t.Unix(), r)
-	//			if ok {
-	//				x := res.(*tree.Tree).Clone(r)
-	//				writesTotal += writes
-	//				if resultTrie == nil {
-	//					resultTrie = x
-	//					return
-	//				}
-	//				resultTrie.Merge(x)
-	//			}
-	//		})
-	//	}
-	//}
-
 	if resultTrie == nil || lastSegment == nil {
 		return nil, nil
 	}
@@ -238,6 +192,84 @@ func (s *Storage) Get(ctx context.Context, gi *GetInput) (*GetOutput, error) {
 	}, nil
 }
 
+// versionRatio returns the ratio of the sampled number of samples
+// to the total number of samples of the segment version.
+func versionRatio(st *segment.Segment, sampled *big.Rat) *big.Rat {
+	total := st.Samples()
+	if total == 0 {
+		return big.NewRat(1, 1)
+	}
+	r := new(big.Rat).Quo(sampled, new(big.Rat).SetUint64(total))
+	if r.Cmp(big.NewRat(1, 1)) > 0 {
+		return big.NewRat(1, 1)
+	}
+	return r
+}
+
+// lookupSegments returns segment versions within the time range. Rollups of
+// the coarsest suitable resolution are read instead of the segment versions,
+// if the rollups hold all the data of the range, and have any data within
+// the range. The returned function maps
+// the rollup keys to the segment keys. A positive limit restricts the number
+// of versions of every key, as in cache.LookupByKeys.
+func (s *Storage) lookupSegments(ctx context.Context, segmentKeys []string, gi *GetInput, limit int) (map[string][]cache.Version, func(string) string, error) {
+	r, ok := pickRollup(gi.StartTime, gi.EndTime)
+	if !ok {
+		versions, err := s.segments.LookupByKeys(segmentKeys, gi.StartTime, gi.EndTime, limit)
+		return versions, func(k string) string { return k }, err
+	}
+
+	// A bucket that starts before the range may hold data within the range.
+	st := gi.StartTime.Truncate(r.d)
+	rawKeys := make([]string, 0, len(segmentKeys))
+	rollupKeys := make([]string, 0, len(segmentKeys))
+	keys := make(map[string]string, len(segmentKeys))
+	for _, sk := range segmentKeys {
+		if since, ok := s.rollupsSince(ctx, sk); ok && !since.After(st) {
+			rk := rollupKey(sk, r)
+			rollupKeys = append(rollupKeys, rk)
+			keys[rk] = sk
+			continue
+		}
+		rawKeys = append(rawKeys, sk)
+	}
+
+	versions := make(map[string][]cache.Version, len(segmentKeys))
+	if len(rollupKeys) > 0 {
+		res, err := s.segments.LookupByKeys(rollupKeys, st, gi.EndTime, limit)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, rk := range rollupKeys {
+			// Segment versions are read if the rollup has no data
+			// within the range, e.g. if it has been removed.
+			v, ok := res[rk]
+			if !ok || len(v) == 0 {
+				rawKeys = append(rawKeys, keys[rk])
+				delete(keys, rk)
+				continue
+			}
+			versions[rk] = v
+		}
+	}
+	if len(rawKeys) > 0 {
+		res, err := s.segments.LookupByKeys(rawKeys, gi.StartTime, gi.EndTime, limit)
+		if err != nil {
+			return nil, nil, err
+		}
+		for k, v := range res {
+			versions[k] = v
+		}
+	}
+
+	return versions, func(k string) string {
+		if sk, ok := keys[k]; ok {
+			return sk
+		}
+		return k
+	}, nil
+}
+
 func (s *Storage) tryGetExemplar(ctx context.Context, gi *GetInput) (*GetOutput, bool, error) {
 	ids := make([]string, 0, len(gi.Query.Matchers))
 	for _, m := range gi.Query.Matchers {
@@ -279,13 +311,13 @@ func (s *Storage) tryGetExemplar(ctx context.Context, gi *GetInput) (*GetOutput,
 	return &out, true, nil
 }
 
-func (s *Storage) execQuery(_ context.Context, qry *flameql.Query) []dimension.Key {
+func (s *Storage) execQuery(ctx context.Context, qry *flameql.Query) ([]dimension.Key, error) {
 	app, found := s.lookupAppDimension(qry.AppName)
 	if !found {
-		return nil
+		return nil, nil
 	}
 	if len(qry.Matchers) == 0 {
-		return app.Keys
+		return app.Keys, nil
 	}
 
 	r := []*dimension.Dimension{app}
@@ -297,20 +329,27 @@ func (s *Storage) execQuery(_ context.Context, qry *flameql.Query) []dimension.K
 			if d, ok := s.lookupDimension(m); ok {
 				r = append(r, d)
 			} else {
-				return nil
+				return nil, nil
 			}
 		case flameql.OpNotEqual:
 			if d, ok := s.lookupDimension(m); ok {
 				n = append(n, d)
 			}
 		case flameql.OpEqualRegex:
-			if d, ok := s.lookupDimensionRegex(m); ok {
-				r = append(r, d)
-			} else {
-				return nil
+			d, ok, err := s.lookupDimensionRegex(ctx, m)
+			switch {
+			case err != nil:
+				return nil, err
+			case !ok:
+				return nil, nil
 			}
+			r = append(r, d)
 		case flameql.OpNotEqualRegex:
-			if d, ok := s.lookupDimensionRegex(m); ok {
+			d, ok, err := s.lookupDimensionRegex(ctx, m)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
 				n = append(n, d)
 			}
 		}
@@ -318,61 +357,28 @@ func (s *Storage) execQuery(_ context.Context, qry *flameql.Query) []dimension.K
 
 	i := dimension.Intersection(r...)
 	if len(n) == 0 {
-		return i
+		return i, nil
 	}
 
 	return dimension.AndNot(
 		&dimension.Dimension{Keys: i},
-		&dimension.Dimension{Keys: dimension.Union(n...)})
+		&dimension.Dimension{Keys: dimension.Union(n...)}), nil
 }
 
-func (s *Storage) dimensionKeysByQuery(ctx context.Context, qry *flameql.Query) func() []dimension.Key {
-	return func() []dimension.Key { return s.execQuery(ctx, qry) }
+func (s *Storage) dimensionKeysByQuery(ctx context.Context, qry *flameql.Query) func() ([]dimension.Key, error) {
+	return func() ([]dimension.Key, error) { return s.execQuery(ctx, qry) }
 }
 
-func (s *Storage) dimensionKeysByQueryApp(ctx context.Context, qry *GetInput) func() []dimension.Key {
-	return func() []dimension.Key {
-		query := url.Values{}
-		query.Set("name", qry.Query.AppName)
-		for _, m := range qry.Query.Matchers {
-			switch m.Key {
-			case "DICE_WORKSPACE":
-				query.Set("workspace", m.Value)
-			case "DICE_PROJECT_ID":
-				query.Set("projectID", m.Value)
-			case "POD_IP":
-				query.Set("podIP", m.Value)
-			default:
-			}
-		}
-		if !qry.StartTime.IsZero() {
-			query.Set("updateTime", qry.StartTime.Format("2006-01-02 15:04:05"))
-		}
-		ctx = context.WithValue(ctx, "query", query)
-		apps, err := s.appSvc.List(ctx)
-		if err != nil {
-			logrus.Errorf("failed to get application metadata, query: %v, err: %v", query, err)
-			return nil
-		}
-		var keys []dimension.Key
-		for _, app := range apps {
-			segmentKey := app.ToSegmentKey()
-			keys = append(keys, []byte(segmentKey.Normalized()))
-		}
-		return keys
-	}
-}
-
-func (s *Storage) dimensionKeysByKey(key *segment.Key) func() []dimension.Key {
-	return func() []dimension.Key {
+func (s *Storage) dimensionKeysByKey(key *segment.Key) func() ([]dimension.Key, error) {
+	return func() ([]dimension.Key, error) {
 		d, ok := s.lookupAppDimension(key.AppName())
 		if !ok {
-			return nil
+			return nil, nil
 		}
 		l := key.Labels()
 		if len(l) == 1 {
 			// No tags specified: return application dimension keys.
-			return d.Keys
+			return d.Keys, nil
 		}
 		dimensions := []*dimension.Dimension{d}
 		for k, v := range l {
@@ -385,9 +391,9 @@ func (s *Storage) dimensionKeysByKey(key *segment.Key) func() []dimension.Key {
 		}
 		if len(dimensions) == 1 {
 			// Tags specified but not found.
-			return nil
+			return nil, nil
 		}
-		return dimension.Intersection(dimensions...)
+		return dimension.Intersection(dimensions...), nil
 	}
 }
 
@@ -399,9 +405,9 @@ func (s *Storage) lookupDimension(m *flameql.TagMatcher) (*dimension.Dimension,
 	return s.lookupDimensionKV(m.Key, m.Value)
 }
 
-func (s *Storage) lookupDimensionRegex(m *flameql.TagMatcher) (*dimension.Dimension, bool) {
+func (s *Storage) lookupDimensionRegex(ctx context.Context, m *flameql.TagMatcher) (*dimension.Dimension, bool, error) {
 	d := dimension.New()
-	s.labels.GetValues(m.Key, func(v string) bool {
+	err := s.labels.GetValues(ctx, m.Key, func(v string) bool {
 		if m.R.MatchString(v) {
 			if x, ok := s.lookupDimensionKV(m.Key, v); ok {
 				d.Keys = append(d.Keys, x.Keys...)
@@ -409,10 +415,13 @@ func (s *Storage) lookupDimensionRegex(m *flameql.TagMatcher) (*dimension.Dimens
 		}
 		return true
 	})
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to get values of label %q: %w", m.Key, err)
+	}
 	if len(d.Keys) > 0 {
-		return d, true
+		return d, true, nil
 	}
-	return nil, false
+	return nil, false, nil
 }
 
 func (s *Storage) lookupDimensionKV(k, v string) (*dimension.Dimension, bool) {
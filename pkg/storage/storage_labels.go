@@ -11,11 +11,13 @@ import (
 )
 
 //revive:disable-next-line:get-return callback is used
-func (s *Storage) GetKeys(_ context.Context, cb func(string) bool) { s.labels.GetKeys(cb) }
+func (s *Storage) GetKeys(ctx context.Context, cb func(string) bool) error {
+	return s.labels.GetKeys(ctx, cb)
+}
 
 //revive:disable-next-line:get-return callback is used
-func (s *Storage) GetValues(_ context.Context, key string, cb func(v string) bool) {
-	s.labels.GetValues(key, func(v string) bool {
+func (s *Storage) GetValues(ctx context.Context, key string, cb func(v string) bool) error {
+	return s.labels.GetValues(ctx, key, func(v string) bool {
 		if key != "__name__" || !slices.StringContains(s.config.hideApplications, v) {
 			return cb(v)
 		}
@@ -36,8 +38,13 @@ func (s *Storage) GetKeysByQuery(_ context.Context, in GetLabelKeysByQueryInput)
 	}
 	dimensionKeys := s.dimensionKeysByKey(segmentKey)
 
+	keys, err := dimensionKeys()
+	if err != nil {
+		return output, err
+	}
+
 	resultSet := map[string]bool{}
-	for _, dk := range dimensionKeys() {
+	for _, dk := range keys {
 		dkParsed, _ := segment.ParseKey(string(dk))
 		if dkParsed.AppName() == parsedQuery.AppName {
 			for k := range dkParsed.Labels() {
@@ -67,8 +74,13 @@ func (s *Storage) GetValuesByQuery(_ context.Context, in GetLabelValuesByQueryIn
 	}
 	dimensionKeys := s.dimensionKeysByKey(segmentKey)
 
+	keys, err := dimensionKeys()
+	if err != nil {
+		return output, err
+	}
+
 	resultSet := map[string]bool{}
-	for _, dk := range dimensionKeys() {
+	for _, dk := range keys {
 		dkParsed, _ := segment.ParseKey(string(dk))
 		if v, ok := dkParsed.Labels()[in.Label]; ok {
 			resultSet[v] = true
@@ -85,10 +97,10 @@ func (s *Storage) GetValuesByQuery(_ context.Context, in GetLabelValuesByQueryIn
 
 // GetAppNames returns the list of all app's names
 // It works by querying the __name__ label
-func (s *Storage) GetAppNames(ctx context.Context) []string {
+func (s *Storage) GetAppNames(ctx context.Context) ([]string, error) {
 	appNames := make([]string, 0)
 
-	s.GetValues(ctx, "__name__", func(v string) bool {
+	err := s.GetValues(ctx, "__name__", func(v string) bool {
 		if strings.TrimSpace(v) != "" {
 			// skip empty app names
 			appNames = append(appNames, v)
@@ -97,5 +109,5 @@ func (s *Storage) GetAppNames(ctx context.Context) []string {
 		return true
 	})
 
-	return appNames
+	return appNames, err
 }
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"math/big"
 	"strings"
 	"time"
@@ -9,6 +10,7 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"github.com/pyroscope-io/pyroscope/pkg/model/appmetadata"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/dimension"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
@@ -26,10 +28,35 @@ type PutInput struct {
 	SampleType      string
 }
 
-func (s *Storage) Put(ctx context.Context, pi *PutInput) error {
+// CheckPut returns the error Put would fail with because of the disk
+// space, quota, and cardinality limits, without writing anything. A new
+// series of the input is reserved until the input is put, or ReleasePut
+// is called.
+func (s *Storage) CheckPut(ctx context.Context, pi *PutInput) error {
 	if s.hc.IsOutOfDiskSpace() {
 		return errOutOfSpace
 	}
+	if err := s.checkQuota(pi.Key.AppName()); err != nil {
+		return err
+	}
+	if pi.Key.HasProfileID() {
+		return nil
+	}
+	return s.checkCardinality(ctx, pi.Key)
+}
+
+// ReleasePut releases the reservation made by CheckPut
+// for an input that is not going to be put.
+func (s *Storage) ReleasePut(pi *PutInput) {
+	if !pi.Key.HasProfileID() {
+		s.releaseSeries(pi.Key)
+	}
+}
+
+func (s *Storage) Put(ctx context.Context, pi *PutInput) error {
+	if err := s.CheckPut(ctx, pi); err != nil {
+		return err
+	}
 	if pi.StartTime.Before(s.retentionPolicy().LowerTimeBoundary()) {
 		return errRetention
 	}
@@ -46,16 +73,7 @@ func (s *Storage) Put(ctx context.Context, pi *PutInput) error {
 		Units:           pi.Units,
 		AggregationType: pi.AggregationType,
 		SampleType:      appList[len(appList)-1],
-		OrgID:           pi.Key.Labels()["DICE_ORG_ID"],
-		OrgName:         pi.Key.Labels()["DICE_ORG_NAME"],
-		Workspace:       pi.Key.Labels()["DICE_WORKSPACE"],
-		ProjectID:       pi.Key.Labels()["DICE_PROJECT_ID"],
-		ProjectName:     pi.Key.Labels()["DICE_PROJECT_NAME"],
-		AppID:           pi.Key.Labels()["DICE_APPLICATION_ID"],
-		AppName:         pi.Key.Labels()["DICE_APPLICATION_NAME"],
-		ClusterName:     pi.Key.Labels()["DICE_CLUSTER_NAME"],
-		ServiceName:     pi.Key.Labels()["DICE_SERVICE"],
-		PodIP:           pi.Key.Labels()["POD_IP"],
+		Attributes:      s.config.appMetadataLabels.Attributes(pi.Key.Labels()),
 	}); err != nil {
 		s.logger.Error("error saving metadata", err)
 	}
@@ -77,26 +95,62 @@ func (s *Storage) Put(ctx context.Context, pi *PutInput) error {
 		"aggregationType": pi.AggregationType,
 	}).Debug("storage.Put")
 
-	// Suspend writing labels, because the currently written labels are known
-	//if err := s.labels.PutLabels(pi.Key.Labels()); err != nil {
-	//	return fmt.Errorf("unable to write labels: %w", err)
-	//}
+	// The series reserved by the cardinality check is released once
+	// it is added to the dimensions, or the put fails.
+	defer s.releaseSeries(pi.Key)
+	s.observeCardinality(pi.Key)
+	if err := s.labels.PutLabels(ctx, pi.Key.Labels()); err != nil {
+		return fmt.Errorf("unable to write labels: %w", err)
+	}
 
 	sk := pi.Key.SegmentKey()
-	//for k, v := range pi.Key.Labels() {
-	//	key := k + ":" + v
-	//	r, err := s.dimensions.GetOrCreate(key)
-	//	if err != nil {
-	//		s.logger.Errorf("dimensions cache for %v: %v", key, err)
-	//		continue
-	//	}
-	//	r.(*dimension.Dimension).Insert([]byte(sk))
-	//	s.dimensions.Put(key, r)
-	//}
-
-	//skWithTime := fmt.Sprintf("%s:%d", sk, pi.EndTime.Unix())
-	skKey := sk
-	r := s.segments.New(skKey)
+	_, exists := s.seriesCount(pi.Key)
+	for k, v := range pi.Key.Labels() {
+		key := k + ":" + v
+		r, err := s.dimensions.GetOrCreate(key)
+		if err != nil {
+			s.logger.Errorf("dimensions cache for %v: %v", key, err)
+			continue
+		}
+		r.(*dimension.Dimension).Insert([]byte(sk))
+		if err = s.dimensions.Put(key, r); err != nil {
+			return fmt.Errorf("dimensions cache for %v: %w", key, err)
+		}
+	}
+
+	// Existing rollups of the segment would miss the data written
+	// while rollups are disabled, therefore they are not used anymore.
+	if !s.config.rollups {
+		if err := s.discardRollups(ctx, sk); err != nil {
+			return fmt.Errorf("unable to discard rollups: %w", err)
+		}
+	}
+
+	// Every put creates a segment version at the profile start time,
+	// the version accumulates all the profiles that start at this time.
+	if err := s.putSegmentVersion(sk, pi.StartTime, pi); err != nil {
+		return err
+	}
+	if !s.config.rollups {
+		return nil
+	}
+	if err := s.initRollups(ctx, sk, !exists); err != nil {
+		return fmt.Errorf("unable to init rollups: %w", err)
+	}
+	for _, r := range rollupResolutions {
+		if err := s.putSegmentVersion(rollupKey(sk, r), pi.StartTime.Truncate(r.d), pi); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *Storage) putSegmentVersion(sk string, version time.Time, pi *PutInput) error {
+	r, err := s.segments.GetOrCreateWithTime(sk, version)
+	if err != nil {
+		return fmt.Errorf("segments cache for %v: %w", sk, err)
+	}
 
 	st := r.(*segment.Segment)
 	st.SetMetadata(metadata.Metadata{
@@ -106,29 +160,23 @@ func (s *Storage) Put(ctx context.Context, pi *PutInput) error {
 		AggregationType: pi.AggregationType,
 	})
 
-	samples := pi.Val.Samples()
-	err := st.Put(pi.StartTime, pi.EndTime, samples, func(depth int, t time.Time, r *big.Rat, addons []segment.Addon) {
-		tk := pi.Key.TreeKey()
-		res := s.trees.New(tk)
-		cachedTree := res.(*tree.Tree)
-		treeClone := pi.Val.Clone(r)
-		//for _, addon := range addons {
-		//	if res, ok := s.trees.Lookup(pi.Key.TreeKey()); ok {
-		//		ta := res.(*tree.Tree)
-		//		ta.RLock()
-		//		treeClone.Merge(ta)
-		//		ta.RUnlock()
-		//	}
-		//}
-		cachedTree.Lock()
-		cachedTree.Merge(treeClone)
-		cachedTree.Unlock()
-		s.trees.PutWithTime(tk, cachedTree, pi.StartTime)
-	})
-	if err != nil {
+	// The segment version tree is keyed by the segment key and versioned
+	// by the segment version time: the tree holds all the data of the
+	// version, and the segment nodes only account for the samples.
+	if err = st.Put(pi.StartTime, pi.EndTime, pi.Val.Samples(), func(int, time.Time, *big.Rat, []segment.Addon) {}); err != nil {
 		return err
 	}
+	res, err := s.trees.GetOrCreateWithTime(sk, version)
+	if err != nil {
+		return fmt.Errorf("trees cache for %v: %w", sk, err)
+	}
+	cachedTree := res.(*tree.Tree)
+	cachedTree.Lock()
+	cachedTree.Merge(pi.Val)
+	cachedTree.Unlock()
+	if err = s.trees.PutWithTime(sk, cachedTree, version); err != nil {
+		return fmt.Errorf("trees cache for %v: %w", sk, err)
+	}
 
-	s.segments.PutWithTime(skKey, st, pi.StartTime)
-	return nil
+	return s.segments.PutWithTime(sk, st, version)
 }
@@ -5,13 +5,17 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"runtime"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/shirou/gopsutil/mem"
 	"github.com/sirupsen/logrus"
 
@@ -22,6 +26,7 @@ import (
 	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
 	"github.com/pyroscope-io/pyroscope/pkg/testing"
+	"github.com/pyroscope-io/pyroscope/pkg/util/bytesize"
 )
 
 // 21:22:08      air |  (time.Duration) 16m40s,
@@ -103,10 +108,10 @@ var _ = Describe("storage package", func() {
 					})
 
 					Expect(s.Delete(context.TODO(), &DeleteInput{key})).ToNot(HaveOccurred())
-					s.GetValues(context.TODO(), "__name__", func(v string) bool {
+					Expect(s.GetValues(context.TODO(), "__name__", func(v string) bool {
 						Fail("app name label was not removed")
 						return false
-					})
+					})).ToNot(HaveOccurred())
 
 					gOut, err := s.Get(context.TODO(), &GetInput{
 						StartTime: st2,
@@ -298,24 +303,26 @@ var _ = Describe("storage package", func() {
 
 	logrus.SetLevel(logrus.InfoLevel)
 
-	// Disk-based storage
-	testing.WithConfig(func(cfg **config.Config) {
-		JustBeforeEach(func() {
-			var err error
-			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller), NoopApplicationMetadataService{})
-			Expect(err).ToNot(HaveOccurred())
+	Context("disk-based storage", func() {
+		testing.WithConfig(func(cfg **config.Config) {
+			JustBeforeEach(func() {
+				var err error
+				s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller), NoopApplicationMetadataService{})
+				Expect(err).ToNot(HaveOccurred())
+			})
+			suite()
 		})
-		suite()
 	})
 
-	// In-memory storage
-	testing.WithConfig(func(cfg **config.Config) {
-		JustBeforeEach(func() {
-			var err error
-			s, err = New(NewConfig(&(*cfg).Server).WithInMemory(), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller), NoopApplicationMetadataService{})
-			Expect(err).ToNot(HaveOccurred())
+	Context("in-memory storage", func() {
+		testing.WithConfig(func(cfg **config.Config) {
+			JustBeforeEach(func() {
+				var err error
+				s, err = New(NewConfig(&(*cfg).Server).WithInMemory(), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller), NoopApplicationMetadataService{})
+				Expect(err).ToNot(HaveOccurred())
+			})
+			suite()
 		})
-		suite()
 	})
 })
 
@@ -422,6 +429,21 @@ var _ = Describe("querying", func() {
 				Expect(s.Close()).ToNot(HaveOccurred())
 			})
 
+			It("get filters results with negative and regex matchers", func() {
+				qry, err := flameql.ParseQuery(`app.name{baz!="qux",waldo!~"f.*"}`)
+				Expect(err).ToNot(HaveOccurred())
+				output, err := s.Get(context.TODO(), &GetInput{
+					StartTime: time.Time{},
+					EndTime:   maxTime,
+					Query:     qry,
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(output).ToNot(BeNil())
+				Expect(output.Tree).ToNot(BeNil())
+				Expect(output.Tree.Samples()).To(Equal(uint64(3)))
+				Expect(s.Close()).ToNot(HaveOccurred())
+			})
+
 			It("get returns a particular tree for a fully qualified key", func() {
 				k, err := segment.ParseKey(`app.name{foo=bar,baz=qux}`)
 				Expect(err).ToNot(HaveOccurred())
@@ -527,7 +549,8 @@ var _ = Describe("querying", func() {
 				for _, tc := range testCases {
 					qry, err := flameql.ParseQuery(tc.query)
 					Expect(err).ToNot(HaveOccurred())
-					r := s.execQuery(context.TODO(), qry)
+					r, err := s.execQuery(context.TODO(), qry)
+					Expect(err).ToNot(HaveOccurred())
 					if tc.segmentKeys == nil {
 						Expect(r).To(BeEmpty())
 						continue
@@ -536,29 +559,45 @@ var _ = Describe("querying", func() {
 				}
 				Expect(s.Close()).ToNot(HaveOccurred())
 			})
+
+			It("get returns an error if label values can not be read", func() {
+				qry, err := flameql.ParseQuery(`app.name{foo=~"ba.+"}`)
+				Expect(err).ToNot(HaveOccurred())
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+				_, err = s.Get(ctx, &GetInput{
+					StartTime: time.Now().Add(-time.Hour),
+					EndTime:   time.Now(),
+					Query:     qry,
+				})
+				Expect(err).To(MatchError(context.Canceled))
+				Expect(s.Close()).ToNot(HaveOccurred())
+			})
 		})
 	}
 
-	// Disk-based storage
-	testing.WithConfig(func(cfg **config.Config) {
-		JustBeforeEach(func() {
-			var err error
-			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller), NoopApplicationMetadataService{})
-			Expect(err).ToNot(HaveOccurred())
-			setup()
+	Context("disk-based storage", func() {
+		testing.WithConfig(func(cfg **config.Config) {
+			JustBeforeEach(func() {
+				var err error
+				s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller), NoopApplicationMetadataService{})
+				Expect(err).ToNot(HaveOccurred())
+				setup()
+			})
+			suite()
 		})
-		suite()
 	})
 
-	// In-memory storage
-	testing.WithConfig(func(cfg **config.Config) {
-		JustBeforeEach(func() {
-			var err error
-			s, err = New(NewConfig(&(*cfg).Server).WithInMemory(), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller), NoopApplicationMetadataService{})
-			Expect(err).ToNot(HaveOccurred())
-			setup()
+	Context("in-memory storage", func() {
+		testing.WithConfig(func(cfg **config.Config) {
+			JustBeforeEach(func() {
+				var err error
+				s, err = New(NewConfig(&(*cfg).Server).WithInMemory(), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller), NoopApplicationMetadataService{})
+				Expect(err).ToNot(HaveOccurred())
+				setup()
+			})
+			suite()
 		})
-		suite()
 	})
 })
 
@@ -616,27 +655,146 @@ var _ = Describe("CollectGarbage", func() {
 
 				Expect(s.Close()).ToNot(HaveOccurred())
 			})
+
+			It("removes expired segment versions along with their trees", func() {
+				key, _ := segment.ParseKey("foo")
+				tree := tree.New()
+				tree.Insert([]byte("a;b"), uint64(1))
+				now := time.Now()
+
+				var expired []time.Time
+				for i := 0; i < 3; i++ {
+					expired = append(expired, now.Add(-3*time.Hour).Add(time.Duration(i)*time.Minute))
+				}
+				for _, st := range append(expired, now.Add(-time.Minute)) {
+					Expect(s.Put(context.TODO(), &PutInput{
+						StartTime:  st,
+						EndTime:    st.Add(time.Second * 10),
+						Key:        key,
+						Val:        tree,
+						SpyName:    "testspy",
+						SampleRate: 100,
+					})).ToNot(HaveOccurred())
+				}
+
+				rp := segment.NewRetentionPolicy().SetAbsolutePeriod(time.Hour)
+				s.enforceRetentionPolicy(context.Background(), rp)
+
+				sk := key.SegmentKey()
+				for _, st := range expired {
+					_, ok := s.segments.LookupWithTime(sk, st)
+					Expect(ok).To(BeFalse())
+					_, ok = s.trees.LookupWithTime(sk, st)
+					Expect(ok).To(BeFalse())
+				}
+				_, ok := s.trees.LookupWithTime(sk, now.Add(-time.Minute))
+				Expect(ok).To(BeTrue())
+				Expect(testutil.ToFloat64(s.metrics.retentionRemovedTotal.WithLabelValues("trees"))).To(Equal(float64(3)))
+				Expect(testutil.ToFloat64(s.metrics.retentionRemovedTotal.WithLabelValues("segments"))).To(Equal(float64(3)))
+
+				Expect(s.Close()).ToNot(HaveOccurred())
+			})
+
+			It("removes data outside level retention period", func() {
+				key, _ := segment.ParseKey("foo")
+				tree := tree.New()
+				tree.Insert([]byte("a;b"), uint64(2))
+				tree.Insert([]byte("a;c"), uint64(4))
+				// The profile covers two level 0 nodes,
+				// therefore it is also written to level 1.
+				st := time.Now().Add(-3 * time.Hour).Truncate(1000 * time.Second)
+				et := st.Add(200 * time.Second)
+				Expect(s.Put(context.TODO(), &PutInput{
+					StartTime:  st,
+					EndTime:    et,
+					Key:        key,
+					Val:        tree,
+					SpyName:    "testspy",
+					SampleRate: 100,
+				})).ToNot(HaveOccurred())
+
+				rp := segment.NewRetentionPolicy().SetLevelPeriod(0, time.Hour)
+				s.enforceRetentionPolicy(context.Background(), rp)
+
+				sk := key.SegmentKey()
+				_, ok := s.segments.LookupWithTime(sk, st)
+				Expect(ok).To(BeTrue())
+				// The version tree is kept as long as the version has nodes.
+				_, ok = s.trees.LookupWithTime(sk, st)
+				Expect(ok).To(BeTrue())
+
+				// Level 1 node is still available.
+				o, err := s.Get(context.TODO(), &GetInput{
+					StartTime: st,
+					EndTime:   st.Add(1000 * time.Second),
+					Key:       key,
+				})
+				Expect(err).ToNot(HaveOccurred())
+				Expect(o).ToNot(BeNil())
+				Expect(o.Tree.Samples()).To(Equal(uint64(6)))
+
+				Expect(s.Close()).ToNot(HaveOccurred())
+			})
+
+			It("removes labels not referenced anymore", func() {
+				tree := tree.New()
+				tree.Insert([]byte("a;b"), uint64(1))
+				now := time.Now()
+
+				for _, k := range []string{"foo{bar=baz}", "qux{bar=waldo}"} {
+					key, _ := segment.ParseKey(k)
+					st := now.Add(-3 * time.Hour)
+					if key.AppName() == "qux" {
+						st = now.Add(-time.Minute)
+					}
+					Expect(s.Put(context.TODO(), &PutInput{
+						StartTime:  st,
+						EndTime:    st.Add(time.Second * 10),
+						Key:        key,
+						Val:        tree,
+						SpyName:    "testspy",
+						SampleRate: 100,
+					})).ToNot(HaveOccurred())
+				}
+
+				rp := segment.NewRetentionPolicy().SetAbsolutePeriod(time.Hour)
+				s.enforceRetentionPolicy(context.Background(), rp)
+
+				Expect(s.GetAppNames(context.TODO())).To(Equal([]string{"qux"}))
+				var values []string
+				Expect(s.GetValues(context.TODO(), "bar", func(v string) bool {
+					values = append(values, v)
+					return true
+				})).ToNot(HaveOccurred())
+				Expect(values).To(Equal([]string{"waldo"}))
+				_, ok := s.lookupDimensionKV("bar", "baz")
+				Expect(ok).To(BeFalse())
+
+				Expect(s.Close()).ToNot(HaveOccurred())
+			})
 		})
 	}
 
-	// Disk-based storage
-	testing.WithConfig(func(cfg **config.Config) {
-		JustBeforeEach(func() {
-			var err error
-			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller), NoopApplicationMetadataService{})
-			Expect(err).ToNot(HaveOccurred())
+	Context("disk-based storage", func() {
+		testing.WithConfig(func(cfg **config.Config) {
+			JustBeforeEach(func() {
+				var err error
+				s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller), NoopApplicationMetadataService{})
+				Expect(err).ToNot(HaveOccurred())
+			})
+			suite()
 		})
-		suite()
 	})
 
-	// In-memory storage
-	testing.WithConfig(func(cfg **config.Config) {
-		JustBeforeEach(func() {
-			var err error
-			s, err = New(NewConfig(&(*cfg).Server).WithInMemory(), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller), NoopApplicationMetadataService{})
-			Expect(err).ToNot(HaveOccurred())
+	Context("in-memory storage", func() {
+		testing.WithConfig(func(cfg **config.Config) {
+			JustBeforeEach(func() {
+				var err error
+				s, err = New(NewConfig(&(*cfg).Server).WithInMemory(), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller), NoopApplicationMetadataService{})
+				Expect(err).ToNot(HaveOccurred())
+			})
+			suite()
 		})
-		suite()
 	})
 })
 
@@ -673,3 +831,348 @@ var _ = Describe("Getters", func() {
 		})
 	})
 })
+
+var _ = Describe("Storage quota", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			(*cfg).Server.StorageQuota.App = bytesize.Byte
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller), NoopApplicationMetadataService{})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("refuses ingestion once the application quota is exceeded", func() {
+			put := func(app string) error {
+				tree := tree.New()
+				tree.Insert([]byte("a;b"), uint64(1))
+				key, _ := segment.ParseKey(app)
+				return s.Put(context.TODO(), &PutInput{
+					StartTime:  testing.SimpleTime(10),
+					EndTime:    testing.SimpleTime(19),
+					Key:        key,
+					Val:        tree,
+					SpyName:    "testspy",
+					SampleRate: 100,
+				})
+			}
+
+			Expect(put("foo")).ToNot(HaveOccurred())
+			s.writeBackTask()
+			s.quotaTask()
+
+			Expect(errors.Is(put("foo"), errOutOfSpace)).To(BeTrue())
+			Expect(put("bar")).ToNot(HaveOccurred())
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("refuses to queue inputs once the application quota is exceeded", func() {
+			q := NewIngestionQueue(logrus.StandardLogger(), s, prometheus.NewRegistry(), s.config, nil)
+			put := func(app string) error {
+				tree := tree.New()
+				tree.Insert([]byte("a;b"), uint64(1))
+				key, _ := segment.ParseKey(app)
+				return q.Put(context.TODO(), &PutInput{
+					StartTime:  testing.SimpleTime(10),
+					EndTime:    testing.SimpleTime(19),
+					Key:        key,
+					Val:        tree,
+					SpyName:    "testspy",
+					SampleRate: 100,
+				})
+			}
+
+			Expect(put("foo")).ToNot(HaveOccurred())
+			Eventually(func() bool {
+				s.writeBackTask()
+				s.quotaTask()
+				return IsOutOfSpaceError(put("foo"))
+			}).Should(BeTrue())
+			Expect(put("bar")).ToNot(HaveOccurred())
+			q.Stop()
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+	})
+})
+
+var _ = Describe("Storage cardinality limits", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			(*cfg).Server.CardinalityLimits.SeriesPerApp = 2
+			(*cfg).Server.CardinalityLimits.ValuesPerLabel = 2
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller), NoopApplicationMetadataService{})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		put := func(k string) error {
+			tree := tree.New()
+			tree.Insert([]byte("a;b"), uint64(1))
+			key, _ := segment.ParseKey(k)
+			return s.Put(context.TODO(), &PutInput{
+				StartTime:  testing.SimpleTime(10),
+				EndTime:    testing.SimpleTime(19),
+				Key:        key,
+				Val:        tree,
+				SpyName:    "testspy",
+				SampleRate: 100,
+			})
+		}
+
+		It("refuses ingestion of new series once the limit is reached", func() {
+			Expect(put("foo{a=1}")).ToNot(HaveOccurred())
+			Expect(put("foo{b=1}")).ToNot(HaveOccurred())
+			Expect(IsCardinalityLimitError(put("foo{c=1}"))).To(BeTrue())
+			Expect(put("foo{a=1}")).ToNot(HaveOccurred())
+			Expect(put("bar{c=1}")).ToNot(HaveOccurred())
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("does not exceed the limits with concurrent puts", func() {
+			var wg sync.WaitGroup
+			var accepted int32
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					err := put("foo{a=" + strconv.Itoa(i%2) + ",b=" + strconv.Itoa(i) + "}")
+					if err == nil {
+						atomic.AddInt32(&accepted, 1)
+						return
+					}
+					Expect(IsCardinalityLimitError(err)).To(BeTrue())
+				}(i)
+			}
+			wg.Wait()
+			Expect(accepted).To(Equal(int32(2)))
+			n, _ := s.seriesCount(segment.NewKey(map[string]string{"__name__": "foo"}))
+			Expect(n).To(Equal(2))
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("refuses ingestion of new label values once the limit is reached", func() {
+			Expect(put("foo{a=1}")).ToNot(HaveOccurred())
+			Expect(put("bar{a=2}")).ToNot(HaveOccurred())
+			Expect(IsCardinalityLimitError(put("baz{a=3}"))).To(BeTrue())
+			Expect(put("baz{a=1}")).ToNot(HaveOccurred())
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("refuses to queue inputs exceeding the limits", func() {
+			q := NewIngestionQueue(logrus.StandardLogger(), s, prometheus.NewRegistry(), s.config, nil)
+			queue := func(k string) error {
+				tree := tree.New()
+				tree.Insert([]byte("a;b"), uint64(1))
+				key, _ := segment.ParseKey(k)
+				return q.Put(context.TODO(), &PutInput{
+					StartTime:  testing.SimpleTime(10),
+					EndTime:    testing.SimpleTime(19),
+					Key:        key,
+					Val:        tree,
+					SpyName:    "testspy",
+					SampleRate: 100,
+				})
+			}
+
+			Expect(queue("foo{a=1}")).ToNot(HaveOccurred())
+			Expect(queue("bar{a=2}")).ToNot(HaveOccurred())
+			Expect(IsCardinalityLimitError(queue("baz{a=3}"))).To(BeTrue())
+
+			Expect(queue("foo{b=1}")).ToNot(HaveOccurred())
+			Eventually(func() int {
+				n, _ := s.seriesCount(segment.NewKey(map[string]string{"__name__": "foo"}))
+				return n
+			}).Should(Equal(2))
+			Expect(IsCardinalityLimitError(queue("foo{c=1}"))).To(BeTrue())
+			q.Stop()
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("reports cardinality estimates", func() {
+			Expect(put("foo{a=1}")).ToNot(HaveOccurred())
+			Expect(put("foo{a=2}")).ToNot(HaveOccurred())
+			Expect(put("bar{b=1}")).ToNot(HaveOccurred())
+			Expect(s.CardinalityReport(1)).To(Equal(CardinalityReport{
+				Apps:   []CardinalityEstimate{{Name: "foo", Count: 2}},
+				Labels: []CardinalityEstimate{{Name: "a", Count: 2}},
+			}))
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+	})
+})
+
+var _ = Describe("Storage without rollups", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		It("does not write rollups", func() {
+			var err error
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller), NoopApplicationMetadataService{})
+			Expect(err).ToNot(HaveOccurred())
+			st := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+			tree := tree.New()
+			tree.Insert([]byte("a;b"), 1)
+			key, _ := segment.ParseKey("foo{bar=baz}")
+			Expect(s.Put(context.TODO(), &PutInput{
+				StartTime:  st,
+				EndTime:    st.Add(10 * time.Second),
+				Key:        key,
+				Val:        tree,
+				SpyName:    "testspy",
+				SampleRate: 100,
+			})).ToNot(HaveOccurred())
+
+			_, ok := s.rollupsSince(context.TODO(), "foo{bar=baz}")
+			Expect(ok).To(BeFalse())
+			versions, err := s.segments.LookupByKeys([]string{"foo{bar=baz}@1m"}, st, st.Add(time.Hour), 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(versions).To(BeEmpty())
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+	})
+})
+
+var _ = Describe("Storage rollups", func() {
+	testing.WithConfig(func(cfg **config.Config) {
+		JustBeforeEach(func() {
+			var err error
+			(*cfg).Server.StorageRollups = true
+			s, err = New(NewConfig(&(*cfg).Server), logrus.StandardLogger(), prometheus.NewRegistry(), new(health.Controller), NoopApplicationMetadataService{})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		put := func(k string, st time.Time, v uint64) {
+			tree := tree.New()
+			tree.Insert([]byte("a;b"), v)
+			key, _ := segment.ParseKey(k)
+			Expect(s.Put(context.TODO(), &PutInput{
+				StartTime:  st,
+				EndTime:    st.Add(10 * time.Second),
+				Key:        key,
+				Val:        tree,
+				SpyName:    "testspy",
+				SampleRate: 100,
+			})).ToNot(HaveOccurred())
+		}
+
+		get := func(k string, st, et time.Time) *GetOutput {
+			key, _ := segment.ParseKey(k)
+			o, err := s.Get(context.TODO(), &GetInput{StartTime: st, EndTime: et, Key: key})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(o).ToNot(BeNil())
+			return o
+		}
+
+		It("picks the coarsest resolution not exceeding the range", func() {
+			st := testing.SimpleTime(0)
+			_, ok := pickRollup(st, st.Add(30*time.Second))
+			Expect(ok).To(BeFalse())
+			r, _ := pickRollup(st, st.Add(30*time.Minute))
+			Expect(r.name).To(Equal("1m"))
+			r, _ = pickRollup(st, st.Add(5*time.Hour))
+			Expect(r.name).To(Equal("1h"))
+			r, _ = pickRollup(st, st.Add(30*24*time.Hour))
+			Expect(r.name).To(Equal("1d"))
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("returns the same data as segment versions", func() {
+			st := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+			for i := 0; i < 30; i++ {
+				put("foo{bar=baz}", st.Add(time.Duration(i)*7*time.Minute), uint64(i+1))
+			}
+
+			et := st.Add(5 * time.Hour)
+			o := get("foo{bar=baz}", st, et)
+			Expect(o.Tree.Samples()).To(Equal(uint64(465)))
+			Expect(o.Count).To(Equal(uint64(30)))
+
+			// Segment versions are read if the rollups are not available.
+			s.rollups.since["foo{bar=baz}"] = time.Now()
+			raw := get("foo{bar=baz}", st, et)
+			Expect(o.Tree.String()).To(Equal(raw.Tree.String()))
+			Expect(o.Timeline).To(Equal(raw.Timeline))
+			Expect(o.Count).To(Equal(raw.Count))
+
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("reads rollup versions", func() {
+			st := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+			for i := 0; i < 30; i++ {
+				put("foo{bar=baz}", st.Add(time.Duration(i)*7*time.Minute), 1)
+			}
+
+			versions, keyOf, err := s.lookupSegments(context.TODO(), []string{"foo{bar=baz}"}, &GetInput{
+				StartTime: st.Add(30 * time.Minute),
+				EndTime:   st.Add(5 * time.Hour),
+			}, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(versions).To(HaveLen(1))
+			Expect(versions).To(HaveKey("foo{bar=baz}@1h"))
+			Expect(versions["foo{bar=baz}@1h"]).To(HaveLen(4))
+			Expect(keyOf("foo{bar=baz}@1h")).To(Equal("foo{bar=baz}"))
+
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("reads segment versions if the rollup has no data within the range", func() {
+			st := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+			for i := 0; i < 30; i++ {
+				put("foo{bar=baz}", st.Add(time.Duration(i)*7*time.Minute), 1)
+			}
+			Expect(s.segments.Delete("foo{bar=baz}@1h")).ToNot(HaveOccurred())
+
+			versions, keyOf, err := s.lookupSegments(context.TODO(), []string{"foo{bar=baz}"}, &GetInput{
+				StartTime: st,
+				EndTime:   st.Add(5 * time.Hour),
+			}, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(versions).To(HaveLen(1))
+			Expect(versions["foo{bar=baz}"]).To(HaveLen(30))
+			Expect(keyOf("foo{bar=baz}")).To(Equal("foo{bar=baz}"))
+			Expect(get("foo{bar=baz}", st, st.Add(5*time.Hour)).Count).To(Equal(uint64(30)))
+
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("discards rollups once they are disabled", func() {
+			st := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+			put("foo{bar=baz}", st, 1)
+			_, ok := s.rollupsSince(context.TODO(), "foo{bar=baz}")
+			Expect(ok).To(BeTrue())
+
+			s.config.rollups = false
+			put("foo{bar=baz}", st.Add(2*time.Hour), 1)
+			_, ok = s.rollupsSince(context.TODO(), "foo{bar=baz}")
+			Expect(ok).To(BeFalse())
+			Expect(get("foo{bar=baz}", st, st.Add(5*time.Hour)).Count).To(Equal(uint64(2)))
+
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+
+		It("rollups of existing segments hold data ingested afterwards", func() {
+			st := time.Now().Add(-10 * time.Minute)
+			put("foo{bar=baz}", st, 1)
+			Expect(s.DeleteApp(context.TODO(), "foo")).ToNot(HaveOccurred())
+
+			// Make the segment look as one created before rollups.
+			put("foo{bar=baz}", st, 1)
+			sk := segmentPrefix.String() + "foo{bar=baz}" + rollupsSinceSuffix
+			Expect(s.segments.DBInstance().Delete(context.TODO(), sk)).ToNot(HaveOccurred())
+			s.forgetRollups("foo")
+			Expect(s.initRollups(context.TODO(), "foo{bar=baz}", false)).ToNot(HaveOccurred())
+			since, ok := s.rollupsSince(context.TODO(), "foo{bar=baz}")
+			Expect(ok).To(BeTrue())
+			Expect(since.After(st)).To(BeTrue())
+
+			// Segment versions are read.
+			versions, _, err := s.lookupSegments(context.TODO(), []string{"foo{bar=baz}"}, &GetInput{
+				StartTime: st.Add(-time.Hour),
+				EndTime:   st.Add(time.Hour),
+			}, 0)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(versions).To(HaveKey("foo{bar=baz}"))
+
+			Expect(s.Close()).ToNot(HaveOccurred())
+		})
+	})
+})
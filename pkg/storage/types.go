@@ -15,6 +15,15 @@ type Putter interface {
 	Put(context.Context, *PutInput) error
 }
 
+// PutChecker is implemented by putters that can reject an input before it is
+// put, so that the error can be returned to the client even if the input is
+// put asynchronously. An input that passed the check but is not going to be
+// put must be released with ReleasePut.
+type PutChecker interface {
+	CheckPut(context.Context, *PutInput) error
+	ReleasePut(*PutInput)
+}
+
 type Getter interface {
 	Get(context.Context, *GetInput) (*GetOutput, error)
 }
@@ -42,7 +51,7 @@ type GetLabelKeysByQueryOutput struct {
 }
 
 type LabelsGetter interface {
-	GetKeys(ctx context.Context, cb func(string) bool)
+	GetKeys(ctx context.Context, cb func(string) bool) error
 	GetKeysByQuery(ctx context.Context, in GetLabelKeysByQueryInput) (GetLabelKeysByQueryOutput, error)
 }
 
@@ -58,7 +67,7 @@ type GetLabelValuesByQueryOutput struct {
 }
 
 type LabelValuesGetter interface {
-	GetValues(ctx context.Context, key string, cb func(v string) bool)
+	GetValues(ctx context.Context, key string, cb func(v string) bool) error
 	GetValuesByQuery(ctx context.Context, in GetLabelValuesByQueryInput) (GetLabelValuesByQueryOutput, error)
 }
 
@@ -67,10 +76,10 @@ type LabelValuesGetter interface {
 // 	Put(ctx context.Context, pi *PutInput) error
 // 	Get(ctx context.Context, gi *GetInput) (*GetOutput, error)
 
-// 	GetAppNames(ctx context.Context, ) []string
-// 	GetKeys(ctx context.Context, cb func(string) bool)
+// 	GetAppNames(ctx context.Context) ([]string, error)
+// 	GetKeys(ctx context.Context, cb func(string) bool) error
 // 	GetKeysByQuery(ctx context.Context, query string, cb func(_k string) bool) error
-// 	GetValues(ctx context.Context, key string, cb func(v string) bool)
+// 	GetValues(ctx context.Context, key string, cb func(v string) bool) error
 // 	GetValuesByQuery(ctx context.Context, label string, query string, cb func(v string) bool) error
 // 	DebugExport(ctx context.Context, w http.ResponseWriter, r *http.Request)
 
@@ -86,11 +95,12 @@ type LabelValuesGetter interface {
 //}
 
 type CacheLayer interface {
-	Put(key string, val interface{})
-	PutWithTime(key string, val interface{}, t time.Time)
+	Put(key string, val interface{}) error
+	PutWithTime(key string, val interface{}, t time.Time) error
 	Evict(percent float64)
 	WriteBack()
 	Delete(key string) error
+	DeleteAt(key string, ts ...time.Time) error
 	Discard(key string)
 	DiscardPrefix(prefix string) error
 	GetOrCreate(key string) (interface{}, error)
@@ -98,7 +108,7 @@ type CacheLayer interface {
 	Lookup(key string) (interface{}, bool)
 	LookupWithTime(key string, t time.Time) (interface{}, bool)
 	LookupWithTimeLimit(key string, st, et time.Time, limit int) ([]interface{}, error)
-	LookupByKeys(keys []string, st, et time.Time, limit int) (map[string][]interface{}, error)
+	LookupByKeys(keys []string, st, et time.Time, limit int) (map[string][]cache.Version, error)
 	New(key string) interface{}
 }
 
@@ -114,14 +124,14 @@ type CacheLayer interface {
 //	Name() string
 //}
 
-type ClickHouseDBWithCache interface {
-	types.ClickHouseDB
+// DBWithCache is a storage backend DB fronted by a cache.
+type DBWithCache interface {
 	CacheLayer
 
 	Size() bytesize.ByteSize
 	CacheSize() uint64
 
-	DBInstance() types.ClickHouseDB
+	DBInstance() types.DB
 	CacheInstance() *cache.Cache
 	Name() string
 }
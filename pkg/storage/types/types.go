@@ -0,0 +1,61 @@
+package types
+
+import (
+	"context"
+	"time"
+)
+
+// Row is a single record stored in a DB: the value of the key
+// at the given point in time. A key may have many rows, one
+// per timestamp.
+type Row struct {
+	Key       string
+	Value     []byte
+	Timestamp time.Time
+}
+
+// DB is the storage backend interface. Every table (main, dicts, dimensions,
+// segments, trees, profiles) is represented by a DB instance holding
+// timestamped key-value rows.
+type DB interface {
+	// Put writes the rows to the DB. A row with the same key and
+	// timestamp as an existing one replaces it.
+	Put(ctx context.Context, rows ...Row) error
+	// Get returns the most recent row of the key.
+	Get(ctx context.Context, key string) (Row, bool, error)
+	// GetAt returns the row of the key with exactly the given timestamp.
+	GetAt(ctx context.Context, key string, t time.Time) (Row, bool, error)
+	// Range calls fn for every row of the keys within [st, et], ordered by
+	// timestamp. If step is not zero, only the first row of every key within
+	// a step-long interval is returned, with its own timestamp. Intervals are
+	// aligned to the Unix epoch.
+	Range(ctx context.Context, keys []string, st, et time.Time, step time.Duration, fn func(Row) error) error
+	// Scan calls fn for the most recent row of every key with the given
+	// prefix, in lexicographical key order, until fn returns false.
+	Scan(ctx context.Context, prefix string, fn func(Row) bool) error
+	// Delete removes all rows of the keys.
+	Delete(ctx context.Context, keys ...string) error
+	// DeleteAt removes the rows of the key with exactly the given timestamps.
+	DeleteAt(ctx context.Context, key string, ts ...time.Time) error
+	// DeletePrefix removes all rows of the keys with the given prefix.
+	DeletePrefix(ctx context.Context, prefix string) error
+	// Size returns the on-disk size of the DB in bytes.
+	Size(ctx context.Context) (int64, error)
+	// PrefixSizes returns the approximate size in bytes of the rows of the
+	// keys with the given prefix, grouped by the part of the key between
+	// the prefix and the first sep byte following it.
+	PrefixSizes(ctx context.Context, prefix string, sep byte) (map[string]int64, error)
+
+	// NewWriteBatch creates a batch; ctx applies to the batch writes.
+	NewWriteBatch(ctx context.Context) (WriteBatch, error)
+	MaxBatchCount() int64
+	Close() error
+}
+
+// WriteBatch accumulates rows and writes them to the DB at once.
+// A batch must be either sent or aborted.
+type WriteBatch interface {
+	Append(Row) error
+	Send() error
+	Abort() error
+}
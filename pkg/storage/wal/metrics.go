@@ -0,0 +1,45 @@
+package wal
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+type metrics struct {
+	size          prometheus.Gauge
+	segments      prometheus.Gauge
+	replayPending prometheus.Gauge
+	replayed      prometheus.Counter
+	corruptions   prometheus.Counter
+}
+
+func newMetrics(r prometheus.Registerer, name string) *metrics {
+	labels := prometheus.Labels{"wal": name}
+	return &metrics{
+		size: promauto.With(r).NewGauge(prometheus.GaugeOpts{
+			Name:        "pyroscope_wal_size_bytes",
+			Help:        "total size of the write-ahead log segments",
+			ConstLabels: labels,
+		}),
+		segments: promauto.With(r).NewGauge(prometheus.GaugeOpts{
+			Name:        "pyroscope_wal_segments",
+			Help:        "number of the write-ahead log segments",
+			ConstLabels: labels,
+		}),
+		replayPending: promauto.With(r).NewGauge(prometheus.GaugeOpts{
+			Name:        "pyroscope_wal_replay_pending_records",
+			Help:        "number of records written before the start that are yet to be replayed",
+			ConstLabels: labels,
+		}),
+		replayed: promauto.With(r).NewCounter(prometheus.CounterOpts{
+			Name:        "pyroscope_wal_replayed_records_total",
+			Help:        "number of records written before the start that were replayed",
+			ConstLabels: labels,
+		}),
+		corruptions: promauto.With(r).NewCounter(prometheus.CounterOpts{
+			Name:        "pyroscope_wal_corruptions_total",
+			Help:        "number of corrupted write-ahead log records found",
+			ConstLabels: labels,
+		}),
+	}
+}
@@ -0,0 +1,575 @@
+// Package wal implements a write-ahead log of opaque records.
+//
+// Records are appended to segment files and read back in the same order
+// by a single reader. A segment is deleted once all its records are
+// acknowledged. Records that are not acknowledged when the log is closed
+// (or the process crashes) are read again after the log is reopened,
+// therefore every record is delivered at least once.
+package wal
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	ErrClosed = errors.New("wal: closed")
+	ErrFull   = errors.New("wal: max size exceeded")
+)
+
+const (
+	defaultSegmentSize = 16 << 20
+
+	segmentExt     = ".wal"
+	checkpointFile = "checkpoint"
+
+	// Record header: length and CRC32 of the data.
+	headerSize = 8
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+type Options struct {
+	// Name distinguishes metrics of logs sharing the registerer.
+	Name string
+	// SegmentSize is the size at which the head segment is rotated.
+	SegmentSize int64
+	// MaxSize is the total size of the segments at which
+	// Append fails with ErrFull. Zero means no limit.
+	MaxSize int64
+}
+
+type WAL struct {
+	logger  logrus.FieldLogger
+	dir     string
+	opts    Options
+	metrics *metrics
+	notify  chan struct{}
+
+	mu       sync.Mutex
+	closed   bool
+	segments []*segment // The last one is the head.
+	head     *os.File
+	size     int64
+	nextSeq  uint64
+
+	reader     *os.File
+	readSeg    *segment
+	readSeq    uint64
+	readOffset int64
+	replayEnd  uint64
+
+	ackedSeq uint64
+	acked    map[uint64]struct{}
+
+	// syncMu serializes syncs of the head segment: records
+	// written while a sync is in progress are synced at once.
+	syncMu    sync.Mutex
+	syncedSeq uint64
+}
+
+type segment struct {
+	index    int
+	firstSeq uint64
+	records  uint64
+	size     int64
+}
+
+func (s *segment) endSeq() uint64 { return s.firstSeq + s.records }
+
+// Open opens the log in the directory, creating it if needed.
+// Records of the existing segments are validated: a segment is
+// truncated at the first corrupted or partially written record.
+func Open(logger logrus.FieldLogger, reg prometheus.Registerer, dir string, opts Options) (*WAL, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = defaultSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	w := WAL{
+		logger:  logger,
+		dir:     dir,
+		opts:    opts,
+		metrics: newMetrics(reg, opts.Name),
+		notify:  make(chan struct{}, 1),
+		acked:   make(map[uint64]struct{}),
+	}
+	indices, err := w.listSegments()
+	if err != nil {
+		return nil, err
+	}
+	cpIndex, cpRecords, err := w.readCheckpoint()
+	if err != nil {
+		return nil, err
+	}
+
+	next := 1
+	for _, i := range indices {
+		next = i + 1
+		if i < cpIndex {
+			// All the records are acknowledged.
+			if err = os.Remove(w.segmentPath(i)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		s, err := w.openSegment(i)
+		if err != nil {
+			return nil, err
+		}
+		if s.records == 0 {
+			if err = os.Remove(w.segmentPath(i)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if i == cpIndex && cpRecords > 0 {
+			if cpRecords > s.records {
+				cpRecords = s.records
+			}
+			if w.readOffset, err = w.recordOffset(s, cpRecords); err != nil {
+				return nil, err
+			}
+			w.ackedSeq = s.firstSeq + cpRecords
+			w.readSeq = w.ackedSeq
+			w.readSeg = s
+		}
+	}
+
+	w.replayEnd = w.nextSeq
+	w.syncedSeq = w.nextSeq
+	w.metrics.replayPending.Set(float64(w.replayEnd - w.readSeq))
+	if err = w.createHead(next); err != nil {
+		return nil, err
+	}
+	if w.readSeg == nil {
+		w.readSeg = w.segments[0]
+	}
+	if err = w.truncate(); err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+// Append writes the record to the log and waits for it
+// to be synced. Returned is the sequence number of the record.
+func (w *WAL) Append(data []byte) (uint64, error) {
+	seq, err := w.Write(data)
+	if err != nil {
+		return 0, err
+	}
+	return seq, w.Sync(seq)
+}
+
+// Write writes the record to the log without syncing it. Returned
+// is the sequence number of the record: the record is durable once
+// Sync returns. The record may be read before it is synced.
+func (w *WAL) Write(data []byte) (uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, ErrClosed
+	}
+	n := int64(headerSize + len(data))
+	if w.opts.MaxSize > 0 && w.size+n > w.opts.MaxSize {
+		return 0, ErrFull
+	}
+	h := w.segments[len(w.segments)-1]
+	if h.size >= w.opts.SegmentSize {
+		if err := w.head.Sync(); err != nil {
+			return 0, err
+		}
+		w.syncedSeq = w.nextSeq
+		if err := w.head.Close(); err != nil {
+			return 0, err
+		}
+		if err := w.createHead(h.index + 1); err != nil {
+			return 0, err
+		}
+		h = w.segments[len(w.segments)-1]
+	}
+
+	buf := make([]byte, n)
+	binary.LittleEndian.PutUint32(buf, uint32(len(data)))
+	binary.LittleEndian.PutUint32(buf[4:], crc32.Checksum(data, crcTable))
+	copy(buf[headerSize:], data)
+	if _, err := w.head.Write(buf); err != nil {
+		// Discard the partially written record.
+		_ = w.head.Truncate(h.size)
+		return 0, err
+	}
+
+	h.records++
+	h.size += n
+	w.size += n
+	seq := w.nextSeq
+	w.nextSeq++
+	w.metrics.size.Set(float64(w.size))
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+	return seq, nil
+}
+
+// Sync waits for the record to be synced to disk. The head segment
+// is synced without blocking writers, and records written by the
+// concurrent callers are synced at once.
+func (w *WAL) Sync(seq uint64) error {
+	w.syncMu.Lock()
+	defer w.syncMu.Unlock()
+	w.mu.Lock()
+	if seq < w.syncedSeq {
+		w.mu.Unlock()
+		return nil
+	}
+	if w.closed {
+		w.mu.Unlock()
+		return ErrClosed
+	}
+	head, end := w.head, w.nextSeq
+	w.mu.Unlock()
+
+	err := head.Sync()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if seq < w.syncedSeq {
+		// The head was synced when it was rotated or closed.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	w.syncedSeq = end
+	return nil
+}
+
+// Next returns the next record, waiting for it to be appended if
+// needed. Next must not be called concurrently. ErrClosed is returned
+// when the log is closed, ctx error is returned if it is cancelled.
+func (w *WAL) Next(ctx context.Context) (uint64, []byte, error) {
+	for {
+		seq, data, ok, err := w.read()
+		if ok || err != nil {
+			return seq, data, err
+		}
+		select {
+		case <-w.notify:
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		}
+	}
+}
+
+func (w *WAL) read() (seq uint64, data []byte, ok bool, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for {
+		if w.closed {
+			return 0, nil, false, ErrClosed
+		}
+		if w.readSeq >= w.nextSeq {
+			return 0, nil, false, nil
+		}
+		if w.readSeq >= w.readSeg.endSeq() {
+			if err = w.advanceReader(); err != nil {
+				return 0, nil, false, err
+			}
+			continue
+		}
+		if w.reader == nil {
+			if w.reader, err = os.Open(w.segmentPath(w.readSeg.index)); err != nil {
+				return 0, nil, false, err
+			}
+		}
+		if data, err = w.readRecord(); err != nil {
+			// The segment was corrupted after it had been validated.
+			// Its remaining records are lost.
+			w.logger.WithError(err).WithField("segment", w.readSeg.index).Error("corrupted WAL record")
+			w.metrics.corruptions.Inc()
+			for w.readSeq < w.readSeg.endSeq() {
+				w.ack(w.readSeq)
+				w.readProgress()
+			}
+			continue
+		}
+		seq = w.readSeq
+		w.readProgress()
+		return seq, data, true, nil
+	}
+}
+
+func (w *WAL) readProgress() {
+	if w.readSeq < w.replayEnd {
+		w.metrics.replayed.Inc()
+		w.metrics.replayPending.Dec()
+	}
+	w.readSeq++
+}
+
+func (w *WAL) readRecord() ([]byte, error) {
+	var h [headerSize]byte
+	if _, err := w.reader.ReadAt(h[:], w.readOffset); err != nil {
+		return nil, err
+	}
+	n := int64(binary.LittleEndian.Uint32(h[:]))
+	if w.readOffset+headerSize+n > w.readSeg.size {
+		return nil, fmt.Errorf("record length %d exceeds segment size", n)
+	}
+	data := make([]byte, n)
+	if _, err := w.reader.ReadAt(data, w.readOffset+headerSize); err != nil {
+		return nil, err
+	}
+	if crc32.Checksum(data, crcTable) != binary.LittleEndian.Uint32(h[4:]) {
+		return nil, errors.New("checksum mismatch")
+	}
+	w.readOffset += headerSize + n
+	return data, nil
+}
+
+func (w *WAL) advanceReader() error {
+	if w.reader != nil {
+		if err := w.reader.Close(); err != nil {
+			return err
+		}
+		w.reader = nil
+	}
+	for i, s := range w.segments {
+		if s == w.readSeg && i+1 < len(w.segments) {
+			w.readSeg = w.segments[i+1]
+			w.readOffset = 0
+			return nil
+		}
+	}
+	return fmt.Errorf("wal: segment %d not found", w.readSeg.index)
+}
+
+// Ack acknowledges the record: once all the records of a
+// segment are acknowledged, the segment is deleted.
+func (w *WAL) Ack(seq uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.ack(seq)
+	if err := w.truncate(); err != nil {
+		w.logger.WithError(err).Error("failed to delete WAL segment")
+	}
+}
+
+func (w *WAL) ack(seq uint64) {
+	if seq < w.ackedSeq {
+		return
+	}
+	w.acked[seq] = struct{}{}
+	for {
+		if _, ok := w.acked[w.ackedSeq]; !ok {
+			return
+		}
+		delete(w.acked, w.ackedSeq)
+		w.ackedSeq++
+	}
+}
+
+func (w *WAL) truncate() error {
+	for len(w.segments) > 1 && w.segments[0].endSeq() <= w.ackedSeq {
+		s := w.segments[0]
+		if w.readSeg == s {
+			if err := w.advanceReader(); err != nil {
+				return err
+			}
+		}
+		if err := os.Remove(w.segmentPath(s.index)); err != nil {
+			return err
+		}
+		w.segments = w.segments[1:]
+		w.size -= s.size
+		w.metrics.size.Set(float64(w.size))
+		w.metrics.segments.Set(float64(len(w.segments)))
+	}
+	return nil
+}
+
+// Close closes the log and saves the position of the
+// first record that has not been acknowledged.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.reader != nil {
+		_ = w.reader.Close()
+	}
+	if err := w.head.Sync(); err != nil {
+		_ = w.head.Close()
+		return err
+	}
+	w.syncedSeq = w.nextSeq
+	if err := w.head.Close(); err != nil {
+		return err
+	}
+	for _, s := range w.segments {
+		if w.ackedSeq < s.endSeq() {
+			return w.writeCheckpoint(s.index, w.ackedSeq-s.firstSeq)
+		}
+	}
+	return w.writeCheckpoint(w.segments[len(w.segments)-1].index+1, 0)
+}
+
+// Size returns the total size of the segments.
+func (w *WAL) Size() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.size
+}
+
+func (w *WAL) createHead(index int) error {
+	f, err := os.OpenFile(w.segmentPath(index), os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	w.head = f
+	w.segments = append(w.segments, &segment{index: index, firstSeq: w.nextSeq})
+	w.metrics.segments.Set(float64(len(w.segments)))
+	return nil
+}
+
+// openSegment validates records of the segment. The segment is
+// truncated at the first record that is incomplete or corrupted.
+func (w *WAL) openSegment(index int) (*segment, error) {
+	p := w.segmentPath(index)
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	s := &segment{index: index, firstSeq: w.nextSeq}
+	r := bufio.NewReader(f)
+	var h [headerSize]byte
+	for {
+		if _, err = io.ReadFull(r, h[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			break
+		}
+		n := int64(binary.LittleEndian.Uint32(h[:]))
+		if s.size+headerSize+n > fi.Size() {
+			err = io.ErrUnexpectedEOF
+			break
+		}
+		data := make([]byte, n)
+		if _, err = io.ReadFull(r, data); err != nil {
+			break
+		}
+		if crc32.Checksum(data, crcTable) != binary.LittleEndian.Uint32(h[4:]) {
+			err = errors.New("checksum mismatch")
+			break
+		}
+		s.records++
+		s.size += headerSize + n
+	}
+	if err != nil && err != io.EOF {
+		w.logger.WithError(err).
+			WithField("segment", p).
+			WithField("offset", s.size).
+			Warn("corrupted WAL segment is truncated")
+		w.metrics.corruptions.Inc()
+		if err = os.Truncate(p, s.size); err != nil {
+			return nil, err
+		}
+	}
+	w.nextSeq += s.records
+	w.size += s.size
+	w.metrics.size.Set(float64(w.size))
+	if s.records > 0 {
+		w.segments = append(w.segments, s)
+	}
+	return s, nil
+}
+
+// recordOffset returns the offset of the n-th record of the segment.
+func (w *WAL) recordOffset(s *segment, n uint64) (int64, error) {
+	f, err := os.Open(w.segmentPath(s.index))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var off int64
+	var h [headerSize]byte
+	for i := uint64(0); i < n; i++ {
+		if _, err = f.ReadAt(h[:], off); err != nil {
+			return 0, err
+		}
+		off += headerSize + int64(binary.LittleEndian.Uint32(h[:]))
+	}
+	return off, nil
+}
+
+func (w *WAL) listSegments() ([]int, error) {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return nil, err
+	}
+	var indices []int
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		i, err := strconv.Atoi(strings.TrimSuffix(name, segmentExt))
+		if err != nil {
+			continue
+		}
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	return indices, nil
+}
+
+func (w *WAL) segmentPath(index int) string {
+	return filepath.Join(w.dir, fmt.Sprintf("%08d%s", index, segmentExt))
+}
+
+func (w *WAL) readCheckpoint() (index int, records uint64, err error) {
+	b, err := os.ReadFile(filepath.Join(w.dir, checkpointFile))
+	switch {
+	case err == nil:
+	case os.IsNotExist(err):
+		return 0, 0, nil
+	default:
+		return 0, 0, err
+	}
+	if _, err = fmt.Sscanf(string(b), "%d %d", &index, &records); err != nil {
+		w.logger.WithError(err).Warn("invalid WAL checkpoint is ignored")
+		return 0, 0, nil
+	}
+	return index, records, nil
+}
+
+func (w *WAL) writeCheckpoint(index int, records uint64) error {
+	p := filepath.Join(w.dir, checkpointFile)
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, []byte(fmt.Sprintf("%d %d\n", index, records)), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
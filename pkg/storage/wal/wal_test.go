@@ -0,0 +1,219 @@
+package wal_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pyroscope-io/pyroscope/pkg/storage/wal"
+)
+
+var _ = Describe("WAL", func() {
+	var (
+		dir  string
+		reg  *prometheus.Registry
+		opts wal.Options
+	)
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		opts = wal.Options{Name: "test", SegmentSize: 64}
+	})
+
+	open := func() *wal.WAL {
+		reg = prometheus.NewRegistry()
+		w, err := wal.Open(logrus.StandardLogger(), reg, dir, opts)
+		Expect(err).ToNot(HaveOccurred())
+		return w
+	}
+
+	metric := func(name string) float64 {
+		mfs, err := reg.Gather()
+		Expect(err).ToNot(HaveOccurred())
+		for _, mf := range mfs {
+			if mf.GetName() != name {
+				continue
+			}
+			m := mf.GetMetric()[0]
+			if m.Gauge != nil {
+				return m.Gauge.GetValue()
+			}
+			return m.Counter.GetValue()
+		}
+		return 0
+	}
+
+	next := func(w *wal.WAL) (uint64, string) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		seq, data, err := w.Next(ctx)
+		Expect(err).ToNot(HaveOccurred())
+		return seq, string(data)
+	}
+
+	appendAll := func(w *wal.WAL, records ...string) {
+		for _, r := range records {
+			_, err := w.Append([]byte(r))
+			Expect(err).ToNot(HaveOccurred())
+		}
+	}
+
+	segments := func() []string {
+		m, err := filepath.Glob(filepath.Join(dir, "*.wal"))
+		Expect(err).ToNot(HaveOccurred())
+		return m
+	}
+
+	It("reads records in the append order", func() {
+		w := open()
+		appendAll(w, "a", "b")
+		seq, data := next(w)
+		Expect(seq).To(Equal(uint64(0)))
+		Expect(data).To(Equal("a"))
+		seq, data = next(w)
+		Expect(seq).To(Equal(uint64(1)))
+		Expect(data).To(Equal("b"))
+		Expect(w.Close()).To(Succeed())
+	})
+
+	It("waits for records to be appended", func() {
+		w := open()
+		go func() {
+			defer GinkgoRecover()
+			time.Sleep(10 * time.Millisecond)
+			appendAll(w, "a")
+		}()
+		_, data := next(w)
+		Expect(data).To(Equal("a"))
+		Expect(w.Close()).To(Succeed())
+	})
+
+	It("replays records that were not acknowledged", func() {
+		w := open()
+		appendAll(w, "a", "b", "c")
+		seq, _ := next(w)
+		w.Ack(seq)
+		seq, _ = next(w)
+		Expect(w.Close()).To(Succeed())
+
+		w = open()
+		Expect(metric("pyroscope_wal_replay_pending_records")).To(Equal(float64(2)))
+		_, data := next(w)
+		Expect(data).To(Equal("b"))
+		_, data = next(w)
+		Expect(data).To(Equal("c"))
+		Expect(metric("pyroscope_wal_replay_pending_records")).To(BeZero())
+		Expect(metric("pyroscope_wal_replayed_records_total")).To(Equal(float64(2)))
+		Expect(w.Close()).To(Succeed())
+	})
+
+	It("replays records after a crash", func() {
+		w := open()
+		appendAll(w, "a", "b")
+		// The log is not closed: there is no checkpoint.
+		w = open()
+		_, data := next(w)
+		Expect(data).To(Equal("a"))
+		_, data = next(w)
+		Expect(data).To(Equal("b"))
+	})
+
+	It("deletes acknowledged segments", func() {
+		w := open()
+		for i := 0; i < 10; i++ {
+			appendAll(w, "0123456789abcdef")
+		}
+		Expect(len(segments())).To(BeNumerically(">", 2))
+		for i := 0; i < 10; i++ {
+			seq, _ := next(w)
+			w.Ack(seq)
+		}
+		Expect(segments()).To(HaveLen(1))
+		Expect(w.Close()).To(Succeed())
+
+		w = open()
+		Expect(segments()).To(HaveLen(1))
+		Expect(w.Size()).To(BeZero())
+		Expect(w.Close()).To(Succeed())
+	})
+
+	It("truncates corrupted segments", func() {
+		w := open()
+		appendAll(w, "a", "b")
+		Expect(w.Close()).To(Succeed())
+
+		s := segments()
+		Expect(s).To(HaveLen(1))
+		f, err := os.OpenFile(s[0], os.O_RDWR, 0)
+		Expect(err).ToNot(HaveOccurred())
+		// Corrupt the last byte of the second record.
+		_, err = f.WriteAt([]byte{'x'}, 17)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+
+		w = open()
+		Expect(metric("pyroscope_wal_corruptions_total")).To(Equal(float64(1)))
+		_, data := next(w)
+		Expect(data).To(Equal("a"))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, _, err = w.Next(ctx)
+		Expect(err).To(MatchError(context.DeadlineExceeded))
+		Expect(w.Close()).To(Succeed())
+	})
+
+	It("syncs records appended concurrently", func() {
+		w := open()
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				appendAll(w, strconv.Itoa(i))
+			}(i)
+		}
+		wg.Wait()
+		Expect(w.Close()).To(Succeed())
+
+		w = open()
+		records := make(map[string]struct{})
+		for i := 0; i < 20; i++ {
+			_, data := next(w)
+			records[data] = struct{}{}
+		}
+		Expect(records).To(HaveLen(20))
+		Expect(w.Close()).To(Succeed())
+	})
+
+	It("syncs written records when the log is closed", func() {
+		w := open()
+		seq, err := w.Write([]byte("a"))
+		Expect(err).ToNot(HaveOccurred())
+		_, data := next(w)
+		Expect(data).To(Equal("a"))
+		Expect(w.Close()).To(Succeed())
+		Expect(w.Sync(seq)).To(Succeed())
+		_, err = w.Write([]byte("b"))
+		Expect(err).To(MatchError(wal.ErrClosed))
+	})
+
+	It("rejects records exceeding the max size", func() {
+		opts.MaxSize = 20
+		w := open()
+		appendAll(w, "0123456789")
+		_, err := w.Append([]byte("0123456789"))
+		Expect(err).To(MatchError(wal.ErrFull))
+		Expect(w.Close()).To(Succeed())
+		_, err = w.Append([]byte("a"))
+		Expect(err).To(MatchError(wal.ErrClosed))
+	})
+})
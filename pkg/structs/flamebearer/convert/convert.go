@@ -2,6 +2,7 @@ package convert
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,9 +14,15 @@ import (
 	"unicode"
 
 	"github.com/pyroscope-io/pyroscope/pkg/agent/spy"
+	"github.com/pyroscope-io/pyroscope/pkg/convert/cpuprofile"
+	"github.com/pyroscope-io/pyroscope/pkg/convert/gecko"
 	"github.com/pyroscope-io/pyroscope/pkg/convert/perf"
 	"github.com/pyroscope-io/pyroscope/pkg/convert/pprof"
+	"github.com/pyroscope-io/pyroscope/pkg/convert/speedscope"
+	"github.com/pyroscope-io/pyroscope/pkg/ingestion"
+	"github.com/pyroscope-io/pyroscope/pkg/storage"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/metadata"
+	"github.com/pyroscope-io/pyroscope/pkg/storage/segment"
 	"github.com/pyroscope-io/pyroscope/pkg/storage/tree"
 	"github.com/pyroscope-io/pyroscope/pkg/structs/flamebearer"
 )
@@ -44,6 +51,9 @@ const (
 	ProfileFileTypePprof      ProfileFileType = "pprof"
 	ProfileFileTypeCollapsed  ProfileFileType = "collapsed"
 	ProfileFileTypePerfScript ProfileFileType = "perf_script"
+	ProfileFileTypeSpeedscope ProfileFileType = "speedscope"
+	ProfileFileTypeCPUProfile ProfileFileType = "cpuprofile"
+	ProfileFileTypeGecko      ProfileFileType = "gecko"
 )
 
 type ConverterFn func(b []byte, name string, maxNodes int) (*flamebearer.FlamebearerProfile, error)
@@ -53,6 +63,9 @@ var formatConverters = map[ProfileFileType]ConverterFn{
 	ProfileFileTypePprof:      PprofToProfile,
 	ProfileFileTypeCollapsed:  CollapsedToProfile,
 	ProfileFileTypePerfScript: PerfScriptToProfile,
+	ProfileFileTypeSpeedscope: SpeedscopeToProfile,
+	ProfileFileTypeCPUProfile: CPUProfileToProfile,
+	ProfileFileTypeGecko:      GeckoToProfile,
 }
 
 func FlamebearerFromFile(f ProfileFile, maxNodes int) (*flamebearer.FlamebearerProfile, error) {
@@ -100,6 +113,12 @@ func converterToFormat(f ConverterFn) ProfileFileType {
 		return ProfileFileTypeCollapsed
 	case reflect.ValueOf(PerfScriptToProfile).Pointer():
 		return ProfileFileTypePerfScript
+	case reflect.ValueOf(SpeedscopeToProfile).Pointer():
+		return ProfileFileTypeSpeedscope
+	case reflect.ValueOf(CPUProfileToProfile).Pointer():
+		return ProfileFileTypeCPUProfile
+	case reflect.ValueOf(GeckoToProfile).Pointer():
+		return ProfileFileTypeGecko
 	}
 	return "unknown"
 }
@@ -114,6 +133,10 @@ func converter(p ProfileFile) (ConverterFn, error) {
 		return f, nil
 	}
 	ext := strings.TrimPrefix(path.Ext(p.Name), ".")
+	if ext == string(ProfileFileTypeJSON) {
+		// Speedscope and Firefox profiles are JSON files as well.
+		return jsonConverter(p.Data), nil
+	}
 	if f, ok := formatConverters[ProfileFileType(ext)]; ok {
 		return f, nil
 	}
@@ -127,7 +150,7 @@ func converter(p ProfileFile) (ConverterFn, error) {
 		return nil, errors.New("profile is too short")
 	}
 	if p.Data[0] == '{' {
-		return JSONToProfile, nil
+		return jsonConverter(p.Data), nil
 	}
 	if p.Data[0] == '\x1f' && p.Data[1] == '\x8b' {
 		// gzip magic number, assume pprof
@@ -151,6 +174,31 @@ func converter(p ProfileFile) (ConverterFn, error) {
 	return CollapsedToProfile, nil
 }
 
+// jsonConverter tells JSON-based formats apart by their top-level keys.
+func jsonConverter(b []byte) ConverterFn {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(b, &keys); err != nil {
+		return JSONToProfile
+	}
+	has := func(names ...string) bool {
+		for _, n := range names {
+			if _, ok := keys[n]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+	switch {
+	case has("$schema", "profiles"):
+		return SpeedscopeToProfile
+	case has("nodes", "startTime"):
+		return CPUProfileToProfile
+	case has("meta", "threads"):
+		return GeckoToProfile
+	}
+	return JSONToProfile
+}
+
 func JSONToProfile(b []byte, name string, maxNodes int) (*flamebearer.FlamebearerProfile, error) {
 	var profile flamebearer.FlamebearerProfile
 	if err := json.Unmarshal(b, &profile); err != nil {
@@ -267,3 +315,53 @@ func PerfScriptToProfile(b []byte, name string, maxNodes int) (*flamebearer.Flam
 	})
 	return &fb, nil
 }
+
+func SpeedscopeToProfile(b []byte, name string, maxNodes int) (*flamebearer.FlamebearerProfile, error) {
+	return rawProfileToProfile(&speedscope.RawProfile{RawData: b}, name, maxNodes)
+}
+
+func CPUProfileToProfile(b []byte, name string, maxNodes int) (*flamebearer.FlamebearerProfile, error) {
+	return rawProfileToProfile(&cpuprofile.RawProfile{RawData: b}, name, maxNodes)
+}
+
+func GeckoToProfile(b []byte, name string, maxNodes int) (*flamebearer.FlamebearerProfile, error) {
+	return rawProfileToProfile(&gecko.RawProfile{RawData: b}, name, maxNodes)
+}
+
+// rawProfileToProfile converts profiles of the formats accepted by the
+// ingestion API. If the profile yields multiple trees, e.g. for different
+// units, only the first one is used.
+func rawProfileToProfile(p ingestion.RawProfile, name string, maxNodes int) (*flamebearer.FlamebearerProfile, error) {
+	var c putCollector
+	md := ingestion.Metadata{
+		Key:        segment.NewKey(map[string]string{"__name__": "adhoc"}),
+		SpyName:    "unknown",
+		SampleRate: 100,
+	}
+	if err := p.Parse(context.Background(), &c, nil, md); err != nil {
+		return nil, err
+	}
+	if c.input == nil {
+		return nil, errors.New("profile is empty")
+	}
+	fb := flamebearer.NewProfile(flamebearer.ProfileConfig{
+		Name:     name,
+		Tree:     c.input.Val,
+		MaxNodes: maxNodes,
+		Metadata: metadata.Metadata{
+			SpyName:    c.input.SpyName,
+			SampleRate: c.input.SampleRate,
+			Units:      c.input.Units,
+		},
+	})
+	return &fb, nil
+}
+
+type putCollector struct{ input *storage.PutInput }
+
+func (c *putCollector) Put(_ context.Context, pi *storage.PutInput) error {
+	if c.input == nil {
+		c.input = pi
+	}
+	return nil
+}
@@ -580,6 +580,38 @@ var _ = Describe("Server", func() {
 			})
 		})
 
+		Context("JSON-based formats", func() {
+			testcases := []struct {
+				name     string
+				file     ProfileFile
+				expected ConverterFn
+			}{
+				{"speedscope", ProfileFile{Data: []byte(`{"$schema":"https://www.speedscope.app/file-format-schema.json","profiles":[]}`)}, SpeedscopeToProfile},
+				{"V8 CPU profile", ProfileFile{Data: []byte(`{"nodes":[],"startTime":0,"endTime":0}`)}, CPUProfileToProfile},
+				{"Gecko profile", ProfileFile{Data: []byte(`{"meta":{},"threads":[]}`)}, GeckoToProfile},
+				{"Gecko profile with .json extension", ProfileFile{Name: "profile.json", Data: []byte(`{"meta":{},"threads":[]}`)}, GeckoToProfile},
+				{"V8 CPU profile by .cpuprofile extension", ProfileFile{Name: "Profile-20230101.cpuprofile", Data: []byte(`{}`)}, CPUProfileToProfile},
+			}
+			for _, tc := range testcases {
+				tc := tc
+				It("detects "+tc.name, func() {
+					f, err := converter(tc.file)
+					Expect(err).To(BeNil())
+					Expect(reflect.ValueOf(f).Pointer()).To(Equal(reflect.ValueOf(tc.expected).Pointer()))
+				})
+			}
+
+			It("converts V8 CPU profiles", func() {
+				b, err := ioutil.ReadFile("../../../convert/cpuprofile/testdata/simple.cpuprofile")
+				Expect(err).ToNot(HaveOccurred())
+				fb, err := FlamebearerFromFile(ProfileFile{Name: "simple.cpuprofile", Data: b}, 1024)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(fb.Metadata.SampleRate).To(Equal(uint32(1000000)))
+				Expect(fb.Metadata.Name).To(Equal("simple.cpuprofile"))
+				Expect(fb.Flamebearer.NumTicks).To(Equal(100))
+			})
+		})
+
 		Context("with an empty ProfileFile", func() {
 			var m ProfileFile
 			It("should return an error", func() {
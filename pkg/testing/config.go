@@ -13,8 +13,9 @@ func WithConfig(cb func(cfg **config.Config)) {
 		tmpDir = TmpDirSync()
 		cfg = &config.Config{
 			Server: config.Server{
-				StoragePath: tmpDir.Path,
-				APIBindAddr: ":4040",
+				StorageBackend: "embedded",
+				StoragePath:    tmpDir.Path,
+				APIBindAddr:    ":4040",
 
 				CacheEvictThreshold: 0.02,
 				CacheEvictVolume:    0.10,
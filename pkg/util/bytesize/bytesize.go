@@ -99,3 +99,13 @@ func (b *ByteSize) Set(value string) error {
 	*b = v
 	return nil
 }
+
+// UnmarshalYAML parses the size given either in
+// bytes or as a string with a unit suffix.
+func (b *ByteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	return b.Set(s)
+}
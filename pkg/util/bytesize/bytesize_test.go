@@ -3,6 +3,7 @@ package bytesize
 import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"gopkg.in/yaml.v2"
 )
 
 var _ = Describe("bytesize package", func() {
@@ -27,4 +28,16 @@ var _ = Describe("bytesize package", func() {
 			Expect(err).To(MatchError("could not parse ByteSize"))
 		})
 	})
+
+	Describe("UnmarshalYAML", func() {
+		It("works with numbers and strings", func() {
+			var v struct {
+				A ByteSize `yaml:"a"`
+				B ByteSize `yaml:"b"`
+			}
+			Expect(yaml.Unmarshal([]byte("a: 1024\nb: 16MB\n"), &v)).To(Succeed())
+			Expect(v.A).To(Equal(1 * KB))
+			Expect(v.B).To(Equal(16 * MB))
+		})
+	})
 })
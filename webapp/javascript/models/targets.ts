@@ -1,6 +1,19 @@
 import { z } from 'zod';
 
 const healthModel = z.enum(['up', 'down', 'unknown']);
+const shardModel = z.object({
+  index: z.number(),
+  owned: z.boolean(),
+});
+const scrapeModel = z.object({
+  time: z.string(),
+  duration: z.string(),
+  bytes: z.number(),
+  statusCode: z.optional(z.number()),
+  samples: z.optional(z.record(z.number())),
+  scrapeError: z.optional(z.string()),
+  ingestError: z.optional(z.string()),
+});
 const targetModel = z.object({
   discoveredLabels: z.record(z.string()),
   labels: z.record(z.string()),
@@ -10,6 +23,8 @@ const targetModel = z.object({
   lastScrape: z.string(),
   lastScrapeDuration: z.string(),
   health: healthModel,
+  shard: z.optional(shardModel),
+  history: z.optional(z.array(scrapeModel)),
 });
 export const targetsModel = z.array(targetModel);
 